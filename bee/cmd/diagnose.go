@@ -0,0 +1,77 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/CamiloHernandez/beekeeper/lib"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+var diagnoseTimeout time.Duration
+
+// diagnoseCmd represents the diagnose command
+var diagnoseCmd = &cobra.Command{
+	Use:   "diagnose <ip> [-t token] [-p port]",
+	Short: "Runs a self-test against a node",
+	Long: `A sequence of checks is run against the node: network reachability, TLS handshake, token authentication, job
+transfer and task execution. The checks stop at the first failure. Note that the node's current job is replaced.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if portOverride != 0 {
+			cfg.OutboundPort = portOverride
+		}
+
+		server := beekeeper.NewServer(cfg)
+		go func() {
+			defer server.Stop()
+			err := server.Start()
+			if err != nil {
+				panic(err)
+			}
+		}()
+
+		passed := true
+		for _, step := range server.Diagnose(args[0], diagnoseTimeout) {
+			if step.Passed() {
+				fmt.Printf("PASS  %s\n", step.Name)
+				continue
+			}
+
+			passed = false
+			fmt.Printf("FAIL  %s: %s\n", step.Name, step.Err.Error())
+		}
+
+		if !passed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	diagnoseCmd.Flags().DurationVar(&diagnoseTimeout, "timeout", time.Second*10, "time waited on every check")
+
+	rootCmd.AddCommand(diagnoseCmd)
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/CamiloHernandez/beekeeper/lib"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var exportOutputPath string
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export [-o output] [-t token] [-p port]",
+	Short: "Scans the local network and exports a snapshot of the cluster",
+	Long: `A snapshot of the cluster is created by scanning the local network and written as JSON to the output file.
+The snapshot can be restored afterwards with the import command.`,
+	Run: func(cmd *cobra.Command, _ []string) {
+		server := beekeeper.NewServer(cfg)
+		go func() {
+			defer server.Stop()
+			err := server.Start()
+			if err != nil {
+				panic(err)
+			}
+		}()
+
+		_, err := server.Scan(beekeeper.DefaultScanTime)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return
+		}
+
+		f, err := os.Create(exportOutputPath)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return
+		}
+
+		defer f.Close()
+
+		err = server.Export(f)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return
+		}
+
+		fmt.Println("Cluster exported to", exportOutputPath)
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringVarP(&exportOutputPath, "output", "o", "cluster.json", "snapshot output path")
+
+	rootCmd.AddCommand(exportCmd)
+}
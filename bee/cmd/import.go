@@ -0,0 +1,64 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/CamiloHernandez/beekeeper/lib"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var importInputPath string
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import [-i input]",
+	Short: "Imports a cluster snapshot to be restored by the server",
+	Long: `A cluster snapshot created with the export command is read from the input file and saved in the work folder.
+The servers started afterwards with the start command, using the same work folder, restore its nodes and history.`,
+	Run: func(cmd *cobra.Command, _ []string) {
+		f, err := os.Open(importInputPath)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return
+		}
+
+		defer f.Close()
+
+		nodes, err := beekeeper.SaveSnapshot(cfg, f)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return
+		}
+
+		nodes.PrettyPrint()
+		fmt.Println("Snapshot saved, it will be restored the next time the server starts")
+	},
+}
+
+func init() {
+	importCmd.Flags().StringVarP(&importInputPath, "input", "i", "cluster.json", "snapshot input path")
+
+	rootCmd.AddCommand(importCmd)
+}
@@ -36,9 +36,12 @@ import (
 var cfgFilePath string
 
 var tokenOverride string
+var tokenFileOverride string
 var cleanupOverride bool
 var debugOverride bool
+var dryRunOverride bool
 var portOverride int
+var workDirOverride string
 
 var cfg beekeeper.Config
 
@@ -69,9 +72,12 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&cfgFilePath, "config", "", "config file path")
 	rootCmd.PersistentFlags().StringVarP(&tokenOverride, "token", "t", "", "sets a token")
+	rootCmd.PersistentFlags().StringVar(&tokenFileOverride, "token-file", "", "reads the token from a file")
 	rootCmd.PersistentFlags().BoolVarP(&cleanupOverride, "cleanup", "c", true, "enables post-build cleanup")
 	rootCmd.PersistentFlags().BoolVar(&debugOverride, "debug", false, "enables debug mode")
+	rootCmd.PersistentFlags().BoolVar(&dryRunOverride, "dry-run", false, "logs the actions without sending messages")
 	rootCmd.PersistentFlags().IntVarP(&portOverride, "port", "p", 0, "sets a custom port")
+	rootCmd.PersistentFlags().StringVar(&workDirOverride, "workdir", "", "sets the folder used to store jobs and builds")
 }
 
 // initConfig reads in the config file and manages the persistent flags.
@@ -86,10 +92,23 @@ func initConfig() {
 		cfg.Debug = true
 	}
 
+	if dryRunOverride {
+		cfg.DryRun = true
+	}
+
+	if tokenFileOverride != "" {
+		cfg.Token = "" // Replaces the config file's token, and is ambiguous only with --token
+		cfg.TokenFile = tokenFileOverride
+	}
+
 	if tokenOverride != "" {
 		cfg.Token = tokenOverride
 	}
 
+	if workDirOverride != "" {
+		cfg.WorkDir = workDirOverride
+	}
+
 	return
 }
 
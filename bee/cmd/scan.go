@@ -26,16 +26,25 @@ import (
 	"fmt"
 	"github.com/CamiloHernandez/beekeeper/lib"
 	"github.com/spf13/cobra"
+	"os"
 )
 
+var scanFormat string
+
 // scanCmd represents the scan command
 var scanCmd = &cobra.Command{
-	Use:   "scan [-t token] [-p port]",
+	Use:   "scan [-t token] [-p port] [--format table|json|csv]",
 	Short: "Scans the local network for available workers and displays them",
 	Run: func(cmd *cobra.Command, _ []string) {
 		var nodes beekeeper.Nodes
 		var err error
 
+		format, err := beekeeper.ParseOutputFormat(scanFormat)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return
+		}
+
 		server := beekeeper.NewServer(cfg)
 		go func() {
 			defer server.Stop()
@@ -51,10 +60,16 @@ var scanCmd = &cobra.Command{
 			return
 		}
 
-		nodes.PrettyPrint()
+		err = nodes.Print(format, os.Stdout)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return
+		}
 	},
 }
 
 func init() {
+	scanCmd.Flags().StringVar(&scanFormat, "format", "table", "output format: table, json or csv")
+
 	rootCmd.AddCommand(scanCmd)
 }
@@ -24,6 +24,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"github.com/CamiloHernandez/beekeeper/lib"
 	"github.com/spf13/cobra"
@@ -61,6 +62,17 @@ For a detailed usage guide visit https://www.beekeeper.dev`,
 		}()
 
 		err := sv.Start()
+
+		var validationErr *beekeeper.ValidationError
+		if errors.As(err, &validationErr) {
+			fmt.Println("Unable to start server, the configuration is invalid:")
+			for _, problem := range validationErr.Errors {
+				fmt.Println("  -", problem.Error())
+			}
+
+			os.Exit(1)
+		}
+
 		if err != nil {
 			fmt.Println("Unable to start server:", err.Error())
 		}
@@ -33,8 +33,11 @@ var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Shows version information",
 	Run: func(cmd *cobra.Command, _ []string) {
+		info := beekeeper.VersionInfo()
+
 		fmt.Printf("Beekeeper %s by %s. Released under the %s licence.\n",
-			beekeeper.Version, beekeeper.Author, beekeeper.License)
+			info.Semver, beekeeper.Author, beekeeper.License)
+		fmt.Printf("Commit %s, built %s with %s.\n", info.GitCommit, info.BuildTime, info.GoVersion)
 	},
 }
 
@@ -23,8 +23,11 @@
 package beekeeper
 
 import (
+	"context"
 	"errors"
 	"net"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -33,6 +36,9 @@ type awaitables []awaitable
 type awaitable struct {
 	notify    chan Message
 	checkFunc func(Message) bool
+
+	// taskUUID is the UUID of the awaited task, if any. It's used to reap the awaitables of disconnected nodes.
+	taskUUID string
 }
 
 // ErrTimeout is produced by functions called with a timeout when the allocated time is exceeded
@@ -41,19 +47,115 @@ var ErrTimeout = errors.New("time exceeded")
 // ErrNodeDisconnected is produced when a node is gets disconnected while executing an operation
 var ErrNodeDisconnected = errors.New("node disconnected")
 
-// awaitTask blocks the execution until a node sends a Result with a matching taskID.
+// awaitTask blocks the execution until a node sends a Result with a matching taskID. Optionally a timeout argument can
+// be passed.
 func (s *Server) awaitTask(taskId string, timeout ...time.Duration) (Result, error) {
+	ctx := context.Background()
+	if len(timeout) > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout[0])
+		defer cancel()
+	}
+
+	res, err := s.awaitTaskCtx(ctx, taskId)
+	if err == context.DeadlineExceeded {
+		return Result{}, ErrTimeout
+	}
+
+	return res, err
+}
+
+// awaitTaskCtx blocks the execution until a node sends a Result with a matching taskID, or the context is done.
+func (s *Server) awaitTaskCtx(ctx context.Context, taskId string) (Result, error) {
+	notifyChan := s.expectTask(taskId)
+	defer s.removeAwaitable(notifyChan)
+
+	return s.awaitExpectedTask(ctx, taskId, notifyChan)
+}
+
+// awaitExpectedTask blocks the execution until the Result of the task, registered with expectTask, is received through
+// the chan, or the context is done.
+func (s *Server) awaitExpectedTask(ctx context.Context, taskId string, notifyChan chan Message) (Result, error) {
+	if s.Config.DryRun {
+		return Result{UUID: taskId}, nil
+	}
+
+	select {
+	case msg := <-notifyChan:
+		res, _ := decodeResult(msg.Data)
+		return res, nil
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	}
+}
+
+// query sends the Message to the node and blocks until it responds with the response operation. Optionally a timeout
+// argument can be passed.
+func (s *Server) query(n Node, msg Message, response Operation, timeout ...time.Duration) (Message, error) {
+	ctx := context.Background()
+	if len(timeout) > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout[0])
+		defer cancel()
+	}
 
+	res, err := s.queryCtx(ctx, n, msg, response)
+	if err == context.DeadlineExceeded {
+		return Message{}, ErrTimeout
+	}
+
+	return res, err
+}
+
+// queryCtx sends the Message to the node and blocks until it responds with the response operation, or the context is
+// done.
+func (s *Server) queryCtx(ctx context.Context, n Node, msg Message, response Operation) (Message, error) {
 	notifyChan := make(chan Message, 1)
 
 	s.awaitedLock.Lock()
 	s.awaited = append(s.awaited, awaitable{
 		notify: notifyChan,
 		checkFunc: func(msg Message) bool {
-			if msg.Operation == OperationJobResult {
+			return msg.Operation == response && msg.Addr.IP.Equal(n.Addr.IP)
+		},
+	})
+	s.awaitedLock.Unlock()
+	defer s.removeAwaitable(notifyChan)
+
+	err := s.send(n, msg)
+	if err != nil {
+		return Message{}, err
+	}
+
+	select {
+	case res := <-notifyChan:
+		return res, nil
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+// expectTask registers an awaitable for the Result of the task with the given UUID. The Result message is sent
+// through the returned chan.
+func (s *Server) expectTask(taskId string) chan Message {
+	notifyChan := make(chan Message, 1)
+	s.addTaskAwaitable(taskId, notifyChan)
+
+	return notifyChan
+}
+
+// addTaskAwaitable registers an awaitable that sends the Result or Busy message of the task with the given UUID through
+// notifyChan. The chan may be shared by several awaitables if it's big enough to hold all their messages.
+func (s *Server) addTaskAwaitable(taskId string, notifyChan chan Message) {
+	s.awaitedLock.Lock()
+	s.awaited = append(s.awaited, awaitable{
+		notify:   notifyChan,
+		taskUUID: taskId,
+		checkFunc: func(msg Message) bool {
+			if msg.Operation == OperationJobResult || msg.Operation == OperationBusy {
 				res, err := decodeResult(msg.Data)
 				if err != nil {
-					logger.Errorln("Unable to decode task response:", err)
+					s.log().Errorln("Unable to decode task response:", err)
 					return false
 				}
 
@@ -66,38 +168,135 @@ func (s *Server) awaitTask(taskId string, timeout ...time.Duration) (Result, err
 		},
 	})
 	s.awaitedLock.Unlock()
+}
+
+// MultiTaskResult holds the outcome of awaiting several tasks, keyed by task UUID. It's returned as an error when some
+// of them failed.
+type MultiTaskResult struct {
+	// Results holds the results of the tasks that succeeded.
+	Results map[string]Result
+
+	// Errors holds the errors of the tasks that failed or weren't received in time.
+	Errors map[string]error
+}
+
+// Error lists the UUIDs of the tasks that failed and their errors.
+func (r *MultiTaskResult) Error() string {
+	uuids := make([]string, 0, len(r.Errors))
+	for uuid := range r.Errors {
+		uuids = append(uuids, uuid)
+	}
 
+	sort.Strings(uuids)
+
+	details := make([]string, len(uuids))
+	for i, uuid := range uuids {
+		details[i] = uuid + ": " + r.Errors[uuid].Error()
+	}
+
+	return "tasks failed: " + strings.Join(details, "; ")
+}
+
+// AwaitAllTasks blocks the execution until a Result is received for every task UUID, and returns them in the same
+// order. A single optional timeout governs the whole wait. If any task failed, or wasn't received in time, the
+// Results of the failed tasks are left empty and a *MultiTaskResult is returned with the details of every task. Only
+// Results received after the call are considered. The UUIDs must be unique.
+func (s *Server) AwaitAllTasks(taskIDs []string, timeout ...time.Duration) ([]Result, error) {
+	indexes := make(map[string]int, len(taskIDs))
+	for i, id := range taskIDs {
+		if _, ok := indexes[id]; ok {
+			return nil, errors.New("duplicate task UUID: " + id)
+		}
+
+		indexes[id] = i
+	}
+
+	results := make([]Result, len(taskIDs))
+
+	if s.Config.DryRun {
+		for i, id := range taskIDs {
+			results[i] = Result{UUID: id}
+		}
+
+		return results, nil
+	}
+
+	notifyChan := make(chan Message, len(taskIDs))
+	for _, id := range taskIDs {
+		s.addTaskAwaitable(id, notifyChan)
+	}
+	defer s.removeAwaitable(notifyChan)
+
+	outcome := &MultiTaskResult{Results: make(map[string]Result), Errors: make(map[string]error)}
+
+	var timeoutChan <-chan time.Time
 	if len(timeout) > 0 {
-		// Use Timer instead of using time.After. See:
-		// https://medium.com/@oboturov/golang-time-after-is-not-garbage-collected-4cbc94740082
 		toTimer := time.NewTimer(timeout[0])
 		defer toTimer.Stop()
 
+		timeoutChan = toTimer.C
+	}
+
+	for len(outcome.Results)+len(outcome.Errors) < len(indexes) {
 		select {
 		case msg := <-notifyChan:
-			res, _ := decodeResult(msg.Data)
-			return res, nil
-		case <-toTimer.C:
-			return Result{}, ErrTimeout
+			res, err := decodeResult(msg.Data)
+			if err != nil {
+				continue // Already logged by the awaitable
+			}
+
+			if remoteErr := res.remoteError(); remoteErr != nil {
+				outcome.Errors[res.UUID] = remoteErr
+				continue
+			}
+
+			outcome.Results[res.UUID] = res
+			results[indexes[res.UUID]] = res
+
+		case <-timeoutChan:
+			for id := range indexes {
+				if _, ok := outcome.Results[id]; ok {
+					continue
+				}
+
+				if _, ok := outcome.Errors[id]; !ok {
+					outcome.Errors[id] = ErrTimeout
+				}
+			}
 		}
 	}
 
-	msg := <-notifyChan
-	res, _ := decodeResult(msg.Data)
-	return res, nil
+	if len(outcome.Errors) > 0 {
+		return results, outcome
+	}
+
+	return results, nil
 }
 
-// awaitTransfer blocks the execution until the node sends a transfer acknowledgement or reports a transfer error.
-// If an error message is received i'll be returned. An empty string means no error was raised.
-func (s *Server) awaitTransfer(n Node, timeout ...time.Duration) error {
+// sendTransfer sends the Message to the node and blocks until it acknowledges the transfer or reports a transfer
+// error, which is returned. The awaitable is registered before sending, so the response can't be missed.
+func (s *Server) sendTransfer(n Node, msg Message, timeout ...time.Duration) error {
+	notifyChan := s.expectTransfer(n)
+	defer s.removeAwaitable(notifyChan)
+
+	err := s.send(n, msg)
+	if err != nil {
+		return err
+	}
+
+	return s.awaitTransfer(n, notifyChan, timeout...)
+}
+
+// expectTransfer registers an awaitable for the transfer acknowledgement or transfer error of the node. The returned
+// chan is meant for awaitTransfer.
+func (s *Server) expectTransfer(n Node) chan Message {
 	notifyChan := make(chan Message, 1)
-	disconnectChan := newDisconnectionWatchdog(s, n, 2)
 
 	s.awaitedLock.Lock()
 	s.awaited = append(s.awaited, awaitable{
 		notify: notifyChan,
 		checkFunc: func(msg Message) bool {
-			if msg.Operation == OperationTransferFailed || msg.Operation == OperationTransferAcknowledge &&
+			if (msg.Operation == OperationTransferFailed || msg.Operation == OperationTransferAcknowledge) &&
 				msg.Addr.IP.Equal(n.Addr.IP) {
 				return true
 			}
@@ -107,6 +306,18 @@ func (s *Server) awaitTransfer(n Node, timeout ...time.Duration) error {
 	})
 	s.awaitedLock.Unlock()
 
+	return notifyChan
+}
+
+// awaitTransfer blocks the execution until a transfer acknowledgement or transfer error of the node is received
+// through the chan, or the node gets disconnected. If an error message is received it'll be returned.
+func (s *Server) awaitTransfer(n Node, notifyChan chan Message, timeout ...time.Duration) error {
+	if s.Config.DryRun {
+		return nil
+	}
+
+	disconnectChan := newDisconnectionWatchdog(s, n, 2)
+
 	if len(timeout) > 0 {
 		// Use Timer instead of using time.After. See:
 		// https://medium.com/@oboturov/golang-time-after-is-not-garbage-collected-4cbc94740082
@@ -138,7 +349,51 @@ func (s *Server) awaitTransfer(n Node, timeout ...time.Duration) error {
 	case <-disconnectChan:
 		return ErrNodeDisconnected
 	}
+}
+
+// queryBinary asks the node whether it has the job binary with the given SHA-256 checksum and blocks until it
+// responds. present will be true if the node already has it. Optionally a timeout argument can be passed.
+func (s *Server) queryBinary(n Node, sum []byte, timeout ...time.Duration) (present bool, err error) {
+	notifyChan := make(chan Message, 1)
+
+	s.awaitedLock.Lock()
+	s.awaited = append(s.awaited, awaitable{
+		notify: notifyChan,
+		checkFunc: func(msg Message) bool {
+			if (msg.Operation == OperationBinaryPresent || msg.Operation == OperationBinaryMissing) &&
+				msg.Addr.IP.Equal(n.Addr.IP) {
+				return true
+			}
+
+			return false
+		},
+	})
+	s.awaitedLock.Unlock()
+	defer s.removeAwaitable(notifyChan)
+
+	err = s.send(n, Message{Operation: OperationBinaryQuery, Data: sum})
+	if err != nil {
+		return false, err
+	}
+
+	if s.Config.DryRun {
+		return false, nil
+	}
+
+	if len(timeout) > 0 {
+		toTimer := time.NewTimer(timeout[0])
+		defer toTimer.Stop()
 
+		select {
+		case msg := <-notifyChan:
+			return msg.Operation == OperationBinaryPresent, nil
+		case <-toTimer.C:
+			return false, ErrTimeout
+		}
+	}
+
+	msg := <-notifyChan
+	return msg.Operation == OperationBinaryPresent, nil
 }
 
 // awaitAny blocks the execution until the node with a matching address sends any operation
@@ -150,6 +405,10 @@ func (s *Server) awaitAny(addr string, timeout ...time.Duration) (Node, error) {
 		return Node{}, err
 	}
 
+	if s.Config.DryRun {
+		return Node{Addr: &net.TCPAddr{IP: resolvedAddr.IP}}, nil
+	}
+
 	s.awaitedLock.Lock()
 	s.awaited = append(s.awaited, awaitable{
 		notify: notifyChan,
@@ -162,6 +421,7 @@ func (s *Server) awaitAny(addr string, timeout ...time.Duration) (Node, error) {
 		},
 	})
 	s.awaitedLock.Unlock()
+	defer s.removeAwaitable(notifyChan)
 
 	if len(timeout) > 0 {
 		// Use Timer instead of using time.After. See:
@@ -181,6 +441,22 @@ func (s *Server) awaitAny(addr string, timeout ...time.Duration) (Node, error) {
 	return s.nodes.find(resolvedAddr.IP), nil
 }
 
+// removeAwaitable removes the awaitables that notify through the chan, if they are still registered. It's meant to be
+// deferred by the functions that stop waiting on an error or timeout.
+func (s *Server) removeAwaitable(notifyChan chan Message) {
+	s.awaitedLock.Lock()
+	defer s.awaitedLock.Unlock()
+
+	var remaining awaitables
+	for _, a := range s.awaited {
+		if a.notify != notifyChan {
+			remaining = append(remaining, a)
+		}
+	}
+
+	s.awaited = remaining
+}
+
 // checkAwaited compares a Message object with the awaitables list and passes it forward if matching
 func (s *Server) checkAwaited(msg Message) {
 	s.awaitedLock.Lock()
@@ -25,6 +25,7 @@ package beekeeper
 import (
 	"github.com/google/go-cmp/cmp"
 	"net"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -53,7 +54,7 @@ func TestAwaitTaskWithTimeoutReceived(t *testing.T) {
 		t.Fail()
 	}
 
-	receiveChan <- Request{msg, Conn{}}
+	receiveChan <- Request{Msg: msg, Conn: Conn{}}
 
 	wg.Wait()
 }
@@ -80,6 +81,44 @@ func TestAwaitTaskWithTimeoutTimeout(t *testing.T) {
 	wg.Wait()
 }
 
+func TestServer_awaitTimeoutRemovesAwaitables(t *testing.T) {
+	c := NewDefaultConfig()
+	c.DisableConnectionWatchdog = true
+
+	s := NewServer(c) // Not shared, so the awaitables of other tests aren't counted
+
+	_ = s.SetSendCallback(func(*Server, *Conn, Message) error {
+		return nil
+	})
+
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	_, err := s.awaitTask("test", time.Millisecond*10)
+	if err != ErrTimeout {
+		t.Error("expected a timeout awaiting the task, got", err)
+	}
+
+	n := Node{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.1")}}
+	err = s.sendTransfer(n, Message{Operation: OperationJobTransfer}, time.Millisecond*10)
+	if err != ErrTimeout {
+		t.Error("expected a timeout awaiting the transfer, got", err)
+	}
+
+	_, err = s.awaitAny("192.168.1.1", time.Millisecond*10)
+	if err != ErrTimeout {
+		t.Error("expected a timeout awaiting the node, got", err)
+	}
+
+	s.awaitedLock.Lock()
+	defer s.awaitedLock.Unlock()
+
+	if len(s.awaited) != 0 {
+		t.Error("expected no awaitables left, got", len(s.awaited))
+	}
+}
+
 func TestAwaitTask(t *testing.T) {
 	s, receiveChan, _ := startPrimaryTestChannels()
 
@@ -121,7 +160,7 @@ func TestAwaitTask(t *testing.T) {
 		return
 	}
 
-	receiveChan <- Request{msg, Conn{}}
+	receiveChan <- Request{Msg: msg, Conn: Conn{}}
 
 	wg.Wait()
 }
@@ -133,24 +172,23 @@ func TestAwaitTransferAndCheckAcknowledge(t *testing.T) {
 	wg.Add(1)
 
 	addr := &net.TCPAddr{}
+	notifyChan := s.expectTransfer(Node{Addr: addr})
 
 	go func() {
 		defer wg.Done()
 
-		err := s.awaitTransfer(Node{Addr: addr})
+		err := s.awaitTransfer(Node{Addr: addr}, notifyChan)
 		if err != nil {
 			t.Error(err)
 			return
 		}
 	}()
 
-	time.Sleep(time.Millisecond * 10) // Goroutine might execute last
-
 	msg := newMessage()
 	msg.Operation = OperationTransferAcknowledge
 	msg.Addr = addr
 
-	receiveChan <- Request{msg, Conn{}}
+	receiveChan <- Request{Msg: msg, Conn: Conn{}}
 
 	wg.Wait()
 }
@@ -162,24 +200,106 @@ func TestAwaitTransferAndCheckFailed(t *testing.T) {
 	wg.Add(1)
 
 	addr := &net.TCPAddr{}
+	notifyChan := s.expectTransfer(Node{Addr: addr})
 
 	go func() {
 		defer wg.Done()
 
-		err := s.awaitTransfer(Node{Addr: addr}, 2)
+		err := s.awaitTransfer(Node{Addr: addr}, notifyChan, time.Second)
 		if err == nil {
 			t.Fail()
 			return
 		}
 	}()
 
-	time.Sleep(time.Millisecond * 10) // Goroutine might execute last
-
 	msg := newMessage()
 	msg.Operation = OperationTransferFailed
 	msg.Addr = addr
 
-	receiveChan <- Request{msg, Conn{}}
+	receiveChan <- Request{Msg: msg, Conn: Conn{}}
 
 	wg.Wait()
 }
+
+func TestAwaitDryRun(t *testing.T) {
+	s := &Server{Config: Config{DryRun: true}}
+
+	res, err := s.awaitTask("test")
+	if err != nil || res.UUID != "test" {
+		t.Error("expected a mocked result, got", err)
+		return
+	}
+
+	n := Node{Addr: &net.TCPAddr{}}
+	err = s.awaitTransfer(n, s.expectTransfer(n))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestServer_AwaitAllTasks(t *testing.T) {
+	s, receiveChan, _ := startPrimaryTestChannels()
+
+	type outcome struct {
+		results []Result
+		err     error
+	}
+
+	done := make(chan outcome, 1)
+	go func() {
+		results, err := s.AwaitAllTasks([]string{"all_a", "all_b", "all_c"}, time.Millisecond*500)
+		done <- outcome{results, err}
+	}()
+
+	time.Sleep(time.Millisecond * 100) // Let the awaitables register
+
+	for _, uuid := range []string{"all_b", "all_a"} {
+		msg := newMessage()
+		msg.Operation = OperationJobResult
+
+		msg, err := msg.setData(Result{UUID: uuid})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		receiveChan <- Request{Msg: msg, Conn: Conn{}}
+	}
+
+	o := <-done
+
+	if len(o.results) != 3 || o.results[0].UUID != "all_a" || o.results[1].UUID != "all_b" || o.results[2].UUID != "" {
+		t.Error("unexpected results", o.results)
+		return
+	}
+
+	multi, ok := o.err.(*MultiTaskResult)
+	if !ok {
+		t.Error("expected a MultiTaskResult, got", o.err)
+		return
+	}
+
+	if len(multi.Results) != 2 || len(multi.Errors) != 1 || multi.Errors["all_c"] != ErrTimeout {
+		t.Error("unexpected outcome", multi.Results, multi.Errors)
+	}
+
+	s.awaitedLock.Lock()
+	defer s.awaitedLock.Unlock()
+
+	// The server is shared, so only the awaitables of these tasks are checked
+	for _, a := range s.awaited {
+		if strings.HasPrefix(a.taskUUID, "all_") {
+			t.Error("awaitable left for task", a.taskUUID)
+		}
+	}
+}
+
+func TestServer_AwaitAllTasksDuplicate(t *testing.T) {
+	s, _, _ := startPrimaryTestChannels()
+
+	_, err := s.AwaitAllTasks([]string{"dup_a", "dup_b", "dup_a"}, time.Millisecond*10)
+	if err == nil {
+		t.Error("expected an error for the duplicate UUID")
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrBackoffActive is produced when a node is not dialed because a previous connection attempt failed recently
+var ErrBackoffActive = errors.New("connection backoff active")
+
+// ExponentialBackoff tracks the consecutive failures of an operation, and the time to wait before retrying it. The
+// wait starts at Base and doubles on every failure, up to Max.
+type ExponentialBackoff struct {
+	// Base is the wait after the first failure.
+	Base time.Duration
+
+	// Max is the maximum wait.
+	Max time.Duration
+
+	// failures is the amount of consecutive failures.
+	failures int
+
+	// lastFailure is the time of the last failure.
+	lastFailure time.Time
+}
+
+// Delay returns the time to wait after the last failure. It's 0 if there were no failures.
+func (b *ExponentialBackoff) Delay() time.Duration {
+	if b.failures == 0 {
+		return 0
+	}
+
+	delay := b.Base
+	for i := 1; i < b.failures; i++ {
+		delay *= 2
+		if b.Max > 0 && delay >= b.Max {
+			return b.Max
+		}
+	}
+
+	if b.Max > 0 && delay > b.Max {
+		return b.Max
+	}
+
+	return delay
+}
+
+// Active reports if the wait after the last failure hasn't finished yet.
+func (b *ExponentialBackoff) Active() bool {
+	return time.Since(b.lastFailure) < b.Delay()
+}
+
+// Fail registers a failure, advancing the backoff.
+func (b *ExponentialBackoff) Fail() {
+	b.failures++
+	b.lastFailure = time.Now()
+}
+
+// Reset clears the failures.
+func (b *ExponentialBackoff) Reset() {
+	b.failures = 0
+	b.lastFailure = time.Time{}
+}
+
+// isBackoffActive reports if the node with the ip failed to be dialed recently.
+func (s *Server) isBackoffActive(ip string) bool {
+	s.backoffStateLock.Lock()
+	defer s.backoffStateLock.Unlock()
+
+	b, ok := s.backoffState[ip]
+	return ok && b.Active()
+}
+
+// backoffFailed advances the backoff of the node with the ip. It does nothing if Config.BackoffBase is 0.
+func (s *Server) backoffFailed(ip string) {
+	if s.Config.BackoffBase <= 0 {
+		return
+	}
+
+	s.backoffStateLock.Lock()
+	defer s.backoffStateLock.Unlock()
+
+	if s.backoffState == nil {
+		s.backoffState = make(map[string]*ExponentialBackoff)
+	}
+
+	b, ok := s.backoffState[ip]
+	if !ok {
+		b = &ExponentialBackoff{Base: s.Config.BackoffBase, Max: s.Config.BackoffMax}
+		s.backoffState[ip] = b
+	}
+
+	b.Fail()
+}
+
+// backoffReset clears the backoff of the node with the ip.
+func (s *Server) backoffReset(ip string) {
+	s.backoffStateLock.Lock()
+	defer s.backoffStateLock.Unlock()
+
+	delete(s.backoffState, ip)
+}
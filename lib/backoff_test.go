@@ -0,0 +1,104 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff_Delay(t *testing.T) {
+	b := ExponentialBackoff{Base: time.Second, Max: time.Second * 5}
+
+	for _, expect := range []time.Duration{0, time.Second, time.Second * 2, time.Second * 4, time.Second * 5,
+		time.Second * 5} {
+		if delay := b.Delay(); delay != expect {
+			t.Error("expected", expect, "got", delay)
+		}
+
+		b.Fail()
+	}
+
+	if !b.Active() {
+		t.Error("backoff not active after a failure")
+	}
+
+	b.Reset()
+	if b.Active() || b.Delay() != 0 {
+		t.Error("backoff active after a reset")
+	}
+}
+
+func TestServer_sendBackoff(t *testing.T) {
+	config := NewDefaultConfig()
+	config.BackoffBase = time.Minute
+
+	s := NewServer(config)
+
+	dials := 0
+	s.connCallback = func(_ *Server, ip string, timeout ...time.Duration) (*Conn, error) {
+		dials++
+		return nil, errors.New("unreachable")
+	}
+
+	n := Node{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.1")}}
+
+	err := s.send(n, Message{})
+	if err == nil || err == ErrBackoffActive {
+		t.Error("expected a connection error, got", err)
+	}
+
+	err = s.send(n, Message{})
+	if err != ErrBackoffActive {
+		t.Error("expected ErrBackoffActive, got", err)
+	}
+
+	if dials != 1 {
+		t.Error("expected a single dial, got", dials)
+	}
+
+	s.connCallback = func(_ *Server, ip string, timeout ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	}
+	s.sendCallback = func(*Server, *Conn, Message) error {
+		return nil
+	}
+
+	s.backoffReset(n.Addr.IP.String())
+	err = s.send(n, Message{})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServer_backoffFailedLiteral(t *testing.T) {
+	s := &Server{Config: Config{BackoffBase: time.Minute}}
+
+	s.backoffFailed("192.168.1.1")
+
+	if !s.isBackoffActive("192.168.1.1") {
+		t.Error("backoff not active after a failure")
+	}
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// binaryDownloadTimeout is the maximum time a node takes to download its job binary.
+const binaryDownloadTimeout = time.Minute * 10
+
+// ErrBinaryDownloadDisabled is produced when a node is asked to download its job binary without
+// Config.AllowBinaryDownload set
+var ErrBinaryDownloadDisabled = errors.New("binary download disabled")
+
+// ErrChecksumMismatch is produced when a downloaded job binary doesn't have the expected checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrBinaryTooLarge is produced when a downloaded job binary exceeds Config.MaxMessageSize
+var ErrBinaryTooLarge = errors.New("binary too large")
+
+// binaryURL is the location of a job binary sent in a BinaryURL message.
+type binaryURL struct {
+	URL      string
+	Checksum []byte
+}
+
+// DistributeJobURL asks the nodes to download their job binary from url, instead of sending it. It's meant for
+// deployments where the nodes can't be reached by this node for the transfer. The nodes verify the binary against the
+// hex encoded SHA-256 checksum, and must have Config.AllowBinaryDownload set. It blocks until every node has the
+// binary.
+func (s *Server) DistributeJobURL(url, checksum string, nodes ...Node) error {
+	if len(nodes) < 1 {
+		return errors.New("no nodes provided")
+	}
+
+	sum, err := hex.DecodeString(checksum)
+	if err != nil || len(sum) != sha256.Size {
+		return errors.New("invalid SHA-256 checksum: " + checksum)
+	}
+
+	msg, err := Message{Operation: OperationBinaryURL}.setData(binaryURL{URL: url, Checksum: sum})
+	if err != nil {
+		return err
+	}
+
+	errChan := make(chan error, len(nodes))
+	for _, node := range nodes {
+		go func(node Node) {
+			err := s.sendTransfer(node, msg, binaryDownloadTimeout)
+			if err != nil {
+				err = fmt.Errorf("unable to download job on node %s: %s", node.Name, err)
+			}
+
+			errChan <- err
+		}(node)
+	}
+
+	for range nodes {
+		err := <-errChan
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// binaryURLCallback is the callback for the BinaryURL operation. The job binary is downloaded from the URL in the
+// Data and saved once its checksum is verified.
+func binaryURLCallback(s *Server, conn *Conn, msg Message) {
+	if !s.Config.AllowBinaryDownload {
+		s.log().Warnln("Rejected binary download requested by node", msg.Name+": binary download disabled")
+		respondTransferError(s, conn, ErrBinaryDownloadDisabled.Error())
+
+		return
+	}
+
+	var location binaryURL
+	err := gob.NewDecoder(bytes.NewBuffer(msg.Data)).Decode(&location)
+	if err != nil {
+		s.log().Errorln("Unable to read binary URL:", err)
+		respondTransferError(s, conn, err.Error())
+
+		return
+	}
+
+	s.log().Infoln("Downloading job from", location.URL, "as requested by node", msg.Name)
+
+	err = downloadBinary(location, s.Config.workDir(), s.Config.jobBinaryPath(), s.Config.MaxMessageSize)
+	if err != nil {
+		s.log().Errorln("Unable to download job:", err)
+		respondTransferError(s, conn, err.Error())
+
+		return
+	}
+
+	err = s.sendWithConn(conn, Message{Operation: OperationTransferAcknowledge})
+	if err != nil {
+		s.log().Errorln("Failed to acknowledge transfer:", err)
+		return
+	}
+
+	s.log().Infoln("Job downloaded successfully from", location.URL)
+}
+
+// downloadBinary downloads the binary into a temporary file inside workDir, and moves it to binPath once its checksum
+// is verified. Binaries bigger than maxSize bytes are rejected with ErrBinaryTooLarge, unless maxSize is 0. The
+// previous binary is kept if the download fails.
+func downloadBinary(location binaryURL, workDir, binPath string, maxSize uint64) error {
+	err := createFolderIfNotExist(workDir)
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: binaryDownloadTimeout}
+
+	res, err := client.Get(location.URL)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New("unexpected HTTP status: " + res.Status)
+	}
+
+	f, err := ioutil.TempFile(workDir, "download_")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+
+	body := io.Reader(res.Body)
+	if maxSize > 0 {
+		body = io.LimitReader(res.Body, int64(maxSize)+1) // One extra byte to tell a binary over the limit apart
+	}
+
+	h := sha256.New()
+	written, err := io.Copy(io.MultiWriter(f, h), body)
+
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+
+	if closeErr != nil {
+		return closeErr
+	}
+
+	if maxSize > 0 && uint64(written) > maxSize {
+		return ErrBinaryTooLarge
+	}
+
+	if !bytes.Equal(h.Sum(nil), location.Checksum) {
+		return ErrChecksumMismatch
+	}
+
+	err = os.Chmod(f.Name(), 0777)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filepath.Clean(binPath))
+}
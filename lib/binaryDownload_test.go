@@ -0,0 +1,166 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDownloadBinary(t *testing.T) {
+	data := []byte("binary")
+	sum := sha256.Sum256(data)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(data)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "beekeeper")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	binPath := filepath.Join(dir, "job.bin")
+
+	err = downloadBinary(binaryURL{URL: ts.URL, Checksum: make([]byte, sha256.Size)}, dir, binPath, 0)
+	if err != ErrChecksumMismatch {
+		t.Error("expected ErrChecksumMismatch, got", err)
+		return
+	}
+
+	if doesPathExists(binPath) {
+		t.Error("binary saved with a wrong checksum")
+		return
+	}
+
+	err = downloadBinary(binaryURL{URL: ts.URL, Checksum: sum[:]}, dir, binPath, uint64(len(data))-1)
+	if err != ErrBinaryTooLarge {
+		t.Error("expected ErrBinaryTooLarge, got", err)
+		return
+	}
+
+	if doesPathExists(binPath) {
+		t.Error("binary saved over the size limit")
+		return
+	}
+
+	err = downloadBinary(binaryURL{URL: ts.URL, Checksum: sum[:]}, dir, binPath, uint64(len(data)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	saved, err := ioutil.ReadFile(binPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if string(saved) != string(data) {
+		t.Error("unexpected binary", string(saved))
+	}
+}
+
+func TestBinaryURLCallbackDisabled(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	sent := make(chan Message, 1)
+	_ = s.SetSendCallback(func(_ *Server, _ *Conn, m Message) error {
+		sent <- m
+		return nil
+	})
+
+	msg, err := getTestMessage().setData(binaryURL{URL: "http://localhost"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	binaryURLCallback(s, &Conn{}, msg)
+
+	select {
+	case res := <-sent:
+		if res.Operation != OperationTransferFailed || string(res.Data) != ErrBinaryDownloadDisabled.Error() {
+			t.Error("unexpected response", res.summary())
+		}
+	case <-time.After(time.Second):
+		t.Error("no response sent")
+	}
+}
+
+func TestServer_DistributeJobURLInvalidChecksum(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	err := s.DistributeJobURL("http://localhost/job", "not hex", getTestNodes()...)
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestServer_DistributeJobURLFastAcknowledge(t *testing.T) {
+	c := NewDefaultConfig()
+	c.DisableConnectionWatchdog = true
+
+	s := NewServer(c)
+
+	node := getTestNodes()[0]
+
+	// Acknowledge before the send returns, as a fast node could
+	_ = s.SetSendCallback(func(s *Server, _ *Conn, m Message) error {
+		if m.Operation == OperationBinaryURL {
+			s.checkAwaited(Message{Operation: OperationTransferAcknowledge, Addr: node.Addr})
+		}
+
+		return nil
+	})
+
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	sum := sha256.Sum256([]byte("binary"))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.DistributeJobURL("http://localhost/job", hex.EncodeToString(sum[:]), node)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("acknowledgement missed")
+	}
+}
@@ -23,12 +23,105 @@
 package beekeeper
 
 import (
+	"errors"
+	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
+// MulticastError holds the errors produced while sending a multicast, or while running a task on several nodes, keyed
+// by IP.
+type MulticastError struct {
+	Errors map[string]error
+}
+
+// Error lists the IPs that failed and their errors.
+func (e *MulticastError) Error() string {
+	ips := make([]string, 0, len(e.Errors))
+	for ip := range e.Errors {
+		ips = append(ips, ip)
+	}
+
+	sort.Strings(ips)
+
+	details := make([]string, len(ips))
+	for i, ip := range ips {
+		details[i] = ip + ": " + e.Errors[ip].Error()
+	}
+
+	return "failed for " + strings.Join(details, "; ")
+}
+
+// Multicast sends the Message to every IP, dialing up to Config.BroadcastConcurrency of them at once. If await is
+// true it blocks until all of them are sent, and a *MulticastError is returned if any failed. Otherwise it returns
+// immediately and the errors are only logged.
+func (s *Server) Multicast(ips []string, msg Message, await bool) error {
+	return s.multicast(ips, msg, await, s.Config.BroadcastConcurrency)
+}
+
+// multicast works like Multicast, dialing up to concurrency IPs at once. A concurrency of 0 means no limit.
+func (s *Server) multicast(ips []string, msg Message, await bool, concurrency int) error {
+	return s.multicastWith(ips, await, concurrency, func(conn *Conn) error {
+		return s.sendWithConn(conn, msg)
+	})
+}
+
+// multicastWith works like multicast, writing to the connection of each IP with send.
+func (s *Server) multicastWith(ips []string, await bool, concurrency int, send func(conn *Conn) error) error {
+	var sem chan struct{}
+	if concurrency > 0 {
+		sem = make(chan struct{}, concurrency)
+	}
+
+	var wg sync.WaitGroup
+	var errsLock sync.Mutex
+	errs := make(map[string]error)
+
+	for _, ip := range ips {
+		wg.Add(1)
+
+		go func(ip string) {
+			defer wg.Done()
+
+			if sem != nil {
+				sem <- struct{}{}
+				defer func() {
+					<-sem
+				}()
+			}
+
+			conn, err := s.dial(ip, time.Second)
+			if err == nil {
+				err = send(conn)
+				s.releaseConn(conn)
+			}
+
+			if err != nil {
+				s.log().Debugln("Unable to multicast to", ip+":", err)
+
+				errsLock.Lock()
+				errs[ip] = err
+				errsLock.Unlock()
+			}
+		}(ip)
+	}
+
+	if !await {
+		return nil
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return &MulticastError{Errors: errs}
+	}
+
+	return nil
+}
+
 // broadcastMessage sends the Message to all IPs in the local subnetwork.
 func (s *Server) broadcastMessage(msg Message, await bool) error {
 	return broadcastCallback(s, msg, await)
@@ -36,55 +129,162 @@ func (s *Server) broadcastMessage(msg Message, await bool) error {
 
 // broadcastOperation sends a Message containing only the op operation to all IPs in the local subnetwork.
 func (s *Server) broadcastOperation(op Operation, await bool) error {
-	return broadcastCallback(s, Message{Operation: op, Token: s.Config.Token}, await)
+	return broadcastCallback(s, Message{Operation: op}, await)
 }
 
-// broadcastCallback is the callback for the broadcast functions.
+// maxSweepBits is the largest amount of host bits of a subnet swept address by address. Bigger IPv6 subnets are
+// reached through the neighbor cache instead.
+const maxSweepBits = 16
+
+// ErrSubnetTooLarge is produced when broadcasting to an IPv4 subnet with more than 2^maxSweepBits addresses
+var ErrSubnetTooLarge = errors.New("subnet too large to sweep")
+
+// broadcastCallback is the callback for the broadcast functions. Up to Config.BroadcastConcurrency addresses are dialed
+// at once. Most addresses of a sweep have no node behind them, so failing to reach them isn't reported.
 func broadcastCallback(s *Server, msg Message, await bool) error {
-	myIP, err := getLocalIP()
+	targets, err := s.broadcastTargets()
 	if err != nil {
 		return err
 	}
 
-	ipComponents := strings.Split(myIP.String(), ".")
-	localNetwork := strings.Join(ipComponents[:len(ipComponents)-1], ".") + "." // 192.168.0.
+	err = s.multicastWith(targets, await, s.Config.BroadcastConcurrency, func(conn *Conn) error {
+		// A slow node shouldn't delay the broadcast
+		_, err := s.trySendWithConn(conn, msg)
+		return err
+	})
+	if _, ok := err.(*MulticastError); ok {
+		return nil
+	}
 
-	myIPEnding, _ := strconv.Atoi(ipComponents[len(ipComponents)-1])
+	return err
+}
 
-	var wg sync.WaitGroup
+// broadcastTargets returns the addresses reached by a broadcast. If Config.BroadcastSubnet is set those are the
+// addresses inside it. Otherwise they are the ones in the /24 of the local IPv4 address, plus the ones in the
+// Config.IPv6PrefixLength prefix of the local IPv6 address, if the machine has a routable one.
+func (s *Server) broadcastTargets() ([]string, error) {
+	if s.Config.BroadcastSubnet != "" {
+		_, subnet, err := net.ParseCIDR(s.Config.BroadcastSubnet)
+		if err != nil {
+			return nil, err
+		}
 
-	for x := 1; x <= 255; x++ {
-		if myIPEnding == x {
-			continue
+		myIP, err := getLocalIP(subnet)
+		if err != nil {
+			return nil, err
 		}
 
-		x := x
-		if await {
-			wg.Add(1)
+		iface := ""
+		if addr, ok := localAddrIn(subnet); ok {
+			iface = addr.iface
 		}
 
-		go func() {
-			if await {
-				defer wg.Done()
-			}
+		return s.subnetTargets(subnet, myIP, iface)
+	}
 
-			ip := localNetwork + strconv.Itoa(x)
+	var targets []string
 
-			conn, err := s.dial(ip, time.Second)
-			if err != nil {
-				return
-			}
+	myIP, ipv4Err := getLocalIP(nil)
+	if ipv4Err == nil && myIP.To4() != nil {
+		ipv4Targets, err := s.subnetTargets(&net.IPNet{IP: myIP.Mask(net.CIDRMask(24, 32)),
+			Mask: net.CIDRMask(24, 32)}, myIP, "")
+		if err != nil {
+			return nil, err
+		}
 
-			err = s.sendWithConn(conn, msg)
-			if err != nil {
-				return
+		targets = append(targets, ipv4Targets...)
+	}
+
+	myIPv6, ok := getLocalIPv6()
+	if ok {
+		mask := net.CIDRMask(s.Config.IPv6PrefixLength, 128)
+		ipv6Targets, err := s.subnetTargets(&net.IPNet{IP: myIPv6.ipNet.IP.Mask(mask), Mask: mask}, myIPv6.ipNet.IP,
+			myIPv6.iface)
+		if err != nil {
+			s.log().Debugln("Unable to list the IPv6 broadcast targets:", err)
+		}
+
+		targets = append(targets, ipv6Targets...)
+	}
+
+	if len(targets) == 0 && ipv4Err != nil {
+		return nil, ipv4Err
+	}
+
+	return targets, nil
+}
+
+// subnetTargets lists the addresses of the subnet except the network address and myIP. IPv6 subnets with more than
+// maxSweepBits host bits, like a /64, can't be swept, so the neighbors of the interface inside the subnet are used
+// instead. Those are taken from the system's neighbor cache, so only nodes that already exchanged traffic with this
+// machine, or answered a solicitation to ff02::1, are reached; the solicitation is sent at most once every
+// neighborSolicitInterval and needs the ping command. IPv6 addresses include the outbound port.
+func (s *Server) subnetTargets(subnet *net.IPNet, myIP net.IP, iface string) ([]string, error) {
+	ones, bits := subnet.Mask.Size()
+	isIPv6 := bits == 8*net.IPv6len
+
+	port := s.Config.OutboundPort
+	if port == 0 {
+		port = DefaultPort
+	}
+
+	format := func(ip net.IP) string {
+		if !isIPv6 {
+			return ip.String()
+		}
+
+		return net.JoinHostPort(ip.String(), strconv.Itoa(port))
+	}
+
+	if bits-ones > maxSweepBits {
+		if !isIPv6 {
+			return nil, ErrSubnetTooLarge
+		}
+
+		if iface == "" {
+			return nil, errors.New("no interface found for " + subnet.String())
+		}
+
+		solicitNeighborsLimited(iface, s.log())
+
+		out, err := neighborCommand(iface)
+		if err != nil {
+			return nil, err
+		}
+
+		var targets []string
+		for _, ip := range parseNeighbors(out, subnet.Contains) {
+			if !ip.Equal(myIP) {
+				targets = append(targets, format(ip))
 			}
-		}()
+		}
+
+		return targets, nil
 	}
 
-	if await {
-		wg.Wait()
+	targets := make([]string, 0, 1<<uint(bits-ones))
+
+	ip := make(net.IP, len(subnet.IP))
+	copy(ip, subnet.IP)
+
+	for i := 1; i < 1<<uint(bits-ones); i++ {
+		incrementIP(ip)
+		if ip.Equal(myIP) {
+			continue
+		}
+
+		targets = append(targets, format(ip))
 	}
 
-	return nil
+	return targets, nil
+}
+
+// incrementIP adds one to the IP, in place.
+func incrementIP(ip net.IP) {
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			return
+		}
+	}
 }
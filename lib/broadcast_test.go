@@ -23,7 +23,10 @@
 package beekeeper
 
 import (
+	"errors"
 	"github.com/google/go-cmp/cmp"
+	"net"
+	"sync"
 	"testing"
 	"time"
 )
@@ -95,3 +98,195 @@ func TestBroadcastOperation(t *testing.T) {
 		}
 	}
 }
+
+func TestServer_Multicast(t *testing.T) {
+	config := NewDefaultConfig()
+	config.BroadcastConcurrency = 1
+	s := NewServer(config)
+
+	var lock sync.Mutex
+	active, maxActive := 0, 0
+	sent := make(map[string]bool)
+
+	_ = s.SetConnCallback(func(_ *Server, ip string, _ ...time.Duration) (*Conn, error) {
+		if ip == "192.168.1.3" {
+			return nil, errors.New("unreachable")
+		}
+
+		return &Conn{stream: &testAddrConn{ip: ip}}, nil
+	})
+	_ = s.SetSendCallback(func(_ *Server, c *Conn, _ Message) error {
+		lock.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		sent[c.RemoteAddr().String()] = true
+		lock.Unlock()
+
+		time.Sleep(time.Millisecond * 10)
+
+		lock.Lock()
+		active--
+		lock.Unlock()
+
+		return nil
+	})
+
+	err := s.Multicast([]string{"192.168.1.1", "192.168.1.2", "192.168.1.3"}, Message{}, true)
+
+	multicastErr, ok := err.(*MulticastError)
+	if !ok || len(multicastErr.Errors) != 1 || multicastErr.Errors["192.168.1.3"] == nil {
+		t.Error("unexpected error:", err)
+	}
+
+	if !sent["192.168.1.1"] || !sent["192.168.1.2"] {
+		t.Error("message not sent to every reachable IP:", sent)
+	}
+
+	if maxActive > 1 {
+		t.Error("concurrency limit exceeded:", maxActive)
+	}
+}
+
+// testAddrConn is a net.Conn that only reports its remote address.
+type testAddrConn struct {
+	net.Conn
+	ip string
+}
+
+// RemoteAddr returns the IP of the connection.
+func (c *testAddrConn) RemoteAddr() net.Addr {
+	return &net.IPAddr{IP: net.ParseIP(c.ip)}
+}
+
+func TestServer_broadcastTargets(t *testing.T) {
+	defaultInterfaceAddrs, defaultNeighborCommand, defaultSolicitNeighbors :=
+		interfaceAddrs, neighborCommand, solicitNeighbors
+	defer func() {
+		interfaceAddrs, neighborCommand, solicitNeighbors =
+			defaultInterfaceAddrs, defaultNeighborCommand, defaultSolicitNeighbors
+	}()
+
+	interfaceAddrs = func() ([]localInterfaceAddr, error) {
+		var addrs []localInterfaceAddr
+		for _, cidr := range []string{"10.0.0.2/30", "fd00::2/64"} {
+			ip, ipNet, _ := net.ParseCIDR(cidr)
+			ipNet.IP = ip
+			addrs = append(addrs, localInterfaceAddr{ipNet: ipNet, iface: "eth0"})
+		}
+
+		return addrs, nil
+	}
+
+	neighborCommand = func(ifaceName string) ([]byte, error) {
+		if ifaceName != "eth0" {
+			t.Error("unexpected interface", ifaceName)
+		}
+
+		return []byte("fd00::1 lladdr 00:11:22:33:44:55 REACHABLE\n" +
+			"fd00::2 lladdr 00:11:22:33:44:56 STALE\n" +
+			"fd00:1::1 lladdr 00:11:22:33:44:57 REACHABLE\n" +
+			"fe80::1 lladdr 00:11:22:33:44:58 router REACHABLE\n"), nil
+	}
+
+	solicitedAtLock.Lock()
+	solicitedAt = make(map[string]time.Time)
+	solicitedAtLock.Unlock()
+
+	solicited := 0
+	solicitNeighbors = func(_ string) error {
+		solicited++
+		return nil
+	}
+
+	tests := []struct {
+		subnet  string
+		targets []string
+	}{
+		{"10.0.0.0/30", []string{"10.0.0.1", "10.0.0.3"}},
+		{"fd00::/126", []string{"[fd00::1]:2020", "[fd00::3]:2020"}},
+		{"fd00::/64", []string{"[fd00::1]:2020"}},
+		{"fd00::/64", []string{"[fd00::1]:2020"}},
+	}
+
+	for _, test := range tests {
+		config := NewDefaultConfig()
+		config.BroadcastSubnet = test.subnet
+		s := NewServer(config)
+
+		targets, err := s.broadcastTargets()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if !cmp.Equal(targets, test.targets) {
+			t.Errorf("%s: expected targets %v, got %v", test.subnet, test.targets, targets)
+		}
+	}
+
+	if solicited != 1 {
+		t.Error("expected a single solicitation, got", solicited)
+	}
+}
+
+func TestBroadcastCallback_concurrency(t *testing.T) {
+	defaultInterfaceAddrs := interfaceAddrs
+	defer func() {
+		interfaceAddrs = defaultInterfaceAddrs
+	}()
+
+	interfaceAddrs = func() ([]localInterfaceAddr, error) {
+		ip, ipNet, _ := net.ParseCIDR("10.0.0.2/29")
+		ipNet.IP = ip
+
+		return []localInterfaceAddr{{ipNet: ipNet, iface: "eth0"}}, nil
+	}
+
+	config := NewDefaultConfig()
+	config.BroadcastSubnet = "10.0.0.0/29"
+	config.BroadcastConcurrency = 2
+	s := NewServer(config)
+
+	var lock sync.Mutex
+	active, maxActive, sent := 0, 0, 0
+
+	_ = s.SetConnCallback(func(_ *Server, ip string, _ ...time.Duration) (*Conn, error) {
+		if ip == "10.0.0.3" {
+			return nil, errors.New("unreachable")
+		}
+
+		return &Conn{}, nil
+	})
+	_ = s.SetSendCallback(func(_ *Server, _ *Conn, _ Message) error {
+		lock.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		sent++
+		lock.Unlock()
+
+		time.Sleep(time.Millisecond * 10)
+
+		lock.Lock()
+		active--
+		lock.Unlock()
+
+		return nil
+	})
+
+	err := broadcastCallback(s, Message{Operation: OperationStatus}, true)
+	if err != nil {
+		t.Error("unreachable addresses reported:", err)
+	}
+
+	if sent != 5 { // 10.0.0.1 to 10.0.0.7, without the local and the unreachable addresses
+		t.Error("unexpected amount of messages sent:", sent)
+	}
+
+	if maxActive > 2 {
+		t.Error("concurrency limit exceeded:", maxActive)
+	}
+}
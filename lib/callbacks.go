@@ -23,18 +23,35 @@
 package beekeeper
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/disk"
 	"github.com/shirou/gopsutil/host"
+	"github.com/shirou/gopsutil/mem"
+	"go.opentelemetry.io/otel/trace"
 	"math"
+	"path/filepath"
 	"runtime"
 	"time"
 )
 
-// jobResultCallback is the callback for the JobResult operation.
-func jobResultCallback(_ *Server, _ *Conn, _ Message) {
-	// Only updating the node list is needed. Kept for consistency
-	return
+// jobResultCallback is the callback for the JobResult operation. Errors reported by the node are kept in its error
+// history.
+func jobResultCallback(s *Server, _ *Conn, msg Message) {
+	if msg.Addr == nil {
+		return
+	}
+
+	res, err := decodeResult(msg.Data)
+	if err != nil {
+		return // Handled when checking the awaited tasks
+	}
+
+	if res.Error != "" {
+		s.addNodeError(msg.Addr.IP, res.Error)
+	}
 }
 
 // transferStatusCallback is the callback for the JobTransferAcknowledge and JobTransferFailed operations.
@@ -45,48 +62,37 @@ func transferStatusCallback(_ *Server, _ *Conn, _ Message) {
 
 // statusCallback is the callback for the Status operation.
 func statusCallback(s *Server, conn *Conn, _ Message) {
-	ni := NodeInfo{}
-
-	// CPU Usage
-	usageSlice, err := cpu.Percent(time.Second, false)
-	if err == nil && len(usageSlice) > 0 {
-		ni.Usage = float32(usageSlice[0])
-	}
-
-	// CPU Temp
-	ni.CPUTemp = getCPUTemp()
-
-	err = s.sendWithConn(conn, Message{NodeInfo: ni})
+	err := s.sendWithConn(conn, Message{NodeInfo: s.getNodeInfo()})
 	if err != nil {
-		logger.Errorln("Unable to respond to a status request:", err)
+		s.log().Errorln("Unable to respond to a status request:", err)
 		return
 	}
 }
 
 // jobTransferCallback is the callback for the JobTransfer operation.
 func jobTransferCallback(s *Server, conn *Conn, msg Message) {
-	logger.Infoln("Starting job transfer from node", msg.Name)
+	s.log().Infoln("Starting job transfer from node", msg.Name)
 
-	folderPath := ".beekeeper"
+	folderPath := filepath.Clean(s.Config.workDir())
 	err := createFolderIfNotExist(folderPath)
 	if err != nil {
-		logger.Println("Unable to create beekeeper folder:", err.Error())
+		s.log().Println("Unable to create beekeeper folder:", err.Error())
 		respondTransferError(s, conn, err.Error())
 
 		return
 	}
 
 	if len(msg.Data) == 0 {
-		logger.Errorln("Unable to save job data: empty data field")
+		s.log().Errorln("Unable to save job data: empty data field")
 		respondTransferError(s, conn, "empty data field")
 
 		return
 	}
 
-	binPath := folderPath + "/job.bin"
+	binPath := s.Config.jobBinaryPath()
 	err = saveBinary(binPath, msg.Data)
 	if err != nil {
-		logger.Errorln("Unable to save job data:", err)
+		s.log().Errorln("Unable to save job data:", err)
 		respondTransferError(s, conn, err.Error())
 
 		return
@@ -94,41 +100,104 @@ func jobTransferCallback(s *Server, conn *Conn, msg Message) {
 
 	err = s.sendWithConn(conn, Message{Operation: OperationTransferAcknowledge})
 	if err != nil {
-		logger.Println("Failed to acknowledge transfer:", err)
+		s.log().Println("Failed to acknowledge transfer:", err)
 
 		return
 	}
 
-	logger.Println("Job transferred successfully from node", msg.Name)
+	s.log().Println("Job transferred successfully from node", msg.Name)
+}
+
+// binaryQueryCallback is the callback for the BinaryQuery operation. The node responds whether its job binary has the
+// checksum sent in the Data.
+func binaryQueryCallback(s *Server, conn *Conn, msg Message) {
+	var op Operation = OperationBinaryMissing
+
+	sum, err := fileChecksum(s.Config.jobBinaryPath())
+	if err == nil && bytes.Equal(sum, msg.Data) {
+		op = OperationBinaryPresent
+	}
+
+	err = s.sendWithConn(conn, Message{Operation: op})
+	if err != nil {
+		s.log().Errorln("Unable to respond to a binary query:", err)
+		return
+	}
 }
 
 // jobExecuteCallback is the callback for the JobExecute operation.
 func jobExecuteCallback(s *Server, conn *Conn, msg Message) {
 	task, err := decodeTask(msg.Data)
 	if err != nil {
-		logger.Errorln("Unable to read task data:", err)
+		s.log().Errorln("Unable to read task data:", err)
 		return
 	}
 
-	logger.Infoln("Executing task", task.UUID, "for node", msg.Name)
+	if !s.acquireTaskSlot() {
+		s.log().Warnln("Rejecting task", task.UUID, "from node", msg.Name+": too many tasks running")
+		respondBusy(s, conn, task.UUID)
+
+		return
+	}
+
+	defer s.releaseTaskSlot()
+
+	s.log().Infoln("Executing task", task.UUID, "for node", msg.Name)
 
+	startedAt := time.Now()
 	s.Status = StatusWorking
 
-	res, err := runLocalJob(task)
+	_, endSpan := s.startSpan(SpanRunJob, trace.SpanKindServer, msg.TraceContext)
+
+	var res Result
+	if s.Config.DryRun {
+		s.log().Infoln("Dry run: skipping execution of task", task.UUID)
+		res = Result{UUID: task.UUID, Task: task}
+	} else {
+		if task.MaxOutputBytes == 0 {
+			task.MaxOutputBytes = s.Config.MaxMessageSize
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		s.addRunningTask(task.UUID, cancel)
+
+		res, err = runLocalJob(ctx, s.Config.jobBinaryPath(), task, s.Config.MaxInlineResultSize)
+
+		s.removeRunningTask(task.UUID)
+		cancel()
+	}
+
 	if err != nil {
-		errMsg := "Unable to run job: " + err.Error()
-		logger.Errorln(errMsg)
+		s.log().Errorln("Unable to run job:", err)
+
+		remoteErr := newRemoteError(err)
+		remoteErr.Message = "Unable to run job: " + remoteErr.Message
+
+		res = newRemoteErrorResult(remoteErr)
+		res.UUID = task.UUID
+	}
 
-		res = Result{UUID: task.UUID, Error: errMsg}
+	if res.RemoteError != nil {
+		res.RemoteError.NodeName = s.Config.Name
+		endSpan(res.RemoteError)
+	} else {
+		endSpan(nil)
 	}
 
-	logger.Infoln("Ran task", task.UUID, "successfully")
+	s.log().Infoln("Ran task", task.UUID, "successfully")
 
 	s.Status = StatusIDLE
 
+	s.addExecutionSummary(ExecutionSummary{
+		UUID:      task.UUID,
+		StartedAt: startedAt,
+		Duration:  time.Since(startedAt),
+		Error:     res.Error != "",
+	})
+
 	resBytes, err := res.encode()
 	if err != nil {
-		logger.Errorln("Unable to encode response:", err)
+		s.log().Errorln("Unable to encode response:", err)
 		return
 	}
 
@@ -137,16 +206,131 @@ func jobExecuteCallback(s *Server, conn *Conn, msg Message) {
 		Data:      resBytes,
 	})
 	if err != nil {
-		logger.Errorln("Failed to send job result:", err)
+		s.log().Errorln("Failed to send job result:", err)
 		return
 	}
 }
 
+// acquireTaskSlot reserves one of the Config.WorkerConcurrency task slots, if any is free. It reports if the slot was
+// reserved; it must be released with releaseTaskSlot.
+func (s *Server) acquireTaskSlot() bool {
+	if s.taskSlots == nil {
+		return true
+	}
+
+	select {
+	case s.taskSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseTaskSlot frees a task slot reserved with acquireTaskSlot.
+func (s *Server) releaseTaskSlot() {
+	if s.taskSlots == nil {
+		return
+	}
+
+	<-s.taskSlots
+}
+
+// respondBusy answers a task that can't be run because all task slots are in use. The Busy message carries a failed
+// Result so that the task can be awaited as usual.
+func respondBusy(s *Server, conn *Conn, uuid string) {
+	res := newRemoteErrorResult(&RemoteError{
+		Code:     ErrorCodeNodeBusy,
+		Message:  "node busy",
+		NodeName: s.Config.Name,
+	})
+	res.UUID = uuid
+
+	data, err := res.encode()
+	if err != nil {
+		s.log().Errorln("Unable to encode response:", err)
+		return
+	}
+
+	err = s.sendWithConn(conn, Message{Operation: OperationBusy, Data: data})
+	if err != nil {
+		s.log().Errorln("Failed to send busy response:", err)
+	}
+}
+
+// getNodeInfo measures the current usage of the host system. It takes at least one second.
+func (s *Server) getNodeInfo() NodeInfo {
+	ni := NodeInfo{
+		Arch:             runtime.GOARCH,
+		RunningTasks:     s.runningTaskCount(),
+		GoVersion:        runtime.Version(),
+		BeekeeperVersion: Version,
+		Labels:           s.Config.Labels,
+	}
+
+	// CPU Usage
+	usageSlice, err := cpu.Percent(time.Second, false)
+	if err == nil && len(usageSlice) > 0 {
+		ni.Usage = float32(usageSlice[0])
+	}
+
+	// CPU Temp
+	ni.CPUTemp = getCPUTemp()
+
+	ni.CoreTemps = getCoreTemps()
+
+	// Memory
+	vm, err := mem.VirtualMemory()
+	if err == nil {
+		ni.MemFree = vm.Available
+		ni.MemTotal = vm.Total
+		ni.MemUsed = vm.Used
+	}
+
+	// Disk
+	usage, err := disk.Usage(".")
+	if err == nil {
+		ni.DiskTotal = usage.Total
+		ni.DiskFree = usage.Free
+	}
+
+	return ni
+}
+
+// getCoreTemps returns the temperature of every CPU core. Only Linux is supported, other OS return nil.
+func getCoreTemps() []float32 {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	temps, err := host.SensorsTemperatures()
+	if err != nil {
+		return nil
+	}
+
+	var coreTemps []float32
+	for coreNum := 0; ; coreNum++ {
+		key := fmt.Sprintf("coretemp_core%d_input", coreNum)
+
+		found := false
+		for _, sensor := range temps {
+			if sensor.SensorKey == key {
+				coreTemps = append(coreTemps, float32(math.Round(sensor.Temperature*10)/10))
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return coreTemps
+		}
+	}
+}
+
 // respondTransferError is a shorthand for sending a TransferFailed operation to the remote node.
 func respondTransferError(s *Server, conn *Conn, errMsg string) {
 	err := s.sendWithConn(conn, Message{Operation: OperationTransferFailed, Data: []byte(errMsg)})
 	if err != nil {
-		logger.Errorln("An additional error arose while reporting the transfer error:", err.Error())
+		s.log().Errorln("An additional error arose while reporting the transfer error:", err.Error())
 	}
 }
 
@@ -94,3 +94,51 @@ func TestJobTransferCallback_Failed(t *testing.T) {
 	}
 
 }
+
+func TestJobExecuteCallback_Busy(t *testing.T) {
+	sv := NewServer(NewDefaultConfig())
+
+	sent := make(chan Message, 1)
+	_ = sv.SetSendCallback(func(_ *Server, _ *Conn, m Message) error {
+		sent <- m
+		return nil
+	})
+
+	sv.taskSlots <- struct{}{} // Take the only slot
+
+	task := Task{UUID: "busy-task"}
+	data, err := task.encode()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	msg := getTestMessage()
+	msg.Operation = OperationJobExecute
+	msg.Data = data
+
+	go jobExecuteCallback(sv, &Conn{}, msg)
+
+	var response Message
+	select {
+	case response = <-sent:
+	case <-time.After(time.Second):
+		t.Error("busy response not sent")
+		return
+	}
+
+	if response.Operation != OperationBusy {
+		t.Error("unexpected operation", response.Operation)
+		return
+	}
+
+	res, err := decodeResult(response.Data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res.UUID != task.UUID || res.ErrorCode() != ErrorCodeNodeBusy {
+		t.Error("unexpected result", res)
+	}
+}
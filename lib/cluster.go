@@ -0,0 +1,101 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNodeNotFound is produced when no known node has the requested name
+var ErrNodeNotFound = errors.New("node not found")
+
+// defaultShutdownPeriod is the time given to Cluster.Shutdown when the context has no deadline.
+const defaultShutdownPeriod = time.Minute
+
+// Cluster is a higher-level API over Server, meant as the entry point for most uses. The underlying Server is still
+// available through Cluster.Server for advanced customization.
+type Cluster struct {
+	server *Server
+}
+
+// NewCluster creates a Cluster with the given Config, or the default one if none is provided. See NewServer.
+func NewCluster(configs ...Config) *Cluster {
+	return &Cluster{server: NewServer(configs...)}
+}
+
+// Server returns the underlying Server.
+func (c *Cluster) Server() *Server {
+	return c.server
+}
+
+// Start serves the cluster's node and blocks until it's stopped. See Server.Start.
+func (c *Cluster) Start() error {
+	return c.server.Start()
+}
+
+// Workers returns the known nodes.
+func (c *Cluster) Workers() Nodes {
+	return c.server.Nodes()
+}
+
+// Submit runs the task in the background on one of the known nodes, selected by the load balancer. ErrNoNodes is
+// returned if no node is known.
+func (c *Cluster) Submit(t Task) (*Future, error) {
+	if len(c.Workers()) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	return newFuture(c.server, t, func(t Task, onSent func(string, Node)) (Result, error) {
+		return c.server.executeBalanced(t, onSent)
+	}), nil
+}
+
+// SubmitTo runs the task in the background on the known node with the given name. ErrNodeNotFound is returned if there
+// is no such node.
+func (c *Cluster) SubmitTo(name string, t Task) (*Future, error) {
+	n, found := c.server.GetNodeByName(name)
+	if !found {
+		return nil, ErrNodeNotFound
+	}
+
+	return newFuture(c.server, t, func(t Task, onSent func(string, Node)) (Result, error) {
+		return c.server.execute(n, t, onSent)
+	}), nil
+}
+
+// Shutdown drains the cluster's pending tasks and stops it. Tasks still pending halfway to the context's deadline are
+// cancelled, and the cluster is stopped anyway once it's reached or the context is cancelled. See Server.Drain.
+// Without a deadline one minute is given.
+func (c *Cluster) Shutdown(ctx context.Context) error {
+	period := defaultShutdownPeriod
+	if deadline, ok := ctx.Deadline(); ok {
+		period = time.Until(deadline)
+		if period < 0 {
+			period = 0
+		}
+	}
+
+	return c.server.drain(period/2, period, ctx.Done())
+}
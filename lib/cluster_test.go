@@ -0,0 +1,154 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCluster_SubmitTo(t *testing.T) {
+	c := NewCluster()
+	c.Server().Config.DisableConnectionWatchdog = true
+
+	sent := make(chan Message, 1)
+	_ = c.Server().SetSendCallback(func(_ *Server, _ *Conn, m Message) error {
+		sent <- m
+		return nil
+	})
+
+	_ = c.Server().SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	_, err := c.Submit(NewTask())
+	if err != ErrNoNodes {
+		t.Error("expected ErrNoNodes, got", err)
+		return
+	}
+
+	nodes := getTestNodes()
+	c.Server().nodes = Nodes{nodes[0], nodes[1]}
+
+	if !c.Workers().sameAs(c.Server().nodes) {
+		t.Error("unexpected workers", c.Workers())
+	}
+
+	_, err = c.SubmitTo("unknown", NewTask())
+	if err != ErrNodeNotFound {
+		t.Error("expected ErrNodeNotFound, got", err)
+		return
+	}
+
+	future, err := c.SubmitTo(nodes[1].Name, NewTask())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var task Task
+	select {
+	case msg := <-sent:
+		task, err = decodeTask(msg.Data)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	case <-time.After(time.Second):
+		t.Error("task not sent")
+		return
+	}
+
+	_, err = future.Await(time.Millisecond * 10)
+	if err != ErrTimeout {
+		t.Error("expected ErrTimeout, got", err)
+		return
+	}
+
+	time.Sleep(time.Millisecond * 100) // Let the awaitables register
+
+	response := newMessage()
+	response.Operation = OperationJobResult
+	response, err = response.setData(Result{UUID: task.UUID})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	c.Server().checkAwaited(response)
+
+	select {
+	case <-future.Done():
+	case <-time.After(time.Second):
+		t.Error("future not done")
+		return
+	}
+
+	res, err := future.Await()
+	if err != nil || res.UUID != task.UUID {
+		t.Error("unexpected result", res.UUID, err)
+	}
+}
+
+func TestCluster_Shutdown(t *testing.T) {
+	c := NewCluster()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := c.Shutdown(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = c.Server().WaitForStop(ctx)
+	if err != nil {
+		t.Error("cluster not stopped:", err)
+	}
+}
+
+func TestCluster_ShutdownCancelled(t *testing.T) {
+	c := NewCluster()
+	c.Server().addPendingTask("pending", getTestNodes()[0])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		cancel()
+	}()
+
+	start := time.Now()
+
+	err := c.Shutdown(ctx)
+	if !errors.Is(err, ErrForcedShutdown) {
+		t.Error("expected ErrForcedShutdown, got", err)
+		return
+	}
+
+	if time.Since(start) > time.Second*10 {
+		t.Error("cancelled context ignored")
+	}
+}
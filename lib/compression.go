@@ -0,0 +1,64 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"fmt"
+)
+
+// CompressionAlgorithm is the algorithm used to compress the messages sent.
+type CompressionAlgorithm int
+
+const (
+	// CompressionGzip compresses with gzip
+	CompressionGzip CompressionAlgorithm = iota
+
+	// CompressionZstd compresses with zstd
+	CompressionZstd
+
+	// CompressionNone sends the messages uncompressed
+	CompressionNone
+)
+
+// String returns a string representation of the CompressionAlgorithm.
+func (a CompressionAlgorithm) String() string {
+	switch a {
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionNone:
+		return "none"
+	default:
+		return fmt.Sprintf("CompressionAlgorithm(%d)", int(a))
+	}
+}
+
+// The first byte of an encoded message identifies its compression, so that nodes using different algorithms can
+// communicate. Gzip messages start with the gzip magic number, as sent by nodes that predate the other algorithms,
+// while the rest are prefixed by one of these.
+const (
+	gzipMagic          = 0x1f
+	uncompressedPrefix = 0x01
+	zstdPrefix         = 0x02
+)
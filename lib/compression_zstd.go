@@ -0,0 +1,82 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"sync"
+)
+
+// zstdEncoder is shared by all messages, as its EncodeAll method is safe for concurrent use. Without options it can't
+// fail to be created.
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+// zstdDecoders holds a shared *zstd.Decoder for every message size limit, keyed by the limit, as it can only be set
+// when the decoder is created. Their DecodeAll method is safe for concurrent use.
+var zstdDecoders sync.Map
+
+// zstdCompress compresses the data with zstd.
+func zstdCompress(data []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+// zstdDecompress decompresses data compressed with zstdCompress. Frames decompressing to more than maxSize bytes are
+// rejected with ErrMessageTooLarge before they are decompressed, unless maxSize is 0.
+func zstdDecompress(data []byte, maxSize uint64) ([]byte, error) {
+	decoder, err := zstdDecoderFor(maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed, err := decoder.DecodeAll(data, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return nil, ErrMessageTooLarge
+	}
+
+	return decompressed, err
+}
+
+// zstdDecoderFor returns the shared decoder for the size limit, creating it if needed.
+func zstdDecoderFor(maxSize uint64) (*zstd.Decoder, error) {
+	if decoder, ok := zstdDecoders.Load(maxSize); ok {
+		return decoder.(*zstd.Decoder), nil
+	}
+
+	var opts []zstd.DOption
+	if maxSize > 0 {
+		opts = append(opts, zstd.WithDecoderMaxMemory(maxSize))
+	}
+
+	decoder, err := zstd.NewReader(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, loaded := zstdDecoders.LoadOrStore(maxSize, decoder)
+	if loaded {
+		decoder.Close() // Created concurrently by another message
+	}
+
+	return stored.(*zstd.Decoder), nil
+}
@@ -24,6 +24,8 @@ package beekeeper
 
 import (
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/trace"
+	"path/filepath"
 	"time"
 )
 
@@ -46,9 +48,19 @@ type Config struct {
 	// Debug toggles between verbosity for debugging.
 	Debug bool `mapstructure:"debug,omitempty"`
 
-	// Token is a passphrase used to restrict usage of the node. Must match on the receiving node.
+	// Token is a passphrase used to restrict usage of the node. Must match on the receiving node. If
+	// TokenHashAlgorithm is set, it holds the hash of the passphrase instead. See Config.HashToken.
 	Token string `mapstructure:"token,omitempty"`
 
+	// TokenFile is the path of a file holding the Token, like a mounted Kubernetes or Docker secret. It's read when
+	// the server is created. Token must be empty if it's set.
+	TokenFile string `mapstructure:"token_file,omitempty"`
+
+	// TokenHashAlgorithm is the algorithm used to hash Token. Messages always carry the plain passphrase, which is
+	// compared against the hash. A node with a hashed Token replies using the passphrase received from the primary
+	// node, so it's unable to start connections on its own. Defaults to HashPlain.
+	TokenHashAlgorithm HashAlgorithm `mapstructure:"token_hash_algorithm,omitempty"`
+
 	// InboundPort is the port to be used for receiving connections. Defaults to 2020.
 	InboundPort int `mapstructure:"inbound_port,omitempty"`
 
@@ -64,6 +76,16 @@ type Config struct {
 	// run and reused as needed.
 	TLSPrivateKey []byte
 
+	// TLSCA is a PEM encoded CA certificate. If set, connections in both directions are only accepted if the remote
+	// node presents a certificate signed by it, so TLSCertificate and TLSPrivateKey must be set, and signed by it
+	// too. See NewTLSCA and NewCASignedCertificate. Defaults to nil, meaning that any certificate is accepted.
+	TLSCA []byte
+
+	// TracerProvider is the OpenTelemetry provider of the tracer that starts the spans of the tasks sent and run by the
+	// node. Their trace context is propagated to the other nodes in the W3C format. It's left out of snapshots.
+	// Defaults to nil, meaning no tracing.
+	TracerProvider trace.TracerProvider `json:"-"`
+
 	// AllowExternal sets whether non-local connections should be accepted. It's heavily encouraged that a whitelist
 	// and token is set with this featured turn on. Defaults to false.
 	AllowExternal bool `mapstructure:"allow_external,omitempty"`
@@ -80,6 +102,162 @@ type Config struct {
 
 	// DisableConnectionWatchdog disables the connection watchdog, and stops disconnection notifications.
 	DisableConnectionWatchdog bool `mapstructure:"disable_connection_watchdog,omitempty"`
+
+	// DisableConnPool stops reusing the Conn structs of short-lived connections. Meant for debugging. It only affects
+	// the memory of the structs, and open connections are still reused as set by IdleConnsPerNode.
+	DisableConnPool bool `mapstructure:"disable_conn_pool,omitempty"`
+
+	// IdleConnsPerNode is the maximum amount of idle connections kept open for every node, to be reused by later
+	// messages instead of dialing again. When all of them are in use a new connection is dialed. A value of 0 closes
+	// every connection after its message is sent. Defaults to 4.
+	IdleConnsPerNode int `mapstructure:"idle_conns_per_node,omitempty"`
+
+	// IdleConnTimeout is the time an idle connection kept by IdleConnsPerNode stays open before closing it. A value of
+	// 0 keeps them until the server is stopped. Defaults to 90 s.
+	IdleConnTimeout time.Duration `mapstructure:"idle_conn_timeout,omitempty"`
+
+	// NodeErrorHistorySize is the amount of recent errors kept for every node. Defaults to 10.
+	NodeErrorHistorySize int `mapstructure:"node_error_history_size,omitempty"`
+
+	// CertExpiryWarningDays is the amount of days before the TLS certificate expiry at which a warning is logged.
+	// Defaults to 30.
+	CertExpiryWarningDays int `mapstructure:"cert_expiry_warning_days,omitempty"`
+
+	// MaxConcurrentTasksPerNode is the maximum amount of tasks sent at once to a single node when executing a batch of
+	// tasks. Defaults to 1.
+	MaxConcurrentTasksPerNode int `mapstructure:"max_concurrent_tasks_per_node,omitempty"`
+
+	// WorkerConcurrency is the maximum amount of tasks this node runs at once. Tasks received while the limit is
+	// reached are answered with OperationBusy. A value of 0 means no limit. Defaults to 1.
+	WorkerConcurrency int `mapstructure:"worker_concurrency,omitempty"`
+
+	// MaxNodesPerScan is the maximum amount of nodes returned by a scan. Scans stop as soon as that many nodes respond,
+	// and the monitor shows no more than that many nodes. The server doesn't keep more than that many nodes either, so
+	// new nodes are discarded once it's reached, and Connect fails with ErrNodeLimitReached. Defaults to 0, meaning no
+	// limit.
+	MaxNodesPerScan int `mapstructure:"max_nodes_per_scan,omitempty"`
+
+	// ExecutionHistorySize is the amount of task executions kept in the server's history. Defaults to 100.
+	ExecutionHistorySize int `mapstructure:"execution_history_size,omitempty"`
+
+	// WorkerHistorySize is the amount of tasks run by this node kept to answer history queries from a primary node.
+	// See Server.QueryNodeHistory. Defaults to 100.
+	WorkerHistorySize int `mapstructure:"worker_history_size,omitempty"`
+
+	// Transport is the protocol used for connections between nodes. TransportQUIC requires building with the quic
+	// build tag. Defaults to TransportTCP.
+	Transport TransportType `mapstructure:"transport,omitempty"`
+
+	// Compression is the algorithm used to compress the messages sent. Messages are read whatever algorithm the sender
+	// used. Defaults to CompressionGzip.
+	Compression CompressionAlgorithm `mapstructure:"compression,omitempty"`
+
+	// SendBytesPerSecond limits the bytes per second sent through every connection. Defaults to 0, meaning no limit.
+	SendBytesPerSecond float64 `mapstructure:"send_bytes_per_second,omitempty"`
+
+	// BuildFlags are the linker flags passed to go build when building jobs. Defaults to -s and -w, which are also used
+	// if it's nil. Set it to an empty slice to build without linker flags. Jobs are always built with -trimpath, so
+	// that rebuilding a job produces the same binary and the nodes that already have it can be skipped.
+	BuildFlags []string `mapstructure:"build_flags,omitempty"`
+
+	// BuildTags are the build tags passed to go build when building jobs.
+	BuildTags []string `mapstructure:"build_tags,omitempty"`
+
+	// BuildParallelism is the maximum amount of distributions built at once when distributing a job. 0 builds all of
+	// them at once.
+	BuildParallelism int `mapstructure:"build_parallelism,omitempty"`
+
+	// WorkDir is the folder used to store builds, received jobs and files. Relative paths are resolved from the
+	// working directory. Defaults to .beekeeper.
+	WorkDir string `mapstructure:"work_dir,omitempty"`
+
+	// GoModuleRoot is the root folder of the Go module containing the jobs to build. Defaults to none, meaning the
+	// closest folder with a go.mod file above the working directory is used.
+	GoModuleRoot string `mapstructure:"go_module_root,omitempty"`
+
+	// AllowAffinityFallback allows tasks with an affinity node to run on a node chosen by a LoadBalancer when the
+	// affinity node is offline. Defaults to false.
+	AllowAffinityFallback bool `mapstructure:"allow_affinity_fallback,omitempty"`
+
+	// DryRun logs the messages instead of sending them, skips job builds and executions, and mocks the responses from
+	// the nodes. It's meant for validating setups without side effects.
+	DryRun bool `mapstructure:"dry_run,omitempty"`
+
+	// MaxInlineResultSize is the size limit in bytes for results sent inline by jobs wrapped with WrapJobToFile.
+	// Bigger results are passed through a temporary file. Defaults to 64 MB.
+	MaxInlineResultSize uint64 `mapstructure:"max_inline_result_size,omitempty"`
+
+	// HeartbeatInterval is the time between status updates pushed by a node to the last primary node that contacted
+	// it. Defaults to 0, meaning no updates are pushed.
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval,omitempty"`
+
+	// Labels describe the node, like its hardware or location, to let primary nodes pick the nodes for a task with
+	// Nodes.WithLabel. They are sent with every message.
+	Labels map[string]string `mapstructure:"labels,omitempty"`
+
+	// Capabilities lists the optional features enabled on this node. A feature is only used on a connection if the
+	// remote node enables it too, which is negotiated during the handshake.
+	Capabilities []string `mapstructure:"capabilities,omitempty"`
+
+	// BackoffBase is the time a node is not dialed again after a failed connection attempt. It doubles on every
+	// consecutive failure, up to BackoffMax. A value of 0 disables the backoff. Defaults to 500 ms.
+	BackoffBase time.Duration `mapstructure:"backoff_base,omitempty"`
+
+	// BackoffMax is the maximum time a node is not dialed again after consecutive failed connection attempts.
+	// Defaults to 30 s.
+	BackoffMax time.Duration `mapstructure:"backoff_max,omitempty"`
+
+	// BroadcastConcurrency is the maximum amount of nodes dialed at once by Server.Multicast and the broadcasts of the
+	// status requests, and of files sent at once by Server.TransferFiles. Defaults to 0, meaning no limit for the
+	// broadcasts and 8 concurrent files for Server.TransferFiles.
+	BroadcastConcurrency int `mapstructure:"broadcast_concurrency,omitempty"`
+
+	// ScanConcurrency is the maximum amount of IPs dialed at once by Server.ScanRange, Server.ScanCIDR and
+	// Server.ScanIPs. A value of 0 means no limit. Defaults to 256.
+	ScanConcurrency int `mapstructure:"scan_concurrency,omitempty"`
+
+	// BroadcastSubnet is the subnet reached by broadcasts and Scan, in CIDR notation, like 192.168.0.0/24 or
+	// fd00::/64. IPv4 subnets can have up to 2^16 addresses. Defaults to none, meaning the /24 of the local IPv4
+	// address and the IPv6PrefixLength prefix of the local IPv6 address are used.
+	BroadcastSubnet string `mapstructure:"broadcast_subnet,omitempty"`
+
+	// IPv6PrefixLength is the length of the prefix of the local IPv6 address reached by broadcasts when no
+	// BroadcastSubnet is set. Only unique local and global addresses are used. Prefixes too big to be swept are
+	// reached through the neighbor cache, after pinging the all-nodes multicast address. Defaults to 64.
+	IPv6PrefixLength int `mapstructure:"ipv6_prefix_length,omitempty"`
+
+	// TLSCipherSuites lists the TLS cipher suites offered by the listener and the dialer. If set, TLS 1.3 is disabled
+	// as its suites can't be configured. Defaults to none, meaning Go's defaults are used. See FIPSCipherSuites.
+	TLSCipherSuites []uint16 `mapstructure:"tls_cipher_suites,omitempty"`
+
+	// EnableProxyProtocol makes the server expect a PROXY protocol v2 header at the start of every incoming TCP
+	// connection, as sent by TCP load balancers. The source address in the header is used as the sender's address,
+	// including when checking AllowExternal and Whitelist.
+	EnableProxyProtocol bool `mapstructure:"enable_proxy_protocol,omitempty"`
+
+	// ReusePort sets SO_REUSEPORT on the TCP listener, allowing it to bind a port still held by a previous process.
+	// Only supported on Linux.
+	ReusePort bool `mapstructure:"reuse_port,omitempty"`
+
+	// ExitOnDisconnect makes the process exit when a primary node disconnects this node with Server.DisconnectNode.
+	ExitOnDisconnect bool `mapstructure:"exit_on_disconnect,omitempty"`
+
+	// AllowBinaryDownload lets primary nodes ask this node to download its job binary from an URL, see
+	// Server.DistributeJobURL. Disabled by default.
+	AllowBinaryDownload bool `mapstructure:"allow_binary_download,omitempty"`
+
+	// AllowMultipleInstances disables the warning shown when another process is already listening on InboundPort,
+	// as happens when a primary node and a node run on the same machine.
+	AllowMultipleInstances bool `mapstructure:"allow_multiple_instances,omitempty"`
+
+	// PersistentQueuePath is the path of a file where the received job executions and transfers are written, without
+	// their token, before being processed. The ones left unprocessed, e.g. after a crash, are processed again on the
+	// next start. Defaults to none, meaning messages are not persisted.
+	PersistentQueuePath string `mapstructure:"persistent_queue_path,omitempty"`
+
+	// PerMessageReadTimeout is the time allowed for every incoming message to be read. A connection that doesn't
+	// deliver a full message within it, including idle connections, is closed. Defaults to 0, meaning no timeout.
+	PerMessageReadTimeout time.Duration `mapstructure:"per_message_read_timeout,omitempty"`
 }
 
 // NewDefaultConfig returns a new Config with sensible defaults. It's recommended that NewDefaultConfig be used.
@@ -97,10 +275,40 @@ func NewDefaultConfig() (c Config) {
 	c.DisableCleanup = false
 	c.AllowExternal = false
 	c.MaxMessageSize = (1 << 20) * 1000 // 1.048 MB
+	c.NodeErrorHistorySize = 10
+	c.CertExpiryWarningDays = 30
+	c.MaxConcurrentTasksPerNode = 1
+	c.WorkerConcurrency = 1
+	c.ExecutionHistorySize = 100
+	c.WorkerHistorySize = 100
+	c.Transport = TransportTCP
+	c.BuildFlags = defaultBuildFlags()
+	c.WorkDir = beekeeperFolder
+	c.MaxInlineResultSize = defaultMaxInlineResultSize
+	c.BackoffBase = time.Millisecond * 500
+	c.BackoffMax = time.Second * 30
+	c.IPv6PrefixLength = 64
+	c.ScanConcurrency = 256
+	c.IdleConnsPerNode = 4
+	c.IdleConnTimeout = time.Second * 90
 
 	return c
 }
 
+// workDir returns WorkDir, or the default folder if it's not set.
+func (c Config) workDir() string {
+	if c.WorkDir == "" {
+		return beekeeperFolder
+	}
+
+	return c.WorkDir
+}
+
+// jobBinaryPath returns the path where the job binary received by the node is stored.
+func (c Config) jobBinaryPath() string {
+	return filepath.Join(c.workDir(), "job.bin")
+}
+
 // NewConfigFromFile parses a file on the provided path as a Config object. If a field is not set, the default value
 // is assigned.
 func NewConfigFromFile(path string) (c Config, err error) {
@@ -25,6 +25,7 @@ package beekeeper
 import (
 	"github.com/google/go-cmp/cmp"
 	"testing"
+	"time"
 )
 
 func TestNewConfigFromFile(t *testing.T) {
@@ -37,6 +38,21 @@ func TestNewConfigFromFile(t *testing.T) {
 		DisableCleanup:            true,
 		DisableConnectionWatchdog: true,
 		MaxMessageSize:            9999999,
+		NodeErrorHistorySize:      10,
+		CertExpiryWarningDays:     30,
+		MaxConcurrentTasksPerNode: 1,
+		WorkerConcurrency:         1,
+		ExecutionHistorySize:      100,
+		WorkerHistorySize:         100,
+		BuildFlags:                []string{"-s", "-w"},
+		WorkDir:                   ".beekeeper",
+		MaxInlineResultSize:       (1 << 20) * 64,
+		BackoffBase:               time.Millisecond * 500,
+		BackoffMax:                time.Second * 30,
+		IPv6PrefixLength:          64,
+		ScanConcurrency:           256,
+		IdleConnsPerNode:          4,
+		IdleConnTimeout:           time.Second * 90,
 		Whitelist:                 []string{"*", "111.111.111.111", "0.0.0.0"},
 	}
 
@@ -23,6 +23,8 @@
 package beekeeper
 
 import (
+	"bufio"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
@@ -30,12 +32,143 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-// Conn represents a TLS connection
+// connPool holds reusable Conn structs, saving an allocation for every short-lived connection. It's shared by all
+// servers, as released structs are zeroed.
+var connPool = sync.Pool{
+	New: func() interface{} {
+		return new(Conn)
+	},
+}
+
+// Conn represents a TLS connection. TLS over TCP connections are kept in the embedded tls.Conn, while the ones made
+// through other transports, like QUIC streams, are only reachable through the methods of Conn.
 type Conn struct {
 	*tls.Conn
+
+	// stream is the connection used in place of Conn by transports other than TLS over TCP.
+	stream net.Conn
+
+	// limiter limits the bytes per second sent through the connection. A nil limiter means no limit.
+	limiter *rateLimiter
+
+	// capabilities holds the optional features negotiated for the connection during the handshake. Only features
+	// enabled on both nodes are present.
+	capabilities *capabilitySet
+
+	// auth holds the token authenticated on the connection. It's nil for connections made by this node.
+	auth *connAuth
+
+	// reader buffers the data read by ReadMessageWithTimeout, so that it's not lost between messages.
+	reader *bufio.Reader
+
+	// pooled is true for the Conn structs taken from the connPool by acquireConn. Only those are released to it.
+	pooled bool
+}
+
+// newConn wraps the connection, keeping it in Conn if it's a TLS over TCP one, and in stream otherwise.
+func newConn(c net.Conn) Conn {
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		return Conn{Conn: tlsConn}
+	}
+
+	return Conn{stream: c}
+}
+
+// netConn returns the underlying connection, or nil if there's none.
+func (c *Conn) netConn() net.Conn {
+	if c.Conn != nil {
+		return c.Conn
+	}
+
+	if c.stream != nil {
+		return c.stream
+	}
+
+	return nil
+}
+
+// Read reads data from the connection.
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.netConn().Read(b)
+}
+
+// Write writes data to the connection.
+func (c *Conn) Write(b []byte) (int, error) {
+	return c.netConn().Write(b)
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.netConn().Close()
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.netConn().LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.netConn().RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.netConn().SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.netConn().SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.netConn().SetWriteDeadline(t)
+}
+
+// HasCapability reports if the feature was negotiated for the connection.
+func (c *Conn) HasCapability(name string) bool {
+	return c.capabilities.has(name)
+}
+
+// ReadMessageWithTimeout reads a single Message from the connection. If the Message isn't fully read within the
+// timeout an error is returned, and a timeout of 0 waits indefinitely. Messages bigger than maxSize bytes are rejected.
+// The read deadline is cleared before returning.
+func (c *Conn) ReadMessageWithTimeout(timeout time.Duration, maxSize uint64) (Message, error) {
+	if c.reader == nil {
+		c.reader = bufio.NewReader(c.netConn())
+	}
+
+	return readWithDeadline(c.netConn(), timeout, func() (Message, error) {
+		return readMessage(c.netConn(), c.reader, maxSize, true, nil)
+	})
+}
+
+// readWithDeadline calls read with the read deadline of the connection set to the timeout, and clears it afterwards.
+// A timeout of 0 calls read without a deadline.
+func readWithDeadline(conn net.Conn, timeout time.Duration, read func() (Message, error)) (Message, error) {
+	if timeout <= 0 {
+		return read()
+	}
+
+	err := conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return Message{}, err
+	}
+
+	msg, err := read()
+
+	resetErr := conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return Message{}, err
+	}
+
+	return msg, resetErr
 }
 
 // dial establishes a new connection to the node using TLS over TCP.
@@ -46,12 +179,21 @@ func (s *Server) dial(ip string, timeout ...time.Duration) (*Conn, error) {
 // defaultConnCallback creates a connection with the ip. It exists to allow for testing without actually
 // creating connections.
 func defaultConnCallback(s *Server, ip string, timeout ...time.Duration) (*Conn, error) {
+	if s.Config.DryRun {
+		s.log().Debugln("Dry run: skipping connection to", ip)
+		return s.acquireConn(), nil
+	}
+
+	if s.Config.Transport == TransportQUIC {
+		return quicConnCallback(s, ip, timeout...)
+	}
+
 	cert, err := tls.X509KeyPair(s.Config.TLSCertificate, s.Config.TLSPrivateKey)
 	if err != nil {
-		logger.Fatalln("Failed to parse TLS certificate")
+		s.log().Fatalln("Failed to parse TLS certificate")
 	}
 
-	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, InsecureSkipVerify: true}
+	tlsConfig := newTLSConfig(s.Config, cert)
 
 	var d *net.Dialer
 	if len(timeout) > 0 {
@@ -65,10 +207,47 @@ func defaultConnCallback(s *Server, ip string, timeout ...time.Duration) (*Conn,
 		return nil, err
 	}
 
-	go s.handle(tlsConn) // Be prepared to receive on this conn
+	conn := s.acquireConn()
+	conn.Conn = tlsConn
+	conn.limiter = newRateLimiter(s.Config.SendBytesPerSecond)
+	conn.capabilities = &capabilitySet{}
+
+	go s.handle(tlsConn, conn.limiter) // Be prepared to receive on this conn
+
+	if len(s.Config.Capabilities) > 0 {
+		err = s.handshake(conn)
+		if err != nil {
+			_ = tlsConn.Close()
+			s.releaseConn(conn)
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// acquireConn returns a zeroed Conn, taken from the connPool unless Config.DisableConnPool is set.
+func (s *Server) acquireConn() *Conn {
+	if s.Config.DisableConnPool {
+		return &Conn{}
+	}
+
+	c := connPool.Get().(*Conn)
+	*c = Conn{pooled: true}
 
-	conn := Conn{tlsConn}
-	return &conn, nil
+	return c
+}
+
+// releaseConn returns the Conn to the connPool once it's no longer used. The underlying connection is left as is, as
+// it may still be read by the handler. Conn structs not taken from the pool, like the ones created by a callback set
+// with SetConnCallback, are left untouched.
+func (s *Server) releaseConn(c *Conn) {
+	if c == nil || !c.pooled {
+		return
+	}
+
+	*c = Conn{}
+	connPool.Put(c)
 }
 
 // defaultSendCallback is used to sendWithConn messages. It exists to allow for testing without actually sending messages.
@@ -76,15 +255,19 @@ func defaultSendCallback(s *Server, c *Conn, m Message) error {
 	m.SentAt = time.Now()
 	m.Name = s.Config.Name
 	m.Status = s.Status
-	m.Token = s.Config.Token
+	if token := s.outgoingToken(c); token != "" {
+		m.Token = token
+	}
 
 	if m.RespondOnPort == 0 {
 		m.RespondOnPort = s.Config.InboundPort
 	}
 
 	m.NodeInfo.OS = runtime.GOOS
+	m.NodeInfo.Arch = runtime.GOARCH
+	m.NodeInfo.Labels = s.Config.Labels
 
-	data, err := m.encode()
+	data, uncompressed, err := m.encodeSized(s.Config.Compression)
 	if err != nil {
 		return err
 	}
@@ -93,15 +276,30 @@ func defaultSendCallback(s *Server, c *Conn, m Message) error {
 		return ErrMessageTooLarge
 	}
 
+	s.messageSizes.Observe(float64(len(data)))
+	s.compressionRatios.Observe(float64(uncompressed) / float64(len(data)))
+
+	if s.Config.DryRun {
+		s.log().Infoln("Dry run: not sent:", m.summary())
+		return nil
+	}
+
 	header := []byte(fmt.Sprintf("%d\n", len(data)))
 	data = append(header, data...)
 
+	err = c.limiter.wait(context.Background(), len(data))
+	if err != nil {
+		return err
+	}
+
 	_, err = c.Write(data)
 	if err != nil {
 		return err
 	}
 
-	logger.Debugln("Sent:", m.summary())
+	s.metrics.sent(len(data))
+
+	s.log().Debugln("Sent:", m.summary())
 
 	return nil
 }
@@ -129,8 +327,51 @@ func setOutPortIfMissing(ip string, port int) string {
 	return ip + ":" + strconv.Itoa(port)
 }
 
-// getLocalIP returns the primary non-loopback local address of the machine.
-func getLocalIP() (ip net.IP, err error) {
+// localInterfaceAddr is an address of one of the machine's network interfaces.
+type localInterfaceAddr struct {
+	ipNet *net.IPNet
+	iface string
+}
+
+// interfaceAddrs lists the addresses of the machine's active, non-loopback interfaces. It's a variable to allow for
+// testing.
+var interfaceAddrs = func() ([]localInterfaceAddr, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []localInterfaceAddr
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range ifaceAddrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				addrs = append(addrs, localInterfaceAddr{ipNet: ipNet, iface: iface.Name})
+			}
+		}
+	}
+
+	return addrs, nil
+}
+
+// getLocalIP returns the local address of the machine inside the subnet. If subnet is nil, or no interface has an
+// address inside it, the primary non-loopback local address is returned.
+func getLocalIP(subnet *net.IPNet) (ip net.IP, err error) {
+	if subnet != nil {
+		addr, ok := localAddrIn(subnet)
+		if ok {
+			return addr.ipNet.IP, nil
+		}
+	}
+
 	conn, err := net.Dial("udp", "1.2.3.4:80")
 	if err != nil {
 		return
@@ -141,3 +382,36 @@ func getLocalIP() (ip net.IP, err error) {
 
 	return
 }
+
+// localAddrIn returns the first interface address inside the subnet.
+func localAddrIn(subnet *net.IPNet) (localInterfaceAddr, bool) {
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return localInterfaceAddr{}, false
+	}
+
+	for _, addr := range addrs {
+		if subnet.Contains(addr.ipNet.IP) {
+			return addr, true
+		}
+	}
+
+	return localInterfaceAddr{}, false
+}
+
+// getLocalIPv6 returns the first routable IPv6 address of the machine, either unique local (ULA) or global (GUA).
+func getLocalIPv6() (localInterfaceAddr, bool) {
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return localInterfaceAddr{}, false
+	}
+
+	for _, addr := range addrs {
+		ip := addr.ipNet.IP
+		if ip.To4() == nil && ip.IsGlobalUnicast() {
+			return addr, true
+		}
+	}
+
+	return localInterfaceAddr{}, false
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// noOpImportPath is the import path of the package holding the job distributed by Diagnose.
+const noOpImportPath = "github.com/CamiloHernandez/beekeeper/lib/noop"
+
+// DiagnosticStep holds the outcome of a single check made by Diagnose.
+type DiagnosticStep struct {
+	// Name describes the check.
+	Name string
+
+	// Err is the error produced by the check. It's nil if the check passed.
+	Err error
+}
+
+// Passed reports if the check was successful.
+func (d DiagnosticStep) Passed() bool {
+	return d.Err == nil
+}
+
+// Diagnose runs a sequence of checks against the node at ip: network reachability, TLS handshake, token
+// authentication, job transfer and task execution. The checks stop at the first failure, and the ones made are
+// returned in order. Every check waits up to the timeout. Note that a successful transfer replaces the job on the
+// node.
+func (s *Server) Diagnose(ip string, timeout time.Duration) []DiagnosticStep {
+	var steps []DiagnosticStep
+	var node Node
+
+	checks := []struct {
+		name  string
+		check func() error
+	}{
+		{"TCP reachability", func() error {
+			if s.Config.Transport != TransportTCP {
+				return nil
+			}
+
+			conn, err := net.DialTimeout("tcp", setOutPortIfMissing(ip, s.Config.OutboundPort), timeout)
+			if err != nil {
+				return err
+			}
+
+			return conn.Close()
+		}},
+		{"TLS handshake", func() error {
+			if s.Config.Transport != TransportTCP {
+				return nil
+			}
+
+			cert, err := tls.X509KeyPair(s.Config.TLSCertificate, s.Config.TLSPrivateKey)
+			if err != nil {
+				return err
+			}
+
+			tlsConfig := newTLSConfig(s.Config, cert)
+			conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp",
+				setOutPortIfMissing(ip, s.Config.OutboundPort), tlsConfig)
+			if err != nil {
+				return err
+			}
+
+			return conn.Close()
+		}},
+		{"Token authentication", func() (err error) {
+			node, err = s.Connect(ip, timeout)
+			return
+		}},
+		{"Job transfer", func() error {
+			return s.DistributeJob(noOpImportPath, "Job", node)
+		}},
+		{"Task execution", func() error {
+			_, err := s.Execute(node, Task{}, timeout)
+			return err
+		}},
+	}
+
+	for _, c := range checks {
+		step := DiagnosticStep{Name: c.name, Err: c.check()}
+		steps = append(steps, step)
+
+		if !step.Passed() {
+			break
+		}
+	}
+
+	return steps
+}
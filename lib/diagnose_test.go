@@ -0,0 +1,74 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServer_DiagnoseUnreachable(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	addr := l.Addr().String()
+	_ = l.Close() // Nothing listens on addr anymore
+
+	steps := s.Diagnose(addr, time.Second)
+	if len(steps) != 1 || steps[0].Passed() {
+		t.Error("unexpected steps:", steps)
+	}
+}
+
+func TestServer_DiagnoseNoTLS(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			_ = conn.Close() // Not a TLS server
+		}
+	}()
+
+	steps := s.Diagnose(l.Addr().String(), time.Second)
+	if len(steps) != 2 || !steps[0].Passed() || steps[1].Passed() {
+		t.Error("unexpected steps:", steps)
+	}
+}
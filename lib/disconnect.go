@@ -0,0 +1,89 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"os"
+)
+
+// exit ends the process. It's a variable to allow for testing.
+var exit = os.Exit
+
+// DisconnectNode tells the node that it's being disconnected, with the reason given, and then closes its connection
+// and forgets it. Callbacks registered with OnNodeStatusChange see the node change to StatusNone. The node is removed
+// even if it can't be notified, in which case the error is returned.
+func (s *Server) DisconnectNode(n Node, reason string) error {
+	err := s.send(n, Message{Operation: OperationDisconnect, Data: []byte(reason)})
+
+	conn := n.Conn
+	if conn == nil {
+		conn = s.knownConn(n)
+	}
+
+	if conn != nil && conn.netConn() != nil {
+		_ = conn.Close()
+	}
+
+	s.removeNode(n)
+
+	return err
+}
+
+// removeNode forgets the node, notifying the status callbacks if it was known.
+func (s *Server) removeNode(n Node) {
+	s.nodesLock.Lock()
+
+	var removed Node
+	found := false
+	for i, node := range s.nodes {
+		if node.Addr.IP.Equal(n.Addr.IP) {
+			removed, found = node, true
+			s.nodes = append(s.nodes[:i], s.nodes[i+1:]...)
+			break
+		}
+	}
+
+	for i, node := range s.clearedNodes {
+		if node.Addr.IP.Equal(n.Addr.IP) {
+			s.clearedNodes = append(s.clearedNodes[:i], s.clearedNodes[i+1:]...)
+			break
+		}
+	}
+
+	s.nodesLock.Unlock()
+
+	if found && removed.Status != StatusNone {
+		s.notifyStatusCallbacks(removed, removed.Status, StatusNone)
+	}
+}
+
+// disconnectCallback is the callback for the Disconnect operation. If Config.ExitOnDisconnect is set the process
+// exits.
+func disconnectCallback(s *Server, _ *Conn, msg Message) {
+	s.log().Warnln("Disconnected by node", msg.Name+":", string(msg.Data))
+
+	if s.Config.ExitOnDisconnect {
+		s.Stop()
+		exit(0)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServer_DisconnectNode(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	sent := make(chan Message, 1)
+	_ = s.SetSendCallback(func(_ *Server, _ *Conn, m Message) error {
+		sent <- m
+		return nil
+	})
+
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	left := make(chan Status, 1)
+	s.OnNodeStatusChange(func(_ Node, _, newStatus Status) {
+		left <- newStatus
+	})
+
+	n := getTestNodes()[0]
+	s.nodes = Nodes{n} // Skip the status callbacks of updateNode
+
+	err := s.DisconnectNode(n, "scaling down")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	msg := <-sent
+	if msg.Operation != OperationDisconnect || string(msg.Data) != "scaling down" {
+		t.Error("unexpected message", msg.summary())
+	}
+
+	if len(s.Nodes()) != 0 {
+		t.Error("node not removed")
+	}
+
+	select {
+	case status := <-left:
+		if status != StatusNone {
+			t.Error("unexpected status", status)
+		}
+	case <-time.After(time.Second):
+		t.Error("status callback not called")
+	}
+}
+
+func TestDisconnectCallback(t *testing.T) {
+	c := NewDefaultConfig()
+	c.ExitOnDisconnect = true
+
+	s := NewServer(c)
+
+	defer func(original func(int)) {
+		exit = original
+	}(exit)
+
+	exited := make(chan int, 1)
+	exit = func(code int) {
+		exited <- code
+	}
+
+	msg := getTestMessage()
+	msg.Operation = OperationDisconnect
+	disconnectCallback(s, &Conn{}, msg)
+
+	select {
+	case code := <-exited:
+		if code != 0 {
+			t.Error("unexpected exit code", code)
+		}
+	default:
+		t.Error("process not exited")
+	}
+}
@@ -25,27 +25,83 @@ package beekeeper
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// beekeeperFolder is the default Config.WorkDir, and the folder in the user's home used to cache the TLS certificate.
+const beekeeperFolder = ".beekeeper"
+
+// binaryQueryTimeout is the time waited for a node to respond to a binary query. Nodes that don't respond in time
+// receive the full transfer.
+const binaryQueryTimeout = time.Second * 5
+
 // DistributeJob builds a job and sends a copy to the workers. Will fail if an empty workers list is given.
 func (s *Server) DistributeJob(pkgName string, function string, nodes ...Node) error {
+	return s.distributeJob(pkgName, function, wrapJobName, false, nil, nodes...)
+}
+
+// DistributeTypedJob builds a typed job, a func(In) (Out, error) wrapped with WrapTypedJob, and sends a copy to the
+// workers. It's run with ExecuteTyped. Will fail if an empty workers list is given.
+func (s *Server) DistributeTypedJob(pkgName string, function string, nodes ...Node) error {
+	return s.distributeJob(pkgName, function, wrapTypedJobName, false, nil, nodes...)
+}
+
+// DistributeJobWithChecksum builds a job and sends a copy to the workers that don't already have it. The SHA-256
+// checksum of the built binary is sent to the workers first, and only the ones without a matching binary receive the
+// full transfer. Will fail if an empty workers list is given.
+func (s *Server) DistributeJobWithChecksum(pkgName string, function string, nodes ...Node) error {
+	return s.distributeJob(pkgName, function, wrapJobName, true, nil, nodes...)
+}
+
+// ConnectAndDistribute connects with the node at the given address, and distributes the job to it. The connected node
+// is returned. An optional timeout argument can be provided, which is used both for the connection and the transfer.
+func (s *Server) ConnectAndDistribute(addr, pkgName, function string, timeout ...time.Duration) (Node, error) {
+	node, err := s.Connect(addr, timeout...)
+	if err != nil {
+		return Node{}, err
+	}
+
+	err = s.distributeJob(pkgName, function, wrapJobName, false, timeout, node)
+	if err != nil {
+		return Node{}, err
+	}
+
+	return node, nil
+}
+
+// distributeJob builds a job wrapped with the wrapper function and sends a copy to the workers. If checksum is true
+// workers that already have the built binary are skipped. The optional timeout is used while waiting for the
+// transfers.
+func (s *Server) distributeJob(pkgName, function, wrapper string, checksum bool, timeout []time.Duration,
+	nodes ...Node) error {
 	if len(nodes) < 1 {
 		return errors.New("no nodes provided")
 	}
 
 	n := Nodes(nodes)
 
-	opSystems := n.getOperatingSystems()
+	distributions := n.getDistributions()
 
-	paths, err := buildJob(pkgName, function, opSystems)
+	if s.Config.DryRun {
+		s.log().Infoln("Dry run: skipping build and transfer of", pkgName+"."+function, "for", distributions)
+		return nil
+	}
+
+	contended, unlock := s.lockTransfers(n)
+	defer unlock()
+
+	paths, err := buildJob(pkgName, function, wrapper, distributions, s.Config, s.log())
 	if err != nil {
 		return err
 	}
@@ -58,14 +114,29 @@ func (s *Server) DistributeJob(pkgName string, function string, nodes ...Node) e
 		}()
 	}
 
-	binaries := make(map[string][]byte, len(opSystems))
-	for _, opSys := range opSystems {
-		data, err := readBinary(paths[opSys])
+	binaries := make(map[Distribution][]byte, len(distributions))
+	for _, dist := range distributions {
+		data, err := readBinary(paths[dist])
+		if err != nil {
+			return fmt.Errorf("unable to load binary for %s: %s", dist, err.Error())
+		}
+
+		binaries[dist] = data
+	}
+
+	if checksum {
+		n, err = s.nodesMissingBinary(n, binaries)
+		if err != nil {
+			return err
+		}
+	} else if len(contended) > 0 {
+		// Another distribution may have sent the binary while waiting for the lock
+		missing, err := s.nodesMissingBinary(contended, binaries)
 		if err != nil {
-			return fmt.Errorf("unable to load binary for os %s: %s", opSys, err.Error())
+			return err
 		}
 
-		binaries[opSys] = data
+		n = append(n.Subtract(contended), missing...)
 	}
 
 	var binariesLock sync.RWMutex
@@ -76,7 +147,7 @@ func (s *Server) DistributeJob(pkgName string, function string, nodes ...Node) e
 	for _, node := range n {
 		go func(node Node) {
 			binariesLock.RLock()
-			data := binaries[node.Info.OS]
+			data := binaries[node.distribution()]
 			binariesLock.RUnlock()
 
 			msg := Message{
@@ -84,18 +155,15 @@ func (s *Server) DistributeJob(pkgName string, function string, nodes ...Node) e
 				Data:      data,
 			}
 
-			err = s.send(node, msg)
-			if err != nil {
-				errChan <- fmt.Errorf("unable to send job to node %s: %s", node.Name, err.Error())
+			err := s.sendTransfer(node, msg, timeout...)
+			if err == ErrNodeDisconnected {
+				errChan <- fmt.Errorf("unable to send job to node %s: disconnected", node.Name)
+				return
 			}
 
-			err = s.awaitTransfer(node)
 			if err != nil {
-				if err == ErrNodeDisconnected {
-					errChan <- fmt.Errorf("unable to send job to node %s: disconnected", node.Name)
-				}
-
 				errChan <- fmt.Errorf("unable to send job to node %s: %s", node.Name, err)
+				return
 			}
 
 			okChan <- true
@@ -113,30 +181,231 @@ func (s *Server) DistributeJob(pkgName string, function string, nodes ...Node) e
 	}
 
 	if !s.Config.DisableCleanup {
-		err = cleanupBuild()
+		err = cleanupBuild(s.Config.workDir())
 		if err != nil {
-			logger.Warnln("Unable to perform cleanup:", err)
+			s.log().Warnln("Unable to perform cleanup:", err)
 		}
 	}
 
 	return nil
 }
 
-// cleanupBuild removes build files and binaries.
-func cleanupBuild() error {
-	folderPath := filepath.FromSlash("./.beekeeper")
-	if !doesPathExists(folderPath) {
-		return nil // Nothing to do here
+// transferLock serializes the job distributions to a node and OS.
+type transferLock struct {
+	sync.Mutex
+
+	// users is the amount of distributions holding or waiting for the lock. It's accessed atomically.
+	users int32
+}
+
+// lockTransfers waits until no other distribution is sending a job to the nodes, and locks them. The nodes that had
+// to be waited for are returned, together with the function that unlocks all of them.
+func (s *Server) lockTransfers(n Nodes) (contended Nodes, unlock func()) {
+	byKey := make(map[string]Node, len(n))
+	for _, node := range n {
+		byKey[node.Addr.IP.String()+"/"+node.Info.OS] = node
 	}
 
-	// Remove temp.go
-	tempGoFile := filepath.FromSlash(folderPath + "/temp.go")
-	if doesPathExists(tempGoFile) {
-		err := os.Remove(tempGoFile)
-		if err != nil {
-			return err
+	// Locking in order prevents deadlocks between distributions to overlapping nodes
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	locks := make([]*transferLock, len(keys))
+	for i, key := range keys {
+		l, _ := s.transferLocks.LoadOrStore(key, &transferLock{})
+		locks[i] = l.(*transferLock)
+
+		if atomic.AddInt32(&locks[i].users, 1) > 1 {
+			s.log().Debugln("Waiting for another job transfer to node", byKey[key].Name)
+			contended = append(contended, byKey[key])
+		}
+
+		locks[i].Lock()
+	}
+
+	return contended, func() {
+		for _, l := range locks {
+			l.Unlock()
+			atomic.AddInt32(&l.users, -1)
 		}
 	}
+}
+
+// nodesMissingBinary queries the nodes for their job binary and returns the ones without a matching one.
+func (s *Server) nodesMissingBinary(n Nodes, binaries map[Distribution][]byte) (Nodes, error) {
+	sums := make(map[Distribution][]byte, len(binaries))
+	for dist, data := range binaries {
+		sum := sha256.Sum256(data)
+		sums[dist] = sum[:]
+	}
+
+	missingChan := make(chan Node, len(n))
+	errChan := make(chan error, len(n))
+
+	for _, node := range n {
+		go func(node Node) {
+			present, err := s.queryBinary(node, sums[node.distribution()], binaryQueryTimeout)
+			if err != nil && err != ErrTimeout {
+				errChan <- fmt.Errorf("unable to query binary of node %s: %s", node.Name, err.Error())
+				return
+			}
+
+			if present {
+				s.log().Debugln("Node", node.Name, "already has the job, skipping transfer")
+				missingChan <- Node{}
+				return
+			}
+
+			missingChan <- node
+		}(node)
+	}
+
+	var missing Nodes
+	for received := 0; received < len(n); received++ {
+		select {
+		case node := <-missingChan:
+			if node.Addr != nil {
+				missing = append(missing, node)
+			}
+		case err := <-errChan:
+			return nil, err
+		}
+	}
+
+	return missing, nil
+}
+
+// fileChecksum returns the SHA-256 checksum of the file at path.
+func fileChecksum(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+// DistributeJobAndExecuteAll distributes a job to the nodes and then runs the tasks on them, assigning them in a
+// round-robin fashion. Tasks with a RequiredOS are only assigned to the nodes running it. Every node runs up to
+// Config.MaxConcurrentTasksPerNode tasks at once, and the rest wait in queue. The results are returned in the same
+// order as the tasks. Optionally a timeout argument can be passed.
+func (s *Server) DistributeJobAndExecuteAll(pkgName, function string, tasks []Task, nodes Nodes,
+	timeout ...time.Duration) ([]Result, error) {
+	err := s.DistributeJob(pkgName, function, nodes...)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.executeRoundRobin(tasks, nodes, timeout...)
+}
+
+// executeRoundRobin runs the tasks on the nodes in a round-robin fashion and blocks until all of them are done. If a
+// task requires an OS none of the nodes run ErrNoCompatibleNode is returned before running any task.
+func (s *Server) executeRoundRobin(tasks []Task, nodes Nodes, timeout ...time.Duration) ([]Result, error) {
+	if len(nodes) < 1 {
+		return nil, errors.New("no nodes provided")
+	}
+
+	assigned, err := s.assignNodes(tasks, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	maxConcurrent := s.Config.MaxConcurrentTasksPerNode
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+
+	semaphores := make([]chan bool, len(nodes))
+	for i := range semaphores {
+		semaphores[i] = make(chan bool, maxConcurrent)
+	}
+
+	results := make([]Result, len(tasks))
+	errChan := make(chan error, len(tasks))
+
+	var wg sync.WaitGroup
+	for i, task := range tasks {
+		wg.Add(1)
+
+		go func(i int, task Task, node Node, semaphore chan bool) {
+			defer wg.Done()
+
+			semaphore <- true
+			defer func() {
+				<-semaphore
+			}()
+
+			res, err := s.Execute(node, task, timeout...)
+			if err != nil {
+				errChan <- fmt.Errorf("node %s error: %s", node.Name, err.Error())
+				return
+			}
+
+			results[i] = res
+		}(i, task, nodes[assigned[i]], semaphores[assigned[i]])
+	}
+
+	wg.Wait()
+	close(errChan)
+
+	if err, ok := <-errChan; ok {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// assignNodes picks the node of every task in a round-robin fashion, among the nodes that run the task's RequiredOS.
+// The index of the picked node is returned for every task.
+func (s *Server) assignNodes(tasks []Task, nodes Nodes) ([]int, error) {
+	compatible := make(map[string][]int)
+	next := make(map[string]int)
+	assigned := make([]int, len(tasks))
+
+	for i, task := range tasks {
+		goos := task.ResourceHints.RequiredOS
+
+		candidates, found := compatible[goos]
+		if !found {
+			for j, n := range nodes {
+				if task.ResourceHints.compatible(s.latestNode(n)) {
+					candidates = append(candidates, j)
+				}
+			}
+
+			compatible[goos] = candidates
+		}
+
+		if len(candidates) == 0 {
+			return nil, ErrNoCompatibleNode
+		}
+
+		assigned[i] = candidates[next[goos]%len(candidates)]
+		next[goos] += 1
+	}
+
+	return assigned, nil
+}
+
+// cleanupBuild removes the binaries built in the workDir folder.
+func cleanupBuild(workDir string) error {
+	folderPath := filepath.Clean(workDir)
+	if !doesPathExists(folderPath) {
+		return nil // Nothing to do here
+	}
 
 	files, err := ioutil.ReadDir(folderPath)
 	if err != nil {
@@ -150,7 +419,7 @@ func cleanupBuild() error {
 		}
 
 		if strings.HasPrefix(file.Name(), "temp_") {
-			err := os.Remove(filepath.FromSlash(folderPath + "/" + file.Name()))
+			err := os.Remove(filepath.Join(folderPath, file.Name()))
 			if err != nil {
 				return err
 			}
@@ -201,10 +470,11 @@ func saveBinary(path string, data []byte) error {
 	return nil
 }
 
-// createFolderIfNotExist checks if a folder exists in the given path. If none is found one is created.
+// createFolderIfNotExist checks if a folder exists in the given path. If none is found one is created, along with any
+// missing parents.
 func createFolderIfNotExist(path string) error {
 	if !doesPathExists(path) {
-		err := os.Mkdir(path, 0777)
+		err := os.MkdirAll(path, 0777)
 		if err != nil {
 			return err
 		}
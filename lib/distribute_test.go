@@ -24,7 +24,15 @@ package beekeeper
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestWorkers_DistributeJobNoWorkers(t *testing.T) {
@@ -68,20 +76,168 @@ func TestWorkers_DistributeJob(t *testing.T) {
 }
 */
 
+func TestServer_executeRoundRobin(t *testing.T) {
+	s, receiveChan, sendChan := startPrimaryTestChannels()
+
+	nodes := getTestNodes()
+
+	var tasks []Task
+	for i := 0; i < 6; i++ {
+		tasks = append(tasks, NewTask())
+		tasks[i].Arguments["index"] = i
+	}
+
+	go func() {
+		for received := 0; received < len(tasks); received++ {
+			select {
+			case msgReceived := <-sendChan:
+				receivedTask, err := decodeTask(msgReceived.Data)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				response := newMessage()
+				response.Operation = OperationJobResult
+				response, err = response.setData(Result{UUID: receivedTask.UUID, Task: receivedTask})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				receiveChan <- Request{Msg: response, Conn: Conn{}}
+			case <-time.After(time.Second):
+				t.Error("task not sent")
+				return
+			}
+		}
+	}()
+
+	results, err := s.executeRoundRobin(tasks, nodes, time.Second)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i, res := range results {
+		if res.Task.Arguments["index"] != i {
+			t.Error("results out of order")
+			return
+		}
+	}
+}
+
+func TestServer_executeRoundRobinOrder(t *testing.T) {
+	c := NewDefaultConfig()
+	c.DisableConnectionWatchdog = true
+	s := NewServer(c)
+
+	var lock sync.Mutex
+	sentTo := make(map[int]string)
+
+	_ = s.SetConnCallback(func(_ *Server, ip string, _ ...time.Duration) (*Conn, error) {
+		return &Conn{stream: &testAddrConn{ip: ip}}, nil
+	})
+	_ = s.SetSendCallback(func(_ *Server, c *Conn, m Message) error {
+		task, err := decodeTask(m.Data)
+		if err != nil {
+			return err
+		}
+
+		lock.Lock()
+		sentTo[task.Arguments["index"].(int)] = c.RemoteAddr().String()
+		lock.Unlock()
+
+		response := newMessage()
+		response.Operation = OperationJobResult
+		response, err = response.setData(Result{UUID: task.UUID, Task: task})
+		if err != nil {
+			return err
+		}
+
+		go s.checkAwaited(response)
+
+		return nil
+	})
+
+	nodes := getTestNodes()
+
+	var tasks []Task
+	for i := 0; i < 2*len(nodes)+1; i++ {
+		tasks = append(tasks, NewTask())
+		tasks[i].Arguments["index"] = i
+	}
+
+	for call := 0; call < 3; call++ {
+		lock.Lock()
+		sentTo = make(map[int]string)
+		lock.Unlock()
+
+		_, err := s.executeRoundRobin(tasks, nodes, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lock.Lock()
+		for i := range tasks {
+			expected := nodes[i%len(nodes)].Addr.IP.String()
+			if sentTo[i] != expected {
+				t.Errorf("call %d: task %d sent to %s, expected %s", call, i, sentTo[i], expected)
+			}
+		}
+		lock.Unlock()
+	}
+}
+
+func TestServer_assignNodes(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	nodes := getTestNodes() // linux, darwin, windows, windows
+
+	var tasks []Task
+	for i := 0; i < 4; i++ {
+		tasks = append(tasks, NewTask())
+		tasks[i].ResourceHints.RequiredOS = "windows"
+	}
+
+	tasks = append(tasks, NewTask())
+
+	assigned, err := s.assignNodes(tasks, nodes)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []int{2, 3, 2, 3, 0}
+	for i := range expected {
+		if assigned[i] != expected[i] {
+			t.Errorf("expected assignment %v, got %v", expected, assigned)
+			return
+		}
+	}
+
+	tasks[0].ResourceHints.RequiredOS = "plan9"
+
+	_, err = s.assignNodes(tasks, nodes)
+	if err != ErrNoCompatibleNode {
+		t.Error("expected ErrNoCompatibleNode, got", err)
+	}
+}
+
 func TestReadBinary(t *testing.T) {
-	err := createFolderIfNotExist("./.beekeeper")
+	err := createFolderIfNotExist(beekeeperFolder)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	err = saveBinary("./.beekeeper/temp_windows", []byte("test"))
+	err = saveBinary(filepath.Join(beekeeperFolder, "temp_windows"), []byte("test"))
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	data, err := readBinary("./.beekeeper/temp_windows")
+	data, err := readBinary(filepath.Join(beekeeperFolder, "temp_windows"))
 	if err != nil {
 		t.Error(err)
 		return
@@ -94,26 +250,311 @@ func TestReadBinary(t *testing.T) {
 }
 
 func TestCleanupBuild(t *testing.T) {
-	err := createFolderIfNotExist("./.beekeeper")
+	err := createFolderIfNotExist(beekeeperFolder)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = saveBinary(filepath.Join(beekeeperFolder, "temp_windows"), []byte("test"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = cleanupBuild(beekeeperFolder)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if doesPathExists(filepath.Join(beekeeperFolder, "temp_windows")) {
+		t.Error(err)
+		return
+	}
+}
+
+func TestPaths(t *testing.T) {
+	sep := string(filepath.Separator)
+
+	_, certPath, keyPath := tlsCachePaths(sep + "home")
+
+	tests := []struct {
+		got    string
+		expect string
+	}{
+		{NewDefaultConfig().jobBinaryPath(), ".beekeeper" + sep + "job.bin"},
+		{Config{WorkDir: "work"}.jobBinaryPath(), "work" + sep + "job.bin"},
+		{buildOutputPath(".beekeeper", Distribution{"windows", "amd64"}), ".beekeeper" + sep + "temp_windows_amd64"},
+		{certPath, sep + "home" + sep + ".beekeeper" + sep + "tls.cert"},
+		{keyPath, sep + "home" + sep + ".beekeeper" + sep + "tls.key"},
+	}
+
+	for _, test := range tests {
+		if test.got != test.expect {
+			t.Errorf("expected path %s, got %s", test.expect, test.got)
+		}
+	}
+}
+
+func TestServer_nodesMissingBinary(t *testing.T) {
+	s, receiveChan, sendChan := startPrimaryTestChannels()
+
+	nodes := getTestNodes()[:2]
+	binaries := map[Distribution][]byte{
+		nodes[0].distribution(): []byte("linux binary"),
+		nodes[1].distribution(): []byte("darwin binary"),
+	}
+
+	type result struct {
+		missing Nodes
+		err     error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		missing, err := s.nodesMissingBinary(nodes, binaries)
+		done <- result{missing, err}
+	}()
+
+	for received := 0; received < len(nodes); received++ {
+		select {
+		case msg := <-sendChan:
+			if msg.Operation != OperationBinaryQuery {
+				t.Error("unexpected operation", msg.Operation)
+				return
+			}
+		case <-time.After(time.Second):
+			t.Error("binary query not sent")
+			return
+		}
+	}
+
+	// The awaitables are registered before sending, so the responses can follow right away
+	for i, op := range []Operation{OperationBinaryPresent, OperationBinaryMissing} {
+		msg := newMessage()
+		msg.Operation = op
+		msg.Addr = nodes[i].Addr
+		receiveChan <- Request{Msg: msg, Conn: Conn{}}
+	}
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			t.Error(res.err)
+			return
+		}
+
+		if len(res.missing) != 1 || !res.missing[0].Equals(nodes[1]) {
+			t.Error("unexpected missing nodes:", res.missing)
+		}
+	case <-time.After(time.Second * 2):
+		t.Error("query not finished")
+	}
+}
+
+func TestServer_lockTransfers(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+	nodes := getTestNodes()[:2]
+
+	contended, unlock := s.lockTransfers(nodes)
+	if len(contended) != 0 {
+		t.Error("unexpected contended nodes:", contended)
+	}
+
+	done := make(chan Nodes, 1)
+	go func() {
+		contended, unlock := s.lockTransfers(nodes[1:])
+		unlock()
+		done <- contended
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("lock acquired while held")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	unlock()
+
+	select {
+	case contended := <-done:
+		if len(contended) != 1 || !contended[0].Equals(nodes[1]) {
+			t.Error("unexpected contended nodes:", contended)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lock not released")
+	}
+
+	contended, unlock = s.lockTransfers(nodes)
+	defer unlock()
+
+	if len(contended) != 0 {
+		t.Error("unexpected contended nodes after release:", contended)
+	}
+}
+
+func TestFileChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beekeeper")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "job.bin")
+	data := []byte("binary")
+
+	err = saveBinary(path, data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sum, err := fileChecksum(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := sha256.Sum256(data)
+	if !bytes.Equal(sum, expected[:]) {
+		t.Fail()
+	}
+}
+
+func TestServer_ConnectAndDistributeUnreachable(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	err := s.SetConnCallback(func(_ *Server, _ string, _ ...time.Duration) (*Conn, error) {
+		return nil, errors.New("unreachable")
+	})
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	err = saveBinary("./.beekeeper/temp_windows", []byte("test"))
+	_, err = s.ConnectAndDistribute("192.168.1.1", "test", "Test", time.Second)
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestServer_distributeJobSkipsContended(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the go stub is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "beekeeper")
 	if err != nil {
 		t.Error(err)
 		return
 	}
+	defer os.RemoveAll(dir)
 
-	err = cleanupBuild()
+	stub := filepath.Join(dir, "go")
+	err = ioutil.WriteFile(stub, []byte(goStub), 0700)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if doesPathExists("./.beekeeper/temp_windows") {
+	err = ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/job\n"), 0600)
+	if err != nil {
 		t.Error(err)
 		return
 	}
+
+	defer func(original string) {
+		goCommand = original
+	}(goCommand)
+	goCommand = stub
+
+	c := NewDefaultConfig()
+	c.WorkDir = filepath.Join(dir, "work")
+	c.GoModuleRoot = dir
+	c.DisableConnectionWatchdog = true
+
+	s := NewServer(c)
+	_ = s.SetServerCallback(func(*Server) error {
+		return nil
+	})
+
+	go func() {
+		_ = s.Start()
+	}()
+	defer s.Stop()
+
+	node := getTestNodes()[0]
+	s.updateNode(node)
+
+	// The node keeps the checksum of the binary it received, and answers like a real one
+	var lock sync.Mutex
+	var present []byte
+	transfers := 0
+
+	respond := func(op Operation) {
+		time.Sleep(time.Millisecond * 50) // Let the awaitables register
+
+		msg := newMessage()
+		msg.Operation = op
+		msg.Addr = node.Addr
+		msg.Token = s.Config.Token
+		s.queue <- Request{Msg: msg, Conn: Conn{}}
+	}
+
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	_ = s.SetSendCallback(func(_ *Server, _ *Conn, m Message) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch m.Operation {
+		case OperationJobTransfer:
+			transfers++
+			sum := sha256.Sum256(m.Data)
+			present = sum[:]
+			go respond(OperationTransferAcknowledge)
+
+		case OperationBinaryQuery:
+			if bytes.Equal(m.Data, present) {
+				go respond(OperationBinaryPresent)
+			} else {
+				go respond(OperationBinaryMissing)
+			}
+		}
+
+		return nil
+	})
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- s.DistributeJob("example.com/job", "Job", node)
+		}()
+
+		time.Sleep(stubBuildTime / 3) // The first distribution holds the lock while building
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		case <-time.After(time.Second * 5):
+			t.Error("distribution not finished")
+			return
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if transfers != 1 {
+		t.Error("unexpected amount of transfers", transfers)
+	}
 }
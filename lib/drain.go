@@ -0,0 +1,189 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrForcedShutdown is produced when the server is stopped while tasks are still pending
+var ErrForcedShutdown = errors.New("forced shutdown")
+
+// drainPollInterval is the time between checks of the pending tasks while draining.
+const drainPollInterval = time.Millisecond * 100
+
+// Drain waits for the tasks awaited by Execute to finish and then stops the server. If tasks are still pending after
+// gracePeriod the nodes running them are asked to cancel them. If they are still pending after forcePeriod the server
+// is stopped anyway and an ErrForcedShutdown wrapping error listing the abandoned task UUIDs is returned. Both periods
+// are measured from the call.
+func (s *Server) Drain(gracePeriod, forcePeriod time.Duration) error {
+	return s.drain(gracePeriod, forcePeriod, nil)
+}
+
+// drain drains the server like Drain. If the done chan is closed before forcePeriod the server is stopped right away,
+// like when forcePeriod is reached. A nil chan is never closed.
+func (s *Server) drain(gracePeriod, forcePeriod time.Duration, done <-chan struct{}) error {
+	graceTimer := time.NewTimer(gracePeriod)
+	defer graceTimer.Stop()
+
+	forceTimer := time.NewTimer(forcePeriod)
+	defer forceTimer.Stop()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if len(s.pendingTaskUUIDs()) == 0 {
+			s.Stop()
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-graceTimer.C:
+			s.cancelPendingTasks()
+		case <-forceTimer.C:
+			return s.forceStop()
+		case <-done:
+			return s.forceStop()
+		}
+	}
+}
+
+// forceStop stops the server regardless of its pending tasks. An ErrForcedShutdown wrapping error listing them is
+// returned if there are any.
+func (s *Server) forceStop() error {
+	abandoned := s.pendingTaskUUIDs()
+	s.Stop()
+
+	if len(abandoned) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%w: abandoned tasks %s", ErrForcedShutdown, strings.Join(abandoned, ", "))
+}
+
+// cancelPendingTasks asks the nodes running the pending tasks to cancel them.
+func (s *Server) cancelPendingTasks() {
+	s.pendingTasksLock.Lock()
+	pending := make(map[string]Node, len(s.pendingTasks))
+	for uuid, n := range s.pendingTasks {
+		pending[uuid] = n
+	}
+	s.pendingTasksLock.Unlock()
+
+	for uuid, n := range pending {
+		s.log().Warnln("Cancelling task", uuid, "on node", n.Name)
+
+		err := s.send(n, Message{Operation: OperationJobCancel, Data: []byte(uuid)})
+		if err != nil {
+			s.log().Errorln("Unable to cancel task", uuid+":", err)
+		}
+	}
+}
+
+// pendingTaskUUIDs returns the sorted UUIDs of the pending tasks.
+func (s *Server) pendingTaskUUIDs() []string {
+	s.pendingTasksLock.Lock()
+	defer s.pendingTasksLock.Unlock()
+
+	uuids := make([]string, 0, len(s.pendingTasks))
+	for uuid := range s.pendingTasks {
+		uuids = append(uuids, uuid)
+	}
+
+	sort.Strings(uuids)
+
+	return uuids
+}
+
+// addPendingTask registers a task awaited by Execute and the node running it.
+func (s *Server) addPendingTask(uuid string, n Node) {
+	s.pendingTasksLock.Lock()
+	defer s.pendingTasksLock.Unlock()
+
+	s.pendingTasks[uuid] = n
+}
+
+// removePendingTask removes a task once it's no longer awaited.
+func (s *Server) removePendingTask(uuid string) {
+	s.pendingTasksLock.Lock()
+	defer s.pendingTasksLock.Unlock()
+
+	delete(s.pendingTasks, uuid)
+}
+
+// pendingTaskCount returns the amount of tasks awaited by Execute.
+func (s *Server) pendingTaskCount() int {
+	s.pendingTasksLock.Lock()
+	defer s.pendingTasksLock.Unlock()
+
+	return len(s.pendingTasks)
+}
+
+// addRunningTask registers the cancel function of a task run by this node.
+func (s *Server) addRunningTask(uuid string, cancel context.CancelFunc) {
+	s.runningTasksLock.Lock()
+	defer s.runningTasksLock.Unlock()
+
+	s.runningTasks[uuid] = cancel
+}
+
+// runningTaskCount returns the amount of tasks being run by this node.
+func (s *Server) runningTaskCount() int {
+	s.runningTasksLock.Lock()
+	defer s.runningTasksLock.Unlock()
+
+	return len(s.runningTasks)
+}
+
+// removeRunningTask removes a task run by this node once it's finished.
+func (s *Server) removeRunningTask(uuid string) {
+	s.runningTasksLock.Lock()
+	defer s.runningTasksLock.Unlock()
+
+	delete(s.runningTasks, uuid)
+}
+
+// jobCancelCallback is the callback for the JobCancel operation. The task with the UUID in the Data is killed, if
+// running.
+func jobCancelCallback(s *Server, _ *Conn, msg Message) {
+	uuid := string(msg.Data)
+
+	s.runningTasksLock.Lock()
+	cancel, ok := s.runningTasks[uuid]
+	s.runningTasksLock.Unlock()
+
+	if !ok {
+		s.log().Debugln("Unable to cancel task", uuid+": not running")
+		return
+	}
+
+	s.log().Warnln("Cancelling task", uuid, "as requested by node", msg.Name)
+	cancel()
+}
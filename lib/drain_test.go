@@ -0,0 +1,90 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_Drain(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	sent := make(chan Message, 1)
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+	_ = s.SetSendCallback(func(_ *Server, _ *Conn, m Message) error {
+		sent <- m
+		return nil
+	})
+
+	s.addPendingTask("stuck", Node{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.1")}})
+
+	err := s.Drain(time.Millisecond*50, time.Millisecond*200)
+	if !errors.Is(err, ErrForcedShutdown) || !strings.Contains(err.Error(), "stuck") {
+		t.Error("expected ErrForcedShutdown listing the task, got", err)
+	}
+
+	select {
+	case msg := <-sent:
+		if msg.Operation != OperationJobCancel || string(msg.Data) != "stuck" {
+			t.Error("unexpected message:", msg.summary())
+		}
+	default:
+		t.Error("cancellation not sent")
+	}
+
+	s.Stop() // Already stopped, no panic
+}
+
+func TestServer_DrainEmpty(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	err := s.Drain(time.Second, time.Second)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJobCancelCallback(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	cancelled := false
+	s.addRunningTask("running", func() {
+		cancelled = true
+	})
+
+	msg := getTestMessage()
+	msg.Operation = OperationJobCancel
+	msg.Data = []byte("running")
+
+	jobCancelCallback(s, &Conn{}, msg)
+
+	if !cancelled {
+		t.Fail()
+	}
+}
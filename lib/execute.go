@@ -24,12 +24,16 @@ package beekeeper
 
 import (
 	"bufio"
+	"context"
 	"errors"
+	"fmt"
 	"github.com/sony/sonyflake"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"math/rand"
+	"net"
+	"os"
 	"os/exec"
-	"path/filepath"
 	"strconv"
 	"time"
 )
@@ -37,9 +41,82 @@ import (
 // flake holds a SonyFlake object for UUID creation. It gets created as needed, and is nil before that.
 var flake *sonyflake.Sonyflake = nil
 
-// Execute runs a task on the given node and blocks until the task results are retrieved.
-// It will fail if no job is present on the node's systems. An optional timeout parameter can be provided.
-func (s *Server) Execute(n Node, t Task, timeout ...time.Duration) (res Result, err error) {
+// ErrNoSuitableNode is produced when no node has the resources required by a task
+var ErrNoSuitableNode = errors.New("no suitable node")
+
+// ErrNoCompatibleNode is produced when no node runs the RequiredOS of a task. It wraps ErrNoSuitableNode
+var ErrNoCompatibleNode = fmt.Errorf("%w: no node runs the required OS", ErrNoSuitableNode)
+
+// ErrOutputTooLarge is produced when a job announces a result bigger than the task's MaxOutputBytes
+var ErrOutputTooLarge = errors.New("job output too large")
+
+// ErrAffinityNodeOffline is produced when the affinity node of a task is offline and fallback is not allowed
+var ErrAffinityNodeOffline = errors.New("affinity node offline")
+
+// Execute runs a task on the given node and blocks until the task results are retrieved. If the task has an affinity
+// node it's used instead of the given one. If the node doesn't run the task's RequiredOS ErrNoCompatibleNode is
+// returned, and if it doesn't have the rest of the resources in the ResourceHints ErrNoSuitableNode is returned. It
+// will fail if no job is present on the node's systems. Errors produced by the job are returned as a *RemoteError. The
+// task's OnComplete or OnError callback is called once it's done. An optional timeout parameter can be provided.
+func (s *Server) Execute(n Node, t Task, timeout ...time.Duration) (Result, error) {
+	return s.execute(n, t, nil, timeout...)
+}
+
+// ExecuteTyped runs a TypedTask on the given node like Server.Execute, and returns the value returned by its job, which
+// must be wrapped with WrapTypedJob. An optional timeout parameter can be provided.
+func ExecuteTyped[In any, Out any](s *Server, n Node, t TypedTask[In, Out], timeout ...time.Duration) (Out, error) {
+	var out Out
+
+	task, err := t.Encode()
+	if err != nil {
+		return out, err
+	}
+
+	res, err := s.Execute(n, task, timeout...)
+	if err != nil {
+		return out, err
+	}
+
+	return t.Decode(res)
+}
+
+// execute runs the task like Execute. If onSent isn't nil it's called once the task is sent to the node, with the UUID
+// of the run.
+func (s *Server) execute(n Node, t Task, onSent func(uuid string, n Node), timeout ...time.Duration) (res Result,
+	err error) {
+	delegated := false
+	defer func() {
+		if !delegated {
+			t.complete(res, err)
+		}
+	}()
+
+	if t.AffinityNodeIP != "" && (n.Addr == nil || n.Addr.IP.String() != t.AffinityNodeIP) {
+		affinity, found, err := s.affinityNode(t)
+		if err != nil {
+			return Result{}, err
+		}
+
+		if !found {
+			// Fallback to the load balancer, which calls the callbacks itself
+			t.AffinityNodeIP = ""
+			delegated = true
+
+			return s.executeBalanced(t, onSent, timeout...)
+		}
+
+		n = affinity
+	}
+
+	latest := s.latestNode(n)
+	if !t.ResourceHints.compatible(latest) {
+		return Result{}, ErrNoCompatibleNode
+	}
+
+	if !t.ResourceHints.suits(latest) {
+		return Result{}, ErrNoSuitableNode
+	}
+
 	if !s.Config.DisableConnectionWatchdog {
 		terminateChan := make(chan bool, 1)
 		go startConnectionWatchdog(s, terminateChan)
@@ -53,40 +130,143 @@ func (s *Server) Execute(n Node, t Task, timeout ...time.Duration) (res Result,
 		return Result{}, err
 	}
 
+	return s.runTask(n, t, onSent, timeout...)
+}
+
+// runTask sends the task, which must already have an UUID, to the node and blocks until its Result is retrieved. The
+// execution is added to the server's history. onSent is called once the task is sent, unless it's nil. Optionally a
+// timeout argument can be passed.
+func (s *Server) runTask(n Node, t Task, onSent func(uuid string, n Node), timeout ...time.Duration) (Result, error) {
+	ctx := context.Background()
+	if len(timeout) > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout[0])
+		defer cancel()
+	}
+
+	return s.runTaskCtx(ctx, n, t, onSent)
+}
+
+// runTaskCtx works like runTask, but stops waiting for the Result once the context is done. ErrTimeout is returned if
+// the context's deadline is exceeded.
+func (s *Server) runTaskCtx(ctx context.Context, n Node, t Task, onSent func(uuid string, n Node)) (res Result,
+	err error) {
+	start := time.Now()
+	defer func() {
+		record := ExecutionRecord{
+			TaskUUID:  t.UUID,
+			NodeName:  n.Name,
+			StartedAt: start,
+			Duration:  time.Since(start),
+			Tags:      t.Tags,
+		}
+
+		if n.Addr != nil {
+			record.NodeAddr = n.Addr.IP.String()
+		}
+
+		if err != nil {
+			record.Error = err.Error()
+		}
+
+		s.addExecutionRecord(record)
+		s.metrics.taskDone(record.Duration, err)
+	}()
+
+	traceContext, endSpan := s.startSpan(SpanExecute, trace.SpanKindClient, nil)
+	defer func() {
+		endSpan(err)
+	}()
+
 	data, err := t.encode()
 	if err != nil {
 		return Result{}, err
 	}
 
+	s.metrics.taskSubmitted()
+
+	// Register before sending, as busy nodes respond right away
+	notifyChan := s.expectTask(t.UUID)
+	defer s.removeAwaitable(notifyChan)
+
+	s.addPendingTask(t.UUID, n)
+	defer s.removePendingTask(t.UUID)
+
 	err = s.send(n, Message{
-		Operation: OperationJobExecute,
-		Data:      data,
+		Operation:    OperationJobExecute,
+		Data:         data,
+		TraceContext: traceContext,
 	})
 	if err != nil {
 		return Result{}, err
 	}
 
-	res, err = s.awaitTask(t.UUID, timeout...)
+	if onSent != nil {
+		onSent(t.UUID, n)
+	}
+
+	res, err = s.awaitExpectedTask(ctx, t.UUID, notifyChan)
+	if err == context.DeadlineExceeded {
+		return Result{}, ErrTimeout
+	}
+
 	if err != nil {
 		return Result{}, err
 	}
 
-	if res.Error != "" {
-		return Result{}, errors.New(res.Error)
+	if remoteErr := res.remoteError(); remoteErr != nil {
+		if remoteErr.NodeName == "" {
+			remoteErr.NodeName = n.Name
+		}
+
+		return Result{}, remoteErr
 	}
 
 	return res, nil
 }
 
-// runLocalJob will execute the current job on the beekeeper folder. Fails if no job is present.
-func runLocalJob(t Task) (Result, error) {
+// latestNode returns the latest known state of the node. If the node is not known it's returned unchanged.
+func (s *Server) latestNode(n Node) Node {
+	if n.Addr == nil {
+		return n
+	}
+
+	known, found := s.GetNode(n.Addr.IP)
+	if !found {
+		return n
+	}
+
+	return known
+}
+
+// affinityNode looks up the affinity node of the task. If the node is offline, and Config.AllowAffinityFallback is set,
+// found will be false. If fallback is not allowed ErrAffinityNodeOffline is returned instead.
+func (s *Server) affinityNode(t Task) (n Node, found bool, err error) {
+	ip := net.ParseIP(t.AffinityNodeIP)
+	if ip == nil {
+		return Node{}, false, errors.New("invalid affinity node IP: " + t.AffinityNodeIP)
+	}
+
+	n, found = s.GetNode(ip)
+	if !found && !s.Config.AllowAffinityFallback {
+		return Node{}, false, ErrAffinityNodeOffline
+	}
+
+	return n, found, nil
+}
+
+// runLocalJob will execute the job binary at binPath. Fails if no job is present. Jobs wrapped with
+// WrapJobToFile send results bigger than maxInlineResultSize through a temporary file. The job is killed if the ctx
+// is cancelled, or if the announced result is bigger than the task's MaxOutputBytes. The limit applies to results sent
+// through a file too.
+func runLocalJob(ctx context.Context, binPath string, t Task, maxInlineResultSize uint64) (Result, error) {
 	data, err := t.encode()
 	if err != nil {
 		return Result{}, err
 	}
 
-	path := filepath.FromSlash("./.beekeeper/job.bin")
-	cmd := exec.Command(path)
+	cmd := exec.CommandContext(ctx, binPath)
+	cmd.Env = append(os.Environ(), maxInlineResultSizeEnv+"="+strconv.FormatUint(maxInlineResultSize, 10))
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
@@ -103,13 +283,30 @@ func runLocalJob(t Task) (Result, error) {
 		return Result{}, errors.New("unable to start process: " + err.Error())
 	}
 
-	_, err = stdin.Write(append(data, byte('\n')))
+	input := []byte(fmt.Sprintf("%d\n", len(data)))
+	_, err = stdin.Write(append(input, data...))
 	if err != nil {
 		return Result{}, errors.New("unable to write task to process: " + err.Error())
 	}
 
 	reader := bufio.NewReader(stdout)
 
+	magic, err := reader.Peek(1)
+	if err != nil {
+		return Result{}, errors.New("error reading data header: " + err.Error())
+	}
+
+	if magic[0] == resultFileMagic {
+		res, err := readResultFile(reader, t.MaxOutputBytes)
+		if err != nil {
+			return Result{}, err
+		}
+
+		res.UUID = t.UUID
+
+		return res, nil
+	}
+
 	header, _, err := reader.ReadLine()
 	if err != nil {
 		return Result{}, errors.New("error reading data header: " + err.Error())
@@ -120,6 +317,11 @@ func runLocalJob(t Task) (Result, error) {
 		return Result{}, errors.New("error parsing data header: " + err.Error())
 	}
 
+	if t.MaxOutputBytes > 0 && uint64(dataLen) > t.MaxOutputBytes {
+		_ = cmd.Process.Kill()
+		return Result{}, fmt.Errorf("%w: %d bytes", ErrOutputTooLarge, dataLen)
+	}
+
 	dataBuf := make([]byte, dataLen)
 
 	_, err = io.ReadFull(reader, dataBuf)
@@ -0,0 +1,47 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"time"
+)
+
+// ExecuteAsync runs the task on the node like Execute, but without blocking. The returned Future holds the Result
+// once it's done, and can be used to cancel the task. The task's OnComplete or OnError callback is called as well. An
+// optional timeout parameter can be provided.
+func (s *Server) ExecuteAsync(n Node, t Task, timeout ...time.Duration) *Future {
+	return newFuture(s, t, func(t Task, onSent func(string, Node)) (Result, error) {
+		return s.execute(n, t, onSent, timeout...)
+	})
+}
+
+// ExecuteAsync runs the task on every node without blocking, like Server.ExecuteAsync. The Futures are returned in the
+// same order as the nodes.
+func (n Nodes) ExecuteAsync(s *Server, t Task, timeout ...time.Duration) []*Future {
+	futures := make([]*Future, len(n))
+	for i, node := range n {
+		futures[i] = s.ExecuteAsync(node, t, timeout...)
+	}
+
+	return futures
+}
@@ -0,0 +1,280 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServer_ExecuteAsync(t *testing.T) {
+	c := NewDefaultConfig()
+	c.DisableConnectionWatchdog = true
+
+	s := NewServer(c)
+
+	sent := make(chan Message, 1)
+	_ = s.SetSendCallback(func(_ *Server, _ *Conn, m Message) error {
+		sent <- m
+		return nil
+	})
+
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	completed := make(chan Result, 1)
+
+	task := NewTask()
+	task.OnComplete = func(res Result) {
+		completed <- res
+	}
+
+	future := s.ExecuteAsync(getTestNodes()[0], task)
+
+	var uuid string
+	select {
+	case msg := <-sent:
+		received, err := decodeTask(msg.Data)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		uuid = received.UUID
+	case <-time.After(time.Second):
+		t.Error("task not sent")
+		return
+	}
+
+	time.Sleep(time.Millisecond * 100) // Let the awaitables register
+
+	response, err := newMessage().setData(Result{UUID: uuid})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	response.Operation = OperationJobResult
+	s.checkAwaited(response)
+
+	res, err := future.Await(time.Second)
+	if err != nil || res.UUID != uuid {
+		t.Error("unexpected result", res.UUID, err)
+		return
+	}
+
+	select {
+	case res := <-completed:
+		if res.UUID != uuid {
+			t.Error("unexpected result in callback", res.UUID)
+		}
+	case <-time.After(time.Second):
+		t.Error("OnComplete not called")
+	}
+}
+
+func TestServer_ExecuteOnError(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	failed := make(chan error, 1)
+
+	task := NewTask()
+	task.ResourceHints.RequiredOS = "plan9"
+	task.OnComplete = func(Result) {
+		t.Error("OnComplete called for a failed task")
+	}
+	task.OnError = func(err error) {
+		failed <- err
+	}
+
+	_, err := s.Execute(getTestNodes()[0], task)
+	if err != ErrNoCompatibleNode {
+		t.Error("expected ErrNoCompatibleNode, got", err)
+		return
+	}
+
+	select {
+	case err := <-failed:
+		if err != ErrNoCompatibleNode {
+			t.Error("unexpected error in callback", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("OnError not called")
+	}
+}
+
+func TestFuture_Cancel(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	sent := make(chan Message, 2)
+	_ = s.SetSendCallback(func(_ *Server, _ *Conn, m Message) error {
+		sent <- m
+		return nil
+	})
+
+	// Holds the task until the Future is cancelled
+	dial := make(chan struct{})
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		<-dial
+		return &Conn{}, nil
+	})
+
+	future := s.ExecuteAsync(getTestNodes()[0], NewTask(), time.Second)
+	if state := future.State(); state != FuturePending {
+		t.Error("expected a pending future, got", state)
+	}
+
+	err := future.Cancel()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err := future.Wait(); err != ErrTaskCancelled {
+		t.Error("expected ErrTaskCancelled, got", err)
+	}
+
+	if state := future.State(); state != FutureCancelled {
+		t.Error("expected a cancelled future, got", state)
+	}
+
+	close(dial)
+
+	execute := <-sent
+	task, err := decodeTask(execute.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case cancel := <-sent:
+		if cancel.Operation != OperationJobCancel || string(cancel.Data) != task.UUID {
+			t.Error("unexpected cancel message:", cancel.Operation, string(cancel.Data))
+		}
+	case <-time.After(time.Second):
+		t.Error("task sent after the cancellation not cancelled")
+	}
+}
+
+func TestFuture_CancelWhileSent(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	cancels := make(chan Message, 2)
+	_ = s.SetSendCallback(func(_ *Server, _ *Conn, m Message) error {
+		cancels <- m
+		return nil
+	})
+
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	for i := 0; i < 100; i++ {
+		f := &Future{done: make(chan struct{}), server: s}
+
+		go f.sent("1", getTestNodes()[0])
+		_ = f.Cancel()
+
+		select {
+		case <-cancels:
+		case <-time.After(time.Second):
+			t.Fatal("run not cancelled")
+		}
+	}
+
+	if len(cancels) != 0 {
+		t.Error("run cancelled more than once")
+	}
+}
+
+func TestNodes_ExecuteAsync(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	sent := make(chan Message, 2)
+	_ = s.SetSendCallback(func(_ *Server, _ *Conn, m Message) error {
+		sent <- m
+		return nil
+	})
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	futures := getTestNodes()[:2].ExecuteAsync(s, NewTask())
+	if len(futures) != 2 {
+		t.Fatal("expected 2 futures, got", len(futures))
+	}
+
+	uuids := make(map[string]bool)
+	for range futures {
+		select {
+		case msg := <-sent:
+			task, err := decodeTask(msg.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			uuids[task.UUID] = true
+		case <-time.After(time.Second):
+			t.Fatal("task not sent")
+		}
+	}
+
+	time.Sleep(time.Millisecond * 100) // Let the awaitables register
+
+	for _, future := range futures {
+		if state := future.State(); state != FutureRunning {
+			t.Error("expected a running future, got", state)
+		}
+	}
+
+	for uuid := range uuids {
+		response, err := newMessage().setData(Result{UUID: uuid})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response.Operation = OperationJobResult
+		s.checkAwaited(response)
+	}
+
+	for _, future := range futures {
+		res, err := future.Await(time.Second)
+		if err != nil || !uuids[res.UUID] {
+			t.Error("unexpected result", res.UUID, err)
+		}
+
+		if state := future.State(); state != FutureSucceeded {
+			t.Error("expected a succeeded future, got", state)
+		}
+	}
+}
+
+func TestFutureState_String(t *testing.T) {
+	if FutureCancelled.String() != "Cancelled" {
+		t.Error("unexpected name:", FutureCancelled.String())
+	}
+
+	if FutureState(42).String() != "FutureState(42)" {
+		t.Error("unexpected name:", FutureState(42).String())
+	}
+}
@@ -23,7 +23,14 @@
 package beekeeper
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 )
@@ -64,7 +71,7 @@ func TestWorkers_Execute(t *testing.T) {
 					return
 				}
 
-				receiveChan <- Request{response, Conn{}}
+				receiveChan <- Request{Msg: response, Conn: Conn{}}
 
 				if received == len(nodes) {
 					return
@@ -80,3 +87,182 @@ func TestWorkers_Execute(t *testing.T) {
 	}
 
 }
+
+func TestServer_affinityNode(t *testing.T) {
+	s := &Server{nodes: getTestNodes()[:2]}
+
+	task := NewTask()
+	task.AffinityNodeIP = "192.168.1.2"
+
+	n, found, err := s.affinityNode(task)
+	if err != nil || !found || n.Name != "testWorker2" {
+		t.Error("affinity node not found:", err)
+		return
+	}
+
+	task.AffinityNodeIP = "192.168.1.3"
+
+	_, _, err = s.affinityNode(task)
+	if err != ErrAffinityNodeOffline {
+		t.Error("expected ErrAffinityNodeOffline, got", err)
+		return
+	}
+
+	s.Config.AllowAffinityFallback = true
+
+	_, found, err = s.affinityNode(task)
+	if err != nil || found {
+		t.Error("expected fallback, got", err)
+		return
+	}
+}
+
+func TestServer_BroadcastTaskPartialFailure(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	err := s.SetConnCallback(func(_ *Server, _ string, _ ...time.Duration) (*Conn, error) {
+		return nil, errors.New("unreachable")
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	nodes := getTestNodes()
+	for _, n := range nodes {
+		s.updateNode(n)
+	}
+
+	results, err := s.BroadcastTask(NewTask(), time.Second)
+	if len(results) != 0 {
+		t.Error("unexpected results", results)
+		return
+	}
+
+	var multiErr *MultiNodeError
+	if !errors.As(err, &multiErr) {
+		t.Error("expected a MultiNodeError, got", err)
+		return
+	}
+
+	if len(multiErr.Errors) != len(nodes) {
+		t.Error("unexpected failed nodes", multiErr.Errors)
+	}
+}
+
+func TestRunLocalJobMaxOutputBytes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the job stub is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "beekeeper")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	resultPath := filepath.Join(dir, "result")
+	err = ioutil.WriteFile(resultPath, make([]byte, 100), 0600)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	outputs := map[string]string{
+		"inline": "100",
+		"file":   fmt.Sprintf("%c%s", resultFileMagic, resultPath),
+	}
+
+	for name, output := range outputs {
+		binPath := filepath.Join(dir, name)
+		err = ioutil.WriteFile(binPath, []byte(fmt.Sprintf("#!/bin/sh\necho '%s'\nsleep 1\n", output)), 0700)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		task := NewTask()
+		task.MaxOutputBytes = 10
+
+		_, err = runLocalJob(context.Background(), binPath, task, defaultMaxInlineResultSize)
+		if !errors.Is(err, ErrOutputTooLarge) {
+			t.Error("expected ErrOutputTooLarge for the", name, "result, got", err)
+		}
+	}
+}
+
+func TestServer_ExecuteFastBusy(t *testing.T) {
+	c := NewDefaultConfig()
+	c.DisableConnectionWatchdog = true
+
+	s := NewServer(c)
+
+	// Answer before the send returns, as a busy node does
+	_ = s.SetSendCallback(func(s *Server, _ *Conn, m Message) error {
+		task, err := decodeTask(m.Data)
+		if err != nil {
+			return err
+		}
+
+		res := newRemoteErrorResult(&RemoteError{Code: ErrorCodeNodeBusy, Message: "node busy"})
+		res.UUID = task.UUID
+
+		data, err := res.encode()
+		if err != nil {
+			return err
+		}
+
+		s.checkAwaited(Message{Operation: OperationBusy, Data: data})
+
+		return nil
+	})
+
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Execute(getTestNodes()[0], NewTask(), time.Second)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		var remoteErr *RemoteError
+		if !errors.As(err, &remoteErr) || remoteErr.Code != ErrorCodeNodeBusy {
+			t.Error("expected a busy error, got", err)
+		}
+	case <-time.After(time.Millisecond * 500):
+		t.Error("busy response missed")
+	}
+}
+
+func TestServer_ExecuteSendFailure(t *testing.T) {
+	c := NewDefaultConfig()
+	c.DisableConnectionWatchdog = true
+
+	s := NewServer(c)
+
+	_ = s.SetSendCallback(func(*Server, *Conn, Message) error {
+		return errors.New("unable to send")
+	})
+
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	_, err := s.Execute(getTestNodes()[0], NewTask(), time.Second)
+	if err == nil {
+		t.Error("expected an error")
+	}
+
+	s.awaitedLock.Lock()
+	awaited := len(s.awaited)
+	s.awaitedLock.Unlock()
+
+	if awaited != 0 || s.pendingTaskCount() != 0 {
+		t.Error("task still awaited:", awaited, s.pendingTaskCount())
+	}
+}
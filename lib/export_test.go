@@ -44,18 +44,18 @@ func startPrimaryTestChannels() (*Server, chan Request, chan Message) {
 	WatchdogSleep = time.Millisecond * 100
 	server = NewServer(config)
 
-	server.serverCallback = func(*Server) error {
+	_ = server.SetServerCallback(func(*Server) error {
 		return nil
-	}
+	})
 
-	server.sendCallback = func(_ *Server, c *Conn, m Message) error {
+	_ = server.SetSendCallback(func(_ *Server, c *Conn, m Message) error {
 		sendChan <- m
 		return nil
-	}
+	})
 
-	server.connCallback = func(_ *Server, ip string, timeout ...time.Duration) (*Conn, error) {
+	_ = server.SetConnCallback(func(_ *Server, ip string, timeout ...time.Duration) (*Conn, error) {
 		return &Conn{}, nil
-	}
+	})
 
 	go func() {
 		err := server.Start()
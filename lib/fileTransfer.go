@@ -0,0 +1,318 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxFileChunkSize is the maximum amount of file data sent in a single FileTransfer message. Smaller chunks are used
+// if Config.MaxMessageSize requires it.
+const maxFileChunkSize = (1 << 20) * 4 // 4 MB
+
+// defaultTransferConcurrency is the maximum amount of files sent at once by Server.TransferFiles when
+// Config.BroadcastConcurrency isn't set.
+const defaultTransferConcurrency = 8
+
+// fileChunkTimeout is the time waited for a node to acknowledge a file chunk.
+const fileChunkTimeout = time.Minute
+
+// ErrInvalidRemotePath is produced when a file is transferred to a path outside the remote node's working directory
+var ErrInvalidRemotePath = errors.New("invalid remote path")
+
+// fileChunk is a part of a file sent in a FileTransfer message.
+type fileChunk struct {
+	Path   string
+	Offset int64
+	Data   []byte
+}
+
+// fileChunkAck is the response of a node to a fileChunk. An empty Error means the chunk was saved.
+type fileChunkAck struct {
+	Path   string
+	Offset int64
+	Error  string
+}
+
+// TransferFiles sends files to the nodes. The files map relates the local path of every file to the path it's saved
+// to on the nodes, which must be relative to their working directory. Up to Config.BroadcastConcurrency files, or 8
+// if it's not set, are sent at once, split in chunks that fit Config.MaxMessageSize, and it blocks until all of them
+// are acknowledged.
+func (s *Server) TransferFiles(files map[string]string, nodes ...Node) error {
+	if len(nodes) < 1 {
+		return errors.New("no nodes provided")
+	}
+
+	opened := make(map[string]*os.File, len(files))
+	defer func() {
+		for _, f := range opened {
+			_ = f.Close()
+		}
+	}()
+
+	for localPath, remotePath := range files {
+		if !isValidRemotePath(remotePath) {
+			return fmt.Errorf("%w: %s", ErrInvalidRemotePath, remotePath)
+		}
+
+		f, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+
+		opened[remotePath] = f
+	}
+
+	type transfer struct {
+		node       Node
+		remotePath string
+		f          *os.File
+	}
+
+	transfers := make(chan transfer, len(nodes)*len(opened))
+	for _, node := range nodes {
+		for remotePath, f := range opened {
+			transfers <- transfer{node: node, remotePath: remotePath, f: f}
+		}
+	}
+	close(transfers)
+
+	workers := s.Config.BroadcastConcurrency
+	if workers == 0 {
+		workers = defaultTransferConcurrency
+	}
+
+	errChan := make(chan error, len(nodes)*len(opened))
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for t := range transfers {
+				err := s.transferFile(t.node, t.f, t.remotePath)
+				if err != nil {
+					errChan <- fmt.Errorf("unable to send file %s to node %s: %s", t.remotePath, t.node.Name,
+						err.Error())
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errChan)
+
+	if err, ok := <-errChan; ok {
+		return err
+	}
+
+	return nil
+}
+
+// transferFile sends the file to the node chunk by chunk, waiting for every chunk to be acknowledged.
+func (s *Server) transferFile(n Node, f *os.File, remotePath string) error {
+	chunkSize := int64(maxFileChunkSize)
+	if s.Config.MaxMessageSize > 0 && s.Config.MaxMessageSize/2 < uint64(chunkSize) {
+		chunkSize = int64(s.Config.MaxMessageSize / 2) // Leave room for the message metadata
+	}
+
+	buf := make([]byte, chunkSize)
+	for offset := int64(0); ; {
+		read, err := f.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		// Empty files are sent as a single empty chunk, so they are still created
+		if read == 0 && offset > 0 {
+			return nil
+		}
+
+		msg, encErr := Message{Operation: OperationFileTransfer}.setData(fileChunk{
+			Path:   remotePath,
+			Offset: offset,
+			Data:   buf[:read],
+		})
+		if encErr != nil {
+			return encErr
+		}
+
+		// Register the awaitable first, so the acknowledgement can't arrive before it
+		ackChan := s.expectFileChunkAck(n, remotePath, offset)
+
+		sendErr := s.send(n, msg)
+		if sendErr == nil {
+			sendErr = s.awaitFileChunkAck(ackChan, fileChunkTimeout)
+		}
+
+		s.removeAwaitable(ackChan)
+		if sendErr != nil {
+			return sendErr
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		offset += int64(read)
+	}
+}
+
+// expectFileChunkAck registers an awaitable for the acknowledgement of the chunk of the file at the given offset. The
+// returned chan is meant for awaitFileChunkAck.
+func (s *Server) expectFileChunkAck(n Node, remotePath string, offset int64) chan Message {
+	notifyChan := make(chan Message, 1)
+
+	s.awaitedLock.Lock()
+	s.awaited = append(s.awaited, awaitable{
+		notify: notifyChan,
+		checkFunc: func(msg Message) bool {
+			if msg.Operation != OperationFileTransferResult || !msg.Addr.IP.Equal(n.Addr.IP) {
+				return false
+			}
+
+			ack, err := decodeFileChunkAck(msg.Data)
+			return err == nil && ack.Path == remotePath && ack.Offset == offset
+		},
+	})
+	s.awaitedLock.Unlock()
+
+	return notifyChan
+}
+
+// awaitFileChunkAck blocks the execution until a file chunk acknowledgement is received through the chan. If the node
+// failed to save the chunk its error is returned.
+func (s *Server) awaitFileChunkAck(notifyChan chan Message, timeout time.Duration) error {
+	if s.Config.DryRun {
+		return nil
+	}
+
+	toTimer := time.NewTimer(timeout)
+	defer toTimer.Stop()
+
+	select {
+	case msg := <-notifyChan:
+		ack, _ := decodeFileChunkAck(msg.Data)
+		if ack.Error != "" {
+			return errors.New(ack.Error)
+		}
+
+		return nil
+	case <-toTimer.C:
+		return ErrTimeout
+	}
+}
+
+// fileTransferCallback is the callback for the FileTransfer operation.
+func fileTransferCallback(s *Server, conn *Conn, msg Message) {
+	var chunk fileChunk
+	err := gob.NewDecoder(bytes.NewBuffer(msg.Data)).Decode(&chunk)
+	if err != nil {
+		s.log().Errorln("Unable to read file chunk:", err)
+		return
+	}
+
+	ack := fileChunkAck{Path: chunk.Path, Offset: chunk.Offset}
+
+	err = saveFileChunk(s.Config.workDir(), chunk)
+	if err != nil {
+		s.log().Errorln("Unable to save file", chunk.Path+":", err)
+		ack.Error = err.Error()
+	}
+
+	response, err := Message{Operation: OperationFileTransferResult}.setData(ack)
+	if err != nil {
+		s.log().Errorln("Unable to encode file chunk acknowledgement:", err)
+		return
+	}
+
+	err = s.sendWithConn(conn, response)
+	if err != nil {
+		s.log().Errorln("Unable to acknowledge file chunk:", err)
+	}
+}
+
+// saveFileChunk writes the chunk to its file, relative to workDir. The first chunk of a file replaces its content.
+// Paths that are absolute or leave workDir are rejected with ErrInvalidRemotePath.
+func saveFileChunk(workDir string, chunk fileChunk) error {
+	if !isValidRemotePath(chunk.Path) {
+		return fmt.Errorf("%w: %s", ErrInvalidRemotePath, chunk.Path)
+	}
+
+	path := filepath.Join(workDir, chunk.Path)
+
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if chunk.Offset == 0 {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(path, flags, 0666)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = f.WriteAt(chunk.Data, chunk.Offset)
+
+	return err
+}
+
+// isValidRemotePath checks that the path is relative and doesn't leave the working directory.
+func isValidRemotePath(path string) bool {
+	if path == "" || filepath.IsAbs(path) {
+		return false
+	}
+
+	clean := filepath.Clean(path)
+
+	return clean != ".." && !strings.HasPrefix(clean, ".."+string(filepath.Separator))
+}
+
+// decodeFileChunkAck decodes the gob encoded fileChunkAck sent in a FileTransferResult.
+func decodeFileChunkAck(data []byte) (fileChunkAck, error) {
+	var ack fileChunkAck
+
+	err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&ack)
+	if err != nil {
+		return fileChunkAck{}, err
+	}
+
+	return ack, nil
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsValidRemotePath(t *testing.T) {
+	valid := []string{"data.csv", "data/input.csv", "./data.csv", "data/../input.csv"}
+	for _, p := range valid {
+		if !isValidRemotePath(p) {
+			t.Error("valid path rejected:", p)
+		}
+	}
+
+	invalid := []string{"", "..", "../data.csv", "data/../../data.csv", filepath.Join(os.TempDir(), "data.csv")}
+	for _, p := range invalid {
+		if isValidRemotePath(p) {
+			t.Error("invalid path accepted:", p)
+		}
+	}
+}
+
+func TestSaveFileChunk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beekeeper")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	chunks := []fileChunk{
+		{Path: "data/input.bin", Offset: 0, Data: []byte("hello ")},
+		{Path: "data/input.bin", Offset: 6, Data: []byte("world")},
+	}
+
+	for _, c := range chunks {
+		err = saveFileChunk(dir, c)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	for _, p := range []string{"../escaped.bin", "data/../../escaped.bin", filepath.Join(dir, "absolute.bin")} {
+		err = saveFileChunk(dir, fileChunk{Path: p, Data: []byte("data")})
+		if !errors.Is(err, ErrInvalidRemotePath) {
+			t.Error("expected ErrInvalidRemotePath for", p, "got", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "data", "input.bin"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !bytes.Equal(data, []byte("hello world")) {
+		t.Error("unexpected file content", string(data))
+	}
+}
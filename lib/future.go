@@ -0,0 +1,214 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FutureState is the state of the task held by a Future.
+type FutureState int
+
+const (
+	// FuturePending the task is not yet sent to a node
+	FuturePending FutureState = iota
+
+	// FutureRunning the task was sent to a node, and its result is awaited
+	FutureRunning
+
+	// FutureSucceeded the task finished and its Result is available
+	FutureSucceeded
+
+	// FutureFailed the task failed
+	FutureFailed
+
+	// FutureCancelled the task was cancelled with Future.Cancel
+	FutureCancelled
+)
+
+// String returns a string representation of the FutureState.
+func (fs FutureState) String() string {
+	switch fs {
+	case FuturePending:
+		return "Pending"
+	case FutureRunning:
+		return "Running"
+	case FutureSucceeded:
+		return "Succeeded"
+	case FutureFailed:
+		return "Failed"
+	case FutureCancelled:
+		return "Cancelled"
+	default:
+		return fmt.Sprintf("FutureState(%d)", int(fs))
+	}
+}
+
+// ErrTaskCancelled is produced when waiting for a task cancelled with Future.Cancel
+var ErrTaskCancelled = errors.New("task cancelled")
+
+// Future holds the Result of a task run in the background. It's safe for concurrent use.
+type Future struct {
+	done chan struct{}
+	res  Result
+	err  error
+
+	// server is the server running the task, used to cancel it.
+	server *Server
+
+	// state is the FutureState of the task.
+	state FutureState
+
+	// uuid and node identify the run of the task once it's sent.
+	uuid string
+	node Node
+
+	// lock is a Mutex lock over res, err, state, uuid and node.
+	lock sync.Mutex
+}
+
+// newFuture runs the task in the background with run and returns a Future holding its outcome. run must call onSent
+// once the task is sent, which lets Future.Cancel reach the node running it.
+func newFuture(s *Server, t Task, run func(t Task, onSent func(uuid string, n Node)) (Result, error)) *Future {
+	f := &Future{done: make(chan struct{}), server: s}
+
+	go func() {
+		res, err := run(t, f.sent)
+		if err != nil {
+			f.finish(Result{}, err, FutureFailed)
+			return
+		}
+
+		f.finish(res, nil, FutureSucceeded)
+	}()
+
+	return f
+}
+
+// sent records the run of the task once it's sent to the node. If the Future was cancelled meanwhile the run is
+// cancelled right away.
+func (f *Future) sent(uuid string, n Node) {
+	f.lock.Lock()
+	f.uuid, f.node = uuid, n
+
+	cancelled := f.state == FutureCancelled
+	if f.state == FuturePending {
+		f.state = FutureRunning
+	}
+
+	f.lock.Unlock()
+
+	if cancelled {
+		_ = f.cancelRun(uuid, n)
+	}
+}
+
+// finish sets the outcome of the task, unless it's already set.
+func (f *Future) finish(res Result, err error, state FutureState) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.finishLocked(res, err, state)
+}
+
+// finishLocked is finish for callers already holding the lock.
+func (f *Future) finishLocked(res Result, err error, state FutureState) bool {
+	if f.state >= FutureSucceeded {
+		return false
+	}
+
+	f.res, f.err, f.state = res, err, state
+	close(f.done)
+
+	return true
+}
+
+// Done returns a chan that is closed once the task is finished.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// State returns the current state of the task.
+func (f *Future) State() FutureState {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.state
+}
+
+// Wait blocks until the task is finished and returns its Result.
+func (f *Future) Wait() (Result, error) {
+	return f.Await()
+}
+
+// Await blocks until the task is finished and returns its Result. If the optional timeout is exceeded ErrTimeout is
+// returned, and the task keeps running.
+func (f *Future) Await(timeout ...time.Duration) (Result, error) {
+	if len(timeout) > 0 {
+		toTimer := time.NewTimer(timeout[0])
+		defer toTimer.Stop()
+
+		select {
+		case <-f.done:
+		case <-toTimer.C:
+			return Result{}, ErrTimeout
+		}
+	}
+
+	<-f.done
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.res, f.err
+}
+
+// Cancel stops the task. Waiting for it returns ErrTaskCancelled right away, and if the task was already sent the node
+// is asked to kill it with a JobCancel operation, whose sending error is returned. Cancelling a finished task has no
+// effect.
+func (f *Future) Cancel() error {
+	f.lock.Lock()
+	cancelled := f.finishLocked(Result{}, ErrTaskCancelled, FutureCancelled)
+	uuid, n := f.uuid, f.node // Read along with the state, so that sent sees either the cancellation or the run
+	f.lock.Unlock()
+
+	if !cancelled || uuid == "" {
+		return nil // Finished, or cancelled by sent once it's sent
+	}
+
+	return f.cancelRun(uuid, n)
+}
+
+// cancelRun asks the node to kill the run of the task.
+func (f *Future) cancelRun(uuid string, n Node) error {
+	if f.server == nil {
+		return nil
+	}
+
+	f.server.log().Infoln("Cancelling task", uuid, "on node", n.Name)
+
+	return f.server.send(n, Message{Operation: OperationJobCancel, Data: []byte(uuid)})
+}
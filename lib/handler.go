@@ -24,7 +24,6 @@ package beekeeper
 
 import (
 	"bufio"
-	"crypto/tls"
 	"errors"
 	"io"
 	"net"
@@ -34,16 +33,28 @@ import (
 // ErrMessageTooLarge is triggered when a message exceeds the size limit set by MaxMessageSize
 var ErrMessageTooLarge = errors.New("message too large")
 
+// ErrEmptyConnection is produced when a connection is closed before sending any data
+var ErrEmptyConnection = errors.New("empty connection")
+
 // Request represents an incoming Message with its connection
 type Request struct {
 	Msg  Message
 	Conn Conn
+
+	// seq is the sequence number of the message in the persistent queue. Messages are written to it before being
+	// queued, and marked as done once handled. It's 0 if the message was not persisted.
+	seq uint64
 }
 
 // handle will process a TCPConnection and return a Message object with its data if possible. Connections
-// coming from the host machine are discarded.
-func (s *Server) handle(conn net.Conn) {
+// coming from the host machine are discarded, and so are the ones that send a message with an invalid token. The
+// limiter is shared by all the messages sent through the connection, including the ones sent by the node that dialed
+// it.
+func (s *Server) handle(conn net.Conn, limiter *rateLimiter) {
 	reader := bufio.NewReader(conn)
+	capabilities := &capabilitySet{} // Filled by the handshake, if the remote node starts one
+	auth := &connAuth{}              // Filled by the first authenticated message
+	received := false
 
 	for {
 		select {
@@ -51,53 +62,109 @@ func (s *Server) handle(conn net.Conn) {
 			_ = conn.Close()
 			return
 		default:
-			header, _, err := reader.ReadLine()
+			msg, err := readWithDeadline(conn, s.Config.PerMessageReadTimeout, func() (Message, error) {
+				return s.readMessage(conn, reader, received)
+			})
 			if err != nil {
+				// Empty and normally closed connections are expected, like the ones made by health checks
+				if err != ErrEmptyConnection && err != io.EOF {
+					s.log().Errorln("Closing connection:", err)
+				}
+
 				_ = conn.Close()
 				return
 			}
 
-			dataLen, err := strconv.Atoi(string(header))
-			if err != nil {
-				logger.Errorln("Failed to parse connection header:", err)
+			received = true
+
+			// Verified here, instead of in the Start loop, as hashed tokens are slow to compare. The connection is
+			// dropped, so that a node can't force a comparison for every message it sends
+			if !s.authenticate(msg, auth) {
+				s.log().Debugln("Closing connection with an invalid token from", conn.RemoteAddr())
 				_ = conn.Close()
 				return
 			}
 
-			if uint64(dataLen) > s.Config.MaxMessageSize {
-				logger.Errorln("Bad connection header: doesn't match declared length")
-				return
-			}
+			c := newConn(conn)
+			c.limiter = limiter
+			c.capabilities = capabilities
+			c.auth = auth
 
-			dataBuf := make([]byte, dataLen)
+			seq := s.persistRequest(msg)
 
-			readLen, err := io.ReadFull(reader, dataBuf)
-			if err != nil {
-				_ = conn.Close()
-				return
-			}
+			s.metrics.queueing(1)
+			s.queue <- Request{Msg: msg, Conn: c, seq: seq}
+			s.metrics.queueing(-1)
+		}
+	}
+}
 
-			if readLen != dataLen {
-				logger.Errorf("Error: Expected to read %d bytes, but read %d\n", readLen, dataLen)
-				_ = conn.Close()
-				return
-			}
+// readMessage reads a single header and Message from the connection. If the connection is closed before any data is
+// sent ErrEmptyConnection is returned, and io.EOF is returned if it's closed between messages.
+func (s *Server) readMessage(conn net.Conn, reader *bufio.Reader, received bool) (Message, error) {
+	return readMessage(conn, reader, s.Config.MaxMessageSize, received, func(size int) {
+		s.messageSizes.Observe(float64(size))
+		s.metrics.received(size)
+	})
+}
 
-			msg, err := decodeMessage(dataBuf)
-			if err != nil {
-				logger.Errorln("Unable to decode message data:", err)
-				_ = conn.Close()
-				return
-			}
+// readMessage reads a single header and Message from the connection, rejecting messages bigger than maxSize. The
+// declared size is passed to observe, which may be nil.
+func readMessage(conn net.Conn, reader *bufio.Reader, maxSize uint64, received bool, observe func(size int)) (Message,
+	error) {
+	header, _, err := reader.ReadLine()
+	if err == io.EOF && !received {
+		return Message{}, ErrEmptyConnection
+	}
 
-			tcpAddr := conn.RemoteAddr().(*net.TCPAddr)
-			msg.Addr = tcpAddr
+	if err != nil {
+		return Message{}, err
+	}
 
-			s.queue <- Request{
-				Msg:  msg,
-				Conn: Conn{conn.(*tls.Conn)},
-			}
-		}
+	if len(header) == 0 {
+		return Message{}, ErrEmptyConnection
+	}
 
+	dataLen, err := strconv.Atoi(string(header))
+	if err != nil {
+		return Message{}, errors.New("failed to parse connection header: " + err.Error())
 	}
+
+	if uint64(dataLen) > maxSize {
+		return Message{}, errors.New("bad connection header: declared length exceeds the size limit")
+	}
+
+	if observe != nil {
+		observe(dataLen)
+	}
+
+	dataBuf := make([]byte, dataLen)
+
+	_, err = io.ReadFull(reader, dataBuf)
+	if err != nil {
+		// io.ErrUnexpectedEOF means that less data than declared was sent
+		return Message{}, errors.New("unable to read message data: " + err.Error())
+	}
+
+	msg, err := decodeMessage(dataBuf, maxSize)
+	if err != nil {
+		return Message{}, errors.New("unable to decode message data: " + err.Error())
+	}
+
+	msg.Addr = toTCPAddr(conn.RemoteAddr())
+
+	return msg, nil
+}
+
+// toTCPAddr converts the address of a connection into a TCPAddr. QUIC connections use UDP addresses, so the IP and
+// port are kept.
+func toTCPAddr(addr net.Addr) *net.TCPAddr {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a
+	case *net.UDPAddr:
+		return &net.TCPAddr{IP: a.IP, Port: a.Port, Zone: a.Zone}
+	}
+
+	return &net.TCPAddr{}
 }
@@ -0,0 +1,150 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServer_readMessageEmptyConnection(t *testing.T) {
+	s := &Server{Config: NewDefaultConfig()}
+
+	server, client := net.Pipe()
+	_ = client.Close()
+
+	_, err := s.readMessage(server, bufio.NewReader(server), false)
+	if err != ErrEmptyConnection {
+		t.Error("expected ErrEmptyConnection, got", err)
+		return
+	}
+}
+
+func TestServer_readMessageMalformed(t *testing.T) {
+	s := &Server{Config: NewDefaultConfig()}
+
+	server, client := net.Pipe()
+	go func() {
+		_, _ = client.Write([]byte("10\nshort"))
+		_ = client.Close()
+	}()
+
+	_, err := s.readMessage(server, bufio.NewReader(server), false)
+	if err == nil || err == ErrEmptyConnection {
+		t.Error("expected a read error, got", err)
+		return
+	}
+}
+
+func TestConn_ReadMessageWithTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := &Conn{stream: server}
+
+	_, err := conn.ReadMessageWithTimeout(time.Millisecond*50, 1<<20)
+	if err == nil {
+		t.Error("expected a timeout error")
+		return
+	}
+
+	data, err := newMessage().encode()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go func() {
+		_, _ = client.Write(append([]byte(fmt.Sprintf("%d\n", len(data))), data...))
+	}()
+
+	_, err = conn.ReadMessageWithTimeout(time.Second, 1<<20)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServer_acquireConn(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	c := s.acquireConn()
+	c.capabilities = &capabilitySet{enabled: map[string]bool{"test": true}}
+	s.releaseConn(c)
+
+	reused := s.acquireConn()
+	if reused.capabilities != nil || reused.netConn() != nil {
+		t.Error("acquired Conn not zeroed")
+	}
+
+	s.Config.DisableConnPool = true
+
+	c = s.acquireConn()
+	c.capabilities = &capabilitySet{enabled: map[string]bool{"test": true}}
+	s.releaseConn(c)
+
+	if !c.HasCapability("test") {
+		t.Error("Conn released with the pool disabled")
+		return
+	}
+
+	s.Config.DisableConnPool = false
+
+	c = &Conn{capabilities: &capabilitySet{enabled: map[string]bool{"test": true}}} // Like the ones of a conn callback
+	s.releaseConn(c)
+
+	if !c.HasCapability("test") {
+		t.Error("Conn not taken from the pool released")
+	}
+}
+
+func TestServer_handleSharesLimiter(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	limiter := newRateLimiter(1000)
+	go s.handle(server, limiter)
+
+	data, err := newMessage().encode()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go func() {
+		_, _ = client.Write(append([]byte(fmt.Sprintf("%d\n", len(data))), data...))
+	}()
+
+	select {
+	case req := <-s.queue:
+		if req.Conn.limiter != limiter {
+			t.Error("the connection got its own limiter")
+		}
+	case <-time.After(time.Second):
+		t.Error("message not queued")
+	}
+}
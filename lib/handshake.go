@@ -0,0 +1,163 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// handshakeTimeout is the time waited for a node to respond to a handshake. Nodes that don't respond in time are
+// assumed to not support any optional feature.
+const handshakeTimeout = time.Second * 2
+
+// handshake negotiates the optional features to be used on the connection. The features enabled on both nodes are
+// stored in the capabilities of the connection.
+func (s *Server) handshake(conn *Conn) error {
+	ip := toTCPAddr(conn.RemoteAddr()).IP.String()
+
+	notifyChan := s.expectHandshake(ip)
+	defer s.removeAwaitable(notifyChan)
+
+	err := s.sendWithConn(conn, Message{
+		Operation:    OperationHandshake,
+		Capabilities: s.Config.Capabilities,
+	})
+	if err != nil {
+		return errors.New("unable to send handshake: " + err.Error())
+	}
+
+	remote, err := awaitHandshake(notifyChan, handshakeTimeout)
+	if err == ErrTimeout {
+		s.log().Warnln("Optional features disabled, no handshake response from", conn.RemoteAddr())
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	storeCapabilities(conn, negotiateCapabilities(s.Config.Capabilities, remote))
+
+	return nil
+}
+
+// handshakeCallback is the callback for the Handshake operation. The node responds with its own capabilities and
+// keeps the negotiated ones on the connection.
+func handshakeCallback(s *Server, conn *Conn, msg Message) {
+	storeCapabilities(conn, negotiateCapabilities(s.Config.Capabilities, msg.Capabilities))
+
+	err := s.sendWithConn(conn, Message{
+		Operation:    OperationHandshakeResponse,
+		Capabilities: s.Config.Capabilities,
+	})
+	if err != nil {
+		s.log().Errorln("Unable to respond to a handshake:", err)
+		return
+	}
+}
+
+// expectHandshake registers an awaitable for the handshake response of the node with a matching address. The
+// response is sent through the returned chan.
+func (s *Server) expectHandshake(ip string) chan Message {
+	notifyChan := make(chan Message, 1)
+
+	s.awaitedLock.Lock()
+	s.awaited = append(s.awaited, awaitable{
+		notify: notifyChan,
+		checkFunc: func(msg Message) bool {
+			return msg.Operation == OperationHandshakeResponse && msg.Addr.IP.String() == ip
+		},
+	})
+	s.awaitedLock.Unlock()
+
+	return notifyChan
+}
+
+// awaitHandshake blocks the execution until the handshake response registered with expectHandshake is received, and
+// returns the capabilities of the node.
+func awaitHandshake(notifyChan chan Message, timeout time.Duration) ([]string, error) {
+	toTimer := time.NewTimer(timeout)
+	defer toTimer.Stop()
+
+	select {
+	case msg := <-notifyChan:
+		return msg.Capabilities, nil
+	case <-toTimer.C:
+		return nil, ErrTimeout
+	}
+}
+
+// negotiateCapabilities returns the capabilities present in both lists.
+func negotiateCapabilities(local, remote []string) map[string]bool {
+	enabled := make(map[string]bool, len(local))
+	for _, c := range local {
+		enabled[c] = true
+	}
+
+	negotiated := make(map[string]bool)
+	for _, c := range remote {
+		if enabled[c] {
+			negotiated[c] = true
+		}
+	}
+
+	return negotiated
+}
+
+// capabilitySet holds the capabilities negotiated for a connection. It's shared by all the requests received through
+// the same connection, so it's safe for concurrent use.
+type capabilitySet struct {
+	lock    sync.RWMutex
+	enabled map[string]bool
+}
+
+// has reports if the capability is enabled. A nil capabilitySet has no capabilities enabled.
+func (cs *capabilitySet) has(name string) bool {
+	if cs == nil {
+		return false
+	}
+
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+
+	return cs.enabled[name]
+}
+
+// set replaces the enabled capabilities.
+func (cs *capabilitySet) set(enabled map[string]bool) {
+	cs.lock.Lock()
+	cs.enabled = enabled
+	cs.lock.Unlock()
+}
+
+// storeCapabilities replaces the capabilities of the connection with the negotiated ones. The set is updated in place,
+// as it's shared by all the requests received through the same connection.
+func storeCapabilities(conn *Conn, negotiated map[string]bool) {
+	if conn.capabilities == nil {
+		conn.capabilities = &capabilitySet{}
+	}
+
+	conn.capabilities.set(negotiated)
+}
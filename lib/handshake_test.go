@@ -0,0 +1,148 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNegotiateCapabilities(t *testing.T) {
+	negotiated := negotiateCapabilities([]string{"hmac", "dedup"}, []string{"dedup", "multiplex"})
+
+	if !cmp.Equal(negotiated, map[string]bool{"dedup": true}) {
+		t.Error("unexpected capabilities:", negotiated)
+	}
+
+	if len(negotiateCapabilities(nil, []string{"dedup"})) != 0 {
+		t.Error("capability enabled only on the remote node")
+	}
+}
+
+func TestHandshakeCallback(t *testing.T) {
+	s, _, sendChan := startPrimaryTestChannels()
+
+	s.Config.Capabilities = []string{"hmac", "dedup"}
+	defer func() {
+		s.Config.Capabilities = nil
+	}()
+
+	conn := &Conn{capabilities: &capabilitySet{enabled: map[string]bool{"multiplex": true}}}
+
+	msg := getTestMessage()
+	msg.Operation = OperationHandshake
+	msg.Capabilities = []string{"hmac"}
+
+	handshakeCallback(s, conn, msg)
+
+	select {
+	case response := <-sendChan:
+		if response.Operation != OperationHandshakeResponse ||
+			!cmp.Equal(response.Capabilities, s.Config.Capabilities) {
+			t.Error("unexpected response:", response.summary())
+		}
+	case <-time.After(time.Second):
+		t.Error("handshake response not sent")
+		return
+	}
+
+	if !conn.HasCapability("hmac") || conn.HasCapability("dedup") || conn.HasCapability("multiplex") {
+		t.Error("unexpected capabilities:", conn.capabilities.enabled)
+	}
+}
+
+func TestCapabilitySet_concurrent(t *testing.T) {
+	conn := &Conn{capabilities: &capabilitySet{}}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			storeCapabilities(conn, map[string]bool{"hmac": i%2 == 0})
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 100; i++ {
+		conn.HasCapability("hmac")
+	}
+
+	<-done
+
+	if conn.HasCapability("hmac") {
+		t.Error("unexpected capability enabled")
+	}
+}
+
+func TestServer_handshakeFastResponse(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+	s.Config.Capabilities = []string{"hmac"}
+
+	addr := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 2020}
+
+	// The response is handled before the send returns, as it can happen with a fast node
+	_ = s.SetSendCallback(func(_ *Server, _ *Conn, m Message) error {
+		if m.Operation == OperationHandshake {
+			response := newMessage()
+			response.Operation = OperationHandshakeResponse
+			response.Addr = addr
+			response.Capabilities = []string{"hmac"}
+
+			s.checkAwaited(response)
+		}
+
+		return nil
+	})
+
+	conn := &Conn{stream: &testTCPConn{addr: addr}}
+
+	err := s.handshake(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !conn.HasCapability("hmac") {
+		t.Error("handshake response lost")
+	}
+
+	s.awaitedLock.Lock()
+	awaited := len(s.awaited)
+	s.awaitedLock.Unlock()
+
+	if awaited != 0 {
+		t.Error("awaitables left after the handshake", awaited)
+	}
+}
+
+// testTCPConn is a net.Conn that only reports its remote TCP address.
+type testTCPConn struct {
+	net.Conn
+	addr *net.TCPAddr
+}
+
+// RemoteAddr returns the address of the connection.
+func (c *testTCPConn) RemoteAddr() net.Addr {
+	return c.addr
+}
@@ -0,0 +1,223 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// minHealthyFreeBytes is the minimum free disk space and memory of a node for it to be considered healthy.
+const minHealthyFreeBytes = (1 << 20) * 100 // 100 MB
+
+// defaultHealthCheckTTL is the time a LoadBalancer reuses the result of a node's health check by default.
+const defaultHealthCheckTTL = time.Second * 5
+
+// HealthStatus holds the state of a node, as reported by a health check.
+type HealthStatus struct {
+	// Reachable is true if the node responded to the health check.
+	Reachable bool
+
+	// BinaryPresent is true if the node has a job binary.
+	BinaryPresent bool
+
+	// DiskSpaceFreeBytes is the free disk space on the node's working directory, in bytes.
+	DiskSpaceFreeBytes uint64
+
+	// MemFreeBytes is the memory available on the node, in bytes.
+	MemFreeBytes uint64
+
+	// CPUUsagePercent is the CPU usage of the node, as a percentage.
+	CPUUsagePercent float32
+
+	// GoVersion is the version of Go the node was built with.
+	GoVersion string
+
+	// BinaryChecksum is the hex encoded SHA-256 checksum of the node's job binary, if present.
+	BinaryChecksum string
+}
+
+// Healthy reports if the node is ready to run tasks: it's reachable, has a job binary and at least 100 MB of free
+// disk space and memory.
+func (h HealthStatus) Healthy() bool {
+	return h.Reachable && h.BinaryPresent && h.DiskSpaceFreeBytes >= minHealthyFreeBytes &&
+		h.MemFreeBytes >= minHealthyFreeBytes
+}
+
+// HealthCheck asks the node for its HealthStatus and blocks until it's received. Unreachable nodes produce an error,
+// along with a HealthStatus with Reachable set to false. An optional timeout parameter can be provided.
+func (s *Server) HealthCheck(n Node, timeout ...time.Duration) (HealthStatus, error) {
+	msg, err := s.query(n, Message{Operation: OperationHealthCheck}, OperationHealthReport, timeout...)
+	if err != nil {
+		return HealthStatus{}, err
+	}
+
+	status, err := decodeHealthStatus(msg.Data)
+	if err != nil {
+		return HealthStatus{}, err
+	}
+
+	status.Reachable = true
+
+	return status, nil
+}
+
+// healthCheckCallback is the callback for the HealthCheck operation.
+func healthCheckCallback(s *Server, conn *Conn, _ Message) {
+	msg, err := Message{Operation: OperationHealthReport}.setData(s.getHealthStatus())
+	if err != nil {
+		s.log().Errorln("Unable to encode health report:", err)
+		return
+	}
+
+	err = s.sendWithConn(conn, msg)
+	if err != nil {
+		s.log().Errorln("Unable to respond to a health check:", err)
+		return
+	}
+}
+
+// getHealthStatus gathers the HealthStatus of the local node.
+func (s *Server) getHealthStatus() HealthStatus {
+	info := s.getNodeInfo()
+
+	status := HealthStatus{
+		Reachable:          true,
+		DiskSpaceFreeBytes: info.DiskFree,
+		MemFreeBytes:       info.MemFree,
+		CPUUsagePercent:    info.Usage,
+		GoVersion:          info.GoVersion,
+	}
+
+	sum, err := fileChecksum(s.Config.jobBinaryPath())
+	if err == nil {
+		status.BinaryPresent = true
+		status.BinaryChecksum = hex.EncodeToString(sum)
+	}
+
+	return status
+}
+
+// decodeHealthStatus decodes the gob encoded HealthStatus sent in a HealthReport.
+func decodeHealthStatus(data []byte) (HealthStatus, error) {
+	var status HealthStatus
+
+	err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&status)
+	if err != nil {
+		return HealthStatus{}, err
+	}
+
+	return status, nil
+}
+
+// EnableHealthChecks makes the LoadBalancer run a health check on the nodes before selecting one, skipping the ones
+// that aren't healthy. See HealthStatus.Healthy. Nodes that don't respond within the timeout are skipped too. A
+// timeout of 0 disables the checks. The result of every check is reused for 5 seconds, see SetHealthCheckTTL.
+func (lb *LoadBalancer) EnableHealthChecks(timeout time.Duration) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+
+	lb.healthCheckTimeout = timeout
+}
+
+// SetHealthCheckTTL sets the time the result of a node's health check is reused before checking it again. A TTL of 0
+// checks the nodes before every selection.
+func (lb *LoadBalancer) SetHealthCheckTTL(ttl time.Duration) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+
+	lb.healthCheckTTL = ttl
+}
+
+// unhealthyRecords returns the records of the nodes that failed their health check. Only the nodes without a health
+// check younger than the TTL are checked again. If health checks are disabled nil is returned.
+func (lb *LoadBalancer) unhealthyRecords() map[*nodeRecord]bool {
+	lb.lock.Lock()
+	timeout := lb.healthCheckTimeout
+	if timeout == 0 {
+		lb.lock.Unlock()
+		return nil
+	}
+
+	unhealthy := make(map[*nodeRecord]bool)
+
+	var expired nodeRecords
+	for _, r := range lb.records {
+		if lb.healthCheckTTL > 0 && !r.checkedAt.IsZero() && time.Since(r.checkedAt) < lb.healthCheckTTL {
+			if !r.healthy {
+				unhealthy[r] = true
+			}
+
+			continue
+		}
+
+		expired = append(expired, r)
+	}
+	lb.lock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, r := range expired {
+		wg.Add(1)
+
+		go func(r *nodeRecord) {
+			defer wg.Done()
+
+			status, err := lb.server.HealthCheck(r.node, timeout)
+			healthy := err == nil && status.Healthy()
+
+			lb.lock.Lock()
+			defer lb.lock.Unlock()
+
+			r.healthy = healthy
+			r.checkedAt = time.Now()
+
+			if !healthy {
+				lb.server.log().Debugln("Skipping unhealthy node", r.node.Name)
+				unhealthy[r] = true
+			}
+		}(r)
+	}
+
+	wg.Wait()
+
+	return unhealthy
+}
+
+// without returns the records that are not in the given set.
+func (rs nodeRecords) without(set map[*nodeRecord]bool) nodeRecords {
+	if len(set) == 0 {
+		return rs
+	}
+
+	var remaining nodeRecords
+	for _, r := range rs {
+		if !set[r] {
+			remaining = append(remaining, r)
+		}
+	}
+
+	return remaining
+}
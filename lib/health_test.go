@@ -0,0 +1,137 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckCallback(t *testing.T) {
+	sv, _, sendChan := startPrimaryTestChannels()
+
+	msg := getTestMessage()
+	msg.Operation = OperationHealthCheck
+
+	go sv.handleMessage(&Conn{Conn: nil}, msg)
+
+	select {
+	case response := <-sendChan:
+		if response.Operation != OperationHealthReport {
+			t.Fail()
+			return
+		}
+
+		status, err := decodeHealthStatus(response.Data)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if status.GoVersion != runtime.Version() {
+			t.Error("unexpected go version", status.GoVersion)
+			return
+		}
+	case <-time.After(time.Second * 3):
+		t.Fail()
+		return
+	}
+}
+
+func TestHealthStatus_Healthy(t *testing.T) {
+	status := HealthStatus{
+		Reachable:          true,
+		BinaryPresent:      true,
+		DiskSpaceFreeBytes: minHealthyFreeBytes,
+		MemFreeBytes:       minHealthyFreeBytes,
+	}
+
+	if !status.Healthy() {
+		t.Error("healthy status reported as unhealthy")
+		return
+	}
+
+	status.BinaryPresent = false
+	if status.Healthy() {
+		t.Error("status without binary reported as healthy")
+		return
+	}
+
+	status.BinaryPresent = true
+	status.DiskSpaceFreeBytes = minHealthyFreeBytes - 1
+	if status.Healthy() {
+		t.Error("status without disk space reported as healthy")
+	}
+}
+
+func TestLoadBalancer_healthCheckTTL(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	var checks int32
+	_ = s.SetSendCallback(func(_ *Server, _ *Conn, m Message) error {
+		if m.Operation == OperationHealthCheck {
+			atomic.AddInt32(&checks, 1)
+		}
+
+		return nil
+	})
+
+	_ = s.SetConnCallback(func(_ *Server, ip string, timeout ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	nodes := getTestNodes()[:1]
+	lb := NewLoadBalancer(s, nodes)
+	lb.EnableHealthChecks(time.Millisecond * 10)
+
+	for i := 0; i < 2; i++ {
+		unhealthy := lb.unhealthyRecords()
+		if len(unhealthy) != 1 {
+			t.Error("unresponsive node not reported as unhealthy")
+			return
+		}
+	}
+
+	if atomic.LoadInt32(&checks) != 1 {
+		t.Error("unexpected amount of health checks", checks)
+		return
+	}
+
+	s.awaitedLock.Lock()
+	awaited := len(s.awaited)
+	s.awaitedLock.Unlock()
+
+	if awaited != 0 {
+		t.Error("awaitables left after the timeout", awaited)
+		return
+	}
+
+	lb.SetHealthCheckTTL(0)
+	lb.unhealthyRecords()
+
+	if atomic.LoadInt32(&checks) != 2 {
+		t.Error("health check result reused without a TTL", checks)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"time"
+)
+
+// setLastPrimaryMsg stores the Message as the last one received from a primary node.
+func (s *Server) setLastPrimaryMsg(msg Message) {
+	s.lastPrimaryMsgLock.Lock()
+	defer s.lastPrimaryMsgLock.Unlock()
+
+	s.lastPrimaryMsg = &msg
+}
+
+// startHeartbeat sends a status update every Config.HeartbeatInterval to the last primary node that contacted this
+// node, using the port it asked to be responded on. It blocks until the server is stopped.
+func (s *Server) startHeartbeat() {
+	ticker := time.NewTicker(s.Config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.terminationChan:
+			return
+		case <-ticker.C:
+			s.sendHeartbeat()
+		}
+	}
+}
+
+// sendHeartbeat sends a status update to the last primary node that contacted this node, if any.
+func (s *Server) sendHeartbeat() {
+	s.lastPrimaryMsgLock.Lock()
+	primaryMsg := s.lastPrimaryMsg
+	s.lastPrimaryMsgLock.Unlock()
+
+	if primaryMsg == nil || primaryMsg.Addr == nil {
+		return // No primary yet
+	}
+
+	err := primaryMsg.respond(s, Message{NodeInfo: s.getNodeInfo()})
+	if err != nil {
+		s.log().Debugln("Unable to send heartbeat:", err)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"sync"
+)
+
+// messageSizeBuckets are the upper bounds of the message size histogram buckets, in bytes.
+var messageSizeBuckets = []float64{1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20}
+
+// compressionRatioBuckets are the upper bounds of the compression ratio histogram buckets.
+var compressionRatioBuckets = []float64{1, 2, 4, 8, 16}
+
+// Histogram counts observed values in buckets, in the style of Prometheus histograms. It's safe for concurrent use, and
+// a nil Histogram ignores all observations.
+type Histogram struct {
+	name   string
+	bounds []float64
+
+	// counts holds the observations of every bucket, plus a last one for those above all the bounds.
+	counts []uint64
+	sum    float64
+	count  uint64
+
+	// lock is a Mutex lock over counts, sum and count.
+	lock sync.Mutex
+}
+
+// HistogramSnapshot holds the state of a Histogram at the moment it was taken.
+type HistogramSnapshot struct {
+	// Name is the name of the metric, like beekeeper_message_size_bytes.
+	Name string
+
+	// Bounds are the upper bounds of the buckets.
+	Bounds []float64
+
+	// Counts are the cumulative amount of observations less or equal to each bound, plus a last element for all
+	// observations (the +Inf bucket).
+	Counts []uint64
+
+	// Sum is the sum of all observed values.
+	Sum float64
+
+	// Count is the amount of observations.
+	Count uint64
+}
+
+// newHistogram creates an empty Histogram with the given name and sorted bucket bounds.
+func newHistogram(name string, bounds []float64) *Histogram {
+	return &Histogram{
+		name:   name,
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe adds the value to the histogram.
+func (h *Histogram) Observe(v float64) {
+	if h == nil {
+		return
+	}
+
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.counts[i] += 1
+	h.sum += v
+	h.count += 1
+}
+
+// Snapshot returns the current state of the histogram. A nil Histogram returns an empty snapshot.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	if h == nil {
+		return HistogramSnapshot{}
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	snap := HistogramSnapshot{
+		Name:   h.name,
+		Bounds: append([]float64(nil), h.bounds...),
+		Counts: make([]uint64, len(h.counts)),
+		Sum:    h.sum,
+		Count:  h.count,
+	}
+
+	var cumulative uint64
+	for i, c := range h.counts {
+		cumulative += c
+		snap.Counts[i] = cumulative
+	}
+
+	return snap
+}
+
+// MessageSizeHistogram returns the distribution of the sizes of the messages sent and received by the server, in bytes.
+func (s *Server) MessageSizeHistogram() HistogramSnapshot {
+	return s.messageSizes.Snapshot()
+}
+
+// CompressionRatioHistogram returns the distribution of the compression ratio (uncompressed / compressed size) of the
+// messages sent by the server.
+func (s *Server) CompressionRatioHistogram() HistogramSnapshot {
+	return s.compressionRatios.Snapshot()
+}
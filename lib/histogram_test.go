@@ -0,0 +1,100 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram("test", []float64{1, 10})
+
+	for _, v := range []float64{0.5, 1, 5, 20} {
+		h.Observe(v)
+	}
+
+	snap := h.Snapshot()
+	if !reflect.DeepEqual(snap.Counts, []uint64{2, 3, 4}) || snap.Count != 4 || snap.Sum != 26.5 {
+		t.Errorf("unexpected snapshot %+v", snap)
+	}
+
+	var nilHistogram *Histogram
+	nilHistogram.Observe(1) // No panic check
+
+	if nilHistogram.Snapshot().Count != 0 {
+		t.Error("nil histogram counted an observation")
+	}
+}
+
+func TestServer_MessageSizeHistogram(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	var buf bytes.Buffer
+	err := defaultSendCallback(s, &Conn{stream: &writerConn{w: &buf}}, getTestMessage())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if s.MessageSizeHistogram().Count != 1 || s.CompressionRatioHistogram().Count != 1 {
+		t.Error("sent message not observed")
+		return
+	}
+
+	client, server := net.Pipe()
+	go func() {
+		_, _ = client.Write(buf.Bytes())
+		_ = client.Close()
+	}()
+
+	_, err = s.readMessage(server, bufio.NewReader(server), false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sizes := s.MessageSizeHistogram()
+	if sizes.Name != "beekeeper_message_size_bytes" || sizes.Count != 2 {
+		t.Errorf("received message not observed: %+v", sizes)
+	}
+
+	expected := fmt.Sprintf("%d\n", int(sizes.Sum/2))
+	if !bytes.HasPrefix(buf.Bytes(), []byte(expected)) {
+		t.Error("observed size doesn't match the header")
+	}
+}
+
+// writerConn is a net.Conn that writes to w.
+type writerConn struct {
+	net.Conn
+	w *bytes.Buffer
+}
+
+func (c *writerConn) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"time"
+)
+
+// ExecutionRecord holds the details of a task execution.
+type ExecutionRecord struct {
+	// TaskUUID is the UUID assigned to the executed task.
+	TaskUUID string
+
+	// NodeName is the name of the node that ran the task.
+	NodeName string
+
+	// NodeAddr is the address of the node that ran the task.
+	NodeAddr string
+
+	// StartedAt is the time at which the task was sent.
+	StartedAt time.Time
+
+	// Duration is the time elapsed until the result was received or the execution failed.
+	Duration time.Duration
+
+	// Error is the error produced by the execution. An empty string means no error was raised.
+	Error string
+
+	// Tags are the tags of the executed task.
+	Tags []string
+}
+
+// ExecutionHistory is a ExecutionRecord slice, ordered from oldest to newest.
+type ExecutionHistory []ExecutionRecord
+
+// Status returns a short description of the outcome of the execution.
+func (r ExecutionRecord) Status() string {
+	if r.Error != "" {
+		return "Failed"
+	}
+
+	return "OK"
+}
+
+// History returns a snapshot of the latest task executions made by the server, from oldest to newest. The amount of
+// records kept is set by Config.ExecutionHistorySize.
+func (s *Server) History() ExecutionHistory {
+	s.historyLock.RLock()
+	defer s.historyLock.RUnlock()
+
+	history := make(ExecutionHistory, len(s.history))
+	copy(history, s.history)
+
+	return history
+}
+
+// HistoryByTag returns the latest task executions made by the server whose task has the given tag, from oldest to
+// newest. See History.
+func (s *Server) HistoryByTag(tag string) ExecutionHistory {
+	var filtered ExecutionHistory
+	for _, r := range s.History() {
+		if r.HasTag(tag) {
+			filtered = append(filtered, r)
+		}
+	}
+
+	return filtered
+}
+
+// HasTag reports if the executed task had the given tag.
+func (r ExecutionRecord) HasTag(tag string) bool {
+	for _, t := range r.Tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addExecutionRecord stores a task execution in the history, discarding the oldest one when the history size is
+// exceeded.
+func (s *Server) addExecutionRecord(r ExecutionRecord) {
+	if s.Config.ExecutionHistorySize <= 0 {
+		return
+	}
+
+	s.historyLock.Lock()
+	defer s.historyLock.Unlock()
+
+	s.history = append(s.history, r)
+	if len(s.history) > s.Config.ExecutionHistorySize {
+		s.history = s.history[len(s.history)-s.Config.ExecutionHistorySize:]
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestServer_History(t *testing.T) {
+	s := &Server{Config: Config{ExecutionHistorySize: 2}}
+
+	for i := 0; i < 3; i++ {
+		s.addExecutionRecord(ExecutionRecord{TaskUUID: strconv.Itoa(i)})
+	}
+
+	history := s.History()
+	if len(history) != 2 || history[0].TaskUUID != "1" || history[1].TaskUUID != "2" {
+		t.Error("unexpected history:", history)
+		return
+	}
+}
+
+func TestServer_HistoryByTag(t *testing.T) {
+	s := &Server{Config: Config{ExecutionHistorySize: 10}}
+
+	s.addExecutionRecord(ExecutionRecord{TaskUUID: "1", Tags: []string{"ml", "gpu"}})
+	s.addExecutionRecord(ExecutionRecord{TaskUUID: "2", Tags: []string{"etl"}})
+	s.addExecutionRecord(ExecutionRecord{TaskUUID: "3", Tags: []string{"ml"}})
+
+	history := s.HistoryByTag("ml")
+	if len(history) != 2 || history[0].TaskUUID != "1" || history[1].TaskUUID != "3" {
+		t.Error("unexpected history:", history)
+		return
+	}
+
+	if len(s.HistoryByTag("none")) != 0 {
+		t.Error("unexpected history for unknown tag")
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"sync"
+	"time"
+)
+
+// idleConn is a connection kept in an idleConns, and the time it was put there.
+type idleConn struct {
+	conn  *Conn
+	since time.Time
+}
+
+// idleConns keeps the connections dialed to send single messages, so that later messages to the same node reuse them
+// instead of dialing again. Up to size connections are kept for every IP, and connections idle for longer than
+// idleTimeout are closed. A nil idleConns keeps no connections.
+type idleConns struct {
+	size        int
+	idleTimeout time.Duration
+
+	// conns holds the idle connections, keyed by IP.
+	conns map[string][]idleConn
+
+	// lock is a Mutex lock over conns.
+	lock sync.Mutex
+}
+
+// newIdleConns creates an idleConns using the Config.IdleConnsPerNode and Config.IdleConnTimeout. If no idle
+// connections are allowed nil is returned.
+func newIdleConns(c Config) *idleConns {
+	if c.IdleConnsPerNode < 1 {
+		return nil
+	}
+
+	// Nodes using the same config close connections that don't deliver a message in time
+	idleTimeout := c.IdleConnTimeout
+	if c.PerMessageReadTimeout > 0 && (idleTimeout == 0 || c.PerMessageReadTimeout < idleTimeout) {
+		idleTimeout = c.PerMessageReadTimeout
+	}
+
+	return &idleConns{
+		size:        c.IdleConnsPerNode,
+		idleTimeout: idleTimeout,
+		conns:       make(map[string][]idleConn),
+	}
+}
+
+// get takes an idle connection to the IP. If there's none nil is returned, and a new connection must be dialed.
+func (p *idleConns) get(ip string) *Conn {
+	if p == nil {
+		return nil
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.evict(time.Now())
+
+	conns := p.conns[ip]
+	if len(conns) == 0 {
+		return nil
+	}
+
+	// The most recently used connection is the least likely to have been closed by the node
+	last := conns[len(conns)-1]
+	p.conns[ip] = conns[:len(conns)-1]
+
+	return last.conn
+}
+
+// put keeps the connection to the IP for later use. If there are already as many idle connections to the IP as
+// allowed false is returned, and the connection is not kept.
+func (p *idleConns) put(ip string, c *Conn) bool {
+	if p == nil || c == nil || c.netConn() == nil {
+		return false
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.evict(time.Now())
+
+	if len(p.conns[ip]) >= p.size {
+		return false
+	}
+
+	p.conns[ip] = append(p.conns[ip], idleConn{conn: c, since: time.Now()})
+
+	return true
+}
+
+// evict closes the connections that have been idle for longer than the idle timeout. It must be called with the lock
+// held.
+func (p *idleConns) evict(now time.Time) {
+	if p.idleTimeout <= 0 {
+		return
+	}
+
+	for ip, conns := range p.conns {
+		kept := conns[:0]
+		for _, idle := range conns {
+			if now.Sub(idle.since) < p.idleTimeout {
+				kept = append(kept, idle)
+				continue
+			}
+
+			_ = idle.conn.Close()
+		}
+
+		if len(kept) == 0 {
+			delete(p.conns, ip)
+			continue
+		}
+
+		p.conns[ip] = kept
+	}
+}
+
+// sweep evicts the expired idle connections every half idle timeout until the stop chan is closed, so that they're
+// closed even if no other connection is used. It returns right away if there's no idle timeout.
+func (p *idleConns) sweep(stop chan bool) {
+	if p == nil || p.idleTimeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			p.lock.Lock()
+			p.evict(now)
+			p.lock.Unlock()
+		}
+	}
+}
+
+// closeAll closes all the idle connections.
+func (p *idleConns) closeAll() {
+	if p == nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for ip, conns := range p.conns {
+		for _, idle := range conns {
+			_ = idle.conn.Close()
+		}
+
+		delete(p.conns, ip)
+	}
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func newPipeConn() *Conn {
+	a, _ := net.Pipe()
+	return &Conn{stream: a}
+}
+
+func TestIdleConns(t *testing.T) {
+	config := NewDefaultConfig()
+	config.IdleConnsPerNode = 1
+
+	p := newIdleConns(config)
+
+	first, second := newPipeConn(), newPipeConn()
+	if !p.put("192.168.1.1", first) {
+		t.Error("connection not kept")
+	}
+
+	if p.put("192.168.1.1", second) {
+		t.Error("connection kept over the pool size")
+	}
+
+	if p.get("192.168.1.2") != nil {
+		t.Error("connection returned for another IP")
+	}
+
+	if p.get("192.168.1.1") != first {
+		t.Error("kept connection not returned")
+	}
+
+	if p.get("192.168.1.1") != nil {
+		t.Error("connection returned twice")
+	}
+
+	if p.put("192.168.1.1", &Conn{}) {
+		t.Error("connection without an underlying connection kept")
+	}
+}
+
+func TestIdleConns_evict(t *testing.T) {
+	config := NewDefaultConfig()
+	config.IdleConnTimeout = time.Millisecond * 50
+
+	p := newIdleConns(config)
+	p.put("192.168.1.1", newPipeConn())
+
+	time.Sleep(time.Millisecond * 100)
+
+	if p.get("192.168.1.1") != nil {
+		t.Error("idle connection not evicted")
+	}
+}
+
+func TestNewIdleConnsDisabled(t *testing.T) {
+	config := NewDefaultConfig()
+	config.IdleConnsPerNode = 0
+
+	p := newIdleConns(config)
+	if p != nil {
+		t.Fatal("expected a nil idleConns")
+	}
+
+	if p.put("192.168.1.1", newPipeConn()) || p.get("192.168.1.1") != nil {
+		t.Error("connection kept by a nil idleConns")
+	}
+
+	p.closeAll()
+}
+
+func TestServer_sendReusesConn(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	dials := 0
+	s.connCallback = func(_ *Server, ip string, timeout ...time.Duration) (*Conn, error) {
+		dials++
+		return newPipeConn(), nil
+	}
+
+	s.sendCallback = func(*Server, *Conn, Message) error {
+		return nil
+	}
+
+	n := Node{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.1")}}
+	for i := 0; i < 3; i++ {
+		err := s.send(n, Message{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if dials != 1 {
+		t.Error("expected a single dial, got", dials)
+	}
+}
+
+func TestIdleConns_sweep(t *testing.T) {
+	config := NewDefaultConfig()
+	config.IdleConnTimeout = time.Millisecond * 50
+
+	p := newIdleConns(config)
+
+	a, b := net.Pipe()
+	p.put("192.168.1.1", &Conn{stream: a})
+
+	stop := make(chan bool)
+	defer close(stop)
+
+	go p.sweep(stop)
+
+	// Reads fail once the sweep closes the connection
+	_ = b.SetReadDeadline(time.Now().Add(time.Second))
+
+	_, err := b.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Error("expired connection not closed")
+	}
+}
+
+func TestServer_sendRetriesIdleConn(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	dials := 0
+	s.connCallback = func(_ *Server, ip string, timeout ...time.Duration) (*Conn, error) {
+		dials++
+		return newPipeConn(), nil
+	}
+
+	stale := newPipeConn()
+	s.idleConns.put("192.168.1.1", stale)
+
+	s.sendCallback = func(_ *Server, c *Conn, _ Message) error {
+		if c == stale {
+			return errors.New("broken pipe")
+		}
+
+		return nil
+	}
+
+	err := s.send(Node{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.1")}}, Message{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if dials != 1 {
+		t.Error("expected a single dial, got", dials)
+	}
+}
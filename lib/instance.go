@@ -0,0 +1,91 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// instanceCheckTimeout is the time waited when checking if another instance is listening on the inbound port.
+const instanceCheckTimeout = time.Millisecond * 100
+
+// ErrPortAlreadyInUse is produced when the inbound port can't be bound because another process holds it
+var ErrPortAlreadyInUse = errors.New("port already in use")
+
+// checkOtherInstance warns if another process, likely another beekeeper instance, is listening on the inbound port.
+// Two instances on the same machine discover each other, which can create feedback loops.
+func (s *Server) checkOtherInstance() {
+	if s.Config.AllowMultipleInstances || s.Config.DryRun {
+		return
+	}
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(s.Config.InboundPort))
+
+	conn, err := net.DialTimeout("tcp", addr, instanceCheckTimeout)
+	if err != nil {
+		return // Nothing is listening
+	}
+
+	_ = conn.Close()
+
+	s.log().Warnln("Another process is listening on port", s.Config.InboundPort, "and may be another beekeeper",
+		"instance. Running more than one instance on the same machine is not recommended")
+
+	s.otherInstanceDetected = true
+}
+
+// ListenError is produced by Start when the inbound port can't be bound. It wraps the error of the listener, which is
+// ErrPortAlreadyInUse if the port is taken.
+type ListenError struct {
+	Err error
+}
+
+// Error describes the error of the listener.
+func (e *ListenError) Error() string {
+	return "unable to listen: " + e.Err.Error()
+}
+
+// Unwrap returns the error of the listener.
+func (e *ListenError) Unwrap() error {
+	return e.Err
+}
+
+// listenError turns an error produced while binding the inbound port into a *ListenError, wrapping
+// ErrPortAlreadyInUse if the port is taken.
+func (s *Server) listenError(err error) error {
+	if !errors.Is(err, errAddrInUse) {
+		return &ListenError{Err: err}
+	}
+
+	if s.otherInstanceDetected {
+		return &ListenError{Err: fmt.Errorf("%w: port %d is held by another process, possibly another beekeeper "+
+			"instance. Stop it, use a different inbound port or set Config.AllowMultipleInstances",
+			ErrPortAlreadyInUse, s.Config.InboundPort)}
+	}
+
+	return &ListenError{Err: fmt.Errorf("%w: port %d: %s", ErrPortAlreadyInUse, s.Config.InboundPort, err.Error())}
+}
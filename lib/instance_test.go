@@ -0,0 +1,60 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestServer_checkOtherInstance(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer l.Close()
+
+	c := NewDefaultConfig()
+	c.InboundPort = l.Addr().(*net.TCPAddr).Port
+
+	s := NewServer(c)
+	if !s.otherInstanceDetected {
+		t.Error("other instance not detected")
+		return
+	}
+
+	_, err = net.Listen("tcp", l.Addr().String())
+	if !errors.Is(s.listenError(err), ErrPortAlreadyInUse) {
+		t.Error("expected ErrPortAlreadyInUse, got", s.listenError(err))
+		return
+	}
+
+	c.AllowMultipleInstances = true
+
+	s = NewServer(c)
+	if s.otherInstanceDetected {
+		t.Error("other instance detected while allowed")
+	}
+}
@@ -23,19 +23,60 @@
 package beekeeper
 
 import (
+	"errors"
+	"fmt"
 	"math"
 	"math/rand"
 	"sync"
 	"time"
 )
 
+// ErrNoNodes is produced when an operation needs at least one node but none is available
+var ErrNoNodes = errors.New("no nodes available")
+
+// Strategy is the method used by a LoadBalancer to select a node.
+type Strategy int
+
+const (
+	// StrategySoftmax picks the least loaded nodes, preferring the best performing ones with a Softmax algorithm
+	StrategySoftmax Strategy = iota
+
+	// StrategyRoundRobin picks the nodes in turns
+	StrategyRoundRobin
+
+	// StrategyRandom picks a node at random
+	StrategyRandom
+)
+
+// String returns a string representation of the Strategy.
+func (s Strategy) String() string {
+	switch s {
+	case StrategySoftmax:
+		return "Softmax"
+	case StrategyRoundRobin:
+		return "RoundRobin"
+	case StrategyRandom:
+		return "Random"
+	default:
+		return fmt.Sprintf("Strategy(%d)", int(s))
+	}
+}
+
 // LoadBalancer contains the data needed to try to select the best node for a task.
 // Should be created using NewLoadBalancer.
 type LoadBalancer struct {
-	server  *Server
-	best    int64
-	records nodeRecords
-	lock    sync.Mutex
+	server   *Server
+	best     int64
+	records  nodeRecords
+	strategy Strategy
+	next     int
+	lock     sync.Mutex
+
+	// healthCheckTimeout is the timeout of the health checks run before selecting a node. 0 disables them.
+	healthCheckTimeout time.Duration
+
+	// healthCheckTTL is the time the result of a health check is reused for.
+	healthCheckTTL time.Duration
 }
 
 type nodeRecords []*nodeRecord
@@ -43,6 +84,11 @@ type nodeRecords []*nodeRecord
 type nodeRecord struct {
 	node   Node
 	record record
+
+	// healthy is the result of the last health check of the node, run at checkedAt. Both are guarded by the
+	// LoadBalancer's lock.
+	healthy   bool
+	checkedAt time.Time
 }
 
 type record struct {
@@ -58,37 +104,120 @@ func NewLoadBalancer(s *Server, ns Nodes) *LoadBalancer {
 		records = append(records, &nodeRecord{node: w, record: record{time: time.Second.Milliseconds()}})
 	}
 
-	return &LoadBalancer{records: records, best: time.Hour.Milliseconds(), server: s}
+	return &LoadBalancer{
+		records:        records,
+		best:           time.Hour.Milliseconds(),
+		server:         s,
+		healthCheckTTL: defaultHealthCheckTTL,
+	}
 }
 
-// Execute will run a task, selecting the node based on it's workload. If multiple nodes are equally as busy, the
-// LoadBalancer will pick the best performing one, or pick based on a Softmax algorithm for exploration.
-func (lb *LoadBalancer) Execute(t Task, timeout ...time.Duration) (res Result, err error) {
+// SetStrategy changes the method used to select the nodes. It defaults to StrategySoftmax.
+func (lb *LoadBalancer) SetStrategy(s Strategy) {
 	lb.lock.Lock()
+	defer lb.lock.Unlock()
 
-	use := lb.pick()
+	lb.strategy = s
+}
 
-	use.record.load += 1
-	defer func() {
-		lb.lock.Lock()
-		use.record.load -= 1
-		lb.lock.Unlock()
-	}()
+// Execute will run a task, selecting the node based on it's workload. If multiple nodes are equally as busy, the
+// LoadBalancer will pick the best performing one, or pick based on a Softmax algorithm for exploration. Tasks with an
+// affinity node skip the selection.
+func (lb *LoadBalancer) Execute(t Task, timeout ...time.Duration) (Result, error) {
+	return lb.execute(t, nil, timeout...)
+}
 
-	lb.lock.Unlock()
+// execute runs the task like Execute, calling onSent once it's sent. See Server.execute.
+func (lb *LoadBalancer) execute(t Task, onSent func(uuid string, n Node), timeout ...time.Duration) (Result, error) {
+	use, err := lb.acquire(t)
+	if err != nil {
+		return Result{}, err
+	}
 
 	start := time.Now()
-	res, err = lb.server.Execute(use.node, t, timeout...)
+	res, err := lb.server.execute(use.node, t, onSent, timeout...)
+	lb.release(use, time.Since(start), err == nil)
+
 	if err != nil {
 		return Result{}, err
 	}
 
-	use.record.time = time.Since(start).Milliseconds()
+	return res, nil
+}
+
+// acquire selects the record of the node that should run the task and adds to its load. Every acquired record must be
+// released once the task is done.
+func (lb *LoadBalancer) acquire(t Task) (*nodeRecord, error) {
+	var use *nodeRecord
+
+	if t.AffinityNodeIP != "" {
+		affinity, found, err := lb.server.affinityNode(t)
+		if err != nil {
+			return nil, err
+		}
+
+		if found {
+			use = lb.records.find(affinity)
+			if use == nil {
+				use = &nodeRecord{node: affinity}
+			}
+		} else {
+			t.AffinityNodeIP = "" // Fallback to the normal selection
+		}
+	}
+
+	var unhealthy map[*nodeRecord]bool
+	if use == nil {
+		unhealthy = lb.unhealthyRecords()
+	}
+
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+
+	if use == nil {
+		candidates := lb.suitableRecords(t.ResourceHints).without(unhealthy)
+		if len(candidates) == 0 {
+			if !lb.anyCompatible(t.ResourceHints) {
+				return nil, ErrNoCompatibleNode
+			}
+
+			return nil, ErrNoSuitableNode
+		}
+
+		use = lb.pick(candidates)
+	}
+
+	use.record.load += 1
+
+	return use, nil
+}
+
+// release removes the load added by acquire. The execution time is only recorded for succeeded tasks.
+func (lb *LoadBalancer) release(use *nodeRecord, elapsed time.Duration, succeeded bool) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+
+	use.record.load -= 1
+
+	if !succeeded {
+		return
+	}
+
+	use.record.time = elapsed.Milliseconds()
 	if use.record.time < lb.best {
 		lb.best = use.record.time
 	}
+}
 
-	return res, nil
+// find returns the record of the given node, or nil if none is found.
+func (rs nodeRecords) find(n Node) *nodeRecord {
+	for _, r := range rs {
+		if r.node.Equals(n) {
+			return r
+		}
+	}
+
+	return nil
 }
 
 // getLowestLoad runs through a slice of nodeRecords and returns the lowes loaded ones. On a tie all the tied nodes
@@ -107,15 +236,59 @@ func (rs nodeRecords) getLowestLoad() nodeRecords {
 	return records
 }
 
-// pick selects the best node based on load, performance or a Softmax algorithm depending on the case.
-func (lb *LoadBalancer) pick() *nodeRecord {
+// suitableRecords returns the records of the nodes that have the resources in the hints.
+func (lb *LoadBalancer) suitableRecords(hints ResourceHints) nodeRecords {
+	if hints == (ResourceHints{}) {
+		return lb.records
+	}
+
+	var suitable nodeRecords
+	for _, r := range lb.records {
+		if hints.suits(lb.server.latestNode(r.node)) {
+			suitable = append(suitable, r)
+		}
+	}
+
+	return suitable
+}
+
+// anyCompatible reports if any of the nodes runs the OS required by the hints.
+func (lb *LoadBalancer) anyCompatible(hints ResourceHints) bool {
+	for _, r := range lb.records {
+		if hints.compatible(lb.server.latestNode(r.node)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pick selects one of the records using the LoadBalancer's Strategy.
+func (lb *LoadBalancer) pick(rs nodeRecords) *nodeRecord {
 	rand.Seed(time.Now().UTC().UnixNano())
 
-	softmax := lb.records.getLowestLoad().softmax(lb.best)
+	switch lb.strategy {
+	case StrategyRoundRobin:
+		use := rs[lb.next%len(rs)]
+		lb.next += 1
+
+		return use
+
+	case StrategyRandom:
+		return rs[rand.Intn(len(rs))]
+	}
+
+	return lb.pickSoftmax(rs)
+}
+
+// pickSoftmax selects the best node based on load, performance or a Softmax algorithm depending on the case.
+func (lb *LoadBalancer) pickSoftmax(rs nodeRecords) *nodeRecord {
+	lowest := rs.getLowestLoad()
+	softmax := lowest.softmax(lb.best)
 	for {
 		for i, prob := range softmax {
 			if prob > rand.Float64() {
-				return lb.records[i]
+				return lowest[i]
 			}
 		}
 	}
@@ -143,3 +316,54 @@ func (rs nodeRecords) softmax(best int64) []float64 {
 
 	return a
 }
+
+// ExecuteBalanced runs a task on one of the server's known nodes, selected by an internal LoadBalancer. The
+// LoadBalancer is recreated whenever the node list changes. An optional timeout argument can be passed.
+func (s *Server) ExecuteBalanced(t Task, timeout ...time.Duration) (Result, error) {
+	return s.executeBalanced(t, nil, timeout...)
+}
+
+// executeBalanced runs the task like ExecuteBalanced, calling onSent once it's sent. See Server.execute.
+func (s *Server) executeBalanced(t Task, onSent func(uuid string, n Node), timeout ...time.Duration) (Result, error) {
+	lb, err := s.getLoadBalancer()
+	if err != nil {
+		return Result{}, err
+	}
+
+	return lb.execute(t, onSent, timeout...)
+}
+
+// SetLoadBalancerStrategy sets the Strategy used by ExecuteBalanced. It defaults to StrategySoftmax.
+func (s *Server) SetLoadBalancerStrategy(strategy Strategy) {
+	s.loadBalancerLock.Lock()
+	defer s.loadBalancerLock.Unlock()
+
+	s.loadBalancerStrategy = strategy
+	if s.loadBalancer != nil {
+		s.loadBalancer.SetStrategy(strategy)
+	}
+}
+
+// getLoadBalancer returns the server's internal LoadBalancer, creating a new one if the node list has changed since
+// the last call.
+func (s *Server) getLoadBalancer() (*LoadBalancer, error) {
+	s.nodesLock.RLock()
+	nodes := make(Nodes, len(s.nodes))
+	copy(nodes, s.nodes)
+	s.nodesLock.RUnlock()
+
+	if len(nodes) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	s.loadBalancerLock.Lock()
+	defer s.loadBalancerLock.Unlock()
+
+	if s.loadBalancer == nil || !nodes.sameAs(s.loadBalancerNodes) {
+		s.loadBalancer = NewLoadBalancer(s, nodes)
+		s.loadBalancer.SetStrategy(s.loadBalancerStrategy)
+		s.loadBalancerNodes = nodes
+	}
+
+	return s.loadBalancer, nil
+}
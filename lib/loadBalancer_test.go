@@ -0,0 +1,107 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestServer_getLoadBalancer(t *testing.T) {
+	s := &Server{}
+
+	_, err := s.getLoadBalancer()
+	if err != ErrNoNodes {
+		t.Error("expected ErrNoNodes, got", err)
+		return
+	}
+
+	nodes := getTestNodes()
+	s.nodes = nodes[:2]
+
+	lb1, err := s.getLoadBalancer()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	lb2, _ := s.getLoadBalancer()
+	if lb1 != lb2 {
+		t.Error("load balancer recreated without node changes")
+		return
+	}
+
+	s.nodes = nodes
+	s.SetLoadBalancerStrategy(StrategyRoundRobin)
+
+	lb3, _ := s.getLoadBalancer()
+	if lb3 == lb1 {
+		t.Error("load balancer not recreated after node changes")
+		return
+	}
+
+	for i := 0; i < len(nodes); i++ {
+		if !lb3.pick(lb3.records).node.Equals(nodes[i]) {
+			t.Error("round robin order not followed")
+			return
+		}
+	}
+}
+
+func TestLoadBalancer_suitableRecords(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	nodes := getTestNodes()
+	nodes[0].Info.MemFree = 1 << 30
+	nodes[1].Info.MemFree = 1 << 20
+
+	lb := NewLoadBalancer(s, nodes)
+
+	if len(lb.suitableRecords(ResourceHints{})) != len(nodes) {
+		t.Error("nodes filtered without hints")
+	}
+
+	suitable := lb.suitableRecords(ResourceHints{MinFreeMemBytes: 1 << 29, RequiredOS: nodes[0].Info.OS})
+	if len(suitable) != 1 || !suitable[0].node.Equals(nodes[0]) {
+		t.Error("unexpected suitable nodes:", len(suitable))
+	}
+
+	_, err := lb.Execute(Task{ResourceHints: ResourceHints{RequiredOS: "plan9"}})
+	if err != ErrNoCompatibleNode {
+		t.Error("expected ErrNoCompatibleNode, got", err)
+	}
+
+	if !errors.Is(err, ErrNoSuitableNode) {
+		t.Error("ErrNoCompatibleNode doesn't wrap ErrNoSuitableNode")
+	}
+}
+
+func TestStrategy_String(t *testing.T) {
+	if StrategyRoundRobin.String() != "RoundRobin" {
+		t.Error("unexpected name:", StrategyRoundRobin.String())
+	}
+
+	if Strategy(42).String() != "Strategy(42)" {
+		t.Error("unexpected name:", Strategy(42).String())
+	}
+}
@@ -26,7 +26,10 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"time"
 )
@@ -55,14 +58,96 @@ const (
 
 	// OperationJobResult job ran and the details come in the Data
 	OperationJobResult
+
+	// OperationTopologyQuery ask a node for the nodes it knows
+	OperationTopologyQuery
+
+	// OperationTopologyReport the IPs of the known nodes come in the Data
+	OperationTopologyReport
+
+	// OperationBinaryQuery ask a node if it has the job binary with the SHA-256 checksum in the Data
+	OperationBinaryQuery
+
+	// OperationBinaryPresent the node has the queried job binary
+	OperationBinaryPresent
+
+	// OperationBinaryMissing the node doesn't have the queried job binary
+	OperationBinaryMissing
+
+	// OperationHandshake start a capability negotiation, the sender's capabilities come in the Capabilities
+	OperationHandshake
+
+	// OperationHandshakeResponse the responder's capabilities come in the Capabilities
+	OperationHandshakeResponse
+
+	// OperationJobCancel kill the running task with the UUID in the Data
+	OperationJobCancel
+
+	// OperationHealthCheck ask a node for its health status
+	OperationHealthCheck
+
+	// OperationHealthReport the node's HealthStatus comes in the Data
+	OperationHealthReport
+
+	// OperationFileTransfer a chunk of a file to be saved comes in the Data
+	OperationFileTransfer
+
+	// OperationFileTransferResult the acknowledgement of a file chunk, or its error, comes in the Data
+	OperationFileTransferResult
+
+	// OperationQueryHistory ask a node for the tasks it ran recently, up to the limit in the Data
+	OperationQueryHistory
+
+	// OperationHistoryResponse the node's recent ExecutionSummary list comes in the Data
+	OperationHistoryResponse
+
+	// OperationMetricsQuery ask a node for the runtime metrics of its process
+	OperationMetricsQuery
+
+	// OperationMetricsResponse the node's NodeMetrics comes in the Data
+	OperationMetricsResponse
+
+	// OperationBusy the node is running as many tasks as allowed, and the rejected task's Result comes in the Data
+	OperationBusy
+
+	// OperationDisconnect the primary node disconnected the node, and the reason comes in the Data
+	OperationDisconnect
+
+	// OperationBinaryURL the node must download its job binary from the URL in the Data, and verify its checksum
+	OperationBinaryURL
+
+	// OperationPing ask a node to respond right away, without gathering its status
+	OperationPing
+
+	// OperationPong the response to a ping
+	OperationPong
 )
 
 // String returns a string representation of the Operation.
 func (o Operation) String() string {
 	return []string{"None", "Status", "JobTransfer", "JobTransferFailed",
-		"JobTransferAcknowledge", "JobExecute", "JobResult"}[o]
+		"JobTransferAcknowledge", "JobExecute", "JobResult", "TopologyQuery", "TopologyReport",
+		"BinaryQuery", "BinaryPresent", "BinaryMissing", "Handshake", "HandshakeResponse",
+		"JobCancel", "HealthCheck", "HealthReport",
+		"FileTransfer", "FileTransferResult", "QueryHistory", "HistoryResponse",
+		"MetricsQuery", "MetricsResponse", "Busy", "Disconnect",
+		"BinaryURL", "Ping", "Pong"}[o]
 }
 
+// Encoding is used to specify how the Data of a Message is encoded
+type Encoding int
+
+const (
+	// EncodingGob the Data is gob encoded
+	EncodingGob Encoding = iota
+
+	// EncodingJSON the Data is JSON encoded
+	EncodingJSON
+)
+
+// ErrWrongEncoding is produced when decoding the Data of a Message with a different encoding than the one it uses
+var ErrWrongEncoding = errors.New("wrong data encoding")
+
 // Message is used for node communication. It holds the transferable data as well as some metadata about the node.
 type Message struct {
 	// SentAt timestamp for the Message.
@@ -91,6 +176,16 @@ type Message struct {
 
 	// NodeInfo contains metadata about the sender, like OS and current usage.
 	NodeInfo NodeInfo
+
+	// Capabilities lists the optional features enabled by the sender. It's only set on handshakes.
+	Capabilities []string
+
+	// DataEncoding is the encoding used for the Data. Defaults to EncodingGob.
+	DataEncoding Encoding
+
+	// TraceContext is the W3C trace context of the span the Message belongs to, as started by the
+	// Config.TracerProvider. It's only set on JobExecute messages sent by nodes with a TracerProvider.
+	TraceContext []byte
 }
 
 // NodeInfo holds additional info abut a node.
@@ -103,6 +198,39 @@ type NodeInfo struct {
 
 	// OS is the GOOS of the host system.
 	OS string
+
+	// Arch is the GOARCH of the host system.
+	Arch string
+
+	// MemFree is the memory available for new processes in the host system, in bytes.
+	MemFree uint64
+
+	// MemTotal is the total memory of the host system, in bytes.
+	MemTotal uint64
+
+	// MemUsed is the memory used in the host system, in bytes.
+	MemUsed uint64
+
+	// DiskTotal is the size of the disk holding the node's working directory, in bytes.
+	DiskTotal uint64
+
+	// DiskFree is the free space on the disk holding the node's working directory, in bytes.
+	DiskFree uint64
+
+	// CoreTemps are the temperatures of every CPU core, when available. Only supported on Linux.
+	CoreTemps []float32
+
+	// RunningTasks is the amount of tasks being run by the node.
+	RunningTasks int
+
+	// GoVersion is the version of Go the node was built with.
+	GoVersion string
+
+	// BeekeeperVersion is the version of the package the node was built with.
+	BeekeeperVersion string
+
+	// Labels are the Config.Labels of the node.
+	Labels map[string]string
 }
 
 // newMessage creates an empty message with a non-nil address
@@ -112,22 +240,72 @@ func newMessage() Message {
 
 // encode returns a gob encoded and gzip compressed message.
 func (m Message) encode() ([]byte, error) {
+	data, _, err := m.encodeSized(CompressionGzip)
+	return data, err
+}
+
+// encodeSized returns the gob encoded message compressed with the algorithm, and its size before compression.
+func (m Message) encodeSized(alg CompressionAlgorithm) (data []byte, uncompressed int, err error) {
+	if alg == CompressionGzip {
+		return m.encodeGzip()
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(uncompressedPrefix)
+
+	err = gob.NewEncoder(&buf).Encode(m)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data = buf.Bytes()
+	uncompressed = len(data) - 1
+
+	if alg == CompressionNone {
+		return data, uncompressed, nil
+	}
+
+	compressed, err := zstdCompress(data[1:])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return append([]byte{zstdPrefix}, compressed...), uncompressed, nil
+}
+
+// encodeGzip returns the gob encoded and gzip compressed message, and its size before compression.
+func (m Message) encodeGzip() (data []byte, uncompressed int, err error) {
 	var buf bytes.Buffer
 
 	// There is some debate on whether creating an encoder everytime is a good idea
 	// but Reddit says it's ok:
 	// https://www.reddit.com/r/golang/comments/7ospor/gob_encoding_how_do_you_use_it_in_production/
 	gzipWriter := gzip.NewWriter(&buf)
-	gobEncoder := gob.NewEncoder(gzipWriter)
+	counter := &countingWriter{w: gzipWriter}
+	gobEncoder := gob.NewEncoder(counter)
 
-	err := gobEncoder.Encode(m)
+	err = gobEncoder.Encode(m)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	_ = gzipWriter.Close()
 
-	return buf.Bytes(), nil
+	return buf.Bytes(), counter.n, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+// Write writes p to the underlying writer and counts the written bytes.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+
+	return n, err
 }
 
 // node uses the Message's metadata to construct a node object.
@@ -137,6 +315,7 @@ func (m Message) node() Node {
 		Name:   m.Name,
 		Status: m.Status,
 		Info:   m.NodeInfo,
+		Labels: m.NodeInfo.Labels,
 	}
 }
 
@@ -157,7 +336,7 @@ func (m Message) summary() string {
 func (m Message) respond(s *Server, response Message) error {
 	defer func() {
 		if r := recover(); r != nil {
-			logger.Errorln("An error ocurred while responding to", m.Name, ":", r)
+			s.log().Errorln("An error ocurred while responding to", m.Name, ":", r)
 		}
 	}()
 
@@ -172,6 +351,10 @@ func (m Message) respond(s *Server, response Message) error {
 	if err != nil {
 		return err
 	}
+	defer s.releaseConn(conn)
+
+	// Nodes with a hashed token reply using the plain one received
+	response.Token = m.Token
 
 	err = s.sendWithConn(conn, response)
 	if err != nil {
@@ -191,20 +374,43 @@ func (m Message) isTokenMatching(token2 string) bool {
 	return false
 }
 
-// decodeMessage expects a byte slice with a gob encoded and gzip compressed message data and turns it into a
-// Message object.
-func decodeMessage(data []byte) (Message, error) {
-	buf := bytes.NewBuffer(data)
+// decodeMessage expects a byte slice with a gob encoded message, compressed with any of the supported algorithms,
+// and turns it into a Message object. zstd compressed messages decompressing to more than maxSize bytes are rejected
+// with ErrMessageTooLarge, unless maxSize is 0.
+func decodeMessage(data []byte, maxSize uint64) (Message, error) {
+	if len(data) == 0 {
+		return Message{}, io.ErrUnexpectedEOF
+	}
 
-	gzipReader, err := gzip.NewReader(buf)
-	if err != nil {
-		return Message{}, err
+	var reader io.Reader
+	switch data[0] {
+	case gzipMagic:
+		gzipReader, err := gzip.NewReader(bytes.NewBuffer(data))
+		if err != nil {
+			return Message{}, err
+		}
+
+		reader = gzipReader
+
+	case uncompressedPrefix:
+		reader = bytes.NewBuffer(data[1:])
+
+	case zstdPrefix:
+		decompressed, err := zstdDecompress(data[1:], maxSize)
+		if err != nil {
+			return Message{}, err
+		}
+
+		reader = bytes.NewBuffer(decompressed)
+
+	default:
+		return Message{}, fmt.Errorf("unknown message compression %#x", data[0])
 	}
 
-	gobDecoder := gob.NewDecoder(gzipReader)
+	gobDecoder := gob.NewDecoder(reader)
 
 	msg := Message{}
-	err = gobDecoder.Decode(&msg)
+	err := gobDecoder.Decode(&msg)
 	if err != nil {
 		return Message{}, err
 	}
@@ -225,3 +431,27 @@ func (m Message) setData(data interface{}) (Message, error) {
 
 	return m, nil
 }
+
+// SetDataJSON JSON encodes v into the Data of the Message, and sets DataEncoding to EncodingJSON. Unlike gob, it
+// doesn't require the types to be registered, and can be read by non-Go nodes.
+func (m *Message) SetDataJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	m.Data = data
+	m.DataEncoding = EncodingJSON
+
+	return nil
+}
+
+// DataJSON decodes the JSON encoded Data of the Message into v. If the Data is not JSON encoded ErrWrongEncoding is
+// returned.
+func (m Message) DataJSON(v interface{}) error {
+	if m.DataEncoding != EncodingJSON {
+		return ErrWrongEncoding
+	}
+
+	return json.Unmarshal(m.Data, v)
+}
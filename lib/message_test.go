@@ -23,8 +23,13 @@
 package beekeeper
 
 import (
+	"fmt"
 	"github.com/google/go-cmp/cmp"
+	"math/rand"
+	"net"
+	"reflect"
 	"testing"
+	"testing/quick"
 	"time"
 )
 
@@ -57,3 +62,238 @@ func TestMessage_Respond(t *testing.T) {
 		return
 	}
 }
+
+func TestMessage_DataJSON(t *testing.T) {
+	expect := map[string]int{"a": 1, "b": 2}
+
+	msg := getTestMessage()
+	err := msg.SetDataJSON(expect)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	data, err := msg.encode()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	msg, err = decodeMessage(data, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var received map[string]int
+	err = msg.DataJSON(&received)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !cmp.Equal(received, expect) {
+		t.Error("non matching data:", cmp.Diff(received, expect))
+	}
+
+	err = getTestMessage().DataJSON(&received)
+	if err != ErrWrongEncoding {
+		t.Error("expected ErrWrongEncoding, got", err)
+	}
+}
+
+// roundTripAddrs are the sender addresses used by randomMessage, as net.TCPAddr can't be generated by testing/quick.
+var roundTripAddrs = []*net.TCPAddr{
+	nil,
+	{IP: net.ParseIP("192.168.1.1"), Port: 2020},
+	{IP: net.ParseIP("10.0.0.1").To4(), Port: 65535},
+	{IP: net.ParseIP("fe80::1"), Port: 2021, Zone: "eth0"},
+}
+
+// randomMessage is a Message generated by testing/quick. Empty slices are left nil and times are in UTC, as that's how
+// gob decodes them.
+type randomMessage struct {
+	Message
+}
+
+// Generate implements quick.Generator.
+func (randomMessage) Generate(r *rand.Rand, size int) reflect.Value {
+	info, _ := quick.Value(reflect.TypeOf(NodeInfo{}), r)
+
+	msg := Message{
+		SentAt:        time.Unix(r.Int63n(1<<33), r.Int63n(int64(time.Second))).UTC(),
+		Name:          randomString(r, size),
+		Operation:     Operation(r.Intn(int(OperationBinaryURL) + 1)),
+		Data:          randomBytes(r, size),
+		Token:         randomString(r, size),
+		Addr:          roundTripAddrs[r.Intn(len(roundTripAddrs))],
+		RespondOnPort: r.Intn(65536),
+		Status:        Status(r.Intn(StatusWorking + 1)),
+		NodeInfo:      info.Interface().(NodeInfo),
+		DataEncoding:  Encoding(r.Intn(int(EncodingJSON) + 1)),
+		TraceContext:  randomBytes(r, size),
+	}
+
+	if len(msg.NodeInfo.CoreTemps) == 0 {
+		msg.NodeInfo.CoreTemps = nil
+	}
+
+	if len(msg.NodeInfo.Labels) == 0 {
+		msg.NodeInfo.Labels = nil
+	}
+
+	for i := r.Intn(3); i > 0; i-- {
+		msg.Capabilities = append(msg.Capabilities, randomString(r, size))
+	}
+
+	return reflect.ValueOf(randomMessage{msg})
+}
+
+// randomBytes returns up to size random bytes, or nil.
+func randomBytes(r *rand.Rand, size int) []byte {
+	n := r.Intn(size + 1)
+	if n == 0 {
+		return nil
+	}
+
+	b := make([]byte, n)
+	r.Read(b)
+
+	return b
+}
+
+// randomString returns a random string of up to size runes.
+func randomString(r *rand.Rand, size int) string {
+	v, _ := quick.Value(reflect.TypeOf(""), r)
+	s := []rune(v.Interface().(string))
+
+	if len(s) > size {
+		s = s[:size]
+	}
+
+	return string(s)
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	roundTrip := func(original randomMessage) bool {
+		data, err := original.encode()
+		if err != nil {
+			t.Error(err)
+			return false
+		}
+
+		decoded, err := decodeMessage(data, 0)
+		if err != nil {
+			t.Error(err)
+			return false
+		}
+
+		if !cmp.Equal(original.Message, decoded) {
+			t.Error(cmp.Diff(original.Message, decoded))
+			return false
+		}
+
+		return true
+	}
+
+	err := quick.Check(roundTrip, &quick.Config{MaxCount: 1000})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMessage_encodeCompression(t *testing.T) {
+	original := getTestMessage()
+	original.SentAt = time.Unix(1600000000, 0).UTC() // Without a monotonic reading, which isn't sent
+	original.Data = []byte("compressed data")
+
+	for _, alg := range []CompressionAlgorithm{CompressionGzip, CompressionZstd, CompressionNone} {
+		data, _, err := original.encodeSized(alg)
+		if err != nil {
+			t.Error(alg, err)
+			continue
+		}
+
+		decoded, err := decodeMessage(data, 0)
+		if err != nil {
+			t.Error(alg, err)
+			continue
+		}
+
+		if !cmp.Equal(original, decoded) {
+			t.Error(alg, cmp.Diff(original, decoded))
+		}
+	}
+}
+
+func TestDecodeMessageUnknownCompression(t *testing.T) {
+	for _, data := range [][]byte{nil, {0xff, 0x00}} {
+		if _, err := decodeMessage(data, 0); err == nil {
+			t.Error("expected an error decoding", data)
+		}
+	}
+}
+
+func TestDecodeMessageZstdSizeLimit(t *testing.T) {
+	original := getTestMessage()
+	original.Data = make([]byte, 1<<20) // Compresses to a few bytes
+
+	data, _, err := original.encodeSized(CompressionZstd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = decodeMessage(data, 1<<16)
+	if err != ErrMessageTooLarge {
+		t.Error("expected ErrMessageTooLarge, got", err)
+	}
+
+	_, err = decodeMessage(data, 2<<20)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func BenchmarkMessageRoundTrip(b *testing.B) {
+	// Repeated words compress like typical task data, unlike random bytes
+	words := []string{"beekeeper", "task", "result", "node", "primary", "worker", "0", "1", "2", "3"}
+	r := rand.New(rand.NewSource(1))
+
+	for _, size := range []int{1 << 10, 100 << 10, 1 << 20} {
+		var payload []byte
+		for len(payload) < size {
+			payload = append(payload, words[r.Intn(len(words))]...)
+		}
+
+		msg := getTestMessage()
+		msg.Data = payload[:size]
+
+		for _, alg := range []CompressionAlgorithm{CompressionGzip, CompressionZstd, CompressionNone} {
+			b.Run(fmt.Sprintf("%s/%dKB", alg, size>>10), func(b *testing.B) {
+				b.SetBytes(int64(size))
+
+				for i := 0; i < b.N; i++ {
+					data, _, err := msg.encodeSized(alg)
+					if err != nil {
+						b.Fatal(err)
+					}
+
+					_, err = decodeMessage(data, 0)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCompressionAlgorithm_String(t *testing.T) {
+	if CompressionZstd.String() != "zstd" {
+		t.Error("unexpected name:", CompressionZstd.String())
+	}
+
+	if CompressionAlgorithm(42).String() != "CompressionAlgorithm(42)" {
+		t.Error("unexpected name:", CompressionAlgorithm(42).String())
+	}
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"runtime"
+	"time"
+)
+
+// procFDPath is the folder listing the open file descriptors of the process. Only available on Linux.
+const procFDPath = "/proc/self/fd"
+
+// NodeMetrics holds runtime metrics of a node's Beekeeper process, as reported to Server.NodeMetrics.
+type NodeMetrics struct {
+	// GoroutineCount is the amount of goroutines running.
+	GoroutineCount int
+
+	// HeapAllocBytes is the memory allocated for heap objects, in bytes.
+	HeapAllocBytes uint64
+
+	// GCPauseNs is the total time spent in garbage collection pauses since the process started, in nanoseconds.
+	GCPauseNs uint64
+
+	// OpenFDCount is the amount of open file descriptors. It's -1 if it can't be measured, as happens outside of
+	// Linux.
+	OpenFDCount int
+}
+
+// NodeMetrics asks the node for the metrics of its Beekeeper process and blocks until they are received. An optional
+// timeout parameter can be provided.
+func (s *Server) NodeMetrics(n Node, timeout ...time.Duration) (NodeMetrics, error) {
+	msg, err := s.query(n, Message{Operation: OperationMetricsQuery}, OperationMetricsResponse, timeout...)
+	if err != nil {
+		return NodeMetrics{}, err
+	}
+
+	return decodeNodeMetrics(msg.Data)
+}
+
+// metricsQueryCallback is the callback for the MetricsQuery operation.
+func metricsQueryCallback(s *Server, conn *Conn, _ Message) {
+	msg, err := Message{Operation: OperationMetricsResponse}.setData(getNodeMetrics())
+	if err != nil {
+		s.log().Errorln("Unable to encode metrics:", err)
+		return
+	}
+
+	err = s.sendWithConn(conn, msg)
+	if err != nil {
+		s.log().Errorln("Unable to respond to a metrics query:", err)
+		return
+	}
+}
+
+// getNodeMetrics measures the NodeMetrics of the local process.
+func getNodeMetrics() NodeMetrics {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	metrics := NodeMetrics{
+		GoroutineCount: runtime.NumGoroutine(),
+		HeapAllocBytes: memStats.HeapAlloc,
+		GCPauseNs:      memStats.PauseTotalNs,
+		OpenFDCount:    -1,
+	}
+
+	fds, err := ioutil.ReadDir(procFDPath)
+	if err == nil {
+		metrics.OpenFDCount = len(fds)
+	}
+
+	return metrics
+}
+
+// decodeNodeMetrics decodes the gob encoded NodeMetrics sent in a MetricsResponse.
+func decodeNodeMetrics(data []byte) (NodeMetrics, error) {
+	var metrics NodeMetrics
+
+	err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&metrics)
+	if err != nil {
+		return NodeMetrics{}, err
+	}
+
+	return metrics, nil
+}
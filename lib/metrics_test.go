@@ -0,0 +1,81 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServer_NodeMetrics(t *testing.T) {
+	sv, receiveChan, sendChan := startPrimaryTestChannels()
+
+	type result struct {
+		metrics NodeMetrics
+		err     error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		metrics, err := sv.NodeMetrics(getTestNodes()[0], time.Second)
+		done <- result{metrics, err}
+	}()
+
+	select {
+	case query := <-sendChan:
+		if query.Operation != OperationMetricsQuery {
+			t.Error("unexpected operation", query.Operation)
+			return
+		}
+	case <-time.After(time.Second):
+		t.Error("query not sent")
+		return
+	}
+
+	// Answer with the metrics of this process, as a node would
+	go metricsQueryCallback(sv, &Conn{}, getTestMessage())
+
+	var response Message
+	select {
+	case response = <-sendChan:
+	case <-time.After(time.Second):
+		t.Error("metrics not sent")
+		return
+	}
+
+	msg := getTestMessage()
+	msg.Operation = response.Operation
+	msg.Data = response.Data
+	msg.Token = sv.Config.Token
+	receiveChan <- Request{Msg: msg, Conn: Conn{}}
+
+	res := <-done
+	if res.err != nil {
+		t.Error(res.err)
+		return
+	}
+
+	if res.metrics.GoroutineCount == 0 || res.metrics.HeapAllocBytes == 0 {
+		t.Error("unexpected metrics", res.metrics)
+	}
+}
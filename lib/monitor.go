@@ -26,6 +26,8 @@ import (
 	"fmt"
 	"github.com/gdamore/tcell/v2"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/rivo/tview"
@@ -33,12 +35,48 @@ import (
 
 const monitorMaxWorkersPerPage = 5
 
+// MonitorPageHistory is the name of the Monitor page that shows the task execution history.
+const MonitorPageHistory = "history"
+
+// MonitorPageDetail is the name of the Monitor page that shows the details of the selected node.
+const MonitorPageDetail = "detail"
+
+// monitorHistoryLimit is the amount of recent tasks shown on the detail page of a node.
+const monitorHistoryLimit = 20
+
+// monitorHistoryTimeout is the time waited for a node to send its recent tasks to the detail page.
+const monitorHistoryTimeout = time.Second * 5
+
+// monitorDetailBoxHeight is the height in rows of a node's detail box, including the row used for its latest error.
+const monitorDetailBoxHeight = 6
+
 // Monitor represents a Beekeeper Monitor.
 type Monitor struct {
 	App         *tview.Application
 	Pages       *tview.Pages
 	CurrentPage int
 	server      *Server
+
+	// pageCount is the amount of node status pages.
+	pageCount int
+
+	// showingHistory is whether the history page is being shown instead of the node status pages.
+	showingHistory bool
+
+	// nodes are the nodes shown on the last render, in the order they are shown.
+	nodes Nodes
+
+	// selected is the index in nodes of the selected node.
+	selected int
+
+	// detailIP is the IP of the node shown on the detail page. It's empty if the page is not being shown.
+	detailIP string
+
+	// detailHistory holds the tasks recently run by the node shown on the detail page. It's nil until received.
+	detailHistory []ExecutionSummary
+
+	// detailHistoryLock is a Mutex lock over detailHistory.
+	detailHistoryLock sync.Mutex
 }
 
 // NewMonitor creates and returns a *Monitor struct.
@@ -65,7 +103,7 @@ func (m *Monitor) Run(configs ...Config) {
 	go func() {
 		err := m.server.Start()
 		if err != nil {
-			logger.Fatalln("Unable to start server:", err.Error())
+			m.server.log().Fatalln("Unable to start server:", err.Error())
 		}
 	}()
 
@@ -75,12 +113,30 @@ func (m *Monitor) Run(configs ...Config) {
 			m.Stop()
 			os.Exit(0)
 		case tcell.KeyEsc:
+			if m.detailIP != "" {
+				m.HideDetail()
+				break
+			}
+
 			m.Stop()
 			os.Exit(0)
 		case tcell.KeyRight:
 			m.NextPage()
 		case tcell.KeyLeft:
 			m.PreviousPage()
+		case tcell.KeyDown:
+			m.SelectNext()
+		case tcell.KeyUp:
+			m.SelectPrevious()
+		case tcell.KeyEnter:
+			m.ShowDetail()
+		case tcell.KeyRune:
+			switch e.Rune() {
+			case 'h', 'H':
+				m.ToggleHistory()
+			case 'd', 'D':
+				m.ShowDetail()
+			}
 		}
 
 		return e
@@ -91,9 +147,7 @@ func (m *Monitor) Run(configs ...Config) {
 
 	go func() {
 		for {
-			m.server.nodesLock.Lock()
-			m.server.nodes = Nodes{}
-			m.server.nodesLock.Unlock()
+			m.server.clearNodes()
 
 			err := m.server.broadcastMessage(Message{
 				Operation:     OperationStatus,
@@ -101,7 +155,7 @@ func (m *Monitor) Run(configs ...Config) {
 				RespondOnPort: config.InboundPort}, true)
 
 			if err != nil {
-				logger.Errorln("Unable to broadcast status request:", err)
+				m.server.log().Errorln("Unable to broadcast status request:", err)
 
 				time.Sleep(sleepTime)
 				continue
@@ -115,7 +169,7 @@ func (m *Monitor) Run(configs ...Config) {
 
 			m.App.QueueUpdateDraw(func() {
 				m.server.nodesLock.RLock()
-				m.Render(m.server.nodes)
+				m.Render(m.server.nodes.first(config.MaxNodesPerScan))
 				m.server.nodesLock.RUnlock()
 			})
 
@@ -124,7 +178,7 @@ func (m *Monitor) Run(configs ...Config) {
 
 	err := m.App.Run()
 	if err != nil {
-		logger.Fatalln("Unable to start monitor interface:", err)
+		m.server.log().Fatalln("Unable to start monitor interface:", err)
 	}
 }
 
@@ -136,10 +190,21 @@ func (m *Monitor) Render(ns Nodes) {
 	// Order the workers so their position keeps regular between updates
 	ns = ns.sort()
 
+	m.nodes = make(Nodes, len(ns))
+	copy(m.nodes, ns)
+
+	if m.selected >= len(m.nodes) {
+		m.selected = len(m.nodes) - 1
+	}
+
+	if m.selected < 0 {
+		m.selected = 0
+	}
+
 	// Generate details
 	var detailBoxes []*tview.Flex
-	for _, w := range ns {
-		detailBoxes = append(detailBoxes, newWorkerDetailBox(w))
+	for i, w := range ns {
+		detailBoxes = append(detailBoxes, newWorkerDetailBox(w, i == m.selected))
 	}
 
 	// Generate pages
@@ -153,30 +218,161 @@ func (m *Monitor) Render(ns Nodes) {
 		m.Pages.AddPage(pageName, content, true, false)
 	}
 
-	m.Pages.SwitchToPage(fmt.Sprintf("%d", m.CurrentPage))
+	m.pageCount = len(chunks)
+
+	// Generate the history page
+	m.Pages.AddPage(MonitorPageHistory, newHistoryPage(m.server.History()), true, false)
+
+	// Generate the detail page
+	if m.detailIP != "" {
+		m.Pages.AddPage(MonitorPageDetail, newNodeDetailPage(m.findNode(m.detailIP), m.getDetailHistory()), true,
+			false)
+	}
+
+	m.switchToCurrentPage()
+
 	m.App.SetRoot(m.Pages, true)
 }
 
-// NextPage  changes the page to the n+1 page.
+// switchToCurrentPage shows the detail, history or node status page, in that order of precedence.
+func (m *Monitor) switchToCurrentPage() {
+	switch {
+	case m.detailIP != "":
+		m.Pages.SwitchToPage(MonitorPageDetail)
+	case m.showingHistory:
+		m.Pages.SwitchToPage(MonitorPageHistory)
+	default:
+		m.Pages.SwitchToPage(fmt.Sprintf("%d", m.CurrentPage))
+	}
+}
+
+// findNode returns the node shown on the last render with the given IP. A nil pointer is returned if it's not found.
+func (m *Monitor) findNode(ip string) *Node {
+	for i := range m.nodes {
+		if m.nodes[i].Addr.IP.String() == ip {
+			return &m.nodes[i]
+		}
+	}
+
+	return nil
+}
+
+// SelectNext selects the next node, changing the page if needed. Only the node status pages can be navigated.
+func (m *Monitor) SelectNext() {
+	if m.showingHistory || m.detailIP != "" || m.selected+1 >= len(m.nodes) {
+		return
+	}
+
+	m.selected++
+	m.followSelection()
+}
+
+// SelectPrevious selects the previous node, changing the page if needed. Only the node status pages can be navigated.
+func (m *Monitor) SelectPrevious() {
+	if m.showingHistory || m.detailIP != "" || m.selected < 1 {
+		return
+	}
+
+	m.selected--
+	m.followSelection()
+}
+
+// followSelection shows the page holding the selected node, and redraws it so the selection is highlighted.
+func (m *Monitor) followSelection() {
+	m.CurrentPage = m.selected/monitorMaxWorkersPerPage + 1
+	m.Render(m.nodes)
+}
+
+// ShowDetail shows the detail page of the selected node. Press Escape to go back.
+func (m *Monitor) ShowDetail() {
+	if m.showingHistory || m.selected >= len(m.nodes) {
+		return
+	}
+
+	n := m.nodes[m.selected]
+	m.detailIP = n.Addr.IP.String()
+	m.setDetailHistory(nil)
+
+	// The history is shown on the next render once received
+	go func() {
+		history, err := m.server.QueryNodeHistory(n, monitorHistoryLimit, monitorHistoryTimeout)
+		if err != nil {
+			m.server.log().Debugln("Unable to query the history of", n.Name+":", err)
+			return
+		}
+
+		if history == nil {
+			history = []ExecutionSummary{}
+		}
+
+		m.setDetailHistory(history)
+	}()
+
+	m.Pages.AddPage(MonitorPageDetail, newNodeDetailPage(&n, nil), true, false)
+	m.switchToCurrentPage()
+}
+
+// getDetailHistory returns the history of the node shown on the detail page, or nil if it wasn't received yet.
+func (m *Monitor) getDetailHistory() []ExecutionSummary {
+	m.detailHistoryLock.Lock()
+	defer m.detailHistoryLock.Unlock()
+
+	return m.detailHistory
+}
+
+// setDetailHistory replaces the history of the node shown on the detail page.
+func (m *Monitor) setDetailHistory(history []ExecutionSummary) {
+	m.detailHistoryLock.Lock()
+	defer m.detailHistoryLock.Unlock()
+
+	m.detailHistory = history
+}
+
+// HideDetail goes back from the detail page to the node status pages.
+func (m *Monitor) HideDetail() {
+	m.detailIP = ""
+	m.setDetailHistory(nil)
+	m.Pages.RemovePage(MonitorPageDetail)
+	m.switchToCurrentPage()
+}
+
+// ToggleHistory switches between the node status pages and the history page.
+func (m *Monitor) ToggleHistory() {
+	if m.detailIP != "" {
+		return
+	}
+
+	m.showingHistory = !m.showingHistory
+
+	if m.showingHistory {
+		m.Pages.SwitchToPage(MonitorPageHistory)
+	} else {
+		m.Pages.SwitchToPage(fmt.Sprintf("%d", m.CurrentPage))
+	}
+}
+
+// NextPage  changes the page to the n+1 page. Only the node status pages are cycled.
 func (m *Monitor) NextPage() {
 	next := m.CurrentPage + 1
-	if m.Pages.GetPageCount() < next {
+	if m.showingHistory || m.detailIP != "" || m.pageCount < next {
 		return
 	}
 
 	m.CurrentPage = next
-	m.Pages.SwitchToPage(fmt.Sprintf("%d", next))
+	m.selected = (next - 1) * monitorMaxWorkersPerPage
+	m.Render(m.nodes)
 }
 
-// PreviousPage changes the page to the n-1 page.
+// PreviousPage changes the page to the n-1 page. Only the node status pages are cycled.
 func (m *Monitor) PreviousPage() {
 	previous := m.CurrentPage - 1
-	if previous < 1 {
+	if m.showingHistory || m.detailIP != "" || previous < 1 {
 		return
 	}
 
 	m.CurrentPage = previous
-	m.Pages.SwitchToPage(fmt.Sprintf("%d", previous))
+	m.selected = (previous - 1) * monitorMaxWorkersPerPage
+	m.Render(m.nodes)
 }
 
 // Stop stops the monitor's App and Server.
@@ -194,7 +390,7 @@ func pageContentFromChunk(chunk []*tview.Flex, pageNum int, totalPages int) *tvi
 	content.SetTitleAlign(tview.AlignCenter)
 
 	for _, row := range chunk {
-		content.AddItem(row, 5, 5, false)
+		content.AddItem(row, monitorDetailBoxHeight, 5, false)
 	}
 
 	// Check if the page has missing workers
@@ -224,8 +420,54 @@ func pageContentFromChunk(chunk []*tview.Flex, pageNum int, totalPages int) *tvi
 	return content
 }
 
-// newWorkerDetailBox creates a new detailed view box of a Node to be rendered on the Monitor.
-func newWorkerDetailBox(w Node) *tview.Flex {
+// newHistoryPage creates a page with a table of the task executions, from newest to oldest, to be rendered on the
+// Monitor.
+func newHistoryPage(h ExecutionHistory) *tview.Flex {
+	table := tview.NewTable().SetFixed(1, 0)
+
+	for col, title := range []string{"Task UUID", "Node", "Duration", "Status"} {
+		table.SetCell(0, col, tview.NewTableCell(title).
+			SetTextColor(tcell.ColorYellow).
+			SetAlign(tview.AlignCenter).
+			SetExpansion(1).
+			SetSelectable(false))
+	}
+
+	for i := range h {
+		r := h[len(h)-1-i]
+		row := i + 1
+
+		statusColor := tcell.ColorGreen
+		if r.Error != "" {
+			statusColor = tcell.ColorRed
+		}
+
+		table.SetCell(row, 0, tview.NewTableCell(r.TaskUUID).SetAlign(tview.AlignCenter).SetExpansion(1))
+		table.SetCell(row, 1, tview.NewTableCell(r.NodeName).SetAlign(tview.AlignCenter).SetExpansion(1))
+		table.SetCell(row, 2, tview.NewTableCell(r.Duration.Round(time.Millisecond).String()).
+			SetAlign(tview.AlignCenter).
+			SetExpansion(1))
+		table.SetCell(row, 3, tview.NewTableCell(r.Status()).
+			SetTextColor(statusColor).
+			SetAlign(tview.AlignCenter).
+			SetExpansion(1))
+	}
+
+	content := tview.NewFlex().SetDirection(tview.FlexRow)
+
+	content.SetBorder(true)
+	content.SetTitle(" Beekeeper Monitor - History ") // Spaces for formatting
+	content.SetTitleAlign(tview.AlignCenter)
+
+	content.AddItem(table, 0, 1, false)
+	content.AddItem(newPrimitive("Press H to go back"), 1, 1, false)
+
+	return content
+}
+
+// newWorkerDetailBox creates a new detailed view box of a Node to be rendered on the Monitor. Selected boxes are
+// highlighted.
+func newWorkerDetailBox(w Node, selected bool) *tview.Flex {
 	ip := tview.NewFlex()
 	ip.SetTitle("IP").
 		SetBorder(true).
@@ -250,17 +492,127 @@ func newWorkerDetailBox(w Node) *tview.Flex {
 		SetTitleAlign(tview.AlignCenter)
 	usage.AddItem(newPrimitive(fmt.Sprintf("%d%%", int(w.Info.Usage))), 0, 1, false)
 
-	flex := tview.NewFlex()
+	stats := tview.NewFlex()
+	stats.AddItem(ip, 0, 1, false)
+	stats.AddItem(status, 0, 1, false)
+	stats.AddItem(cpuTemp, 0, 1, false)
+	stats.AddItem(usage, 0, 1, false)
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow)
 	flex.Box.SetTitle(w.Name).SetBorder(true).SetTitleAlign(tview.AlignLeft)
 
-	flex.AddItem(ip, 0, 1, false)
-	flex.AddItem(status, 0, 1, false)
-	flex.AddItem(cpuTemp, 0, 1, false)
-	flex.AddItem(usage, 0, 1, false)
+	if selected {
+		flex.Box.SetBorderColor(tcell.ColorYellow)
+	}
+
+	flex.AddItem(stats, 0, 1, false)
+
+	// Show the latest error, if any
+	if errs := w.RecentErrors(); len(errs) > 0 {
+		lastErr := tview.NewTextView().
+			SetTextAlign(tview.AlignCenter).
+			SetTextColor(tcell.ColorRed).
+			SetText("Last error: " + errs[len(errs)-1])
+
+		flex.AddItem(lastErr, 1, 0, false)
+	}
 
 	return flex
 }
 
+// newNodeDetailPage creates a page with all the details of a node to be rendered on the Monitor. A nil node means it's
+// no longer online, and a nil history means it wasn't received yet.
+func newNodeDetailPage(n *Node, history []ExecutionSummary) *tview.Flex {
+	content := tview.NewFlex().SetDirection(tview.FlexRow)
+
+	content.SetBorder(true)
+	content.SetTitleAlign(tview.AlignCenter)
+
+	if n == nil {
+		content.SetTitle(" Beekeeper Monitor - Node Detail ") // Spaces for formatting
+		content.AddItem(newPrimitive("The node is no longer online"), 0, 1, false)
+		content.AddItem(newPrimitive("Press Esc to go back"), 1, 1, false)
+
+		return content
+	}
+
+	content.SetTitle(" Beekeeper Monitor - " + n.Name + " ") // Spaces for formatting
+
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("IP: %s\n", n.Addr.IP.String()))
+	sb.WriteString(fmt.Sprintf("Status: %s\n", n.Status.String()))
+	sb.WriteString(fmt.Sprintf("OS: %s\n", n.Info.OS))
+	sb.WriteString(fmt.Sprintf("Architecture: %s\n", n.Info.Arch))
+	sb.WriteString(fmt.Sprintf("Go version: %s\n", n.Info.GoVersion))
+	sb.WriteString(fmt.Sprintf("Beekeeper version: %s\n", n.Info.BeekeeperVersion))
+	sb.WriteString(fmt.Sprintf("Running tasks: %d\n\n", n.Info.RunningTasks))
+
+	sb.WriteString(fmt.Sprintf("CPU usage: %d%%\n", int(n.Info.Usage)))
+	sb.WriteString(fmt.Sprintf("CPU temp.: %d°C\n", int(n.Info.CPUTemp)))
+	for i, temp := range n.Info.CoreTemps {
+		sb.WriteString(fmt.Sprintf("  Core %d: %.1f°C\n", i, temp))
+	}
+
+	sb.WriteString(fmt.Sprintf("\nMemory: %s used, %s available, %s total\n", formatBytes(n.Info.MemUsed),
+		formatBytes(n.Info.MemFree), formatBytes(n.Info.MemTotal)))
+	sb.WriteString(fmt.Sprintf("Disk: %s free, %s total\n", formatBytes(n.Info.DiskFree),
+		formatBytes(n.Info.DiskTotal)))
+
+	sb.WriteString("\nRecent errors:\n")
+	errs := n.RecentErrors()
+	if len(errs) == 0 {
+		sb.WriteString("  None\n")
+	}
+
+	for i := range errs {
+		sb.WriteString("  " + errs[len(errs)-1-i] + "\n")
+	}
+
+	sb.WriteString("\nRecent tasks:\n")
+	switch {
+	case history == nil:
+		sb.WriteString("  Loading...\n")
+	case len(history) == 0:
+		sb.WriteString("  None\n")
+	}
+
+	for i := range history {
+		h := history[len(history)-1-i]
+
+		status := "OK"
+		if h.Error {
+			status = "Failed"
+		}
+
+		sb.WriteString(fmt.Sprintf("  %s  %s  %s  %s\n", h.UUID, h.StartedAt.Format("15:04:05"),
+			h.Duration.Round(time.Millisecond).String(), status))
+	}
+
+	details := tview.NewTextView().SetScrollable(true).SetText(sb.String())
+
+	content.AddItem(details, 0, 1, false)
+	content.AddItem(newPrimitive("Press Esc to go back"), 1, 1, false)
+
+	return content
+}
+
+// formatBytes formats a size in bytes using binary units, e.g. 1.5 GiB.
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
 // chunkDetails utility function to chunk a slice of details into pages.
 func chunkDetails(details []*tview.Flex, perPage int) (chunks [][]*tview.Flex) {
 	for perPage < len(details) {
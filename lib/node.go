@@ -24,15 +24,53 @@ package beekeeper
 
 import (
 	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/olekukonko/tablewriter"
 	"io"
 	"net"
 	"os"
+	"runtime"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// OutputFormat is used to specify how Nodes.Print writes the nodes
+type OutputFormat int
+
+const (
+	// OutputFormatTable a formatted table meant for terminals
+	OutputFormatTable OutputFormat = iota
+
+	// OutputFormatJSON a JSON array
+	OutputFormatJSON
+
+	// OutputFormatCSV comma separated values with a header row
+	OutputFormatCSV
+)
+
+// ErrUnknownOutputFormat is produced when an OutputFormat is not known
+var ErrUnknownOutputFormat = errors.New("unknown output format")
+
+// ParseOutputFormat returns the OutputFormat named by format: "table", "json" or "csv".
+func ParseOutputFormat(format string) (OutputFormat, error) {
+	switch strings.ToLower(format) {
+	case "table":
+		return OutputFormatTable, nil
+	case "json":
+		return OutputFormatJSON, nil
+	case "csv":
+		return OutputFormatCSV, nil
+	}
+
+	return 0, ErrUnknownOutputFormat
+}
+
 // Node represents a node node.
 type Node struct {
 	Conn   *Conn
@@ -40,33 +78,96 @@ type Node struct {
 	Name   string
 	Status Status
 	Info   NodeInfo
+
+	// Labels are the labels set by the node in its Config.Labels.
+	Labels map[string]string
+
+	// recentErrors is a snapshot of the latest errors reported by the node, from oldest to newest.
+	recentErrors []string
 }
 
 // Nodes is a Node slice
 type Nodes []Node
 
+// nodeCallback wraps a function to be called on every node update.
+type nodeCallback struct {
+	fn func(Node)
+}
+
 // Equals compares two workers. The comparison is made using the IP addresses of the nodes.
 func (n Node) Equals(w2 Node) bool {
 	return n.Addr.IP.Equal(w2.Addr.IP)
 }
 
-// getOperatingSystems iterates the workers and returns a set of the GOOSs found.
-func (n Nodes) getOperatingSystems() (opSys []string) {
+// Copy returns a deep copy of the node without its connection, so it can be modified without affecting the node
+// known by the server. Messages sent to the copy still use the connection of the known node.
+func (n Node) Copy() Node {
+	c := Node{
+		Name:   n.Name,
+		Status: n.Status,
+		Info:   n.Info,
+	}
+
+	if n.Addr != nil {
+		c.Addr = &net.TCPAddr{
+			IP:   append(net.IP(nil), n.Addr.IP...),
+			Port: n.Addr.Port,
+			Zone: n.Addr.Zone,
+		}
+	}
+
+	if n.Info.CoreTemps != nil {
+		c.Info.CoreTemps = append([]float32(nil), n.Info.CoreTemps...)
+	}
+
+	c.Info.Labels = copyLabels(n.Info.Labels)
+	c.Labels = copyLabels(n.Labels)
+
+	if n.recentErrors != nil {
+		c.recentErrors = append([]string(nil), n.recentErrors...)
+	}
+
+	return c
+}
+
+// RecentErrors returns the latest errors reported by the node, from oldest to newest. The amount of errors kept is
+// set by Config.NodeErrorHistorySize.
+func (n Node) RecentErrors() []string {
+	errs := make([]string, len(n.recentErrors))
+	copy(errs, n.recentErrors)
+
+	return errs
+}
+
+// getDistributions iterates the workers and returns a set of the distributions found.
+func (n Nodes) getDistributions() (distributions []Distribution) {
 	for _, node := range n {
+		dist := node.distribution()
 		duplicate := false
 
-		for _, ops := range opSys {
-			if ops == node.Info.OS {
+		for _, d := range distributions {
+			if d == dist {
 				duplicate = true
 			}
 		}
 
 		if !duplicate {
-			opSys = append(opSys, node.Info.OS)
+			distributions = append(distributions, dist)
 		}
 	}
 
-	return opSys
+	return distributions
+}
+
+// distribution returns the Distribution the node's jobs are built for. Nodes that don't report their architecture are
+// assumed to share the local one.
+func (n Node) distribution() Distribution {
+	arch := n.Info.Arch
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+
+	return Distribution{OS: n.Info.OS, Arch: arch}
 }
 
 // PrettyPrint prints a formatted table of workers.
@@ -78,31 +179,283 @@ func (n Nodes) PrettyPrint(writer ...io.Writer) {
 		out = os.Stdout
 	}
 
-	table := tablewriter.NewWriter(out)
+	_ = n.Print(OutputFormatTable, out)
+}
+
+// Print writes the workers to w using the given format.
+func (n Nodes) Print(format OutputFormat, w io.Writer) error {
+	switch format {
+	case OutputFormatTable:
+		table := tablewriter.NewWriter(w)
 
-	table.SetHeader([]string{"Name", "Address", "Status"})
-	table.SetAlignment(tablewriter.ALIGN_CENTER)
+		table.SetHeader([]string{"Name", "Address", "Status"})
+		table.SetAlignment(tablewriter.ALIGN_CENTER)
 
-	for _, node := range n {
-		table.Append([]string{node.Name, node.Addr.IP.String(), node.Status.String()})
+		for _, node := range n {
+			table.Append([]string{node.Name, node.Addr.IP.String(), node.Status.String()})
+		}
+
+		table.Render()
+
+		return nil
+
+	case OutputFormatJSON:
+		nodes := make([]nodeSnapshot, 0, len(n))
+		for _, node := range n {
+			nodes = append(nodes, newNodeSnapshot(node))
+		}
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(nodes)
+
+	case OutputFormatCSV:
+		writer := csv.NewWriter(w)
+
+		err := writer.Write([]string{"name", "ip", "port", "status", "os", "usage", "cpu_temp"})
+		if err != nil {
+			return err
+		}
+
+		for _, node := range n {
+			err = writer.Write([]string{
+				node.Name,
+				node.Addr.IP.String(),
+				strconv.Itoa(node.Addr.Port),
+				node.Status.String(),
+				node.Info.OS,
+				strconv.FormatFloat(float64(node.Info.Usage), 'f', -1, 32),
+				strconv.FormatFloat(float64(node.Info.CPUTemp), 'f', -1, 32),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		writer.Flush()
+
+		return writer.Error()
 	}
 
-	table.Render()
+	return ErrUnknownOutputFormat
 }
 
-// updateNode adds new workers if not present and replaces old ones if matching.
+// updateNode adds new workers if not present and replaces old ones if matching. The registered node callbacks are
+// notified afterwards. New workers are discarded if Config.MaxNodesPerScan workers are already known.
 func (s *Server) updateNode(node2 Node) {
+	oldStatus, stored := s.storeNode(node2)
+	if !stored {
+		return
+	}
+
+	s.notifyNodeCallbacks(node2)
+
+	if oldStatus != node2.Status {
+		s.notifyStatusCallbacks(node2, oldStatus, node2.Status)
+	}
+}
+
+// refreshNode stores the node like updateNode, without notifying the callbacks. It's used once a message is handled,
+// as the callbacks were already notified when it was received.
+func (s *Server) refreshNode(node2 Node) {
+	s.storeNode(node2)
+}
+
+// storeNode adds the node if not present, or replaces the matching one, and returns the status it had before. New
+// nodes aren't added if Config.MaxNodesPerScan nodes are already known, in which case stored is false.
+func (s *Server) storeNode(node2 Node) (oldStatus Status, stored bool) {
+	if node2.Addr != nil {
+		node2.recentErrors = s.NodeErrors(node2.Addr.IP)
+	}
+
 	s.nodesLock.Lock()
 	defer s.nodesLock.Unlock()
 
 	for i, node := range s.nodes {
 		if node.Addr.IP.Equal(node2.Addr.IP) {
+			oldStatus = node.Status
 			s.nodes[i] = node2
-			return
+			return oldStatus, true
 		}
 	}
 
+	if s.Config.MaxNodesPerScan > 0 && len(s.nodes) >= s.Config.MaxNodesPerScan {
+		s.log().Debugln("Discarding node", node2.Name, "as the node limit was reached")
+		return StatusNone, false
+	}
+
 	s.nodes = append(s.nodes, node2)
+
+	// Nodes known before the list was cleared keep their old status
+	oldStatus = StatusNone
+	if s.clearedNodes.ContainsByIP(node2.Addr.IP) {
+		oldStatus = s.clearedNodes.find(node2.Addr.IP).Status
+	}
+
+	return oldStatus, true
+}
+
+// clearNodes empties the node list, so it can be refilled by a status broadcast. The cleared nodes are kept until the
+// next call: the ones that respond again keep their status, and the ones that didn't are reported to the callbacks
+// registered with OnNodeStatusChange as changing to StatusNone.
+func (s *Server) clearNodes() {
+	s.nodesLock.Lock()
+
+	var missing Nodes
+	for _, node := range s.clearedNodes {
+		if !s.nodes.ContainsByIP(node.Addr.IP) {
+			missing = append(missing, node)
+		}
+	}
+
+	s.clearedNodes = s.nodes
+	s.nodes = Nodes{}
+
+	s.nodesLock.Unlock()
+
+	for _, node := range missing {
+		if node.Status != StatusNone {
+			s.notifyStatusCallbacks(node, node.Status, StatusNone)
+		}
+	}
+}
+
+// Nodes returns copies of the nodes known by the server, see Node.Copy.
+func (s *Server) Nodes() Nodes {
+	s.nodesLock.RLock()
+	defer s.nodesLock.RUnlock()
+
+	nodes := make(Nodes, len(s.nodes))
+	for i, node := range s.nodes {
+		nodes[i] = node.Copy()
+	}
+
+	return nodes
+}
+
+// PartitionNodes splits the nodes known by the server into n groups. See Nodes.Partition.
+func (s *Server) PartitionNodes(n int) []Nodes {
+	return s.Nodes().Partition(n)
+}
+
+// GetNode looks up a known node by its IP address. The second return value reports whether the node was found.
+func (s *Server) GetNode(ip net.IP) (Node, bool) {
+	s.nodesLock.RLock()
+	defer s.nodesLock.RUnlock()
+
+	node := s.nodes.find(ip)
+	if node.Addr == nil {
+		return Node{}, false
+	}
+
+	return node, true
+}
+
+// GetNodeByName looks up a known node by its name. The second return value reports whether the node was found.
+func (s *Server) GetNodeByName(name string) (Node, bool) {
+	s.nodesLock.RLock()
+	defer s.nodesLock.RUnlock()
+
+	for _, node := range s.nodes {
+		if node.Name == name {
+			return node, true
+		}
+	}
+
+	return Node{}, false
+}
+
+// NodeErrors returns a snapshot of the latest errors reported by the node with the given IP, from oldest to newest.
+func (s *Server) NodeErrors(ip net.IP) []string {
+	s.nodeErrorsLock.RLock()
+	defer s.nodeErrorsLock.RUnlock()
+
+	errs := make([]string, len(s.nodeErrors[ip.String()]))
+	copy(errs, s.nodeErrors[ip.String()])
+
+	return errs
+}
+
+// addNodeError stores an error reported by the node with the given IP, discarding the oldest one when the history
+// size is exceeded.
+func (s *Server) addNodeError(ip net.IP, errMsg string) {
+	if s.Config.NodeErrorHistorySize <= 0 {
+		return
+	}
+
+	s.nodeErrorsLock.Lock()
+	defer s.nodeErrorsLock.Unlock()
+
+	if s.nodeErrors == nil {
+		s.nodeErrors = make(map[string][]string)
+	}
+
+	errs := append(s.nodeErrors[ip.String()], errMsg)
+	if len(errs) > s.Config.NodeErrorHistorySize {
+		errs = errs[len(errs)-s.Config.NodeErrorHistorySize:]
+	}
+
+	s.nodeErrors[ip.String()] = errs
+}
+
+// addNodeCallback registers fn to be called on every node update. The returned value is used to unregister it.
+func (s *Server) addNodeCallback(fn func(Node)) *nodeCallback {
+	s.nodeCallbacksLock.Lock()
+	defer s.nodeCallbacksLock.Unlock()
+
+	callback := &nodeCallback{fn: fn}
+	s.nodeCallbacks = append(s.nodeCallbacks, callback)
+
+	return callback
+}
+
+// removeNodeCallback unregisters a callback previously registered with addNodeCallback.
+func (s *Server) removeNodeCallback(callback *nodeCallback) {
+	s.nodeCallbacksLock.Lock()
+	defer s.nodeCallbacksLock.Unlock()
+
+	var remaining []*nodeCallback
+	for _, c := range s.nodeCallbacks {
+		if c != callback {
+			remaining = append(remaining, c)
+		}
+	}
+
+	s.nodeCallbacks = remaining
+}
+
+// notifyNodeCallbacks calls every registered node callback with the given node.
+func (s *Server) notifyNodeCallbacks(n Node) {
+	s.nodeCallbacksLock.Lock()
+	callbacks := make([]*nodeCallback, len(s.nodeCallbacks))
+	copy(callbacks, s.nodeCallbacks)
+	s.nodeCallbacksLock.Unlock()
+
+	for _, c := range callbacks {
+		c.fn(n)
+	}
+}
+
+// OnNodeStatusChange registers fn to be called every time the Status of a node changes. Nodes seen for the first time
+// have StatusNone as their old status. Callbacks are called on their own goroutine, and can be registered at any time.
+func (s *Server) OnNodeStatusChange(fn func(node Node, oldStatus, newStatus Status)) {
+	s.statusCallbacksLock.Lock()
+	defer s.statusCallbacksLock.Unlock()
+
+	s.statusCallbacks = append(s.statusCallbacks, fn)
+}
+
+// notifyStatusCallbacks calls every callback registered with OnNodeStatusChange.
+func (s *Server) notifyStatusCallbacks(n Node, oldStatus, newStatus Status) {
+	s.statusCallbacksLock.Lock()
+	callbacks := make([]func(Node, Status, Status), len(s.statusCallbacks))
+	copy(callbacks, s.statusCallbacks)
+	s.statusCallbacksLock.Unlock()
+
+	for _, fn := range callbacks {
+		go fn(n, oldStatus, newStatus)
+	}
 }
 
 // ExecuteMany runs a task on the provided Nodes and blocks until a Result is sent back. Optionally a timeout
@@ -137,6 +490,50 @@ func (s *Server) ExecuteMany(n Nodes, t Task, timeout ...time.Duration) ([]Resul
 	return results, nil
 }
 
+// MultiNodeError holds the errors produced while running a task on several nodes, keyed by IP. It's the same type
+// as MulticastError.
+type MultiNodeError = MulticastError
+
+// BroadcastTask runs the task on every known node concurrently and blocks until all of them finish. Every node runs
+// it under a different UUID. Unlike ExecuteMany it doesn't stop on the first error: the successful results are
+// returned in the order of the known nodes, along with a *MultiNodeError listing the nodes that failed, if any.
+// Optionally a timeout argument can be passed.
+func (s *Server) BroadcastTask(t Task, timeout ...time.Duration) ([]Result, error) {
+	nodes := s.Nodes()
+
+	results := make([]Result, len(nodes))
+	errs := make([]error, len(nodes))
+
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+
+		go func(i int, node Node) {
+			defer wg.Done()
+			results[i], errs[i] = s.Execute(node, t, timeout...)
+		}(i, node)
+	}
+
+	wg.Wait()
+
+	var succeeded []Result
+	multiErr := &MultiNodeError{Errors: make(map[string]error)}
+	for i, node := range nodes {
+		if errs[i] != nil {
+			multiErr.Errors[node.Addr.IP.String()] = errs[i]
+			continue
+		}
+
+		succeeded = append(succeeded, results[i])
+	}
+
+	if len(multiErr.Errors) > 0 {
+		return succeeded, multiErr
+	}
+
+	return succeeded, nil
+}
+
 // sort orders a slice of workers based on their IP address.
 func (n Nodes) sort() Nodes {
 	sort.Slice(n, func(i, j int) bool {
@@ -146,6 +543,208 @@ func (n Nodes) sort() Nodes {
 	return n
 }
 
+// first returns up to the first max nodes. All of them are returned if max isn't positive.
+func (n Nodes) first(max int) Nodes {
+	if max <= 0 || len(n) <= max {
+		return n
+	}
+
+	return n[:max]
+}
+
+// sameAs compares two node slices, element by element, using Equals.
+func (n Nodes) sameAs(n2 Nodes) bool {
+	if len(n) != len(n2) {
+		return false
+	}
+
+	for i := range n {
+		if !n[i].Equals(n2[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Partition splits the nodes into the given amount of groups, assigning them in a round-robin fashion. The groups are
+// stable: the same input order always produces the same groups. If there are less nodes than groups some of them will
+// be empty. A nil slice is returned if groups is not positive.
+func (n Nodes) Partition(groups int) []Nodes {
+	if groups <= 0 {
+		return nil
+	}
+
+	partitions := make([]Nodes, groups)
+	for i, node := range n {
+		partitions[i%groups] = append(partitions[i%groups], node)
+	}
+
+	return partitions
+}
+
+// Subtract returns the nodes that are not present in other. Nodes are compared by IP address. Neither slice is
+// modified.
+func (n Nodes) Subtract(other Nodes) Nodes {
+	ips := other.ipSet()
+
+	result := Nodes{}
+	for _, node := range n {
+		if !ips[node.Addr.IP.String()] {
+			result = append(result, node)
+		}
+	}
+
+	return result
+}
+
+// Intersect returns the nodes that are also present in other. Nodes are compared by IP address, and the values of the
+// receiver are kept. Neither slice is modified.
+func (n Nodes) Intersect(other Nodes) Nodes {
+	ips := other.ipSet()
+
+	result := Nodes{}
+	for _, node := range n {
+		if ips[node.Addr.IP.String()] {
+			result = append(result, node)
+		}
+	}
+
+	return result
+}
+
+// Union returns the nodes followed by the ones in other that are not already present. Nodes are compared by IP
+// address, and the values of the receiver are kept. Neither slice is modified.
+func (n Nodes) Union(other Nodes) Nodes {
+	result := make(Nodes, len(n))
+	copy(result, n)
+
+	return append(result, other.Subtract(n)...)
+}
+
+// ipSet returns the set of IP addresses of the nodes.
+func (n Nodes) ipSet() map[string]bool {
+	ips := make(map[string]bool, len(n))
+	for _, node := range n {
+		ips[node.Addr.IP.String()] = true
+	}
+
+	return ips
+}
+
+// Contains reports if the node is present. Nodes are compared by IP address, see Node.Equals.
+func (n Nodes) Contains(node Node) bool {
+	if node.Addr == nil {
+		return false
+	}
+
+	return n.ContainsByIP(node.Addr.IP)
+}
+
+// ContainsByIP reports if a node with the given IP address is present.
+func (n Nodes) ContainsByIP(ip net.IP) bool {
+	for _, node := range n {
+		if node.Addr != nil && node.Addr.IP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ContainsByName reports if a node with the given name is present.
+func (n Nodes) ContainsByName(name string) bool {
+	for _, node := range n {
+		if node.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithLabel returns the nodes that have the label set to value. Neither slice is modified.
+func (n Nodes) WithLabel(key, value string) Nodes {
+	return n.WithLabels(map[string]string{key: value})
+}
+
+// WithLabels returns the nodes that have all the labels set to the given values. Neither slice is modified.
+func (n Nodes) WithLabels(labels map[string]string) Nodes {
+	result := Nodes{}
+	for _, node := range n {
+		if node.hasLabels(labels) {
+			result = append(result, node)
+		}
+	}
+
+	return result
+}
+
+// hasLabels reports if the node has all the labels set to the given values.
+func (n Node) hasLabels(labels map[string]string) bool {
+	for key, value := range labels {
+		if v, ok := n.Labels[key]; !ok || v != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// copyLabels returns a copy of the labels, or nil if there are none.
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+
+	c := make(map[string]string, len(labels))
+	for key, value := range labels {
+		c[key] = value
+	}
+
+	return c
+}
+
+// LeastLoaded returns the node with the lowest Info.Usage. Ties are broken by the lowest Info.CPUTemp. ErrNoNodes is
+// returned if there are no nodes.
+func (n Nodes) LeastLoaded() (Node, error) {
+	return n.mostBy(func(a, b Node) bool {
+		if a.Info.Usage != b.Info.Usage {
+			return a.Info.Usage < b.Info.Usage
+		}
+
+		return a.Info.CPUTemp < b.Info.CPUTemp
+	})
+}
+
+// MostLoaded returns the node with the highest Info.Usage. Ties are broken by the highest Info.CPUTemp. ErrNoNodes is
+// returned if there are no nodes.
+func (n Nodes) MostLoaded() (Node, error) {
+	return n.mostBy(func(a, b Node) bool {
+		if a.Info.Usage != b.Info.Usage {
+			return a.Info.Usage > b.Info.Usage
+		}
+
+		return a.Info.CPUTemp > b.Info.CPUTemp
+	})
+}
+
+// mostBy returns the first node that no other node is preferred over, as reported by prefer(a, b).
+func (n Nodes) mostBy(prefer func(a, b Node) bool) (Node, error) {
+	if len(n) == 0 {
+		return Node{}, ErrNoNodes
+	}
+
+	best := n[0]
+	for _, node := range n[1:] {
+		if prefer(node, best) {
+			best = node
+		}
+	}
+
+	return best, nil
+}
+
 // find orders a slice of workers based on their IP address.
 func (n Nodes) find(addr net.IP) Node {
 	for _, node := range n {
@@ -23,22 +23,34 @@
 package beekeeper
 
 import (
+	"bytes"
+	"encoding/json"
 	"github.com/google/go-cmp/cmp"
+	"runtime"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
-func TestNodes_getOperatingSystems(t *testing.T) {
+func TestNodes_getDistributions(t *testing.T) {
 	nodes := getTestNodes()
+	nodes[0].Info.Arch = "arm"
 
-	opsys := nodes.getOperatingSystems()
-	expect := []string{"linux", "darwin", "windows"}
+	distributions := nodes.getDistributions()
 
-	sort.Strings(opsys)
+	var found []string
+	for _, d := range distributions {
+		found = append(found, d.String())
+	}
+
+	expect := []string{"linux/arm", "darwin/" + runtime.GOARCH, "windows/" + runtime.GOARCH}
+
+	sort.Strings(found)
 	sort.Strings(expect)
 
-	if !cmp.Equal(opsys, expect) {
-		t.Fail()
+	if !cmp.Equal(found, expect) {
+		t.Error("unexpected distributions:", cmp.Diff(found, expect))
 		return
 	}
 }
@@ -46,3 +58,423 @@ func TestNodes_getOperatingSystems(t *testing.T) {
 func TestNodes_PrettyPrint(t *testing.T) {
 	getTestNodes().PrettyPrint() // Panic check
 }
+
+func TestNodes_Print(t *testing.T) {
+	nodes := getTestNodes()
+
+	var buf bytes.Buffer
+	err := nodes.Print(OutputFormatJSON, &buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var decoded []nodeSnapshot
+	err = json.Unmarshal(buf.Bytes(), &decoded)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(decoded) != len(nodes) || decoded[0].Name != nodes[0].Name || decoded[0].IP != nodes[0].Addr.IP.String() {
+		t.Error("unexpected json output:", buf.String())
+	}
+
+	buf.Reset()
+	err = nodes.Print(OutputFormatCSV, &buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(nodes)+1 || !strings.HasPrefix(lines[0], "name,ip,") ||
+		!strings.HasPrefix(lines[1], nodes[0].Name+","+nodes[0].Addr.IP.String()) {
+		t.Error("unexpected csv output:", buf.String())
+	}
+
+	if nodes.Print(OutputFormat(42), &buf) != ErrUnknownOutputFormat {
+		t.Error("expected ErrUnknownOutputFormat")
+	}
+}
+
+func TestParseOutputFormat(t *testing.T) {
+	format, err := ParseOutputFormat("JSON")
+	if err != nil || format != OutputFormatJSON {
+		t.Fail()
+	}
+
+	_, err = ParseOutputFormat("yaml")
+	if err != ErrUnknownOutputFormat {
+		t.Fail()
+	}
+}
+
+func TestServer_NodeErrors(t *testing.T) {
+	s := &Server{Config: Config{NodeErrorHistorySize: 2}}
+	ip := getTestNodes()[0].Addr.IP
+
+	s.addNodeError(ip, "error 1")
+	s.addNodeError(ip, "error 2")
+	s.addNodeError(ip, "error 3")
+
+	expect := []string{"error 2", "error 3"}
+	if !cmp.Equal(s.NodeErrors(ip), expect) {
+		t.Error("non matching errors:", cmp.Diff(s.NodeErrors(ip), expect))
+		return
+	}
+
+	s.updateNode(getTestNodes()[0])
+	if !cmp.Equal(s.nodes[0].RecentErrors(), expect) {
+		t.Error("non matching node errors:", cmp.Diff(s.nodes[0].RecentErrors(), expect))
+		return
+	}
+}
+
+func TestServer_GetNode(t *testing.T) {
+	s := &Server{nodes: getTestNodes()}
+
+	node, ok := s.GetNode(getTestNodes()[1].Addr.IP)
+	if !ok || node.Name != "testWorker2" {
+		t.Error("node not found by IP")
+		return
+	}
+
+	node, ok = s.GetNodeByName("testWorker3")
+	if !ok || !node.Equals(getTestNodes()[2]) {
+		t.Error("node not found by name")
+		return
+	}
+
+	_, ok = s.GetNodeByName("missing")
+	if ok {
+		t.Error("missing node found")
+		return
+	}
+}
+
+func TestNodes_Partition(t *testing.T) {
+	nodes := getTestNodes()
+
+	partitions := nodes.Partition(2)
+	if len(partitions) != 2 {
+		t.Error("unexpected partition count:", len(partitions))
+		return
+	}
+
+	for i, node := range nodes {
+		if !partitions[i%2].find(node.Addr.IP).Equals(node) {
+			t.Error("node", node.Name, "not in partition", i%2)
+		}
+	}
+
+	if len(partitions[0])+len(partitions[1]) != len(nodes) {
+		t.Error("nodes lost while partitioning")
+	}
+
+	partitions = nodes.Partition(len(nodes) + 1)
+	if len(partitions[len(nodes)]) != 0 {
+		t.Error("expected an empty partition")
+	}
+
+	if nodes.Partition(0) != nil {
+		t.Error("expected no partitions")
+	}
+}
+
+func TestNodes_SetOperations(t *testing.T) {
+	nodes := getTestNodes()
+
+	other := Nodes{nodes[1], nodes[2]}
+	other[0].Name = "renamed" // Values of the receiver must be kept
+
+	if diff := nodes[:2].Subtract(other); !diff.sameAs(Nodes{nodes[0]}) {
+		t.Error("unexpected subtraction:", diff)
+	}
+
+	intersection := nodes[:2].Intersect(other)
+	if !intersection.sameAs(Nodes{nodes[1]}) || intersection[0].Name != nodes[1].Name {
+		t.Error("unexpected intersection:", intersection)
+	}
+
+	union := nodes[:2].Union(other)
+	if !union.sameAs(Nodes{nodes[0], nodes[1], nodes[2]}) || union[1].Name != nodes[1].Name {
+		t.Error("unexpected union:", union)
+	}
+
+	if other[0].Name != "renamed" || len(other) != 2 {
+		t.Error("argument modified")
+	}
+}
+
+func TestNodes_Contains(t *testing.T) {
+	nodes := getTestNodes()
+	missing := nodes[3]
+
+	tests := []struct {
+		name  string
+		nodes Nodes
+		found Node
+	}{
+		{"empty", Nodes{}, Node{}},
+		{"single", nodes[:1], nodes[0]},
+		{"multiple", nodes[:3], nodes[2]},
+	}
+
+	for _, test := range tests {
+		if test.found.Addr != nil {
+			if !test.nodes.Contains(test.found) || !test.nodes.ContainsByIP(test.found.Addr.IP) ||
+				!test.nodes.ContainsByName(test.found.Name) {
+				t.Error(test.name+": node not found", test.found.Name)
+			}
+		}
+
+		if test.nodes.Contains(missing) || test.nodes.ContainsByIP(missing.Addr.IP) ||
+			test.nodes.ContainsByName(missing.Name) {
+			t.Error(test.name+": unexpected node found", missing.Name)
+		}
+
+		if test.nodes.Contains(Node{}) {
+			t.Error(test.name + ": node without address found")
+		}
+	}
+}
+
+func TestNodes_LeastLoaded(t *testing.T) {
+	_, err := Nodes{}.LeastLoaded()
+	if err != ErrNoNodes {
+		t.Error("expected ErrNoNodes, got", err)
+	}
+
+	_, err = Nodes{}.MostLoaded()
+	if err != ErrNoNodes {
+		t.Error("expected ErrNoNodes, got", err)
+	}
+
+	nodes := getTestNodes()
+	nodes[0].Info = NodeInfo{Usage: 0.5, CPUTemp: 50}
+	nodes[1].Info = NodeInfo{Usage: 0.2, CPUTemp: 60}
+	nodes[2].Info = NodeInfo{Usage: 0.2, CPUTemp: 40}
+	nodes[3].Info = NodeInfo{Usage: 0.5, CPUTemp: 70}
+
+	least, err := nodes.LeastLoaded()
+	if err != nil || !least.Equals(nodes[2]) {
+		t.Error("unexpected least loaded node", least.Name, err)
+	}
+
+	most, err := nodes.MostLoaded()
+	if err != nil || !most.Equals(nodes[3]) {
+		t.Error("unexpected most loaded node", most.Name, err)
+	}
+}
+
+func TestNodes_WithLabels(t *testing.T) {
+	nodes := getTestNodes()
+	nodes[0].Labels = map[string]string{"gpu": "true", "zone": "a"}
+	nodes[1].Labels = map[string]string{"gpu": "true", "zone": "b"}
+	nodes[2].Labels = map[string]string{"gpu": "false"}
+
+	gpu := nodes.WithLabel("gpu", "true")
+	if len(gpu) != 2 || !gpu[0].Equals(nodes[0]) || !gpu[1].Equals(nodes[1]) {
+		t.Error("unexpected nodes:", gpu)
+	}
+
+	zoneB := nodes.WithLabels(map[string]string{"gpu": "true", "zone": "b"})
+	if len(zoneB) != 1 || !zoneB[0].Equals(nodes[1]) {
+		t.Error("unexpected nodes:", zoneB)
+	}
+
+	if len(nodes.WithLabel("zone", "c")) != 0 {
+		t.Error("nodes without the label returned")
+	}
+
+	if len(nodes.WithLabels(nil)) != len(nodes) {
+		t.Error("expected all nodes for no labels")
+	}
+}
+
+func TestMessage_nodeLabels(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+	s.Config.Labels = map[string]string{"gpu": "true"}
+
+	buf := &bytes.Buffer{}
+	err := defaultSendCallback(s, &Conn{stream: &writerConn{w: buf}}, Message{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := buf.Bytes()
+	msg, err := decodeMessage(sent[bytes.IndexByte(sent, '\n')+1:], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.node().Labels["gpu"] != "true" {
+		t.Error("labels not sent:", msg.NodeInfo.Labels)
+	}
+}
+
+func TestNode_Copy(t *testing.T) {
+	n := getTestNodes()[0]
+	n.Conn = &Conn{}
+	n.Info.CoreTemps = []float32{40}
+	n.recentErrors = []string{"error"}
+	n.Labels = map[string]string{"gpu": "true"}
+
+	c := n.Copy()
+	if c.Conn != nil {
+		t.Error("connection copied")
+	}
+
+	if !c.Equals(n) || c.Addr.Port != n.Addr.Port || c.Name != n.Name || c.Info.OS != n.Info.OS {
+		t.Error("fields not copied")
+	}
+
+	c.Addr.IP[len(c.Addr.IP)-1] = 99
+	c.Info.CoreTemps[0] = 99
+	c.recentErrors[0] = "modified"
+	c.Labels["gpu"] = "false"
+
+	if n.Addr.IP.String() != "192.168.1.1" || n.Info.CoreTemps[0] != 40 || n.recentErrors[0] != "error" ||
+		n.Labels["gpu"] != "true" {
+		t.Error("copy shares state with the original")
+	}
+}
+
+func TestServer_NodesCopies(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	conn := &Conn{}
+	n := getTestNodes()[0]
+	n.Conn = conn
+	s.nodes = Nodes{n}
+
+	nodes := s.Nodes()
+	if nodes[0].Conn != nil {
+		t.Error("connection exposed")
+	}
+
+	nodes[0].Name = "modified"
+	if s.nodes[0].Name != n.Name {
+		t.Error("known node modified")
+	}
+
+	known, dialed, _, err := s.nodeConn(nodes[0])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if known != conn || dialed {
+		t.Error("connection of the known node not used")
+	}
+}
+
+func TestServer_OnNodeStatusChange(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	type change struct {
+		old, new Status
+	}
+
+	changes := make(chan change, 10)
+	s.OnNodeStatusChange(func(_ Node, oldStatus, newStatus Status) {
+		changes <- change{oldStatus, newStatus}
+	})
+
+	n := getTestNodes()[0]
+	n.Status = StatusIDLE
+	s.updateNode(n)
+	s.updateNode(n) // No change
+
+	n.Status = StatusWorking
+	s.updateNode(n)
+
+	// Callbacks run concurrently, so the order isn't guaranteed
+	expected := map[change]bool{{StatusNone, StatusIDLE}: true, {StatusIDLE, StatusWorking}: true}
+	for range expected {
+		select {
+		case c := <-changes:
+			if !expected[c] {
+				t.Error("unexpected change", c)
+			}
+		case <-time.After(time.Second):
+			t.Error("callback not called")
+			return
+		}
+	}
+
+	select {
+	case c := <-changes:
+		t.Error("unexpected change", c)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestServer_updateNodeLimit(t *testing.T) {
+	c := NewDefaultConfig()
+	c.MaxNodesPerScan = 2
+
+	s := NewServer(c)
+
+	nodes := getTestNodes()
+	for _, n := range nodes {
+		s.updateNode(n)
+	}
+
+	if len(s.Nodes()) != 2 || s.Nodes()[1].Name != nodes[1].Name {
+		t.Error("unexpected nodes:", s.Nodes())
+	}
+
+	// Known nodes are still updated at capacity
+	nodes[0].Status = StatusWorking
+	s.updateNode(nodes[0])
+
+	if s.Nodes()[0].Status != StatusWorking {
+		t.Error("known node not updated at capacity")
+	}
+}
+
+func TestServer_clearNodes(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	type change struct {
+		name     string
+		old, new Status
+	}
+
+	changes := make(chan change, 10)
+	s.OnNodeStatusChange(func(n Node, oldStatus, newStatus Status) {
+		changes <- change{n.Name, oldStatus, newStatus}
+	})
+
+	nodes := getTestNodes()[:2]
+	for _, n := range nodes {
+		s.updateNode(n)
+	}
+
+	for range nodes {
+		<-changes // Nodes seen for the first time
+	}
+
+	s.clearNodes()
+	s.updateNode(nodes[0]) // Responds again with the same status
+
+	select {
+	case c := <-changes:
+		t.Error("unexpected change", c)
+		return
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	s.clearNodes()
+
+	select {
+	case c := <-changes:
+		if c != (change{nodes[1].Name, nodes[1].Status, StatusNone}) {
+			t.Error("unexpected change", c)
+		}
+	case <-time.After(time.Second):
+		t.Error("missing node not reported")
+	}
+}
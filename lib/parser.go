@@ -25,13 +25,16 @@ package beekeeper
 import (
 	"errors"
 	"fmt"
+	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
 )
 
-// buildTemplate is a small Go program template that wraps a job into WrapJob.
+// buildTemplate is a small Go program template that wraps a job into WrapJob or WrapTypedJob.
 const buildTemplate = `package main
 
 import (
@@ -40,56 +43,262 @@ import (
 )
 
 func main() {
-	beekeeper.WrapJob(p.%s)
+	beekeeper.%s(p.%s)
 }
 
 `
 
-// buildJob creates a wrapped implementation of the given function and builds for every GOOS in the
-// distributions parameter. It returns a map containing the GOOSes and their executable's paths.
-func buildJob(pkgName string, function string, distributions []string) (map[string]string, error) {
-	content := []byte(generateBuildFile(pkgName, function))
+// wrapJobName and wrapTypedJobName are the wrappers called by the jobs built by DistributeJob and DistributeTypedJob.
+const (
+	wrapJobName      = "WrapJob"
+	wrapTypedJobName = "WrapTypedJob"
+)
+
+// buildModuleName is the name of the temporary module used to build jobs.
+const buildModuleName = "beekeeper_temp"
+
+// goCommand is the go tool used to build jobs. It's a variable to allow testing with a stub.
+var goCommand = "go"
 
-	outPath := filepath.FromSlash("./.beekeeper")
-	filePath := filepath.FromSlash(outPath + "/temp.go")
+// Distribution is a GOOS and GOARCH pair that jobs are built for.
+type Distribution struct {
+	OS   string
+	Arch string
+}
+
+// String returns the Distribution in the GOOS/GOARCH format.
+func (d Distribution) String() string {
+	return d.OS + "/" + d.Arch
+}
+
+// buildJob creates an implementation of the given function wrapped with the wrapper function, and builds for every
+// Distribution in the distributions parameter using the build options in the Config. Up to Config.BuildParallelism
+// distributions are built at once. It returns a map containing the distributions and their executable's paths.
+// Progress is logged to log.
+//
+// The wrapper is built inside its own module in a temporary directory, which is removed afterwards. The module
+// containing the function is linked with a replace directive, see Config.GoModuleRoot.
+func buildJob(pkgName, function, wrapper string, distributions []Distribution, c Config,
+	log *logrus.Logger) (map[Distribution]string, error) {
+	content := []byte(generateBuildFile(pkgName, function, wrapper))
+
+	outPath, err := filepath.Abs(c.workDir())
+	if err != nil {
+		return nil, err
+	}
 
 	if _, err := os.Stat(outPath); os.IsNotExist(err) {
-		err = os.Mkdir(outPath, 0700)
+		err = os.MkdirAll(outPath, 0700)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	err := ioutil.WriteFile(filePath, content, 0700)
+	buildDir, err := ioutil.TempDir("", "beekeeper_build")
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err := os.RemoveAll(buildDir)
+		if err != nil {
+			log.Warnln("Unable to remove build directory:", err)
+		}
+	}()
+
+	filePath := filepath.Join(buildDir, "main.go")
+
+	err = ioutil.WriteFile(filePath, content, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	err = initBuildModule(buildDir, c)
 	if err != nil {
 		return nil, err
 	}
 
-	binPaths := make(map[string]string)
-	for _, goos := range distributions {
-		logger.Infoln("Building binaries for", goos)
+	parallelism := c.BuildParallelism
+	if parallelism <= 0 || parallelism > len(distributions) {
+		parallelism = len(distributions)
+	}
+
+	sem := make(chan struct{}, parallelism)
+	errChan := make(chan error, len(distributions))
+
+	binPaths := make(map[Distribution]string)
+	var binPathsLock sync.Mutex
+
+	var wg sync.WaitGroup
+	for _, dist := range distributions {
+		wg.Add(1)
+
+		go func(dist Distribution) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() {
+				<-sem
+			}()
+
+			outFile, err := buildDistribution(dist, outPath, filePath, buildDir, c, log)
+			if err != nil {
+				errChan <- err
+				return
+			}
+
+			binPathsLock.Lock()
+			binPaths[dist] = outFile
+			binPathsLock.Unlock()
+		}(dist)
+	}
+
+	wg.Wait()
+	close(errChan)
+
+	if err, ok := <-errChan; ok {
+		return nil, err
+	}
+
+	return binPaths, nil
+}
+
+// buildDistribution builds the wrapper at filePath for the Distribution, and returns the path of the binary inside
+// outPath. GOOS and GOARCH are only set for the go command, so several distributions can be built at once. Progress is
+// logged to log.
+func buildDistribution(dist Distribution, outPath, filePath, buildDir string, c Config,
+	log *logrus.Logger) (string, error) {
+	log.Infoln("Building binaries for", dist)
+
+	outFile := buildOutputPath(outPath, dist)
+
+	cmd := exec.Command(goCommand, buildArgs(outFile, filePath, c)...)
+	cmd.Dir = buildDir
+	cmd.Env = append(os.Environ(), "GOOS="+dist.OS, "GOARCH="+dist.Arch)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", errors.New("go build error for " + dist.String() + ": " + string(out))
+	}
+
+	return outFile, nil
+}
 
-		err = os.Setenv("GOOS", goos)
+// initBuildModule creates the temporary module used to build jobs in dir. The module containing the job, found at
+// Config.GoModuleRoot or above the working directory, replaces its published version. If no module is found the job's
+// package is resolved as any other dependency.
+func initBuildModule(dir string, c Config) error {
+	root := c.GoModuleRoot
+	if root == "" {
+		wd, err := os.Getwd()
 		if err != nil {
-			return nil, err
+			return err
+		}
+
+		root = findModuleRoot(wd)
+	}
+
+	commands := [][]string{{"mod", "init", buildModuleName}}
+
+	if root != "" {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			return err
+		}
+
+		modPath, err := readModulePath(root)
+		if err != nil {
+			return err
 		}
 
-		outFile := filepath.FromSlash(outPath + "/temp_" + goos)
+		commands = append(commands, []string{"mod", "edit", "-replace", modPath + "=" + root})
+	}
+
+	commands = append(commands, []string{"mod", "tidy"})
 
-		cmd := exec.Command("go", "build", "-o", outFile, "-ldflags", "-s -w", filePath)
+	for _, args := range commands {
+		cmd := exec.Command(goCommand, args...)
+		cmd.Dir = dir
 
 		out, err := cmd.CombinedOutput()
 		if err != nil {
-			return nil, errors.New("go build error: " + string(out))
+			return fmt.Errorf("go %s error: %s", args[0]+" "+args[1], string(out))
+		}
+	}
+
+	return nil
+}
+
+// findModuleRoot returns the closest folder containing a go.mod file, starting at dir and moving up. An empty string
+// is returned if there's none.
+func findModuleRoot(dir string) string {
+	dir = filepath.Clean(dir)
+	for {
+		if doesPathExists(filepath.Join(dir, "go.mod")) {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
 		}
 
-		binPaths[goos] = outFile
+		dir = parent
 	}
+}
 
-	return binPaths, nil
+// readModulePath returns the module path declared in the go.mod file inside root.
+func readModulePath(root string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`), nil
+		}
+	}
+
+	return "", errors.New("no module declaration found in " + filepath.Join(root, "go.mod"))
+}
+
+// defaultBuildFlags returns the linker flags used to build jobs unless Config.BuildFlags overrides them. They strip
+// the symbol table and debug information, which shrinks the binaries transferred to the nodes.
+func defaultBuildFlags() []string {
+	return []string{"-s", "-w"}
+}
+
+// buildArgs returns the arguments passed to the go command to build filePath into outFile. The -trimpath flag is
+// always passed, as every build happens in a new temporary directory that would otherwise end up in the binary.
+func buildArgs(outFile, filePath string, c Config) []string {
+	args := []string{"build", "-o", outFile}
+
+	flags := c.BuildFlags
+	if flags == nil {
+		flags = defaultBuildFlags()
+	}
+
+	if len(flags) > 0 {
+		args = append(args, "-ldflags", strings.Join(flags, " "))
+	}
+
+	args = append(args, "-trimpath")
+
+	if len(c.BuildTags) > 0 {
+		args = append(args, "-tags", strings.Join(c.BuildTags, ","))
+	}
+
+	return append(args, filePath)
+}
+
+// buildOutputPath returns the path of the binary built for the Distribution inside the outPath folder.
+func buildOutputPath(outPath string, dist Distribution) string {
+	return filepath.Join(outPath, "temp_"+dist.OS+"_"+dist.Arch)
 }
 
-// generateBuildFile formats the passed pkgName and funcName.
-func generateBuildFile(pkgName, funcName string) string {
-	return fmt.Sprintf(buildTemplate, pkgName, funcName)
+// generateBuildFile formats the passed pkgName and funcName, wrapping the function with the wrapper.
+func generateBuildFile(pkgName, funcName, wrapper string) string {
+	return fmt.Sprintf(buildTemplate, pkgName, wrapper, funcName)
 }
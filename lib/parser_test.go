@@ -0,0 +1,189 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildArgs(t *testing.T) {
+	c := NewDefaultConfig()
+	c.BuildTags = []string{"netgo", "quic"}
+
+	expect := []string{"build", "-o", "out", "-ldflags", "-s -w", "-trimpath", "-tags", "netgo,quic", "temp.go"}
+
+	args := buildArgs("out", "temp.go", c)
+	if !cmp.Equal(args, expect) {
+		t.Error("non matching arguments:", cmp.Diff(args, expect))
+		return
+	}
+}
+
+func TestBuildArgs_defaultFlags(t *testing.T) {
+	expect := []string{"build", "-o", "out", "-ldflags", "-s -w", "-trimpath", "temp.go"}
+
+	args := buildArgs("out", "temp.go", Config{})
+	if !cmp.Equal(args, expect) {
+		t.Error("non matching arguments:", cmp.Diff(args, expect))
+	}
+
+	expect = []string{"build", "-o", "out", "-trimpath", "temp.go"}
+
+	args = buildArgs("out", "temp.go", Config{BuildFlags: []string{}})
+	if !cmp.Equal(args, expect) {
+		t.Error("non matching arguments:", cmp.Diff(args, expect))
+	}
+}
+
+func TestFindModuleRoot(t *testing.T) {
+	root, err := ioutil.TempDir("", "beekeeper_test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	defer os.RemoveAll(root)
+
+	err = ioutil.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/jobs\n\ngo 1.13\n"), 0600)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	nested := filepath.Join(root, "pkg", "job")
+	err = os.MkdirAll(nested, 0700)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	found := findModuleRoot(nested)
+	if found != filepath.Clean(root) {
+		t.Error("unexpected module root", found)
+		return
+	}
+
+	modPath, err := readModulePath(found)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if modPath != "example.com/jobs" {
+		t.Error("unexpected module path", modPath)
+	}
+}
+
+// goStub is a go command stub that takes stubBuildTime to build, and writes the GOOS and GOARCH it was called with
+// into the output file.
+const goStub = `#!/bin/sh
+if [ "$1" = "build" ]; then
+	sleep 0.3
+	echo "$GOOS/$GOARCH" > "$3"
+fi
+`
+
+const stubBuildTime = time.Millisecond * 300
+
+func TestBuildJobParallel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the go stub is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "beekeeper")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	stub := filepath.Join(dir, "go")
+	err = ioutil.WriteFile(stub, []byte(goStub), 0700)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/job\n"), 0600)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	defer func(original string) {
+		goCommand = original
+	}(goCommand)
+	goCommand = stub
+
+	distributions := []Distribution{{"linux", "amd64"}, {"darwin", "arm64"}, {"windows", "amd64"}}
+
+	for _, parallelism := range []int{0, 1} {
+		c := NewDefaultConfig()
+		c.WorkDir = filepath.Join(dir, "work")
+		c.GoModuleRoot = dir
+		c.BuildParallelism = parallelism
+
+		start := time.Now()
+		paths, err := buildJob("example.com/job", "Job", wrapJobName, distributions, c, logger)
+		elapsed := time.Since(start)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if parallelism == 0 && elapsed >= stubBuildTime*time.Duration(len(distributions)) {
+			t.Error("distributions not built in parallel, took", elapsed)
+		}
+
+		if parallelism == 1 && elapsed < stubBuildTime*time.Duration(len(distributions)) {
+			t.Error("BuildParallelism not respected, took", elapsed)
+		}
+
+		for _, dist := range distributions {
+			data, err := ioutil.ReadFile(paths[dist])
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if string(data) != dist.String()+"\n" {
+				t.Errorf("%s built with the environment of %s", dist, data)
+			}
+		}
+	}
+}
+
+func TestGenerateBuildFile(t *testing.T) {
+	content := generateBuildFile("example.com/job", "Job", wrapTypedJobName)
+	if !strings.Contains(content, `p "example.com/job"`) ||
+		!strings.Contains(content, "beekeeper.WrapTypedJob(p.Job)") {
+		t.Error("unexpected build file:", content)
+	}
+}
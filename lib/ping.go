@@ -0,0 +1,86 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Ping sends a ping to the node and blocks until it responds. Unlike a status request, the node responds without
+// gathering its usage. ErrTimeout is returned if it doesn't respond within the timeout.
+func (s *Server) Ping(n Node, timeout time.Duration) error {
+	_, err := s.query(n, Message{Operation: OperationPing}, OperationPong, timeout)
+	return err
+}
+
+// pingCallback is the callback for the Ping operation.
+func pingCallback(s *Server, conn *Conn, _ Message) {
+	err := s.sendWithConn(conn, Message{Operation: OperationPong})
+	if err != nil {
+		s.log().Errorln("Unable to respond to a ping:", err)
+	}
+}
+
+// Reachable pings every node at once and returns the ones that respond within the timeout. Unlike Scan it doesn't
+// discover new nodes, and unlike checking their connections it makes a full round-trip to each of them.
+func (n Nodes) Reachable(s *Server, timeout time.Duration) Nodes {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return n.ReachableCtx(ctx, s)
+}
+
+// ReachableCtx pings every node at once and returns the ones that respond before the context is done. See Reachable.
+func (n Nodes) ReachableCtx(ctx context.Context, s *Server) Nodes {
+	reachable := make([]bool, len(n))
+
+	var wg sync.WaitGroup
+	for i, node := range n {
+		wg.Add(1)
+
+		go func(i int, node Node) {
+			defer wg.Done()
+
+			_, err := s.queryCtx(ctx, node, Message{Operation: OperationPing}, OperationPong)
+			if err != nil {
+				s.log().Debugln("Node", node.Name, "unreachable:", err)
+				return
+			}
+
+			reachable[i] = true
+		}(i, node)
+	}
+
+	wg.Wait()
+
+	var nodes Nodes
+	for i, ok := range reachable {
+		if ok {
+			nodes = append(nodes, n[i])
+		}
+	}
+
+	return nodes
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNodes_Reachable(t *testing.T) {
+	sv, receiveChan, sendChan := startPrimaryTestChannels()
+
+	nodes := getTestNodes()[:2]
+
+	done := make(chan Nodes, 1)
+	go func() {
+		done <- nodes.Reachable(sv, time.Millisecond*500)
+	}()
+
+	for range nodes {
+		select {
+		case sent := <-sendChan:
+			if sent.Operation != OperationPing {
+				t.Error("unexpected operation", sent.Operation)
+				return
+			}
+		case <-time.After(time.Second):
+			t.Error("ping not sent")
+			return
+		}
+	}
+
+	time.Sleep(time.Millisecond * 100) // Let the awaitables register
+
+	msg := getTestMessage()
+	msg.Operation = OperationPong
+	msg.Token = sv.Config.Token
+	receiveChan <- Request{Msg: msg, Conn: Conn{}} // Only 192.168.1.1 responds
+
+	reachable := <-done
+	if len(reachable) != 1 || !reachable[0].Equals(nodes[0]) {
+		t.Error("unexpected reachable nodes:", reachable)
+	}
+}
+
+func TestServer_Ping(t *testing.T) {
+	sv, _, sendChan := startPrimaryTestChannels()
+
+	err := sv.Ping(getTestNodes()[0], time.Millisecond*50)
+	if err != ErrTimeout {
+		t.Error("expected ErrTimeout, got", err)
+	}
+
+	<-sendChan
+}
+
+func TestPingCallback(t *testing.T) {
+	sv, _, sendChan := startPrimaryTestChannels()
+
+	msg := getTestMessage()
+	msg.Operation = OperationPing
+
+	go sv.handleMessage(&Conn{}, msg)
+
+	select {
+	case response := <-sendChan:
+		if response.Operation != OperationPong {
+			t.Error("unexpected response", response.Operation)
+		}
+	case <-time.After(time.Millisecond * 500):
+		t.Error("ping not responded")
+	}
+}
@@ -0,0 +1,262 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// taskDurationBuckets are the upper bounds of the task duration histogram buckets, in seconds.
+var taskDurationBuckets = []float64{0.01, 0.1, 1, 10, 60, 600}
+
+// metricsShutdownTimeout is the time given to in-flight scrapes once the server is stopped.
+const metricsShutdownTimeout = time.Second * 5
+
+// Metrics holds the state of the server's metrics at the moment they were taken. See Server.Metrics.
+type Metrics struct {
+	// TasksSubmitted is the amount of tasks sent to the nodes. Tasks are counted before being sent.
+	TasksSubmitted uint64
+
+	// TasksSucceeded is the amount of tasks that returned a result.
+	TasksSucceeded uint64
+
+	// TasksFailed is the amount of tasks that couldn't be sent, timed out or returned an error.
+	TasksFailed uint64
+
+	// TaskDurations is the distribution of the time taken by the tasks, in seconds.
+	TaskDurations HistogramSnapshot
+
+	// MessageBytesSent is the size of all the messages sent, in bytes.
+	MessageBytesSent uint64
+
+	// MessageBytesReceived is the size of all the messages received, in bytes.
+	MessageBytesReceived uint64
+
+	// ActiveNodes is the amount of known nodes.
+	ActiveNodes int
+
+	// QueueDepth is the amount of received messages waiting to be processed, including the tasks held while quiesced.
+	QueueDepth int
+}
+
+// metricCounters holds the counters behind Metrics. All fields are accessed atomically, and a nil metricCounters
+// ignores all updates.
+type metricCounters struct {
+	tasksSubmitted uint64
+	tasksSucceeded uint64
+	tasksFailed    uint64
+	bytesSent      uint64
+	bytesReceived  uint64
+
+	// queued is the amount of received messages waiting to be queued.
+	queued int64
+
+	taskDurations *Histogram
+}
+
+// newMetricCounters creates zeroed metricCounters.
+func newMetricCounters() *metricCounters {
+	return &metricCounters{
+		taskDurations: newHistogram("beekeeper_task_duration_seconds", taskDurationBuckets),
+	}
+}
+
+// taskSubmitted counts a task about to be sent to a node.
+func (m *metricCounters) taskSubmitted() {
+	if m != nil {
+		atomic.AddUint64(&m.tasksSubmitted, 1)
+	}
+}
+
+// taskDone counts a finished task and its duration.
+func (m *metricCounters) taskDone(elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	if err != nil {
+		atomic.AddUint64(&m.tasksFailed, 1)
+	} else {
+		atomic.AddUint64(&m.tasksSucceeded, 1)
+	}
+
+	m.taskDurations.Observe(elapsed.Seconds())
+}
+
+// sent counts the bytes of a sent message.
+func (m *metricCounters) sent(size int) {
+	if m != nil {
+		atomic.AddUint64(&m.bytesSent, uint64(size))
+	}
+}
+
+// received counts the bytes of a received message.
+func (m *metricCounters) received(size int) {
+	if m != nil {
+		atomic.AddUint64(&m.bytesReceived, uint64(size))
+	}
+}
+
+// queueing counts a message that starts or, with a negative delta, stops waiting to be queued.
+func (m *metricCounters) queueing(delta int64) {
+	if m != nil {
+		atomic.AddInt64(&m.queued, delta)
+	}
+}
+
+// Metrics returns the current state of the server's metrics.
+func (s *Server) Metrics() Metrics {
+	m := Metrics{ActiveNodes: len(s.Nodes())}
+
+	s.heldRequestsLock.Lock()
+	m.QueueDepth = len(s.heldRequests)
+	s.heldRequestsLock.Unlock()
+
+	if c := s.metrics; c != nil {
+		m.TasksSubmitted = atomic.LoadUint64(&c.tasksSubmitted)
+		m.TasksSucceeded = atomic.LoadUint64(&c.tasksSucceeded)
+		m.TasksFailed = atomic.LoadUint64(&c.tasksFailed)
+		m.TaskDurations = c.taskDurations.Snapshot()
+		m.MessageBytesSent = atomic.LoadUint64(&c.bytesSent)
+		m.MessageBytesReceived = atomic.LoadUint64(&c.bytesReceived)
+		m.QueueDepth += int(atomic.LoadInt64(&c.queued))
+	}
+
+	return m
+}
+
+// MetricsHandler returns an http.Handler serving the server's metrics in the Prometheus text format, to be mounted
+// on an existing HTTP server. See StartMetricsServer.
+func (s *Server) MetricsHandler() http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metricsCollector{server: s})
+
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorLog: s.log()})
+}
+
+// StartMetricsServer serves the server's metrics on addr at /metrics, in the Prometheus text format. It returns once
+// listening, and the metrics are served until the server is stopped.
+func (s *Server) StartMetricsServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.MetricsHandler())
+
+	httpServer := &http.Server{Handler: mux}
+
+	go func() {
+		err := httpServer.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			s.log().Errorln("Metrics server stopped:", err)
+		}
+	}()
+
+	go func() {
+		<-s.terminationChan
+
+		ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+		defer cancel()
+
+		_ = httpServer.Shutdown(ctx)
+	}()
+
+	s.log().Infoln("Serving metrics on", listener.Addr().String())
+
+	return nil
+}
+
+var (
+	taskSubmittedDesc = prometheus.NewDesc("beekeeper_task_submitted_total", "Tasks sent to the nodes.", nil, nil)
+	taskSuccessDesc   = prometheus.NewDesc("beekeeper_task_success_total", "Tasks that returned a result.", nil, nil)
+	taskErrorDesc     = prometheus.NewDesc("beekeeper_task_error_total", "Tasks that failed.", nil, nil)
+	taskDurationDesc  = prometheus.NewDesc("beekeeper_task_duration_seconds", "Time taken by the tasks, in seconds.",
+		nil, nil)
+
+	bytesSentDesc = prometheus.NewDesc("beekeeper_message_bytes_sent_total", "Size of the messages sent, in bytes.",
+		nil, nil)
+	bytesReceivedDesc = prometheus.NewDesc("beekeeper_message_bytes_received_total",
+		"Size of the messages received, in bytes.", nil, nil)
+	messageSizeDesc = prometheus.NewDesc("beekeeper_message_size_bytes",
+		"Size of the messages sent and received, in bytes.", nil, nil)
+	compressionRatioDesc = prometheus.NewDesc("beekeeper_compression_ratio", "Compression ratio of the messages sent.",
+		nil, nil)
+
+	activeNodesDesc = prometheus.NewDesc("beekeeper_active_nodes", "Known nodes.", nil, nil)
+	queueDepthDesc  = prometheus.NewDesc("beekeeper_queue_depth", "Received messages waiting to be processed.", nil,
+		nil)
+)
+
+// metricsCollector is a prometheus.Collector exporting the Metrics and histograms of a server, as taken when scraped.
+type metricsCollector struct {
+	server *Server
+}
+
+// Describe sends the descriptors of all the metrics exported by the collector.
+func (c metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{taskSubmittedDesc, taskSuccessDesc, taskErrorDesc, taskDurationDesc,
+		bytesSentDesc, bytesReceivedDesc, messageSizeDesc, compressionRatioDesc, activeNodesDesc, queueDepthDesc} {
+		ch <- desc
+	}
+}
+
+// Collect sends the current value of every metric.
+func (c metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	m := c.server.Metrics()
+
+	ch <- prometheus.MustNewConstMetric(taskSubmittedDesc, prometheus.CounterValue, float64(m.TasksSubmitted))
+	ch <- prometheus.MustNewConstMetric(taskSuccessDesc, prometheus.CounterValue, float64(m.TasksSucceeded))
+	ch <- prometheus.MustNewConstMetric(taskErrorDesc, prometheus.CounterValue, float64(m.TasksFailed))
+	collectHistogram(ch, taskDurationDesc, m.TaskDurations)
+
+	ch <- prometheus.MustNewConstMetric(bytesSentDesc, prometheus.CounterValue, float64(m.MessageBytesSent))
+	ch <- prometheus.MustNewConstMetric(bytesReceivedDesc, prometheus.CounterValue, float64(m.MessageBytesReceived))
+	collectHistogram(ch, messageSizeDesc, c.server.MessageSizeHistogram())
+	collectHistogram(ch, compressionRatioDesc, c.server.CompressionRatioHistogram())
+
+	ch <- prometheus.MustNewConstMetric(activeNodesDesc, prometheus.GaugeValue, float64(m.ActiveNodes))
+	ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(m.QueueDepth))
+}
+
+// collectHistogram sends the histogram snapshot as a Prometheus histogram. Empty snapshots, as taken from a nil
+// Histogram, are skipped.
+func collectHistogram(ch chan<- prometheus.Metric, desc *prometheus.Desc, h HistogramSnapshot) {
+	if h.Name == "" {
+		return
+	}
+
+	buckets := make(map[float64]uint64, len(h.Bounds))
+	for i, bound := range h.Bounds {
+		buckets[bound] = h.Counts[i]
+	}
+
+	ch <- prometheus.MustNewConstHistogram(desc, h.Count, h.Sum, buckets)
+}
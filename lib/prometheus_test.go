@@ -0,0 +1,104 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_Metrics(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+	_ = s.SetSendCallback(func(*Server, *Conn, Message) error {
+		return nil
+	})
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	n := Node{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.1")}}
+	_, err := s.Execute(n, NewTask(), time.Millisecond*10)
+	if err == nil {
+		t.Fatal("expected a timeout")
+	}
+
+	s.metrics.taskSubmitted()
+	s.metrics.taskDone(time.Second*2, nil)
+	s.metrics.sent(100)
+	s.metrics.received(50)
+	s.updateNode(getTestNodes()[0])
+
+	m := s.Metrics()
+	if m.TasksSubmitted != 2 || m.TasksSucceeded != 1 || m.TasksFailed != 1 {
+		t.Error("unexpected task counters:", m.TasksSubmitted, m.TasksSucceeded, m.TasksFailed)
+	}
+
+	if m.TaskDurations.Count != 2 || m.MessageBytesSent != 100 || m.MessageBytesReceived != 50 {
+		t.Error("unexpected metrics:", m)
+	}
+
+	if m.ActiveNodes != 1 {
+		t.Error("expected 1 active node, got", m.ActiveNodes)
+	}
+}
+
+func TestServer_MetricsHandler(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+	s.metrics.taskDone(time.Second*2, nil)
+
+	rec := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Error("unexpected content type:", rec.Header().Get("Content-Type"))
+	}
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE beekeeper_task_submitted_total counter",
+		"beekeeper_task_success_total 1",
+		"beekeeper_task_error_total 0",
+		"# TYPE beekeeper_task_duration_seconds histogram",
+		"beekeeper_task_duration_seconds_bucket{le=\"1\"} 0",
+		"beekeeper_task_duration_seconds_bucket{le=\"10\"} 1",
+		"beekeeper_task_duration_seconds_bucket{le=\"+Inf\"} 1",
+		"beekeeper_task_duration_seconds_sum 2",
+		"beekeeper_message_size_bytes_count 0",
+		"# TYPE beekeeper_active_nodes gauge",
+		"beekeeper_queue_depth 0",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Error("missing line:", line)
+		}
+	}
+}
+
+func TestServer_StartMetricsServerInvalidAddr(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+	if err := s.StartMetricsServer("invalid:address:0"); err == nil {
+		t.Error("expected a listen error")
+	}
+}
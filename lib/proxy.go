@@ -0,0 +1,168 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// proxySignature is the signature that starts every PROXY protocol v2 header.
+var proxySignature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+const (
+	// proxyHeaderLen is the length of the fixed part of a PROXY protocol v2 header.
+	proxyHeaderLen = 16
+
+	// proxyCommandProxy is the command used by proxies relaying a connection. The LOCAL command carries no address.
+	proxyCommandProxy = 0x1
+
+	// proxyFamilyIPv4 is the address family of IPv4 addresses.
+	proxyFamilyIPv4 = 0x1
+
+	// proxyFamilyIPv6 is the address family of IPv6 addresses.
+	proxyFamilyIPv6 = 0x2
+)
+
+// ErrInvalidProxyHeader is produced when a connection doesn't start with a valid PROXY protocol v2 header
+var ErrInvalidProxyHeader = errors.New("invalid proxy protocol header")
+
+// proxyConn wraps a connection that starts with a PROXY protocol v2 header. The header is read before any data, and
+// the source address in it is reported as the remote address.
+type proxyConn struct {
+	net.Conn
+
+	// headerOnce makes sure that the header is read only once.
+	headerOnce sync.Once
+
+	// srcAddr is the source address in the header. It's nil if the header carries no address.
+	srcAddr net.Addr
+
+	// headerErr is the error produced while reading the header.
+	headerErr error
+}
+
+// proxyListener wraps a listener to accept connections that start with a PROXY protocol v2 header.
+type proxyListener struct {
+	net.Listener
+}
+
+// Accept waits for the next connection and wraps it to read its header.
+func (l proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return newProxyConn(conn), nil
+}
+
+// newProxyConn wraps the connection to read its PROXY protocol v2 header.
+func newProxyConn(conn net.Conn) *proxyConn {
+	return &proxyConn{Conn: conn}
+}
+
+// Read reads the header on the first call and then reads data from the connection.
+func (c *proxyConn) Read(b []byte) (int, error) {
+	err := c.header()
+	if err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Read(b)
+}
+
+// header reads the header, if not read yet, and returns the error produced while reading it.
+func (c *proxyConn) header() error {
+	c.headerOnce.Do(c.readHeader)
+	return c.headerErr
+}
+
+// RemoteAddr returns the source address in the header. If the header is not read yet or carries no address the
+// address of the connection is returned.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.srcAddr != nil {
+		return c.srcAddr
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// readHeader reads and parses the header.
+func (c *proxyConn) readHeader() {
+	header := make([]byte, proxyHeaderLen)
+
+	_, err := io.ReadFull(c.Conn, header)
+	if err != nil {
+		c.headerErr = err
+		return
+	}
+
+	if !bytes.Equal(header[:len(proxySignature)], proxySignature) || header[12]>>4 != 0x2 {
+		c.headerErr = ErrInvalidProxyHeader
+		return
+	}
+
+	addrs := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+
+	_, err = io.ReadFull(c.Conn, addrs)
+	if err != nil {
+		c.headerErr = err
+		return
+	}
+
+	if header[12]&0x0F != proxyCommandProxy {
+		return // LOCAL command, the connection's address is kept
+	}
+
+	c.srcAddr, c.headerErr = parseProxyAddr(header[13]>>4, addrs)
+}
+
+// parseProxyAddr parses the source address of the address block of a header. Unknown families carry no address.
+func parseProxyAddr(family byte, addrs []byte) (net.Addr, error) {
+	var ipLen int
+	switch family {
+	case proxyFamilyIPv4:
+		ipLen = net.IPv4len
+	case proxyFamilyIPv6:
+		ipLen = net.IPv6len
+	default:
+		return nil, nil
+	}
+
+	// Source and destination IPs followed by the source and destination ports
+	if len(addrs) < ipLen*2+4 {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	ip := make(net.IP, ipLen)
+	copy(ip, addrs[:ipLen])
+
+	port := binary.BigEndian.Uint16(addrs[ipLen*2 : ipLen*2+2])
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestProxyHeader creates a PROXY protocol v2 header for a TCP over IPv4 connection from src.
+func newTestProxyHeader(src *net.TCPAddr) []byte {
+	header := append([]byte{}, proxySignature...)
+	header = append(header, 0x21, 0x11, 0, 12)
+
+	header = append(header, src.IP.To4()...)
+	header = append(header, net.ParseIP("10.0.0.1").To4()...)
+
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports[:2], uint16(src.Port))
+	binary.BigEndian.PutUint16(ports[2:], uint16(DefaultPort))
+
+	return append(header, ports...)
+}
+
+func TestProxyConn(t *testing.T) {
+	client, server := net.Pipe()
+
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 4000}
+	go func() {
+		_, _ = client.Write(append(newTestProxyHeader(src), []byte("data")...))
+		_ = client.Close()
+	}()
+
+	conn := newProxyConn(server)
+
+	data, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if string(data) != "data" {
+		t.Error("unexpected data:", string(data))
+	}
+
+	addr := toTCPAddr(conn.RemoteAddr())
+	if !addr.IP.Equal(src.IP) || addr.Port != src.Port {
+		t.Error("unexpected remote address:", addr)
+	}
+}
+
+func TestProxyConnInvalid(t *testing.T) {
+	client, server := net.Pipe()
+
+	go func() {
+		_, _ = client.Write([]byte("not a proxy protocol header"))
+		_ = client.Close()
+	}()
+
+	defer server.Close()
+
+	_, err := newProxyConn(server).Read(make([]byte, 1))
+	if err != ErrInvalidProxyHeader {
+		t.Error("expected ErrInvalidProxyHeader, got", err)
+	}
+}
+
+func TestProxyListenerTLS(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	cert, err := tls.X509KeyPair(s.Config.TLSCertificate, s.Config.TLSPrivateKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tlsListener := tls.NewListener(proxyListener{l}, newTLSConfig(s.Config, cert))
+	defer tlsListener.Close()
+
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 4000}
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+
+		_, _ = conn.Write(newTestProxyHeader(src))
+
+		tlsConn := tls.Client(conn, newTLSConfig(s.Config, cert))
+		_, _ = tlsConn.Write([]byte("data"))
+		_ = tlsConn.Close()
+	}()
+
+	conn, err := tlsListener.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	data := make([]byte, 4)
+	_, err = io.ReadFull(conn, data)
+	if err != nil || string(data) != "data" {
+		t.Error("unexpected data:", string(data), err)
+	}
+
+	addr := toTCPAddr(conn.RemoteAddr())
+	if !addr.IP.Equal(src.IP) || addr.Port != src.Port {
+		t.Error("unexpected remote address:", addr)
+	}
+}
+
+func TestServer_acceptConnsProxyWhitelist(t *testing.T) {
+	for _, tc := range []struct {
+		src     string
+		allowed bool
+	}{
+		{"192.168.1.1", true},
+		{"192.168.1.2", false},
+	} {
+		c := NewDefaultConfig()
+		c.Whitelist = []string{"192.168.1.1"}
+
+		s := NewServer(c)
+		s.whitelist = resolveWhitelist(c.Whitelist, s.log())
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go s.acceptConns(proxyListener{l}, nil)
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := newMessage().encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The peer is judged by the source address in the header, not by the proxy's
+		_, _ = conn.Write(newTestProxyHeader(&net.TCPAddr{IP: net.ParseIP(tc.src), Port: 4000}))
+		_, _ = conn.Write(append([]byte(fmt.Sprintf("%d\n", len(data))), data...))
+
+		select {
+		case <-s.queue:
+			if !tc.allowed {
+				t.Error("connection accepted from", tc.src)
+			}
+		case <-time.After(time.Millisecond * 500):
+			if tc.allowed {
+				t.Error("connection refused from", tc.src)
+			}
+		}
+
+		_ = conn.Close()
+		_ = l.Close()
+	}
+}
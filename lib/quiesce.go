@@ -0,0 +1,92 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Quiesce pauses task intake without stopping the server. Task execution requests received afterwards are held until
+// Resume is called, while every other message, including the results of tasks already running, is still processed.
+// It blocks until there are no tasks awaited by Execute nor tasks being run by this node, and returns ErrTimeout if
+// that doesn't happen within the timeout. The server remains quiesced either way.
+func (s *Server) Quiesce(timeout time.Duration) error {
+	atomic.StoreInt32(&s.quiescing, 1)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if s.pendingTaskCount() == 0 && s.runningTaskCount() == 0 {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			return ErrTimeout
+		}
+	}
+}
+
+// Resume resumes the task intake paused by Quiesce. The requests held in the meantime are processed.
+func (s *Server) Resume() {
+	s.heldRequestsLock.Lock()
+	atomic.StoreInt32(&s.quiescing, 0)
+	held := s.heldRequests
+	s.heldRequests = nil
+	s.heldRequestsLock.Unlock()
+
+	for _, req := range held {
+		go s.handleRequest(req)
+	}
+}
+
+// IsQuiesced reports if the server was quiesced and not resumed yet.
+func (s *Server) IsQuiesced() bool {
+	return atomic.LoadInt32(&s.quiescing) == 1
+}
+
+// holdRequest keeps the request to be processed on Resume if the server is quiesced and the request would start a
+// task. It reports if the request was held.
+func (s *Server) holdRequest(req Request) bool {
+	if req.Msg.Operation != OperationJobExecute {
+		return false
+	}
+
+	s.heldRequestsLock.Lock()
+	defer s.heldRequestsLock.Unlock()
+
+	if !s.IsQuiesced() {
+		return false
+	}
+
+	s.log().Debugln("Quiesced, holding:", req.Msg.summary())
+	s.heldRequests = append(s.heldRequests, req)
+
+	return true
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServer_Quiesce(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	err := s.Quiesce(time.Second)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !s.IsQuiesced() {
+		t.Error("server not quiesced")
+		return
+	}
+
+	if !s.holdRequest(Request{Msg: Message{Operation: OperationJobExecute}, Conn: Conn{}}) {
+		t.Error("task request not held")
+	}
+
+	if s.holdRequest(Request{Msg: Message{Operation: OperationJobResult}, Conn: Conn{}}) {
+		t.Error("result held")
+	}
+
+	s.expectTask("unrelated") // Awaited responses without a pending task don't block
+
+	err = s.Quiesce(time.Millisecond * 50)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s.addPendingTask("pending", getTestNodes()[0])
+
+	err = s.Quiesce(time.Millisecond * 50)
+	if err != ErrTimeout {
+		t.Error("expected ErrTimeout, got", err)
+		return
+	}
+
+	s.removePendingTask("pending")
+	s.addRunningTask("running", func() {})
+
+	err = s.Quiesce(time.Millisecond * 50)
+	if err != ErrTimeout {
+		t.Error("expected ErrTimeout, got", err)
+	}
+}
+
+func TestServer_Resume(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	_ = s.Quiesce(time.Second)
+	s.Resume()
+
+	if s.IsQuiesced() {
+		t.Error("server still quiesced")
+	}
+
+	if s.holdRequest(Request{Msg: Message{Operation: OperationJobExecute}, Conn: Conn{}}) {
+		t.Error("task request held after resuming")
+	}
+}
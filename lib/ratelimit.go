@@ -0,0 +1,75 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter limits the amount of bytes sent per second through a connection. It allows bursts of up to one second
+// worth of bytes.
+type rateLimiter struct {
+	limiter *rate.Limiter
+}
+
+// newRateLimiter creates a rateLimiter allowing bytesPerSecond bytes per second. If bytesPerSecond is zero or negative
+// nil is returned, meaning no limit.
+func newRateLimiter(bytesPerSecond float64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+
+	burst := int(bytesPerSecond)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), burst)}
+}
+
+// wait blocks until n bytes can be sent without exceeding the rate, or the context is done. Messages larger than the
+// burst are waited for in parts.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	burst := l.limiter.Burst()
+	for n > 0 {
+		part := n
+		if part > burst {
+			part = burst
+		}
+
+		err := l.limiter.WaitN(ctx, part)
+		if err != nil {
+			return err
+		}
+
+		n -= part
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(1000)
+
+	start := time.Now()
+
+	err := l.wait(context.Background(), 1000) // Within the budget
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if time.Since(start) > time.Millisecond*100 {
+		t.Error("blocked within the budget")
+		return
+	}
+
+	err = l.wait(context.Background(), 500) // Half a second over the budget
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	elapsed := time.Since(start)
+	if elapsed < time.Millisecond*400 || elapsed > time.Millisecond*900 {
+		t.Error("unexpected wait time:", elapsed)
+		return
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	if newRateLimiter(0) != nil {
+		t.Error("expected no limiter")
+		return
+	}
+
+	var l *rateLimiter
+	err := l.wait(context.Background(), 1<<30)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+}
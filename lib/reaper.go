@@ -0,0 +1,139 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"time"
+)
+
+// reaperMaxMisses is the amount of consecutive checks a node running an awaited task must be offline for the task to
+// be reaped.
+const reaperMaxMisses = 2
+
+// reaperState tracks the node running an awaited task between reaper checks.
+type reaperState struct {
+	// seen is set once the node is found online. Tasks of nodes never seen online are not reaped.
+	seen bool
+
+	// misses is the amount of consecutive checks the node was offline for.
+	misses int
+}
+
+// startAwaitedReaper checks every WatchdogSleep/2 the nodes running the awaited tasks. The tasks of nodes that
+// disconnect are resolved with a "node disconnected" error instead of waiting for a timeout. It blocks until the
+// server is stopped.
+func (s *Server) startAwaitedReaper() {
+	ticker := time.NewTicker(WatchdogSleep / 2)
+	defer ticker.Stop()
+
+	states := make(map[string]*reaperState)
+
+	for {
+		select {
+		case <-s.terminationChan:
+			return
+		case <-ticker.C:
+			states = s.reapAwaited(states)
+		}
+	}
+}
+
+// reapAwaited resolves the awaited tasks of the nodes that have been offline for reaperMaxMisses checks. It returns
+// the updated states of the tasks still awaited.
+func (s *Server) reapAwaited(states map[string]*reaperState) map[string]*reaperState {
+	s.awaitedLock.Lock()
+	defer s.awaitedLock.Unlock()
+
+	updated := make(map[string]*reaperState)
+	var remaining awaitables
+
+	for _, a := range s.awaited {
+		if a.taskUUID == "" {
+			remaining = append(remaining, a)
+			continue
+		}
+
+		s.pendingTasksLock.Lock()
+		n, pending := s.pendingTasks[a.taskUUID]
+		s.pendingTasksLock.Unlock()
+
+		if !pending || n.Addr == nil {
+			remaining = append(remaining, a)
+			continue
+		}
+
+		state, ok := states[a.taskUUID]
+		if !ok {
+			state = &reaperState{}
+		}
+
+		if s.isOnline(n) {
+			state.seen = true
+			state.misses = 0
+		} else if state.seen {
+			state.misses++
+		}
+
+		if state.misses < reaperMaxMisses {
+			updated[a.taskUUID] = state
+			remaining = append(remaining, a)
+			continue
+		}
+
+		s.log().Warnln("Node", n.Name, "disconnected while running task", a.taskUUID)
+
+		msg, err := newDisconnectedResultMessage(a.taskUUID, n)
+		if err != nil {
+			s.log().Errorln("Unable to reap task", a.taskUUID+":", err)
+			remaining = append(remaining, a)
+			continue
+		}
+
+		select {
+		case a.notify <- msg:
+		default:
+		}
+	}
+
+	s.awaited = remaining
+
+	return updated
+}
+
+// newDisconnectedResultMessage creates a JobResult Message for a task whose node disconnected.
+func newDisconnectedResultMessage(taskUUID string, n Node) (Message, error) {
+	msg := newMessage()
+	msg.Operation = OperationJobResult
+	msg.Addr = n.Addr
+	msg.Name = n.Name
+
+	return msg.setData(Result{
+		UUID:  taskUUID,
+		Error: "node disconnected",
+		RemoteError: &RemoteError{
+			Code:     ErrorCodeNodeDisconnected,
+			Message:  "node disconnected",
+			NodeName: n.Name,
+		},
+	})
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServer_reapAwaited(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	n := Node{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.1")}, Name: "testWorker1"}
+	s.updateNode(n)
+	s.addPendingTask("task", n)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.awaitTask("task", time.Second*5)
+		done <- err
+	}()
+
+	time.Sleep(time.Millisecond * 100) // Let the awaitable register
+
+	states := s.reapAwaited(make(map[string]*reaperState)) // Node seen online
+
+	s.nodesLock.Lock()
+	s.nodes = Nodes{}
+	s.nodesLock.Unlock()
+
+	for i := 0; i < reaperMaxMisses; i++ {
+		states = s.reapAwaited(states)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("task not reaped")
+		return
+	}
+
+	if len(s.awaited) != 0 {
+		t.Error("awaitable not removed")
+	}
+}
+
+func TestNewDisconnectedResultMessage(t *testing.T) {
+	n := Node{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.1")}, Name: "testWorker1"}
+
+	msg, err := newDisconnectedResultMessage("task", n)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := decodeResult(msg.Data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res.UUID != "task" || res.ErrorCode() != ErrorCodeNodeDisconnected || res.remoteError().NodeName != n.Name {
+		t.Error("unexpected result:", res)
+	}
+}
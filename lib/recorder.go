@@ -0,0 +1,214 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"sort"
+	"time"
+)
+
+// RecordDirection tells if a recorded Message was received or sent.
+type RecordDirection string
+
+const (
+	// RecordInbound is a Message received by the server
+	RecordInbound RecordDirection = "in"
+
+	// RecordOutbound is a Message sent by the server
+	RecordOutbound RecordDirection = "out"
+)
+
+// RecordedMessage is a single entry of a recording made by Server.StartRecording.
+type RecordedMessage struct {
+	// Direction tells if the Message was received or sent.
+	Direction RecordDirection `json:"direction"`
+
+	// Time is the moment the Message was received or sent.
+	Time time.Time `json:"time"`
+
+	// Peer is the IP of the remote node. It's empty if unknown.
+	Peer string `json:"peer,omitempty"`
+
+	// Message is the recorded Message. Its Token is removed.
+	Message Message `json:"message"`
+}
+
+// StartRecording writes every Message received and sent by the server to w as newline-delimited JSON, see
+// RecordedMessage. Only one recording can be made at a time, so an ongoing one is replaced. The returned function
+// stops the recording.
+func (s *Server) StartRecording(w io.Writer) func() {
+	enc := json.NewEncoder(w)
+
+	s.recordingLock.Lock()
+	s.recording = enc
+	s.recordingLock.Unlock()
+
+	return func() {
+		s.recordingLock.Lock()
+		defer s.recordingLock.Unlock()
+
+		if s.recording == enc {
+			s.recording = nil
+		}
+	}
+}
+
+// ReplayRecording reads a recording made by StartRecording and queues its received messages in order, waiting between
+// them as much as they were apart when recorded. Sent messages are ignored, as the server sends them again while
+// processing the received ones; SetSendCallback and SetConnCallback can be used to replay without real nodes. The
+// messages are given the server's token, and the server must be started.
+func (s *Server) ReplayRecording(r io.Reader) error {
+	var inbound []RecordedMessage
+
+	dec := json.NewDecoder(r)
+	for {
+		var rec RecordedMessage
+
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if rec.Direction == RecordInbound {
+			inbound = append(inbound, rec)
+		}
+	}
+
+	sort.SliceStable(inbound, func(i, j int) bool {
+		return inbound[i].Time.Before(inbound[j].Time)
+	})
+
+	for i, rec := range inbound {
+		if i > 0 {
+			time.Sleep(rec.Time.Sub(inbound[i-1].Time))
+		}
+
+		msg := rec.Message
+		msg.Token = s.outgoingToken(nil)
+
+		// Recorded messages were already authenticated. Their responses are discarded, as the sender is gone.
+		conn := newConn(discardConn{peer: msg.Addr})
+		conn.auth = &connAuth{token: msg.Token, verified: true}
+
+		s.queue <- Request{Msg: msg, Conn: conn}
+	}
+
+	return nil
+}
+
+// recordMessage writes the Message to the ongoing recording, if any.
+func (s *Server) recordMessage(direction RecordDirection, peer net.Addr, msg Message) {
+	s.recordingLock.Lock()
+	defer s.recordingLock.Unlock()
+
+	if s.recording == nil {
+		return
+	}
+
+	msg.Token = ""
+
+	rec := RecordedMessage{
+		Direction: direction,
+		Time:      time.Now(),
+		Message:   msg,
+	}
+
+	if addr, ok := peer.(*net.TCPAddr); ok {
+		if addr != nil {
+			rec.Peer = addr.IP.String()
+		}
+	} else if peer != nil {
+		rec.Peer = peer.String()
+	}
+
+	err := s.recording.Encode(rec)
+	if err != nil {
+		s.log().Errorln("Unable to record message:", err)
+	}
+}
+
+// peerAddr returns the address of the remote end of the connection, or nil if it's not connected.
+func (c *Conn) peerAddr() net.Addr {
+	if c == nil || c.netConn() == nil {
+		return nil
+	}
+
+	return c.RemoteAddr()
+}
+
+// discardConn is a net.Conn that discards everything written to it, used to respond to replayed messages. Reads
+// return io.EOF.
+type discardConn struct {
+	peer *net.TCPAddr
+}
+
+// Read returns io.EOF, as no data is received.
+func (discardConn) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+// Write discards the data.
+func (discardConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// Close does nothing.
+func (discardConn) Close() error {
+	return nil
+}
+
+// LocalAddr returns an empty address.
+func (discardConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+// SetDeadline does nothing.
+func (discardConn) SetDeadline(time.Time) error {
+	return nil
+}
+
+// SetReadDeadline does nothing.
+func (discardConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline does nothing.
+func (discardConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// RemoteAddr returns the address of the node that sent the replayed message, if known.
+func (c discardConn) RemoteAddr() net.Addr {
+	if c.peer == nil {
+		return &net.TCPAddr{}
+	}
+
+	return c.peer
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestServer_StartRecording(t *testing.T) {
+	s, receiveChan, sendChan := startPrimaryTestChannels()
+
+	var buf bytes.Buffer
+	stop := s.StartRecording(&buf)
+
+	msg := getTestMessage()
+	msg.Token = s.Config.Token
+	receiveChan <- Request{Msg: msg, Conn: Conn{}}
+
+	err := s.sendWithConn(&Conn{}, newMessage())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	<-sendChan
+	time.Sleep(time.Millisecond * 100) // Let the request be processed
+	stop()
+
+	directions := make(map[RecordDirection]bool)
+
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var rec RecordedMessage
+		err := dec.Decode(&rec)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if rec.Message.Token != "" {
+			t.Error("token recorded")
+		}
+
+		directions[rec.Direction] = true
+	}
+
+	if !directions[RecordInbound] || !directions[RecordOutbound] {
+		t.Error("unexpected recording:", directions)
+	}
+}
+
+func TestServer_ReplayRecording(t *testing.T) {
+	s, _, _ := startPrimaryTestChannels()
+
+	found := make(chan Node, 1)
+	callback := s.addNodeCallback(func(n Node) {
+		if n.Name == "replayed" {
+			found <- n
+		}
+	})
+	defer s.removeNodeCallback(callback)
+
+	msg := getTestMessage()
+	msg.Name = "replayed"
+
+	var buf bytes.Buffer
+	_ = json.NewEncoder(&buf).Encode(RecordedMessage{Direction: RecordOutbound, Time: time.Now(), Message: msg})
+	_ = json.NewEncoder(&buf).Encode(RecordedMessage{Direction: RecordInbound, Time: time.Now(), Message: msg})
+
+	err := s.ReplayRecording(&buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	select {
+	case <-found:
+	case <-time.After(time.Second):
+		t.Error("replayed message not processed")
+	}
+}
+
+func TestServer_ReplayRecordingResponse(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+	_ = s.SetServerCallback(func(*Server) error {
+		return nil
+	})
+
+	responded := make(chan error, 1)
+	_ = s.SetSendCallback(func(s *Server, c *Conn, m Message) error {
+		err := defaultSendCallback(s, c, m)
+		responded <- err
+
+		return err
+	})
+
+	go func() {
+		_ = s.Start()
+	}()
+	defer s.Stop()
+
+	msg := getTestMessage()
+	msg.Operation = OperationHealthCheck // Responded through the replayed message's connection
+
+	var buf bytes.Buffer
+	_ = json.NewEncoder(&buf).Encode(RecordedMessage{Direction: RecordInbound, Time: time.Now(), Message: msg})
+
+	err := s.ReplayRecording(&buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	select {
+	case err := <-responded:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Error("replayed message not responded")
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// ErrorCode identifies the kind of error a remote execution failed with.
+type ErrorCode int
+
+const (
+	// ErrorCodeNone means no error occurred.
+	ErrorCodeNone ErrorCode = iota
+	// ErrorCodeUnknown is used for errors that couldn't be identified.
+	ErrorCodeUnknown
+	// ErrorCodeTimeout is used when the job ran out of time.
+	ErrorCodeTimeout
+	// ErrorCodeOutOfMemory is used when the job ran out of memory.
+	ErrorCodeOutOfMemory
+	// ErrorCodeBinaryNotFound is used when the job binary is not present on the node.
+	ErrorCodeBinaryNotFound
+	// ErrorCodeInvalidTask is used when the task couldn't be read by the job.
+	ErrorCodeInvalidTask
+	// ErrorCodePanic is used when the job panicked.
+	ErrorCodePanic
+	// ErrorCodeNodeDisconnected is used when the node disconnected while running the job.
+	ErrorCodeNodeDisconnected
+	// ErrorCodeNodeBusy is used when the node was already running as many tasks as allowed.
+	ErrorCodeNodeBusy
+)
+
+// String returns the name of the ErrorCode.
+func (c ErrorCode) String() string {
+	switch c {
+	case ErrorCodeNone:
+		return "None"
+	case ErrorCodeTimeout:
+		return "Timeout"
+	case ErrorCodeOutOfMemory:
+		return "OutOfMemory"
+	case ErrorCodeBinaryNotFound:
+		return "BinaryNotFound"
+	case ErrorCodeInvalidTask:
+		return "InvalidTask"
+	case ErrorCodePanic:
+		return "Panic"
+	case ErrorCodeNodeDisconnected:
+		return "NodeDisconnected"
+	case ErrorCodeNodeBusy:
+		return "NodeBusy"
+	default:
+		return "Unknown"
+	}
+}
+
+// RemoteError is an error produced by a node while running a job.
+type RemoteError struct {
+	// Code identifies the kind of error.
+	Code ErrorCode
+
+	// Message is the description of the error.
+	Message string
+
+	// NodeName is the name of the node where the error occurred.
+	NodeName string
+}
+
+// Error returns the description of the RemoteError.
+func (e *RemoteError) Error() string {
+	if e.NodeName == "" {
+		return e.Message
+	}
+
+	return "node " + e.NodeName + ": " + e.Message
+}
+
+// newRemoteError creates a RemoteError from err, identifying its ErrorCode.
+func newRemoteError(err error) *RemoteError {
+	code := ErrorCodeUnknown
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		code = ErrorCodeTimeout
+	case errors.Is(err, os.ErrNotExist):
+		code = ErrorCodeBinaryNotFound
+	default:
+		code = parseErrorCode(err.Error())
+	}
+
+	return &RemoteError{Code: code, Message: err.Error()}
+}
+
+// newPanicRemoteError creates a RemoteError from a recovered panic value.
+func newPanicRemoteError(r interface{}) *RemoteError {
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("%v", r)
+	}
+
+	remoteErr := newRemoteError(err)
+
+	var runtimeErr runtime.Error
+	if remoteErr.Code == ErrorCodeUnknown || errors.As(err, &runtimeErr) {
+		remoteErr.Code = ErrorCodePanic
+	}
+
+	return remoteErr
+}
+
+// parseErrorCode identifies the ErrorCode of an error description. It's used for nodes that only report the error
+// as a string.
+func parseErrorCode(msg string) ErrorCode {
+	if msg == "" {
+		return ErrorCodeNone
+	}
+
+	msg = strings.ToLower(msg)
+	switch {
+	case strings.Contains(msg, "timeout"), strings.Contains(msg, "deadline exceeded"):
+		return ErrorCodeTimeout
+	case strings.Contains(msg, "out of memory"), strings.Contains(msg, "cannot allocate memory"):
+		return ErrorCodeOutOfMemory
+	case strings.Contains(msg, "no such file"), strings.Contains(msg, "executable file not found"):
+		return ErrorCodeBinaryNotFound
+	case strings.Contains(msg, "panic"):
+		return ErrorCodePanic
+	case strings.Contains(msg, "node disconnected"):
+		return ErrorCodeNodeDisconnected
+	case strings.Contains(msg, "node busy"):
+		return ErrorCodeNodeBusy
+	default:
+		return ErrorCodeUnknown
+	}
+}
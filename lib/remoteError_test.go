@@ -0,0 +1,77 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestResult_ErrorCode(t *testing.T) {
+	cases := []struct {
+		res  Result
+		code ErrorCode
+	}{
+		{Result{}, ErrorCodeNone},
+		{Result{Error: "i/o timeout"}, ErrorCodeTimeout},
+		{Result{Error: "Unable to run job: fork/exec job.bin: no such file or directory"}, ErrorCodeBinaryNotFound},
+		{Result{Error: "something failed"}, ErrorCodeUnknown},
+		{newErrorResult(fmt.Errorf("waiting: %w", context.DeadlineExceeded)), ErrorCodeTimeout},
+		{newErrorResult(&os.PathError{Op: "open", Path: "job.bin", Err: os.ErrNotExist}), ErrorCodeBinaryNotFound},
+		{newRemoteErrorResult(&RemoteError{Code: ErrorCodeOutOfMemory, Message: "oom"}), ErrorCodeOutOfMemory},
+	}
+
+	for _, c := range cases {
+		if code := c.res.ErrorCode(); code != c.code {
+			t.Error("expected", c.code, "for", c.res.Error, "got", code)
+		}
+	}
+}
+
+func TestNewPanicRemoteError(t *testing.T) {
+	var runtimeErr error
+	func() {
+		defer func() {
+			runtimeErr = newPanicRemoteError(recover())
+		}()
+
+		var m map[string]int
+		m["panic"] = 1
+	}()
+
+	var remoteErr *RemoteError
+	if !errors.As(runtimeErr, &remoteErr) || remoteErr.Code != ErrorCodePanic {
+		t.Error("runtime panic not identified:", runtimeErr)
+	}
+
+	if code := newPanicRemoteError("custom").Code; code != ErrorCodePanic {
+		t.Error("expected", ErrorCodePanic, "got", code)
+	}
+
+	if code := newPanicRemoteError(context.DeadlineExceeded).Code; code != ErrorCodeTimeout {
+		t.Error("expected", ErrorCodeTimeout, "got", code)
+	}
+}
@@ -0,0 +1,399 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// requestLogCompactEntries is the amount of entries written to the requestLog after which it's compacted, keeping only
+// the unprocessed messages.
+const requestLogCompactEntries = 1024
+
+// requestLog is an append-only write-ahead log of the received messages. Every message is written before being
+// processed, and marked as done once it was handled, so the unprocessed ones can be replayed after a restart. Only
+// appends are flushed to disk: a done mark lost in a crash means the message is replayed. The file is truncated
+// whenever all the messages are done, and compacted every requestLogCompactEntries entries otherwise.
+type requestLog struct {
+	path string
+	file *os.File
+	seq  uint64
+
+	// pending holds the encoded messages not yet marked as done, keyed by sequence number.
+	pending map[uint64][]byte
+
+	// written is the amount of entries in the file.
+	written int
+
+	// lock is a Mutex lock over file, seq, pending and written.
+	lock sync.Mutex
+}
+
+// requestLogEntry is a single record of the requestLog. Done entries mark the message with the same Seq as handled.
+type requestLogEntry struct {
+	Seq  uint64
+	Done bool
+	Msg  []byte
+}
+
+// openRequestLog opens the log on the given path, creating it if needed, and returns the messages that were not
+// processed. The log is compacted so that only those remain.
+func openRequestLog(path string) (*requestLog, []requestLogEntry, error) {
+	entries, err := readRequestLog(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l := &requestLog{path: path, pending: make(map[uint64][]byte)}
+
+	done := make(map[uint64]bool)
+	for _, e := range entries {
+		if e.Done {
+			done[e.Seq] = true
+		}
+
+		if e.Seq > l.seq {
+			l.seq = e.Seq
+		}
+	}
+
+	var pending []requestLogEntry
+	for _, e := range entries {
+		if !e.Done && !done[e.Seq] {
+			pending = append(pending, e)
+			l.pending[e.Seq] = e.Msg
+		}
+	}
+
+	err = l.compact()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return l, pending, nil
+}
+
+// readRequestLog reads all the entries in the log file. A missing file has no entries, and a truncated last entry,
+// as left by a crash during a write, is ignored.
+func readRequestLog(path string) ([]requestLogEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var entries []requestLogEntry
+	for {
+		var size uint32
+		err = binary.Read(f, binary.BigEndian, &size)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return entries, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, size)
+		_, err = io.ReadFull(f, data)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return entries, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		var e requestLogEntry
+		err = gob.NewDecoder(bytes.NewReader(data)).Decode(&e)
+		if err != nil {
+			return nil, fmt.Errorf("corrupted request log: %s", err.Error())
+		}
+
+		entries = append(entries, e)
+	}
+}
+
+// compact replaces the log file with one holding only the pending entries, and opens it for appending. The lock must
+// be held.
+func (l *requestLog) compact() error {
+	tmpPath := l.path + ".tmp"
+
+	f, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	seqs := make([]uint64, 0, len(l.pending))
+	for seq := range l.pending {
+		seqs = append(seqs, seq)
+	}
+
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i] < seqs[j]
+	})
+
+	for _, seq := range seqs {
+		err = writeRequestLogEntry(f, requestLogEntry{Seq: seq, Msg: l.pending[seq]})
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+
+	err = f.Sync()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	_ = f.Close()
+
+	err = os.Rename(tmpPath, l.path)
+	if err != nil {
+		return err
+	}
+
+	if l.file != nil {
+		_ = l.file.Close()
+	}
+
+	l.file, err = os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0600)
+	l.written = len(seqs)
+
+	return err
+}
+
+// append writes the message to the log and returns its sequence number. The message is only pending once it was
+// flushed to disk.
+func (l *requestLog) append(msg Message) (uint64, error) {
+	data, err := msg.encode()
+	if err != nil {
+		return 0, err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.seq++
+
+	err = l.write(requestLogEntry{Seq: l.seq, Msg: data})
+	if err == nil {
+		err = l.file.Sync()
+	}
+
+	if err != nil {
+		// Rewrite the file without the entry, as it may have been partially written
+		_ = l.compact()
+		return 0, err
+	}
+
+	l.pending[l.seq] = data
+
+	return l.seq, nil
+}
+
+// done marks the message with the sequence number as processed. The log is truncated if no message is left pending,
+// and compacted if it holds requestLogCompactEntries entries.
+func (l *requestLog) done(seq uint64) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	delete(l.pending, seq)
+
+	if len(l.pending) == 0 {
+		return l.truncate()
+	}
+
+	err := l.write(requestLogEntry{Seq: seq, Done: true})
+	if err != nil {
+		return err
+	}
+
+	if l.written >= requestLogCompactEntries {
+		return l.compact()
+	}
+
+	return nil
+}
+
+// purge removes all the entries from the log.
+func (l *requestLog) purge() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.pending = make(map[uint64][]byte)
+
+	err := l.truncate()
+	if err != nil {
+		return err
+	}
+
+	return l.file.Sync()
+}
+
+// truncate removes all the entries from the file. The lock must be held.
+func (l *requestLog) truncate() error {
+	l.written = 0
+	return l.file.Truncate(0)
+}
+
+// write appends the entry to the file, without flushing it to disk. The lock must be held.
+func (l *requestLog) write(e requestLogEntry) error {
+	l.written++
+	return writeRequestLogEntry(l.file, e)
+}
+
+// writeRequestLogEntry writes a length prefixed, gob encoded entry.
+func writeRequestLogEntry(w io.Writer, e requestLogEntry) error {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(e)
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(w, binary.BigEndian, uint32(buf.Len()))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// PurgeQueue removes all the messages from the persistent queue, including the ones pending to be replayed. Nothing is
+// done if Config.PersistentQueuePath is not set.
+func (s *Server) PurgeQueue() error {
+	if s.requestLog == nil {
+		return nil
+	}
+
+	s.replayLock.Lock()
+	s.replay = nil
+	s.replayLock.Unlock()
+
+	return s.requestLog.purge()
+}
+
+// replayRequests queues the messages left unprocessed by a previous run, so that they're handled like received ones. A
+// new connection is created to the sender of every message, as the original one is gone.
+func (s *Server) replayRequests() {
+	s.replayLock.Lock()
+	entries := s.replay
+	s.replay = nil
+	s.replayLock.Unlock()
+
+	if len(entries) > 0 {
+		s.log().Infoln("Replaying", len(entries), "unprocessed messages")
+	}
+
+	for _, e := range entries {
+		msg, err := decodeMessage(e.Msg, s.Config.MaxMessageSize)
+		if err != nil {
+			s.log().Warnln("Discarding invalid persisted message")
+			s.markRequestDone(e.Seq)
+			continue
+		}
+
+		addr := msg.Addr.IP.String()
+		if msg.RespondOnPort != 0 {
+			addr = fmt.Sprintf("%s:%d", addr, msg.RespondOnPort)
+		}
+
+		conn, err := s.dial(addr)
+		if err != nil {
+			s.log().Warnln("Unable to replay message", msg.summary()+":", err)
+			continue
+		}
+
+		// Persisted messages were already authenticated, and their token was not kept
+		msg.Token = s.outgoingToken(nil)
+		conn.auth = &connAuth{token: msg.Token, verified: true}
+
+		s.log().Debugln("Replayed:", msg.summary())
+
+		s.queue <- Request{Msg: msg, Conn: *conn, seq: e.Seq}
+	}
+}
+
+// persistRequest writes the message to the persistent queue, if enabled, and returns its sequence number. It's called
+// before queueing the message, so that it's not lost if the server stops before handling it. Only the messages worth
+// replaying are persisted, without their token. A sequence number of 0 means the message was not persisted.
+func (s *Server) persistRequest(msg Message) uint64 {
+	if s.requestLog == nil || !isPersistedOperation(msg.Operation) {
+		return 0
+	}
+
+	msg.Token = ""
+
+	seq, err := s.requestLog.append(msg)
+	if err != nil {
+		s.log().Errorln("Unable to persist message:", err)
+		return 0
+	}
+
+	return seq
+}
+
+// isPersistedOperation reports if messages with the operation are kept in the persistent queue. Those are the ones
+// that make a node run or install a job, as the rest are either responses or queries that the sender retries.
+func isPersistedOperation(op Operation) bool {
+	switch op {
+	case OperationJobExecute, OperationJobTransfer, OperationBinaryURL:
+		return true
+	default:
+		return false
+	}
+}
+
+// handleRequest handles the message and, once handled, marks it as done in the persistent queue.
+func (s *Server) handleRequest(req Request) {
+	s.handleMessage(&req.Conn, req.Msg)
+
+	s.markRequestDone(req.seq)
+}
+
+// markRequestDone marks the message with the sequence number as processed in the persistent queue. A sequence number
+// of 0 means the message was never persisted.
+func (s *Server) markRequestDone(seq uint64) {
+	if seq == 0 || s.requestLog == nil {
+		return
+	}
+
+	err := s.requestLog.done(seq)
+	if err != nil {
+		s.log().Errorln("Unable to mark persisted message as done:", err)
+	}
+}
@@ -0,0 +1,331 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRequestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beekeeper")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "queue.wal")
+
+	l, pending, err := openRequestLog(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(pending) != 0 {
+		t.Error("unexpected pending entries", len(pending))
+		return
+	}
+
+	msg1 := newMessage()
+	msg1.Name = "msg1"
+
+	msg2 := newMessage()
+	msg2.Name = "msg2"
+
+	seq1, err := l.append(msg1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = l.append(msg2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = l.done(seq1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	l, pending, err = openRequestLog(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(pending) != 1 {
+		t.Error("unexpected pending entries", len(pending))
+		return
+	}
+
+	msg, err := decodeMessage(pending[0].Msg, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if msg.Name != "msg2" {
+		t.Error("unexpected pending message", msg.Name)
+		return
+	}
+
+	err = l.purge()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, pending, err = openRequestLog(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(pending) != 0 {
+		t.Error("entries left after purge", len(pending))
+	}
+}
+
+func TestRequestLog_truncate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beekeeper")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "queue.wal")
+
+	l, _, err := openRequestLog(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	kept, err := l.append(newMessage())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < requestLogCompactEntries; i++ {
+		seq, err := l.append(newMessage())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		err = l.done(seq)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if l.written >= requestLogCompactEntries {
+		t.Error("log not compacted", l.written)
+		return
+	}
+
+	err = l.done(kept)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if info.Size() != 0 {
+		t.Error("log not truncated", info.Size())
+	}
+}
+
+func TestServer_handlePersistsBeforeQueueing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beekeeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "queue.wal")
+
+	c := NewDefaultConfig()
+	c.PersistentQueuePath = path
+
+	s := NewServer(c)
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	go s.handle(server, nil)
+
+	msg := newMessage()
+	msg.Operation = OperationJobExecute
+
+	data, err := msg.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		_, _ = client.Write(append([]byte(fmt.Sprintf("%d\n", len(data))), data...))
+	}()
+
+	// The message is persisted while it waits in the queue, before any handling starts
+	deadline := time.Now().Add(time.Second)
+	for {
+		entries, err := readRequestLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(entries) == 1 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("message not persisted before being queued")
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	var req Request
+	select {
+	case req = <-s.queue:
+	case <-time.After(time.Second):
+		t.Fatal("message not queued")
+	}
+
+	if req.seq == 0 {
+		t.Fatal("queued request without a sequence number")
+	}
+
+	s.markRequestDone(req.seq)
+
+	_, pending, err := openRequestLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pending) != 0 {
+		t.Error("handled message left pending", len(pending))
+	}
+}
+
+func TestRequestLog_appendFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beekeeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "queue.wal")
+
+	l, _, err := openRequestLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = l.file.Close()
+
+	seq, err := l.append(newMessage())
+	if err == nil || seq != 0 {
+		t.Fatal("expected a failed append, got", seq, err)
+	}
+
+	if len(l.pending) != 0 {
+		t.Error("failed append left pending", len(l.pending))
+	}
+
+	_, pending, err := openRequestLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pending) != 0 {
+		t.Error("failed append replayed", len(pending))
+	}
+}
+
+func TestServer_persistRequest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beekeeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "queue.wal")
+
+	c := NewDefaultConfig()
+	c.PersistentQueuePath = path
+
+	s := NewServer(c)
+
+	status := newMessage()
+	status.Operation = OperationStatus
+
+	if seq := s.persistRequest(status); seq != 0 {
+		t.Error("status message persisted with sequence number", seq)
+	}
+
+	execute := newMessage()
+	execute.Operation = OperationJobExecute
+	execute.Token = "secret"
+
+	if seq := s.persistRequest(execute); seq == 0 {
+		t.Fatal("job execution not persisted")
+	}
+
+	entries, err := readRequestLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatal("unexpected entries", len(entries))
+	}
+
+	msg, err := decodeMessage(entries[0].Msg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Operation != OperationJobExecute || msg.Token != "" {
+		t.Error("unexpected persisted message:", msg.Operation, msg.Token)
+	}
+}
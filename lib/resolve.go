@@ -0,0 +1,87 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net"
+	"strings"
+)
+
+// lookupHost resolves a hostname into its addresses. It exists to allow for testing without a DNS server.
+var lookupHost = net.LookupHost
+
+// resolveAddr resolves the host of addr, which may include a port, into an IP. Addresses with an IP as host are
+// returned unchanged. The returned address keeps the port, if any.
+func resolveAddr(addr string) (resolved string, ip net.IP, err error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, ""
+	}
+
+	ip = net.ParseIP(host)
+	if ip == nil {
+		addrs, err := lookupHost(host)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if len(addrs) == 0 {
+			return "", nil, errors.New("no addresses found for host " + host)
+		}
+
+		ip = net.ParseIP(addrs[0])
+		if ip == nil {
+			return "", nil, errors.New("invalid address found for host " + host + ": " + addrs[0])
+		}
+	}
+
+	if port == "" {
+		return ip.String(), ip, nil
+	}
+
+	return net.JoinHostPort(ip.String(), port), ip, nil
+}
+
+// resolveWhitelist replaces the hostnames in the whitelist with their addresses. IPs and entries with wildcards are
+// kept unchanged, and hostnames that can't be resolved are dropped with a warning on log.
+func resolveWhitelist(wl []string, log *logrus.Logger) []string {
+	var resolved []string
+	for _, entry := range wl {
+		if strings.Contains(entry, "*") || net.ParseIP(entry) != nil {
+			resolved = append(resolved, entry)
+			continue
+		}
+
+		addrs, err := lookupHost(entry)
+		if err != nil {
+			log.Warnln("Unable to resolve whitelisted host", entry+":", err)
+			continue
+		}
+
+		resolved = append(resolved, addrs...)
+	}
+
+	return resolved
+}
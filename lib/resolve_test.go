@@ -0,0 +1,131 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// mockLookupHost replaces lookupHost with a resolver backed by hosts until the returned function is called.
+func mockLookupHost(hosts map[string][]string) func() {
+	original := lookupHost
+	lookupHost = func(host string) ([]string, error) {
+		addrs, ok := hosts[host]
+		if !ok {
+			return nil, errors.New("no such host")
+		}
+
+		return addrs, nil
+	}
+
+	return func() {
+		lookupHost = original
+	}
+}
+
+func TestResolveAddr(t *testing.T) {
+	defer mockLookupHost(map[string][]string{"worker-1.internal": {"192.168.1.1", "192.168.1.5"}})()
+
+	cases := []struct {
+		addr   string
+		expect string
+	}{
+		{"192.168.1.2", "192.168.1.2"},
+		{"192.168.1.2:2020", "192.168.1.2:2020"},
+		{"worker-1.internal", "192.168.1.1"},
+		{"worker-1.internal:2020", "192.168.1.1:2020"},
+	}
+
+	for _, c := range cases {
+		resolved, _, err := resolveAddr(c.addr)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if resolved != c.expect {
+			t.Error("expected", c.expect, "got", resolved)
+		}
+	}
+
+	_, _, err := resolveAddr("unknown.internal")
+	if err == nil {
+		t.Error("unknown host resolved")
+	}
+}
+
+func TestResolveWhitelist(t *testing.T) {
+	defer mockLookupHost(map[string][]string{"worker-1.internal": {"192.168.1.1"}})()
+
+	resolved := resolveWhitelist([]string{"192.168.*", "10.0.0.1", "worker-1.internal", "unknown.internal"}, logger)
+	expect := []string{"192.168.*", "10.0.0.1", "192.168.1.1"}
+
+	if !cmp.Equal(resolved, expect) {
+		t.Error("unexpected whitelist:", cmp.Diff(resolved, expect))
+	}
+}
+
+func TestServer_ConnectHostname(t *testing.T) {
+	defer mockLookupHost(map[string][]string{"worker-1.internal": {"192.168.1.1"}})()
+
+	s, receiveChan, sendChan := startPrimaryTestChannels()
+
+	type result struct {
+		node Node
+		err  error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		node, err := s.Connect("worker-1.internal", time.Second)
+		done <- result{node, err}
+	}()
+
+	select {
+	case <-sendChan:
+	case <-time.After(time.Second):
+		t.Error("status not sent")
+		return
+	}
+
+	time.Sleep(time.Millisecond * 100) // Let the awaitable register
+
+	msg := getTestMessage()
+	msg.Token = s.Config.Token
+	receiveChan <- Request{Msg: msg, Conn: Conn{}}
+
+	res := <-done
+	if res.err != nil {
+		t.Error(res.err)
+		return
+	}
+
+	if !res.node.Addr.IP.Equal(net.ParseIP("192.168.1.1")) {
+		t.Error("unexpected node address:", res.node.Addr)
+	}
+}
@@ -23,25 +23,79 @@
 package beekeeper
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+// resultFileMagic is the first byte sent by a job when the Result is passed through a file instead of inline. The
+// rest of the line holds the path to the file.
+const resultFileMagic = 'F'
+
+// resultDirPrefix and resultFileName name the temporary folder and file a job writes a file-backed Result to. Only
+// files named this way are removed once read, so that a job can't get other files deleted.
+const (
+	resultDirPrefix = "beekeeper_result_"
+	resultFileName  = "result"
+)
+
+// maxInlineResultSizeEnv is the environment variable used to pass Config.MaxInlineResultSize to the jobs.
+const maxInlineResultSizeEnv = "BEEKEEPER_MAX_INLINE_RESULT_SIZE"
+
+// defaultMaxInlineResultSize is the default value for Config.MaxInlineResultSize.
+const defaultMaxInlineResultSize = (1 << 20) * 64 // 64 MB
+
 // Result holds the details from a job execution.
 type Result struct {
 	UUID  string
 	Task  Task
 	Error string
+
+	// RemoteError holds the structured details of Error. It's nil for successful results and for results sent by
+	// older nodes.
+	RemoteError *RemoteError
 }
 
 // newErrorResult creates an empty Result with Error set to err.
 func newErrorResult(err error) Result {
+	return newRemoteErrorResult(newRemoteError(err))
+}
+
+// newRemoteErrorResult creates an empty Result with Error and RemoteError set to err.
+func newRemoteErrorResult(err *RemoteError) Result {
 	return Result{
-		Error: err.Error(),
+		Error:       err.Message,
+		RemoteError: err,
+	}
+}
+
+// ErrorCode returns the ErrorCode of the Result. For results sent by older nodes the code is parsed from Error.
+func (r Result) ErrorCode() ErrorCode {
+	if r.RemoteError != nil {
+		return r.RemoteError.Code
+	}
+
+	return parseErrorCode(r.Error)
+}
+
+// remoteError returns the error of the Result as a RemoteError, or nil if there was no error.
+func (r Result) remoteError() *RemoteError {
+	if r.Error == "" && r.RemoteError == nil {
+		return nil
 	}
+
+	if r.RemoteError != nil {
+		return r.RemoteError
+	}
+
+	return &RemoteError{Code: parseErrorCode(r.Error), Message: r.Error}
 }
 
 // encode returns a gob encoded byte slice representing the Result.
@@ -95,3 +149,144 @@ func (r Result) printEncode(output ...io.Writer) {
 
 	_, _ = fmt.Fprint(out, string(data))
 }
+
+// printEncodeToFile encodes the Result and prints it as with printEncode if it's no bigger than maxInline. Bigger
+// Results are written to a file inside a new temporary folder in dir, and the file path is printed after
+// resultFileMagic.
+func (r Result) printEncodeToFile(dir string, maxInline uint64, output ...io.Writer) {
+	var out io.Writer
+	if len(output) > 0 {
+		out = output[0]
+	} else {
+		out = io.Writer(os.Stdout)
+	}
+
+	spill := &resultSpill{dir: dir, maxInline: maxInline}
+
+	err := gob.NewEncoder(spill).Encode(r)
+	if closeErr := spill.close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		spill.remove()
+		newErrorResult(err).printEncode(out)
+		return
+	}
+
+	if spill.file == nil {
+		header := []byte(fmt.Sprintf("%d\n", spill.buf.Len()))
+		_, _ = fmt.Fprint(out, string(append(header, spill.buf.Bytes()...)))
+
+		return
+	}
+
+	_, _ = fmt.Fprintf(out, "%c%s\n", resultFileMagic, spill.file.Name())
+}
+
+// resultSpill is an io.Writer that keeps the data in memory until it grows bigger than maxInline, and then moves it to
+// a file inside a new temporary folder in dir.
+type resultSpill struct {
+	dir       string
+	maxInline uint64
+
+	buf  bytes.Buffer
+	file *os.File
+}
+
+// Write writes p to memory, or to the file once the data doesn't fit in maxInline bytes.
+func (w *resultSpill) Write(p []byte) (int, error) {
+	if w.file == nil && uint64(w.buf.Len()+len(p)) <= w.maxInline {
+		return w.buf.Write(p)
+	}
+
+	if w.file == nil {
+		folder, err := ioutil.TempDir(w.dir, resultDirPrefix)
+		if err != nil {
+			return 0, err
+		}
+
+		w.file, err = os.Create(filepath.Join(folder, resultFileName))
+		if err != nil {
+			_ = os.Remove(folder)
+			return 0, err
+		}
+
+		_, err = w.buf.WriteTo(w.file)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return w.file.Write(p)
+}
+
+// close closes the file, if any.
+func (w *resultSpill) close() error {
+	if w.file == nil {
+		return nil
+	}
+
+	return w.file.Close()
+}
+
+// remove deletes the file and its folder, if any.
+func (w *resultSpill) remove() {
+	if w.file != nil {
+		removeResultFile(w.file.Name())
+	}
+}
+
+// isResultFile reports whether the path names a file created by printEncodeToFile.
+func isResultFile(path string) bool {
+	folder := filepath.Base(filepath.Dir(path))
+
+	return filepath.Base(path) == resultFileName && strings.HasPrefix(folder, resultDirPrefix)
+}
+
+// removeResultFile deletes a file created by printEncodeToFile and its folder.
+func removeResultFile(path string) {
+	_ = os.Remove(path)
+	_ = os.Remove(filepath.Dir(path))
+}
+
+// readResultFile reads a file-backed Result announced with resultFileMagic and decodes it. The file is removed if it
+// was created by printEncodeToFile, other paths are left untouched. Files bigger than maxSize bytes are rejected with
+// ErrOutputTooLarge, unless maxSize is 0.
+func readResultFile(reader *bufio.Reader, maxSize uint64) (Result, error) {
+	line, _, err := reader.ReadLine()
+	if err != nil {
+		return Result{}, errors.New("error reading result path: " + err.Error())
+	}
+
+	path := string(line[1:])
+	if isResultFile(path) {
+		defer removeResultFile(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Result{}, errors.New("unable to open result file: " + err.Error())
+	}
+
+	defer f.Close()
+
+	if maxSize > 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return Result{}, errors.New("unable to read result file: " + err.Error())
+		}
+
+		if uint64(info.Size()) > maxSize {
+			return Result{}, fmt.Errorf("%w: %d bytes", ErrOutputTooLarge, info.Size())
+		}
+	}
+
+	res := Result{}
+	err = gob.NewDecoder(bufio.NewReader(f)).Decode(&res)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return res, nil
+}
@@ -23,9 +23,15 @@
 package beekeeper
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/gob"
 	"errors"
+	"fmt"
 	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -40,7 +46,7 @@ func TestResult(t *testing.T) {
 		t.Fail()
 	}
 
-	splits := bytes.Split(out.Bytes(), []byte("\n"))
+	splits := bytes.SplitN(out.Bytes(), []byte("\n"), 2)
 	if len(splits) != 2 {
 		t.Error("unable to split header and body with split length", len(splits))
 		return
@@ -57,3 +63,66 @@ func TestResult(t *testing.T) {
 		return
 	}
 }
+
+func TestResultToFile(t *testing.T) {
+	result := Result{UUID: "test", Task: NewTask()}
+	result.Task.Returns["data"] = strings.Repeat("x", 1024)
+
+	// Inline
+	out := &bytes.Buffer{}
+	result.printEncodeToFile("", 1<<20, out)
+
+	if out.Bytes()[0] == resultFileMagic {
+		t.Error("small result sent through a file")
+		return
+	}
+
+	// File-backed
+	out = &bytes.Buffer{}
+	result.printEncodeToFile("", 10, out)
+
+	if out.Bytes()[0] != resultFileMagic {
+		t.Error("big result sent inline")
+		return
+	}
+
+	path := strings.TrimSpace(out.String()[1:])
+
+	result2, err := readResultFile(bufio.NewReader(out), 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if doesPathExists(path) || doesPathExists(filepath.Dir(path)) {
+		t.Error("result file not removed")
+	}
+
+	if !cmp.Equal(result, result2) {
+		t.Error("non matching results", cmp.Diff(result, result2))
+		return
+	}
+}
+
+func TestReadResultFile_foreignPath(t *testing.T) {
+	f, err := ioutil.TempFile("", "beekeeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	err = gob.NewEncoder(f).Encode(Result{UUID: "test"})
+	_ = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readResultFile(bufio.NewReader(strings.NewReader(fmt.Sprintf("%c%s\n", resultFileMagic, f.Name()))), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !doesPathExists(f.Name()) {
+		t.Error("file not created by the job wrapper removed")
+	}
+}
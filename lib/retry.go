@@ -0,0 +1,135 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// RetryPolicy sets how ExecuteWithRetry retries a task. The wait before the nth retry is InitialDelay multiplied by
+// Multiplier n-1 times, up to MaxDelay.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum amount of times the task is run, including the first one. Values below 1 mean a
+	// single attempt.
+	MaxAttempts int
+
+	// InitialDelay is the wait before the first retry.
+	InitialDelay time.Duration
+
+	// Multiplier is the factor the wait grows by on every retry. Values below 1 keep the wait constant.
+	Multiplier float64
+
+	// MaxDelay is the maximum wait between attempts. A value of 0 means no maximum.
+	MaxDelay time.Duration
+}
+
+// delay returns the wait before the given retry, starting at 1.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(p.InitialDelay)
+	for i := 1; i < retry; i++ {
+		delay *= multiplier
+		if p.MaxDelay > 0 && delay >= float64(p.MaxDelay) {
+			return p.MaxDelay
+		}
+	}
+
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+
+	return time.Duration(delay)
+}
+
+// RetryExhaustedError is produced when a task failed with a retryable error on every attempt allowed by the
+// RetryPolicy. It wraps the error of the last attempt.
+type RetryExhaustedError struct {
+	// Attempts is the amount of times the task was run.
+	Attempts int
+
+	// Err is the error of the last attempt.
+	Err error
+}
+
+// Error describes the last error and the amount of attempts.
+func (e *RetryExhaustedError) Error() string {
+	return fmt.Sprintf("task failed after %d attempts: %s", e.Attempts, e.Err)
+}
+
+// Unwrap returns the error of the last attempt.
+func (e *RetryExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+// ExecuteWithRetry runs the task on the node like Execute, retrying it following the policy when it fails with a
+// retryable error: a disconnection, a busy node or a network error. Other errors, including timeouts, are returned
+// right away, as the task may still be running. If every attempt fails a *RetryExhaustedError is returned. The task's
+// OnComplete or OnError callback is only called with the final outcome. An optional timeout parameter can be provided,
+// which applies to every attempt.
+func (s *Server) ExecuteWithRetry(n Node, t Task, policy RetryPolicy, timeout ...time.Duration) (res Result,
+	err error) {
+	defer func() {
+		t.complete(res, err)
+	}()
+
+	attempt := t
+	attempt.OnComplete, attempt.OnError = nil, nil
+
+	for i := 1; ; i++ {
+		res, err = s.Execute(n, attempt, timeout...)
+		if err == nil || !isRetryable(err) {
+			return res, err
+		}
+
+		if i >= policy.MaxAttempts {
+			return Result{}, &RetryExhaustedError{Attempts: i, Err: err}
+		}
+
+		delay := policy.delay(i)
+		s.log().Warnln("Task failed on node", n.Name+", retrying in", delay.String()+":", err)
+
+		time.Sleep(delay)
+	}
+}
+
+// isRetryable reports if a task that failed with err may succeed if run again.
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrNodeDisconnected) || errors.Is(err, ErrBackoffActive) {
+		return true
+	}
+
+	var remoteErr *RemoteError
+	if errors.As(err, &remoteErr) {
+		return remoteErr.Code == ErrorCodeNodeDisconnected || remoteErr.Code == ErrorCodeNodeBusy
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
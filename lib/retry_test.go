@@ -0,0 +1,155 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_delay(t *testing.T) {
+	p := RetryPolicy{InitialDelay: time.Second, Multiplier: 2, MaxDelay: time.Second * 5}
+
+	for retry, expected := range map[int]time.Duration{
+		1: time.Second,
+		2: time.Second * 2,
+		3: time.Second * 4,
+		4: time.Second * 5,
+		9: time.Second * 5,
+	} {
+		if delay := p.delay(retry); delay != expected {
+			t.Error("retry", retry, "expected", expected, "got", delay)
+		}
+	}
+
+	if delay := (RetryPolicy{InitialDelay: time.Second}).delay(3); delay != time.Second {
+		t.Error("expected a constant delay, got", delay)
+	}
+}
+
+// newRetryTestServer creates a server whose sends fail the first failures times. Successful sends are answered with
+// a Result.
+func newRetryTestServer(failures int32) (*Server, *int32) {
+	config := NewDefaultConfig()
+	config.DisableConnectionWatchdog = true
+
+	s := NewServer(config)
+
+	var sends int32
+	_ = s.SetSendCallback(func(s *Server, _ *Conn, m Message) error {
+		if atomic.AddInt32(&sends, 1) <= failures {
+			return &net.OpError{Op: "write", Net: "tcp", Err: errors.New("connection reset")}
+		}
+
+		task, err := decodeTask(m.Data)
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			time.Sleep(time.Millisecond * 100) // Let the awaitables register
+
+			response, _ := newMessage().setData(Result{UUID: task.UUID})
+			response.Operation = OperationJobResult
+			s.checkAwaited(response)
+		}()
+
+		return nil
+	})
+
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	return s, &sends
+}
+
+func TestServer_ExecuteWithRetry(t *testing.T) {
+	s, sends := newRetryTestServer(2)
+	policy := RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond * 10, Multiplier: 2}
+
+	failed := make(chan error, 3)
+	task := NewTask()
+	task.OnError = func(err error) {
+		failed <- err
+	}
+
+	res, err := s.ExecuteWithRetry(getTestNodes()[0], task, policy, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.UUID == "" {
+		t.Error("empty result")
+	}
+
+	if atomic.LoadInt32(sends) != 3 {
+		t.Error("expected 3 attempts, got", atomic.LoadInt32(sends))
+	}
+
+	select {
+	case err := <-failed:
+		t.Error("OnError called for a retried attempt:", err)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestServer_ExecuteWithRetryExhausted(t *testing.T) {
+	s, sends := newRetryTestServer(5)
+	policy := RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond * 10}
+
+	_, err := s.ExecuteWithRetry(getTestNodes()[0], NewTask(), policy, time.Second)
+
+	var exhausted *RetryExhaustedError
+	if !errors.As(err, &exhausted) || exhausted.Attempts != 3 {
+		t.Fatal("expected a RetryExhaustedError after 3 attempts, got", err)
+	}
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) {
+		t.Error("last error not wrapped:", err)
+	}
+
+	if atomic.LoadInt32(sends) != 3 {
+		t.Error("expected 3 attempts, got", atomic.LoadInt32(sends))
+	}
+}
+
+func TestServer_ExecuteWithRetryNotRetryable(t *testing.T) {
+	s, sends := newRetryTestServer(0)
+
+	task := NewTask()
+	task.ResourceHints.RequiredOS = "plan9"
+
+	_, err := s.ExecuteWithRetry(getTestNodes()[0], task, RetryPolicy{MaxAttempts: 3})
+	if err != ErrNoCompatibleNode {
+		t.Error("expected ErrNoCompatibleNode, got", err)
+	}
+
+	if atomic.LoadInt32(sends) != 0 {
+		t.Error("task sent")
+	}
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoLinkLocalAddress is produced when scanning through an interface without an IPv6 link-local address
+var ErrNoLinkLocalAddress = errors.New("interface has no IPv6 link-local address")
+
+// neighborCommand lists the IPv6 neighbor cache of the interface. It's a variable to allow for testing.
+var neighborCommand = func(ifaceName string) ([]byte, error) {
+	return exec.Command("ip", "-6", "neigh", "show", "dev", ifaceName).Output()
+}
+
+// solicitNeighbors pings the all-nodes multicast address (ff02::1) through the interface, so the nodes that answer are
+// added to the neighbor cache. It's a variable to allow for testing.
+var solicitNeighbors = func(ifaceName string) error {
+	return exec.Command("ping", "-6", "-c", "1", "-w", "1", "ff02::1%"+ifaceName).Run()
+}
+
+// neighborSolicitInterval is the minimum time between two solicitations through the same interface. The watchdog
+// broadcasts far more often than the neighbor cache entries expire.
+const neighborSolicitInterval = time.Minute
+
+var (
+	solicitedAt     = make(map[string]time.Time)
+	solicitedAtLock sync.Mutex
+)
+
+// solicitNeighborsLimited calls solicitNeighbors unless the interface was solicited in the last
+// neighborSolicitInterval. It reports whether the interface was solicited. Failures are logged to log.
+func solicitNeighborsLimited(ifaceName string, log *logrus.Logger) bool {
+	solicitedAtLock.Lock()
+	if time.Since(solicitedAt[ifaceName]) < neighborSolicitInterval {
+		solicitedAtLock.Unlock()
+		return false
+	}
+
+	solicitedAt[ifaceName] = time.Now()
+	solicitedAtLock.Unlock()
+
+	err := solicitNeighbors(ifaceName)
+	if err != nil {
+		log.Debugln("Unable to solicit the neighbors of", ifaceName+":", err)
+	}
+
+	return true
+}
+
+// ScanIPv6 broadcasts a status Request to the IPv6 link-local neighbors of the interface and waits the provided amount
+// for a response. As the link-local /64 prefix is too big to be swept, the neighbors are taken from the system's
+// neighbor cache, as found by NDP, after soliciting them through the interface. Up to Config.BroadcastConcurrency
+// neighbors are dialed at once. Only Linux, where the ip command is available, is supported.
+func (s *Server) ScanIPv6(ifaceName string, waitTime time.Duration) (Nodes, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	hasLinkLocal, err := hasLinkLocalAddr(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasLinkLocal {
+		return nil, ErrNoLinkLocalAddress
+	}
+
+	solicitNeighborsLimited(ifaceName, s.log())
+
+	out, err := neighborCommand(ifaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	port := s.Config.OutboundPort
+	if port == 0 {
+		port = DefaultPort
+	}
+
+	var addrs []string
+	for _, ip := range parseNeighbors(out, net.IP.IsLinkLocalUnicast) {
+		addrs = append(addrs, net.JoinHostPort(ip.String()+"%"+ifaceName, strconv.Itoa(port)))
+	}
+
+	err = s.Multicast(addrs, Message{Operation: OperationStatus}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	time.Sleep(waitTime)
+
+	return s.Nodes(), nil
+}
+
+// hasLinkLocalAddr reports if the interface has an IPv6 link-local address.
+func hasLinkLocalAddr(iface *net.Interface) (bool, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false, err
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if ok && ipNet.IP.To4() == nil && ipNet.IP.IsLinkLocalUnicast() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// parseNeighbors reads the output of "ip -6 neigh" and returns the addresses of the reachable neighbors accepted by
+// keep. Entries that failed resolution are skipped.
+func parseNeighbors(out []byte, keep func(net.IP) bool) []net.IP {
+	var ips []net.IP
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		// fe80::1 lladdr 00:11:22:33:44:55 router REACHABLE
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		state := fields[len(fields)-1]
+		if state == "FAILED" || state == "INCOMPLETE" {
+			continue
+		}
+
+		ip := net.ParseIP(fields[0])
+		if ip == nil || ip.To4() != nil || !keep(ip) {
+			continue
+		}
+
+		ips = append(ips, ip)
+	}
+
+	return ips
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseNeighbors(t *testing.T) {
+	out := []byte("fe80::1 lladdr 00:11:22:33:44:55 router REACHABLE\n" +
+		"fe80::2 lladdr 00:11:22:33:44:56 STALE\n" +
+		"fe80::3 FAILED\n" +
+		"fe80::4 INCOMPLETE\n" +
+		"2001:db8::1 lladdr 00:11:22:33:44:57 REACHABLE\n")
+
+	ips := parseNeighbors(out, net.IP.IsLinkLocalUnicast)
+	if len(ips) != 2 {
+		t.Error("unexpected neighbors", ips)
+		return
+	}
+
+	if ips[0].String() != "fe80::1" || ips[1].String() != "fe80::2" {
+		t.Error("unexpected neighbors", ips)
+	}
+}
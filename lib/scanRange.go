@@ -0,0 +1,245 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidRange is produced when scanning a range whose start comes after its end, or whose ends are of different IP
+// versions
+var ErrInvalidRange = errors.New("invalid IP range")
+
+// maxScanRangeSize is the maximum amount of addresses scanned by ScanRange.
+const maxScanRangeSize = 1 << 16
+
+// ScanRange sends a status Request to every IP from startIP to endIP, both inclusive, and waits the provided amount for
+// a response. The nodes in the range that responded are returned. Both IPv4 and IPv6 ranges are supported, of up to
+// 65536 addresses. Up to Config.ScanConcurrency IPs are dialed at once.
+func (s *Server) ScanRange(startIP, endIP net.IP, waitTime time.Duration) (Nodes, error) {
+	start, end, err := normalizeRange(startIP, endIP)
+	if err != nil {
+		return nil, err
+	}
+
+	port := s.Config.OutboundPort
+	if port == 0 {
+		port = DefaultPort
+	}
+
+	var addrs []string
+	for ip := start; bytes.Compare(ip, end) <= 0; ip = nextIP(ip) {
+		if len(addrs) >= maxScanRangeSize {
+			return nil, fmt.Errorf("%w: more than %d addresses", ErrInvalidRange, maxScanRangeSize)
+		}
+
+		addrs = append(addrs, net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+
+		if ip.Equal(end) {
+			break // Avoid wrapping around at the end of the address space
+		}
+	}
+
+	err = s.multicast(addrs, Message{Operation: OperationStatus}, false, s.Config.ScanConcurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	time.Sleep(waitTime)
+
+	var found Nodes
+	for _, n := range s.Nodes() {
+		if n.Addr == nil {
+			continue
+		}
+
+		ip := n.Addr.IP.To16()
+		if len(start) == net.IPv4len {
+			ip = n.Addr.IP.To4()
+		}
+
+		if ip != nil && bytes.Compare(ip, start) >= 0 && bytes.Compare(ip, end) <= 0 {
+			found = append(found, n)
+		}
+	}
+
+	return found, nil
+}
+
+// ScanCIDR scans every host address of the subnet in CIDR notation, like 10.1.0.0/16, the same way as ScanRange. The
+// network and broadcast addresses of IPv4 subnets are skipped. It allows finding nodes outside the local /24, like in
+// other VLANs. As with ScanRange, subnets of more than 65536 addresses, like an IPv4 /15 or an IPv6 /111, are
+// rejected with ErrInvalidRange.
+func (s *Server) ScanCIDR(cidr string, waitTime time.Duration) (Nodes, error) {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRange, err)
+	}
+
+	start, end := subnet.IP, lastIP(subnet)
+
+	ones, bits := subnet.Mask.Size()
+	if bits == 8*net.IPv4len && bits-ones > 1 {
+		start, end = nextIP(start), prevIP(end)
+	}
+
+	return s.ScanRange(start, end, waitTime)
+}
+
+// ScanIPs sends a status Request to every IP of the static list and waits the provided amount for a response. The
+// nodes of the list that responded are returned. The IPs can include a port, and the outbound port is used otherwise.
+// IPv6 link-local addresses can include their zone, like fe80::1%eth0 or [fe80::1%eth0]:2020. Up to
+// Config.ScanConcurrency IPs are dialed at once.
+func (s *Server) ScanIPs(ips []string, waitTime time.Duration) (Nodes, error) {
+	port := s.Config.OutboundPort
+	if port == 0 {
+		port = DefaultPort
+	}
+
+	addrs := make([]string, len(ips))
+	wanted := make([]net.IP, len(ips))
+	for i, ip := range ips {
+		host, hostPort := ip, strconv.Itoa(port)
+		if parseZonedIP(ip) == nil {
+			var err error
+			host, hostPort, err = net.SplitHostPort(ip)
+			if err != nil {
+				return nil, fmt.Errorf("invalid IP %q: %w", ip, err)
+			}
+		}
+
+		wanted[i] = parseZonedIP(host)
+		if wanted[i] == nil {
+			return nil, fmt.Errorf("invalid IP %q", ip)
+		}
+
+		addrs[i] = net.JoinHostPort(host, hostPort)
+	}
+
+	err := s.multicast(addrs, Message{Operation: OperationStatus}, false, s.Config.ScanConcurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	time.Sleep(waitTime)
+
+	var found Nodes
+	for _, n := range s.Nodes() {
+		if n.Addr == nil {
+			continue
+		}
+
+		for _, ip := range wanted {
+			if n.Addr.IP.Equal(ip) {
+				found = append(found, n)
+				break
+			}
+		}
+	}
+
+	return found, nil
+}
+
+// parseZonedIP parses an IP, ignoring the zone of IPv6 addresses like fe80::1%eth0. It returns nil if the IP is
+// invalid, or if an IPv4 address has a zone.
+func parseZonedIP(s string) net.IP {
+	i := strings.LastIndex(s, "%")
+	if i < 0 {
+		return net.ParseIP(s)
+	}
+
+	ip := net.ParseIP(s[:i])
+	if ip == nil || ip.To4() != nil || i == len(s)-1 {
+		return nil
+	}
+
+	return ip
+}
+
+// normalizeRange converts both ends of the range to the same length, 4 bytes for IPv4 and 16 for IPv6, so they can be
+// compared. ErrInvalidRange is returned if they are of different versions or startIP comes after endIP.
+func normalizeRange(startIP, endIP net.IP) (net.IP, net.IP, error) {
+	start, end := startIP.To4(), endIP.To4()
+	if start == nil || end == nil {
+		if start != nil || end != nil {
+			return nil, nil, fmt.Errorf("%w: mixed IPv4 and IPv6 ends", ErrInvalidRange)
+		}
+
+		start, end = startIP.To16(), endIP.To16()
+		if start == nil || end == nil {
+			return nil, nil, fmt.Errorf("%w: malformed IP", ErrInvalidRange)
+		}
+	}
+
+	if bytes.Compare(start, end) > 0 {
+		return nil, nil, fmt.Errorf("%w: %s comes after %s", ErrInvalidRange, startIP, endIP)
+	}
+
+	return start, end, nil
+}
+
+// nextIP returns the IP following ip. The original is not modified.
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+
+	return next
+}
+
+// prevIP returns the IP preceding ip. The original is not modified.
+func prevIP(ip net.IP) net.IP {
+	prev := make(net.IP, len(ip))
+	copy(prev, ip)
+
+	for i := len(prev) - 1; i >= 0; i-- {
+		prev[i]--
+		if prev[i] != 0xff {
+			break
+		}
+	}
+
+	return prev
+}
+
+// lastIP returns the last address of the subnet.
+func lastIP(subnet *net.IPNet) net.IP {
+	last := make(net.IP, len(subnet.IP))
+	for i := range subnet.IP {
+		last[i] = subnet.IP[i] | ^subnet.Mask[i]
+	}
+
+	return last
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServer_ScanRange(t *testing.T) {
+	s, _, sendChan := startPrimaryTestChannels()
+
+	for _, n := range getTestNodes() {
+		s.updateNode(n)
+	}
+
+	nodes, err := s.ScanRange(net.ParseIP("192.168.1.2"), net.ParseIP("192.168.1.3"), time.Millisecond*100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for sent := 0; sent < 2; sent++ {
+		select {
+		case <-sendChan:
+		case <-time.After(time.Second):
+			t.Error("status not sent")
+			return
+		}
+	}
+
+	if len(nodes) != 2 {
+		t.Error("unexpected nodes:", nodes)
+	}
+}
+
+func TestServer_ScanRangeInvalid(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	_, err := s.ScanRange(net.ParseIP("10.0.0.75"), net.ParseIP("10.0.0.50"), 0)
+	if !errors.Is(err, ErrInvalidRange) {
+		t.Error("expected ErrInvalidRange, got", err)
+	}
+
+	_, err = s.ScanRange(net.ParseIP("10.0.0.1"), net.ParseIP("fe80::1"), 0)
+	if !errors.Is(err, ErrInvalidRange) {
+		t.Error("expected ErrInvalidRange, got", err)
+	}
+}
+
+// startScanTestServer starts a server whose send callback answers the status requests sent to the responding IPs
+// like a node would, and calls onSend for every message sent. Its connections report the IP they were dialed with.
+func startScanTestServer(config Config, onSend func(), responding ...string) *Server {
+	config.DisableConnectionWatchdog = true
+	s := NewServer(config)
+
+	_ = s.SetServerCallback(func(*Server) error {
+		return nil
+	})
+	_ = s.SetConnCallback(func(_ *Server, addr string, _ ...time.Duration) (*Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+
+		return &Conn{stream: &testAddrConn{ip: strings.Split(host, "%")[0]}}, nil
+	})
+	_ = s.SetSendCallback(func(_ *Server, c *Conn, m Message) error {
+		if onSend != nil {
+			onSend()
+		}
+
+		ip := c.RemoteAddr().(*net.IPAddr).IP
+		for _, r := range responding {
+			if m.Operation != OperationStatus || !ip.Equal(net.ParseIP(r)) {
+				continue
+			}
+
+			go func() {
+				msg := newMessage()
+				msg.Name = "node " + ip.String()
+				msg.Status = StatusIDLE
+				msg.Addr = &net.TCPAddr{IP: ip, Port: DefaultPort}
+				msg.Token = s.Config.Token
+				s.queue <- Request{Msg: msg, Conn: Conn{}}
+			}()
+		}
+
+		return nil
+	})
+
+	go func() {
+		_ = s.Start()
+	}()
+
+	return s
+}
+
+func TestServer_ScanCIDR(t *testing.T) {
+	var lock sync.Mutex
+	sent := 0
+
+	s := startScanTestServer(NewDefaultConfig(), func() {
+		lock.Lock()
+		sent++
+		lock.Unlock()
+	}, "192.168.1.1", "192.168.1.2", "192.168.1.4")
+	defer s.Stop()
+
+	nodes, err := s.ScanCIDR("192.168.1.0/30", time.Millisecond*100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	lock.Lock()
+	if sent != 2 {
+		t.Error("status not sent to the 2 host addresses, sent", sent)
+	}
+	lock.Unlock()
+
+	// 192.168.1.4 answers too, but is outside the subnet
+	if len(nodes) != 2 {
+		t.Error("unexpected nodes:", nodes)
+	}
+
+	_, err = s.ScanCIDR("192.168.1.0", 0)
+	if !errors.Is(err, ErrInvalidRange) {
+		t.Error("expected ErrInvalidRange, got", err)
+	}
+
+	_, err = s.ScanCIDR("10.0.0.0/15", 0)
+	if !errors.Is(err, ErrInvalidRange) {
+		t.Error("expected ErrInvalidRange, got", err)
+	}
+}
+
+func TestServer_ScanIPs(t *testing.T) {
+	config := NewDefaultConfig()
+	config.ScanConcurrency = 1
+
+	var lock sync.Mutex
+	active, maxActive := 0, 0
+
+	s := startScanTestServer(config, func() {
+		lock.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		lock.Unlock()
+
+		time.Sleep(time.Millisecond * 10)
+
+		lock.Lock()
+		active--
+		lock.Unlock()
+	}, "192.168.1.1", "192.168.1.4", "192.168.1.5", "fe80::1")
+	defer s.Stop()
+
+	var dialed []string
+	connCallback := s.connCallback
+	_ = s.SetConnCallback(func(s *Server, addr string, timeout ...time.Duration) (*Conn, error) {
+		lock.Lock()
+		dialed = append(dialed, addr)
+		lock.Unlock()
+
+		return connCallback(s, addr, timeout...)
+	})
+
+	nodes, err := s.ScanIPs([]string{"192.168.1.1", "192.168.1.4:3030", "10.0.0.1", "fe80::1%eth0"},
+		time.Millisecond*100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 192.168.1.5 answers too, but isn't in the list
+	if len(nodes) != 3 {
+		t.Error("unexpected nodes:", nodes)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	sort.Strings(dialed)
+	expected := []string{"10.0.0.1:2020", "192.168.1.1:2020", "192.168.1.4:3030", "[fe80::1%eth0]:2020"}
+	if !cmp.Equal(dialed, expected) {
+		t.Errorf("expected dials %v, got %v", expected, dialed)
+	}
+
+	if maxActive != 1 {
+		t.Error("ScanConcurrency not respected, max active:", maxActive)
+	}
+
+	for _, ip := range []string{"not an ip", "10.0.0.1%eth0", "fe80::1%"} {
+		_, err = s.ScanIPs([]string{ip}, 0)
+		if err == nil {
+			t.Error("expected error for", ip)
+		}
+	}
+}
+
+func TestLastIP(t *testing.T) {
+	for cidr, expect := range map[string]string{"10.1.0.0/16": "10.1.255.255", "fd00::/120": "fd00::ff"} {
+		_, subnet, _ := net.ParseCIDR(cidr)
+		if last := lastIP(subnet); last.String() != expect {
+			t.Errorf("expected %s, got %s", expect, last)
+		}
+	}
+}
+
+func TestNextIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		expect string
+	}{
+		{"10.0.0.255", "10.0.1.0"},
+		{"fe80::ffff", "fe80::1:0"},
+	}
+
+	for _, test := range tests {
+		ip := net.ParseIP(test.ip)
+		if ip.To4() != nil {
+			ip = ip.To4()
+		}
+
+		if next := nextIP(ip); next.String() != test.expect {
+			t.Errorf("expected %s, got %s", test.expect, next)
+		}
+	}
+}
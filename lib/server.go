@@ -23,8 +23,11 @@
 package beekeeper
 
 import (
+	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
 	"strings"
@@ -35,7 +38,7 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// logger is a logrus logger
+// logger is the logrus logger used while creating a Server, and by the servers not created by NewServer.
 var logger = logrus.New()
 
 // privateIPBlocksStr contains a list of local-only IP blocks as CIDR IPNets
@@ -55,7 +58,11 @@ type Server struct {
 	// nodes keeps a list of active node connections to this server.
 	nodes Nodes
 
-	// nodesLock is a RWMutex over nodes.
+	// clearedNodes holds the nodes known before the last call to clearNodes, to tell the nodes that respond again apart
+	// from new ones.
+	clearedNodes Nodes
+
+	// nodesLock is a RWMutex over nodes and clearedNodes.
 	nodesLock sync.RWMutex
 
 	// queue is a chan with the incoming Requests in queue to be processed.
@@ -75,8 +82,159 @@ type Server struct {
 
 	// awaitedLock is a Mutex lock over awaited.
 	awaitedLock sync.Mutex
+
+	// nodeCallbacks holds the callbacks to be notified on every node update, like the ones used by streaming scans.
+	nodeCallbacks []*nodeCallback
+
+	// nodeCallbacksLock is a Mutex lock over nodeCallbacks.
+	nodeCallbacksLock sync.Mutex
+
+	// statusCallbacks holds the callbacks registered with OnNodeStatusChange.
+	statusCallbacks []func(Node, Status, Status)
+
+	// statusCallbacksLock is a Mutex lock over statusCallbacks.
+	statusCallbacksLock sync.Mutex
+
+	// nodeErrors keeps the most recent errors reported by every node, indexed by IP.
+	nodeErrors map[string][]string
+
+	// nodeErrorsLock is a RWMutex over nodeErrors.
+	nodeErrorsLock sync.RWMutex
+
+	// loadBalancer is the LoadBalancer used by ExecuteBalanced. It's created as needed, and is nil before that.
+	loadBalancer *LoadBalancer
+
+	// loadBalancerNodes is the node list used to create loadBalancer.
+	loadBalancerNodes Nodes
+
+	// loadBalancerStrategy is the Strategy used by loadBalancer.
+	loadBalancerStrategy Strategy
+
+	// loadBalancerLock is a Mutex lock over loadBalancer, loadBalancerNodes and loadBalancerStrategy.
+	loadBalancerLock sync.Mutex
+
+	// history keeps the latest task executions made by the server.
+	history ExecutionHistory
+
+	// historyLock is a RWMutex over history.
+	historyLock sync.RWMutex
+
+	// workerHistory keeps the latest tasks run by this node, to be sent to primary nodes that query them.
+	workerHistory []ExecutionSummary
+
+	// workerHistoryLock is a RWMutex over workerHistory.
+	workerHistoryLock sync.RWMutex
+
+	// lastPrimaryMsg is the last Message received from a primary node. It's used to send heartbeats.
+	lastPrimaryMsg *Message
+
+	// lastPrimaryMsgLock is a Mutex lock over lastPrimaryMsg.
+	lastPrimaryMsgLock sync.Mutex
+
+	// listener is the listener the server accepts connections on. It's set by serverCallback.
+	listener net.Listener
+
+	// listenerLock is a RWMutex over listener.
+	listenerLock sync.RWMutex
+
+	// backoffState holds the connection backoff of every node that failed to be dialed, keyed by IP. It's created
+	// when the first dial fails.
+	backoffState map[string]*ExponentialBackoff
+
+	// backoffStateLock is a Mutex lock over backoffState.
+	backoffStateLock sync.Mutex
+
+	// whitelist is Config.Whitelist with its hostnames resolved. It's set by Start.
+	whitelist []string
+
+	// pendingTasks holds the nodes running the tasks awaited by Execute, keyed by task UUID.
+	pendingTasks map[string]Node
+
+	// pendingTasksLock is a Mutex lock over pendingTasks.
+	pendingTasksLock sync.Mutex
+
+	// runningTasks holds the cancel functions of the tasks run by this node, keyed by task UUID.
+	runningTasks map[string]context.CancelFunc
+
+	// runningTasksLock is a Mutex lock over runningTasks.
+	runningTasksLock sync.Mutex
+
+	// taskSlots is a semaphore limiting the tasks run at once to Config.WorkerConcurrency. It's nil if there's no
+	// limit.
+	taskSlots chan struct{}
+
+	// quiescing is set to 1 while the server is quiesced. It's accessed atomically. See Quiesce.
+	quiescing int32
+
+	// heldRequests holds the task requests received while quiesced, to be processed on Resume.
+	heldRequests []Request
+
+	// heldRequestsLock is a Mutex lock over heldRequests.
+	heldRequestsLock sync.Mutex
+
+	// recording is the encoder messages are recorded to. It's nil unless recording. See StartRecording.
+	recording *json.Encoder
+
+	// recordingLock is a Mutex lock over recording.
+	recordingLock sync.Mutex
+
+	// stopOnce makes sure that the server is stopped only once.
+	stopOnce sync.Once
+
+	// requestLog is the persistent queue of received messages. It's nil if Config.PersistentQueuePath is not set.
+	requestLog *requestLog
+
+	// replay holds the messages left unprocessed by a previous run, to be queued once the server is started.
+	replay []requestLogEntry
+
+	// replayLock is a Mutex lock over replay.
+	replayLock sync.Mutex
+
+	// otherInstanceDetected is set if another process was listening on the inbound port when the server was created.
+	otherInstanceDetected bool
+
+	// started is set once Start is called.
+	started bool
+
+	// startedLock is a Mutex lock over started.
+	startedLock sync.Mutex
+
+	// cachedTLS is set if the TLS certificate is the self-signed one kept in the home directory cache, rather than one
+	// supplied in the Config. Only that one is rotated automatically.
+	cachedTLS bool
+
+	// configErr is an error found in the Config by NewServer. It's returned by Start.
+	configErr error
+
+	// logger is the logger of the server, set with LogTo. The package logger is used if it's nil.
+	logger *logrus.Logger
+
+	// messageSizes is the distribution of the sizes of sent and received messages.
+	messageSizes *Histogram
+
+	// compressionRatios is the distribution of the compression ratios of sent messages.
+	compressionRatios *Histogram
+
+	// metrics holds the counters exported by Metrics.
+	metrics *metricCounters
+
+	// idleConns keeps the connections dialed for single messages to be reused. It's nil if Config.IdleConnsPerNode is
+	// 0.
+	idleConns *idleConns
+
+	// transferLocks holds a *transferLock for every node and OS that had a job distributed, keyed by IP and OS.
+	transferLocks sync.Map
 }
 
+// ErrServerAlreadyRunning is produced when changing a setting that can't be changed while the server is running
+var ErrServerAlreadyRunning = errors.New("server already running")
+
+// ErrNodeLimitReached is produced when connecting to a new node while Config.MaxNodesPerScan nodes are already known
+var ErrNodeLimitReached = errors.New("node limit reached")
+
+// trySendTimeout is the time Server.TrySend waits for a connection to accept a write.
+const trySendTimeout = time.Millisecond
+
 // NewServer creates a Server struct using the given config or the default if none is provided.
 func NewServer(configs ...Config) *Server {
 	var config Config
@@ -86,7 +244,14 @@ func NewServer(configs ...Config) *Server {
 		config = NewDefaultConfig()
 	}
 
-	if config.TLSCertificate == nil || config.TLSPrivateKey == nil {
+	config, configErr := readTokenFile(config)
+	if configErr != nil {
+		logger.Errorln("Unable to use the token file:", configErr)
+	}
+
+	// A self-signed certificate would be rejected by the nodes using TLSCA, so Validate reports the missing one instead
+	cachedTLS := config.TLSCA == nil && (config.TLSCertificate == nil || config.TLSPrivateKey == nil)
+	if cachedTLS {
 		var err error
 		config.TLSCertificate, config.TLSPrivateKey, err = getTLSCache()
 		if err != nil {
@@ -104,62 +269,262 @@ func NewServer(configs ...Config) *Server {
 		}
 	}
 
-	return &Server{
+	s := &Server{
 		Config:          config,
 		terminationChan: make(chan bool),
 		connCallback:    defaultConnCallback,
 		sendCallback:    defaultSendCallback,
 		serverCallback:  defaultServeCallback,
 		queue:           make(chan Request),
+		nodeErrors:      make(map[string][]string),
+		pendingTasks:    make(map[string]Node),
+		runningTasks:    make(map[string]context.CancelFunc),
+		cachedTLS:       cachedTLS,
+		configErr:       configErr,
+		logger:          logrus.New(),
+
+		messageSizes:      newHistogram("beekeeper_message_size_bytes", messageSizeBuckets),
+		compressionRatios: newHistogram("beekeeper_compression_ratio", compressionRatioBuckets),
+		metrics:           newMetricCounters(),
+		idleConns:         newIdleConns(config),
 	}
+
+	if config.WorkerConcurrency > 0 {
+		s.taskSlots = make(chan struct{}, config.WorkerConcurrency)
+	}
+
+	s.checkTLSExpiry()
+	s.checkOtherInstance()
+	s.restoreSnapshot()
+
+	if config.PersistentQueuePath != "" {
+		var err error
+		s.requestLog, s.replay, err = openRequestLog(config.PersistentQueuePath)
+		if err != nil {
+			s.log().Errorln("Unable to open the persistent queue, messages won't be persisted:", err)
+		}
+	}
+
+	return s
 }
 
 // Start serves a node and blocks.
-func (s *Server) Start() error {
+func (s *Server) Start() (err error) {
 	if s.Config.Debug {
-		logger.SetLevel(logrus.DebugLevel)
+		s.log().SetLevel(logrus.DebugLevel)
 	}
 
-	logger.Infoln("Starting server")
+	if s.configErr != nil {
+		return s.configErr
+	}
+
+	err = s.Config.Validate()
+	if err != nil {
+		return err
+	}
+
+	s.log().Infoln("Starting server")
+
+	s.startedLock.Lock()
+	s.started = true
+	s.startedLock.Unlock()
+
+	defer func() {
+		// The server never ran, so it can still be configured
+		if err != nil {
+			s.startedLock.Lock()
+			s.started = false
+			s.startedLock.Unlock()
+		}
+	}()
 
 	if s.Config.AllowExternal && len(s.Config.Whitelist) < 0 {
-		logger.Warnln("External connections are allowed but the whitelist is disabled")
+		s.log().Warnln("External connections are allowed but the whitelist is disabled")
+	}
+
+	s.whitelist = resolveWhitelist(s.Config.Whitelist, s.log())
+
+	err = createFolderIfNotExist(s.Config.workDir())
+	if err != nil {
+		return errors.Wrap(err, "unable to create the work directory")
 	}
 
-	err := s.serverCallback(s)
+	err = s.serverCallback(s)
 	if err != nil {
 		return err
 	}
 
-	logger.Infoln("Listening on port", s.Config.InboundPort)
+	s.log().Infoln("Listening on port", s.Config.InboundPort)
+
+	if s.Config.HeartbeatInterval > 0 {
+		go s.startHeartbeat()
+	}
+
+	go s.startAwaitedReaper()
+	go s.idleConns.sweep(s.terminationChan)
+	go s.replayRequests()
 
 	for {
 		select {
 		case <-s.terminationChan:
 			return nil
 		case req := <-s.queue:
-			authed := req.Msg.isTokenMatching(s.Config.Token)
+			authed := s.authenticate(req.Msg, req.Conn.auth)
 			if !authed {
+				s.markRequestDone(req.seq)
 				continue
 			}
 
-			logger.Debugln("Received:", req.Msg.summary())
+			s.log().Debugln("Received:", req.Msg.summary())
+			s.recordMessage(RecordInbound, req.Msg.Addr, req.Msg)
 
 			s.updateNode(req.Msg.node())
-			go s.handleMessage(&req.Conn, req.Msg)
+
+			if s.holdRequest(req) {
+				continue
+			}
+
+			go s.handleRequest(req)
 		}
 	}
 }
 
-// Stop shutdowns a running server.
+// ListenAndReconnect starts the server like Start, but retries if the listener can't be started, as happens when the
+// port is still held by a previous process. Attempts are separated by retryDelay, and the last error is returned after
+// maxRetries consecutive failures. At least one attempt is made. Errors other than a *ListenError, like an invalid
+// Config, are returned right away.
+func (s *Server) ListenAndReconnect(maxRetries int, retryDelay time.Duration) error {
+	var err error
+	for failures := 0; failures == 0 || failures < maxRetries; failures++ {
+		if failures > 0 {
+			s.log().Warnln("Unable to start the server, retrying in", retryDelay.String()+":", err)
+			time.Sleep(retryDelay)
+		}
+
+		err = s.Start()
+
+		var listenErr *ListenError
+		if !errors.As(err, &listenErr) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// SetSendCallback replaces the function used to send messages through a connection. It's meant for testing and
+// advanced customization. ErrServerAlreadyRunning is returned if the server was already started.
+func (s *Server) SetSendCallback(fn func(*Server, *Conn, Message) error) error {
+	s.startedLock.Lock()
+	defer s.startedLock.Unlock()
+
+	if s.started {
+		return ErrServerAlreadyRunning
+	}
+
+	s.sendCallback = fn
+
+	return nil
+}
+
+// SetConnCallback replaces the function used to create connections with the nodes. It's meant for testing and
+// advanced customization. ErrServerAlreadyRunning is returned if the server was already started.
+func (s *Server) SetConnCallback(fn func(*Server, string, ...time.Duration) (*Conn, error)) error {
+	s.startedLock.Lock()
+	defer s.startedLock.Unlock()
+
+	if s.started {
+		return ErrServerAlreadyRunning
+	}
+
+	s.connCallback = fn
+
+	return nil
+}
+
+// SetServerCallback replaces the function used to start listening for connections. It's meant for testing and
+// advanced customization. ErrServerAlreadyRunning is returned if the server was already started.
+func (s *Server) SetServerCallback(fn func(*Server) error) error {
+	s.startedLock.Lock()
+	defer s.startedLock.Unlock()
+
+	if s.started {
+		return ErrServerAlreadyRunning
+	}
+
+	s.serverCallback = fn
+
+	return nil
+}
+
+// LogTo redirects the log output of the server to w, keeping its format. Other servers in the process are unaffected.
+// Messages logged by NewServer, before the server exists, are always written to the standard error.
+func (s *Server) LogTo(w io.Writer) {
+	if s.logger == nil {
+		s.logger = logrus.New()
+	}
+
+	s.logger.SetOutput(w)
+}
+
+// log returns the logger of the server, or the package logger for servers not created by NewServer.
+func (s *Server) log() *logrus.Logger {
+	if s.logger == nil {
+		return logger
+	}
+
+	return s.logger
+}
+
+// Stop shutdowns a running server. Calling it more than once has no effect.
 func (s *Server) Stop() {
-	close(s.terminationChan)
+	s.stopOnce.Do(func() {
+		close(s.terminationChan)
+		s.idleConns.closeAll()
+	})
 }
 
-// Connect established a TCP over TLS connection with the given address. If no node is reachable an error will be
-// returned. An optional timeout argument can be provided.
-func (s *Server) Connect(ip string, timeout ...time.Duration) (Node, error) {
-	conn, err := s.connCallback(s, ip, timeout...)
+// WaitForStop blocks until Stop is called, or the context is done, in which case the context's error is returned.
+func (s *Server) WaitForStop(ctx context.Context) error {
+	select {
+	case <-s.terminationChan:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LocalAddr returns the address the server is listening on. If the server hasn't been started nil is returned.
+func (s *Server) LocalAddr() net.Addr {
+	s.listenerLock.RLock()
+	defer s.listenerLock.RUnlock()
+
+	if s.listener == nil {
+		return nil
+	}
+
+	return s.listener.Addr()
+}
+
+// setListener stores the listener the server accepts connections on.
+func (s *Server) setListener(l net.Listener) {
+	s.listenerLock.Lock()
+	defer s.listenerLock.Unlock()
+
+	s.listener = l
+}
+
+// Connect established a TCP over TLS connection with the given address. The address may be a hostname, in which case
+// it's resolved and the first IP found is used. If no node is reachable an error will be returned, and
+// ErrNodeLimitReached is returned if the node is new and Config.MaxNodesPerScan nodes are already known. An optional
+// timeout argument can be provided.
+func (s *Server) Connect(addr string, timeout ...time.Duration) (Node, error) {
+	addr, ip, err := resolveAddr(addr)
+	if err != nil {
+		return Node{}, errors.Wrap(err, "unable to resolve address")
+	}
+
+	conn, err := s.connCallback(s, addr, timeout...)
 	if err != nil {
 		return Node{}, err
 	}
@@ -169,11 +534,81 @@ func (s *Server) Connect(ip string, timeout ...time.Duration) (Node, error) {
 		return Node{}, err
 	}
 
-	return s.awaitAny(ip, timeout...)
+	node, err := s.awaitAny(ip.String(), timeout...)
+	if err != nil {
+		return Node{}, err
+	}
+
+	if node.Addr == nil { // Discarded by updateNode
+		return Node{}, ErrNodeLimitReached
+	}
+
+	return node, nil
+}
+
+// GetOrConnect returns the node with the given IP if it's already known and has an open connection. Otherwise it
+// connects with it like Connect. An optional timeout argument can be provided.
+func (s *Server) GetOrConnect(ip string, timeout ...time.Duration) (Node, error) {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		node, found := s.GetNode(parsed)
+		if found && node.Conn != nil {
+			return node, nil
+		}
+	}
+
+	return s.Connect(ip, timeout...)
+}
+
+// AwaitNodes connects to every IP and waits up to the timeout for all of them to respond. The nodes are returned in the
+// same order as the IPs. If any of them doesn't respond in time the nodes that did are returned with an ErrTimeout
+// wrapping error that lists the missing IPs.
+func (s *Server) AwaitNodes(ips []string, timeout time.Duration) (Nodes, error) {
+	found := make([]*Node, len(ips))
+
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Add(1)
+
+		go func(i int, ip string) {
+			defer wg.Done()
+
+			node, err := s.Connect(ip, timeout)
+			if err != nil {
+				s.log().Debugln("Node", ip, "not available:", err)
+				return
+			}
+
+			found[i] = &node
+		}(i, ip)
+	}
+
+	wg.Wait()
+
+	var nodes Nodes
+	var missing []string
+	for i, node := range found {
+		if node == nil {
+			missing = append(missing, ips[i])
+			continue
+		}
+
+		nodes = append(nodes, *node)
+	}
+
+	if len(missing) > 0 {
+		return nodes, fmt.Errorf("%w: missing nodes %s", ErrTimeout, strings.Join(missing, ", "))
+	}
+
+	return nodes, nil
 }
 
-// Scan broadcasts a status Request to all IPs and waits the provided amount for a response.
+// Scan broadcasts a status Request to all IPs and waits the provided amount for a response. If
+// Config.MaxNodesPerScan is set, only the first nodes to respond are returned, as soon as that many do.
 func (s *Server) Scan(waitTime time.Duration) (Nodes, error) {
+	if s.Config.MaxNodesPerScan > 0 {
+		return s.scanLimited(waitTime)
+	}
+
 	err := s.broadcastOperation(OperationStatus, false)
 	if err != nil {
 		return nil, err
@@ -187,6 +622,140 @@ func (s *Server) Scan(waitTime time.Duration) (Nodes, error) {
 	return s.nodes, nil
 }
 
+// scanLimited scans like Scan, returning the first Config.MaxNodesPerScan nodes that respond.
+func (s *Server) scanLimited(waitTime time.Duration) (Nodes, error) {
+	var responded Nodes
+	var respondedLock sync.Mutex
+
+	err := s.ScanWithCallback(func(n Node) {
+		respondedLock.Lock()
+		defer respondedLock.Unlock()
+
+		for i := range responded {
+			if responded[i].Addr.IP.Equal(n.Addr.IP) {
+				responded[i] = n
+				return
+			}
+		}
+
+		responded = append(responded, n)
+	}, waitTime)
+	if err != nil {
+		return nil, err
+	}
+
+	respondedLock.Lock()
+	defer respondedLock.Unlock()
+
+	return responded, nil
+}
+
+// ScanAndConnect scans like Scan and then opens a connection with every node found, so they can be used right away.
+// Nodes that can't be connected within the optional connTimeout are logged and left out of the returned list.
+func (s *Server) ScanAndConnect(waitTime time.Duration, connTimeout ...time.Duration) (Nodes, error) {
+	nodes, err := s.Scan(waitTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.connectNodes(nodes, connTimeout...), nil
+}
+
+// connectNodes concurrently opens a connection with every node and updates them. The connected nodes are returned,
+// and the ones that can't be connected are left out.
+func (s *Server) connectNodes(nodes Nodes, timeout ...time.Duration) Nodes {
+	connected := make([]*Node, len(nodes))
+
+	var wg sync.WaitGroup
+	for i := range nodes {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			node := nodes[i].Copy()
+			conn, err := s.connCallback(s, node.Addr.IP.String(), timeout...)
+			if err != nil {
+				s.log().Warnln("Unable to connect with node", node.Name+":", err)
+				return
+			}
+
+			node.Conn = conn
+			s.updateNode(node)
+			connected[i] = &node
+		}(i)
+	}
+
+	wg.Wait()
+
+	var result Nodes
+	for _, node := range connected {
+		if node != nil {
+			result = append(result, *node)
+		}
+	}
+
+	return result
+}
+
+// ScanWithCallback broadcasts a status Request to all IPs and calls fn every time a node responds during the
+// provided wait time. It blocks until the wait time is over, or until Config.MaxNodesPerScan nodes respond, see
+// ScanWithCallbackCtx.
+func (s *Server) ScanWithCallback(fn func(Node), waitTime time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), waitTime)
+	defer cancel()
+
+	return s.ScanWithCallbackCtx(ctx, fn)
+}
+
+// ScanWithCallbackCtx broadcasts a status Request to all IPs and calls fn every time a node responds until the
+// context is done. If Config.MaxNodesPerScan is set, fn is only called for that many nodes, and the scan returns as
+// soon as they respond.
+func (s *Server) ScanWithCallbackCtx(ctx context.Context, fn func(Node)) error {
+	limit := s.Config.MaxNodesPerScan
+	full := make(chan struct{})
+
+	seen := make(map[string]bool)
+	var seenLock sync.Mutex
+
+	callback := s.addNodeCallback(func(n Node) {
+		last := false
+		if limit > 0 {
+			seenLock.Lock()
+			ip := n.Addr.IP.String()
+			if !seen[ip] {
+				if len(seen) >= limit {
+					seenLock.Unlock()
+					return
+				}
+
+				seen[ip] = true
+				last = len(seen) == limit
+			}
+			seenLock.Unlock()
+		}
+
+		fn(n)
+
+		if last {
+			close(full)
+		}
+	})
+	defer s.removeNodeCallback(callback)
+
+	err := s.broadcastOperation(OperationStatus, false)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-full:
+		return nil
+	}
+}
+
 // handleMessage takes a Message from the node's server and runs the corresponding operation callback.
 func (s *Server) handleMessage(conn *Conn, msg Message) {
 	switch msg.Operation {
@@ -200,19 +769,62 @@ func (s *Server) handleMessage(conn *Conn, msg Message) {
 		transferStatusCallback(s, conn, msg) // Primary
 
 	case OperationStatus:
+		s.setLastPrimaryMsg(msg)
 		statusCallback(s, conn, msg) // Node
 
 	case OperationJobTransfer:
+		s.setLastPrimaryMsg(msg)
 		jobTransferCallback(s, conn, msg) // Node
 
 	case OperationJobExecute:
+		s.setLastPrimaryMsg(msg)
 		jobExecuteCallback(s, conn, msg) // Node
+
+	case OperationTopologyQuery:
+		topologyQueryCallback(s, conn, msg) // Node
+
+	case OperationHandshake:
+		handshakeCallback(s, conn, msg) // Both
+
+	case OperationJobCancel:
+		s.setLastPrimaryMsg(msg)
+		jobCancelCallback(s, conn, msg) // Node
+
+	case OperationBinaryQuery:
+		s.setLastPrimaryMsg(msg)
+		binaryQueryCallback(s, conn, msg) // Node
+
+	case OperationHealthCheck:
+		s.setLastPrimaryMsg(msg)
+		healthCheckCallback(s, conn, msg) // Node
+
+	case OperationFileTransfer:
+		s.setLastPrimaryMsg(msg)
+		fileTransferCallback(s, conn, msg) // Node
+
+	case OperationQueryHistory:
+		s.setLastPrimaryMsg(msg)
+		queryHistoryCallback(s, conn, msg) // Node
+
+	case OperationMetricsQuery:
+		s.setLastPrimaryMsg(msg)
+		metricsQueryCallback(s, conn, msg) // Node
+
+	case OperationDisconnect:
+		disconnectCallback(s, conn, msg) // Node
+
+	case OperationBinaryURL:
+		s.setLastPrimaryMsg(msg)
+		binaryURLCallback(s, conn, msg) // Node
+
+	case OperationPing:
+		pingCallback(s, conn, msg) // Both
 	}
 
 	node := msg.node()
 	node.Conn = conn
 
-	s.updateNode(node)
+	s.refreshNode(node)
 	s.checkAwaited(msg)
 }
 
@@ -221,13 +833,7 @@ func (s *Server) isOnline(n Node) bool {
 	s.nodesLock.Lock()
 	defer s.nodesLock.Unlock()
 
-	for _, node := range s.nodes {
-		if n.Equals(node) {
-			return true
-		}
-	}
-
-	return false
+	return s.nodes.Contains(n)
 }
 
 // defaultServeCallback listens for TCP connections and sends the processed output of handler to the c chan.
@@ -237,67 +843,120 @@ func defaultServeCallback(s *Server) error {
 		return errors.Wrap(err, "unable to parse ips")
 	}
 
+	if s.Config.Transport == TransportQUIC {
+		return quicServeCallback(s)
+	}
+
 	cer, err := tls.X509KeyPair(s.Config.TLSCertificate, s.Config.TLSPrivateKey)
 	if err != nil {
-		logger.Fatal(errors.Wrap(err, "invalid tls certificate or private key"))
+		s.log().Fatal(errors.Wrap(err, "invalid tls certificate or private key"))
 	}
 
-	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cer}, InsecureSkipVerify: true}
+	tlsConfig := newTLSConfig(s.Config, cer)
 
-	l, err := tls.Listen("tcp", ":"+strconv.Itoa(s.Config.InboundPort), tlsConfig)
+	var lc net.ListenConfig
+	if s.Config.ReusePort {
+		lc.Control = reusePortControl(s.log())
+	}
+
+	l, err := lc.Listen(context.Background(), "tcp", ":"+strconv.Itoa(s.Config.InboundPort))
 	if err != nil {
-		return err
+		return s.listenError(err)
+	}
+
+	if s.Config.EnableProxyProtocol {
+		l = proxyListener{l} // The PROXY protocol header precedes the TLS handshake
 	}
 
-	go func() {
-		for {
-			ip := l.Addr().(*net.TCPAddr).IP
-			if !s.Config.AllowExternal {
-				if !isPrivateIP(ip) {
-					continue
+	s.setListener(l)
+
+	go s.acceptConns(l, tlsConfig)
+
+	return nil
+}
+
+// acceptConns accepts the connections of the listener and handles them over TLS, unless tlsConfig is nil. Connections
+// from peers that are not allowed by Config.AllowExternal and Config.Whitelist are closed. With the PROXY protocol the
+// peer is the source address in the header, which is read before the TLS handshake.
+func (s *Server) acceptConns(l net.Listener, tlsConfig *tls.Config) {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+
+		if err != nil {
+			s.log().Errorln("Received invalid connection:", err)
+			continue
+		}
+
+		go func() {
+			if pc, ok := conn.(*proxyConn); ok {
+				err := pc.header()
+				if err != nil {
+					s.log().Errorln("Received invalid connection:", err)
+					_ = conn.Close()
+					return
 				}
 			}
 
-			if len(s.Config.Whitelist) > 0 {
-				if !isWhitelisted(ip, s.Config.Whitelist) {
-					continue
-				}
+			if !s.isAllowedPeer(toTCPAddr(conn.RemoteAddr()).IP) {
+				s.log().Debugln("Refusing connection from", conn.RemoteAddr())
+				_ = conn.Close()
+				return
 			}
 
-			conn, err := l.Accept()
-			if err != nil {
-				logger.Errorln("Received invalid connection:", err)
-				continue
+			if tlsConfig != nil {
+				conn = tls.Server(conn, tlsConfig)
 			}
 
-			go func() {
-				s.handle(conn)
-			}()
-		}
-	}()
+			s.handle(conn, newRateLimiter(s.Config.SendBytesPerSecond))
+		}()
+	}
+}
 
-	return nil
+// isAllowedPeer reports if connections from the IP are accepted, according to Config.AllowExternal and
+// Config.Whitelist.
+func (s *Server) isAllowedPeer(ip net.IP) bool {
+	if !s.Config.AllowExternal && !isPrivateIP(ip) {
+		return false
+	}
+
+	return len(s.Config.Whitelist) == 0 || isWhitelisted(ip, s.whitelist)
 }
 
-// send sends the provided Message to the Node.
+// send sends the provided Message to the Node. If the node has to be dialed, but a previous dial failed recently,
+// ErrBackoffActive is returned without dialing. If an idle connection is reused but writing to it fails, the message
+// is sent once more through a new connection, as the node may have closed it in the meantime.
 func (s *Server) send(n Node, m Message) error {
 	defer func() {
 		if r := recover(); r != nil {
-			logger.Errorln("An error ocurred while responding to", m.Name, ":", r)
+			s.log().Errorln("An error ocurred while responding to", m.Name, ":", r)
 		}
 	}()
 
-	if n.Conn == nil {
-		logger.Debugln("Creating new connection to node", n.Name)
+	conn, dialed, idle, err := s.nodeConn(n)
+	if err != nil {
+		return err
+	}
 
-		var err error
-		n.Conn, err = s.dial(n.Addr.IP.String())
+	err = s.sendWithConn(conn, m)
+	if dialed {
+		s.doneWithConn(n, conn, err == nil) // Dialed only for this message
+	}
+
+	if err != nil && idle {
+		s.log().Debugln("Idle connection to node", n.Name, "failed, dialing again:", err)
+
+		conn, err = s.dialNode(n)
 		if err != nil {
-			return errors.Wrap(err, "connection error")
+			return err
 		}
+
+		err = s.sendWithConn(conn, m)
+		s.doneWithConn(n, conn, err == nil)
 	}
 
-	err := s.sendWithConn(n.Conn, m)
 	if err != nil {
 		return errors.Wrap(err, "send error")
 	}
@@ -305,9 +964,133 @@ func (s *Server) send(n Node, m Message) error {
 	return nil
 }
 
+// TrySend sends a Message to the node without blocking on a busy connection. If the message can't be written right
+// away sent will be false and the connection is closed, as part of the message may have been written. A connection is
+// still established if the node has none. It's meant for fire-and-forget messages; use Server.send otherwise.
+func (s *Server) TrySend(n Node, m Message) (sent bool, err error) {
+	conn, dialed, _, err := s.nodeConn(n)
+	if err != nil {
+		return false, err
+	}
+
+	sent, err = s.trySendWithConn(conn, m)
+	if dialed {
+		s.doneWithConn(n, conn, sent && err == nil) // Dialed only for this message
+	}
+
+	return sent, err
+}
+
+// trySendWithConn is the non-blocking variant of sendWithConn. See Server.TrySend.
+func (s *Server) trySendWithConn(c *Conn, m Message) (bool, error) {
+	if c.netConn() == nil {
+		return true, s.sendWithConn(c, m)
+	}
+
+	// A deadline of time.Now() would fail every write before it's attempted, so a minimal grace period is given
+	err := c.SetWriteDeadline(time.Now().Add(trySendTimeout))
+	if err != nil {
+		return false, err
+	}
+
+	err = s.sendWithConn(c, m)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		_ = c.Close()
+		return false, nil
+	}
+
+	if err != nil {
+		return false, errors.Wrap(err, "send error")
+	}
+
+	err = c.SetWriteDeadline(time.Time{})
+	if err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
+
+// nodeConn returns the connection of the node, or the one of the known node with its address, as copies returned by
+// Node.Copy have none. If neither has a connection an idle one is reused or a new one is dialed, and dialed is true.
+// idle is true if the connection is a reused idle one.
+func (s *Server) nodeConn(n Node) (conn *Conn, dialed, idle bool, err error) {
+	if n.Conn != nil {
+		return n.Conn, false, false, nil
+	}
+
+	if known := s.knownConn(n); known != nil {
+		return known, false, false, nil
+	}
+
+	if idleConn := s.idleConns.get(n.Addr.IP.String()); idleConn != nil {
+		return idleConn, true, true, nil
+	}
+
+	conn, err = s.dialNode(n)
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	return conn, true, false, nil
+}
+
+// dialNode dials a new connection to the node, unless a previous dial failed recently, in which case ErrBackoffActive
+// is returned.
+func (s *Server) dialNode(n Node) (*Conn, error) {
+	ip := n.Addr.IP.String()
+	if s.isBackoffActive(ip) {
+		return nil, ErrBackoffActive
+	}
+
+	s.log().Debugln("Creating new connection to node", n.Name)
+
+	conn, err := s.dial(ip)
+	if err != nil {
+		s.backoffFailed(ip)
+		return nil, errors.Wrap(err, "connection error")
+	}
+
+	s.backoffReset(ip)
+
+	return conn, nil
+}
+
+// doneWithConn is called once a connection dialed by nodeConn is no longer used. Healthy connections are kept for
+// later messages to the node if Config.IdleConnsPerNode allows it, and the rest are released. Connections that failed
+// are closed.
+func (s *Server) doneWithConn(n Node, conn *Conn, healthy bool) {
+	if healthy && s.idleConns.put(n.Addr.IP.String(), conn) {
+		return
+	}
+
+	if !healthy && conn.netConn() != nil {
+		_ = conn.Close()
+	}
+
+	s.releaseConn(conn)
+}
+
+// knownConn returns the connection of the known node with the same address, or nil if there's none.
+func (s *Server) knownConn(n Node) *Conn {
+	if n.Addr == nil {
+		return nil
+	}
+
+	s.nodesLock.RLock()
+	defer s.nodesLock.RUnlock()
+
+	return s.nodes.find(n.Addr.IP).Conn
+}
+
 // sendWithConn fills the Message with the required metadata and sends it.
 func (s *Server) sendWithConn(c *Conn, m Message) error {
-	return s.sendCallback(s, c, m)
+	err := s.sendCallback(s, c, m)
+	if err == nil {
+		s.recordMessage(RecordOutbound, c.peerAddr(), m)
+	}
+
+	return err
 }
 
 func initPrivateIPs() error {
@@ -0,0 +1,461 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_ScanMaxNodesPerScan(t *testing.T) {
+	c := NewDefaultConfig()
+	c.MaxNodesPerScan = 2
+
+	s := NewServer(c)
+
+	broadcasting := make(chan struct{}, 1)
+	_ = s.SetSendCallback(func(*Server, *Conn, Message) error {
+		select {
+		case broadcasting <- struct{}{}:
+		default:
+		}
+
+		return nil
+	})
+
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	type scan struct {
+		nodes Nodes
+		err   error
+	}
+
+	done := make(chan scan, 1)
+	go func() {
+		nodes, err := s.Scan(time.Second * 5)
+		done <- scan{nodes, err}
+	}()
+
+	select {
+	case <-broadcasting:
+	case <-time.After(time.Second):
+		t.Fatal("broadcast not sent")
+	}
+
+	// Responses, as handled by the Start loop
+	for _, n := range getTestNodes() {
+		s.updateNode(n)
+	}
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			t.Error(res.err)
+		}
+
+		if len(res.nodes) != 2 || res.nodes[0].Name != "testWorker1" || res.nodes[1].Name != "testWorker2" {
+			t.Error("unexpected scanned nodes:", res.nodes)
+		}
+	case <-time.After(time.Second):
+		t.Error("scan not stopped once the limit was reached")
+	}
+
+	if len(s.Nodes()) != 2 {
+		t.Error("nodes past the limit kept:", len(s.Nodes()))
+	}
+}
+
+func TestServer_ScanWithCallbackCtx(t *testing.T) {
+	s, receiveChan, sendChan := startPrimaryTestChannels()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	found := make(chan Node, 2)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ScanWithCallbackCtx(ctx, func(n Node) {
+			if n.Name == "TEST_HOST" {
+				select {
+				case found <- n:
+				default:
+				}
+			}
+		})
+	}()
+
+	// Drain the broadcast
+	for received := 0; received < 254; received++ {
+		select {
+		case <-sendChan:
+		case <-time.After(time.Second):
+			t.Error("broadcast not sent")
+			return
+		}
+	}
+
+	msg := getTestMessage()
+	msg.Token = s.Config.Token
+	receiveChan <- Request{Msg: msg, Conn: Conn{}}
+
+	select {
+	case <-found:
+	case <-time.After(time.Second):
+		t.Error("callback not called")
+	}
+
+	select {
+	case <-found:
+		t.Error("callback called more than once for a single message")
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	cancel()
+
+	err := <-done
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServer_LocalAddr(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+	if s.LocalAddr() != nil {
+		t.Error("address set before start")
+		return
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer l.Close()
+
+	s.setListener(l)
+	if s.LocalAddr() == nil || s.LocalAddr().String() != l.Addr().String() {
+		t.Fail()
+	}
+}
+
+func TestServer_AwaitNodes(t *testing.T) {
+	s, receiveChan, sendChan := startPrimaryTestChannels()
+
+	ips := []string{"192.168.1.1", "192.168.1.2"}
+
+	type result struct {
+		nodes Nodes
+		err   error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		nodes, err := s.AwaitNodes(ips, time.Millisecond*500)
+		done <- result{nodes, err}
+	}()
+
+	for range ips {
+		select {
+		case <-sendChan:
+		case <-time.After(time.Second):
+			t.Error("status not sent")
+			return
+		}
+	}
+
+	time.Sleep(time.Millisecond * 100) // Let the awaitables register
+
+	msg := getTestMessage()
+	msg.Token = s.Config.Token
+	receiveChan <- Request{Msg: msg, Conn: Conn{}} // Only 192.168.1.1 responds
+
+	res := <-done
+	if !errors.Is(res.err, ErrTimeout) || !strings.Contains(res.err.Error(), "192.168.1.2") {
+		t.Error("expected ErrTimeout listing the missing node, got", res.err)
+	}
+
+	if len(res.nodes) != 1 || !res.nodes[0].Addr.IP.Equal(net.ParseIP("192.168.1.1")) {
+		t.Error("unexpected nodes:", res.nodes)
+	}
+}
+
+func TestServer_connectNodes(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+	s.connCallback = func(_ *Server, ip string, timeout ...time.Duration) (*Conn, error) {
+		if ip == "192.168.1.2" {
+			return nil, errors.New("unreachable")
+		}
+
+		return &Conn{}, nil
+	}
+
+	nodes := s.connectNodes(getTestNodes()[:3])
+	if len(nodes) != 2 {
+		t.Fatal("expected 2 connected nodes, got", len(nodes))
+	}
+
+	for _, node := range nodes {
+		if node.Addr.IP.Equal(net.ParseIP("192.168.1.2")) {
+			t.Error("unreachable node returned")
+		}
+
+		if node.Conn == nil {
+			t.Error("node returned without a connection:", node.Name)
+		}
+
+		if s.knownConn(node) == nil {
+			t.Error("node not updated:", node.Name)
+		}
+	}
+}
+
+func TestServer_SetCallbacks(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	err := s.SetSendCallback(func(*Server, *Conn, Message) error {
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.SetServerCallback(func(*Server) error {
+		return errors.New("not serving")
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_ = s.Start() // Fails right after being marked as started
+
+	err = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+	if err != ErrServerAlreadyRunning {
+		t.Error("expected ErrServerAlreadyRunning, got", err)
+	}
+
+	err = s.SetServerCallback(func(*Server) error {
+		return nil
+	})
+	if err != ErrServerAlreadyRunning {
+		t.Error("expected ErrServerAlreadyRunning, got", err)
+	}
+}
+
+func TestServer_LogTo(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+	other := NewServer(NewDefaultConfig())
+
+	var buf, otherBuf bytes.Buffer
+	s.LogTo(&buf)
+	other.LogTo(&otherBuf)
+
+	s.log().Warnln("redirected")
+
+	if !strings.Contains(buf.String(), "redirected") {
+		t.Error("log not written to the writer:", buf.String())
+	}
+
+	if otherBuf.Len() != 0 {
+		t.Error("log written to the writer of another server:", otherBuf.String())
+	}
+}
+
+func TestServer_GetOrConnect(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	dials := 0
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		dials++
+		return nil, errors.New("unreachable")
+	})
+
+	known := getTestNodes()[0]
+	known.Conn = &Conn{}
+	s.updateNode(known)
+
+	node, err := s.GetOrConnect(known.Addr.IP.String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !node.Equals(known) || dials != 0 {
+		t.Error("known node not returned without dialing")
+	}
+
+	_, err = s.GetOrConnect("192.168.1.200", time.Millisecond*100)
+	if err == nil || dials != 1 {
+		t.Error("unknown node not dialed")
+	}
+}
+
+func TestServer_ListenAndReconnect(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	attempts := 0
+	_ = s.SetServerCallback(func(_ *Server) error {
+		attempts++
+		return &ListenError{Err: ErrPortAlreadyInUse}
+	})
+
+	err := s.ListenAndReconnect(3, time.Millisecond)
+	if !errors.Is(err, ErrPortAlreadyInUse) {
+		t.Error("expected ErrPortAlreadyInUse, got", err)
+		return
+	}
+
+	if attempts != 3 {
+		t.Error("unexpected attempts", attempts)
+	}
+
+	// The server never ran, so it can still be configured
+	err = s.SetSendCallback(defaultSendCallback)
+	if err != nil {
+		t.Error(err)
+	}
+
+	attempts = 0
+
+	_ = s.ListenAndReconnect(0, time.Millisecond)
+	if attempts != 1 {
+		t.Error("expected a single attempt, got", attempts)
+	}
+}
+
+func TestServer_ListenAndReconnectPermanentError(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	attempts := 0
+	_ = s.SetServerCallback(func(_ *Server) error {
+		attempts++
+		return errors.New("permanent")
+	})
+
+	err := s.ListenAndReconnect(3, time.Millisecond)
+	if err == nil || attempts != 1 {
+		t.Error("permanent error retried:", attempts, err)
+	}
+}
+
+func TestServer_TrySend(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	a, b := net.Pipe()
+	defer b.Close()
+
+	n := Node{Name: "test", Conn: &Conn{stream: a}}
+
+	// Nobody reads from the pipe, so the write can't complete
+	sent, err := s.TrySend(n, newMessage())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if sent {
+		t.Error("message sent on a busy connection")
+	}
+}
+
+func TestServer_WaitForStop(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	err := s.WaitForStop(ctx)
+	if err != context.DeadlineExceeded {
+		t.Error("expected context.DeadlineExceeded, got", err)
+		return
+	}
+
+	go s.Stop()
+
+	err = s.WaitForStop(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServer_acceptConnsWhitelist(t *testing.T) {
+	for _, tc := range []struct {
+		whitelist []string
+		allowed   bool
+	}{
+		{[]string{"127.0.0.1"}, true},
+		{[]string{"127.0.*"}, true},
+		{[]string{"10.0.0.1"}, false},
+	} {
+		c := NewDefaultConfig()
+		c.Whitelist = tc.whitelist
+
+		s := NewServer(c)
+		s.whitelist = resolveWhitelist(c.Whitelist, s.log())
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go s.acceptConns(l, nil)
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := newMessage().encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _ = conn.Write(append([]byte(fmt.Sprintf("%d\n", len(data))), data...))
+
+		select {
+		case <-s.queue:
+			if !tc.allowed {
+				t.Error("connection accepted with whitelist", tc.whitelist)
+			}
+		case <-time.After(time.Millisecond * 500):
+			if tc.allowed {
+				t.Error("connection refused with whitelist", tc.whitelist)
+			}
+		}
+
+		_ = conn.Close()
+		_ = l.Close()
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"context"
+	"time"
+)
+
+// slaAttemptFactor is the amount of times the SLA each run started by ExecuteWithSLA is given to finish, after which it
+// fails with ErrTimeout.
+const slaAttemptFactor = 4
+
+// attempt is the outcome of one of the runs of a task started by ExecuteWithSLA.
+type attempt struct {
+	node Node
+	uuid string
+	res  Result
+	err  error
+}
+
+// ExecuteWithSLA runs a task on the best performing node, as selected by the server's LoadBalancer. If no result has
+// arrived once the sla time has passed the same task is also started on the first of the fallback nodes. Whichever
+// finishes first successfully wins, and the other run is cancelled with a JobCancel operation. Every run fails with
+// ErrTimeout if it doesn't finish within four times the sla. An error is only returned once every started run has
+// failed.
+func (s *Server) ExecuteWithSLA(t Task, sla time.Duration, fallbacks Nodes) (Result, error) {
+	lb, err := s.getLoadBalancer()
+	if err != nil {
+		return Result{}, err
+	}
+
+	use, err := lb.acquire(t)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if !s.Config.DisableConnectionWatchdog {
+		terminateChan := make(chan bool, 1)
+		go startConnectionWatchdog(s, terminateChan)
+		defer func() {
+			terminateChan <- true
+		}()
+	}
+
+	// Cancelled on return, so the losing run stops waiting for its Result
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Buffered so the losing run can finish without a receiver
+	attempts := make(chan attempt, 2)
+
+	start := time.Now()
+	primary, err := s.startAttempt(ctx, use.node, t, sla*slaAttemptFactor, attempts)
+	if err != nil {
+		lb.release(use, time.Since(start), false)
+		return Result{}, err
+	}
+
+	slaTimer := time.NewTimer(sla)
+	defer slaTimer.Stop()
+
+	running := map[string]Node{primary: use.node}
+	released := false
+
+	for {
+		select {
+		case a := <-attempts:
+			delete(running, a.uuid)
+
+			if a.uuid == primary {
+				lb.release(use, time.Since(start), a.err == nil)
+				released = true
+			}
+
+			if a.err != nil && len(running) > 0 {
+				s.log().Warnln("Task", a.uuid, "failed on node", a.node.Name+", waiting for the other run:", a.err)
+				continue
+			}
+
+			if !released {
+				lb.release(use, time.Since(start), false) // Outrun by the fallback
+			}
+
+			for uuid, n := range running {
+				s.log().Infoln("Cancelling task", uuid, "on node", n.Name, "as it was outrun")
+
+				err := s.send(n, Message{Operation: OperationJobCancel, Data: []byte(uuid)})
+				if err != nil {
+					s.log().Errorln("Unable to cancel task", uuid+":", err)
+				}
+			}
+
+			return a.res, a.err
+
+		case <-slaTimer.C:
+			fallback, found := firstOtherNode(fallbacks, use.node)
+			if !found {
+				s.log().Warnln("Task exceeded its SLA on node", use.node.Name, "and no fallback node is available")
+				continue
+			}
+
+			s.log().Infoln("Task exceeded its SLA on node", use.node.Name+", starting it on node", fallback.Name)
+
+			uuid, err := s.startAttempt(ctx, fallback, t, sla*slaAttemptFactor, attempts)
+			if err != nil {
+				s.log().Errorln("Unable to start task on fallback node", fallback.Name+":", err)
+				continue
+			}
+
+			running[uuid] = fallback
+		}
+	}
+}
+
+// startAttempt runs the task with a new UUID on the given node in the background, sending the outcome through the
+// attempts chan. The run fails with ErrTimeout if it doesn't finish within the timeout, and stops waiting if the
+// context is cancelled. The UUID of the run is returned.
+func (s *Server) startAttempt(ctx context.Context, n Node, t Task, timeout time.Duration,
+	attempts chan attempt) (string, error) {
+	n = s.latestNode(n)
+	if !t.ResourceHints.suits(n) {
+		return "", ErrNoSuitableNode
+	}
+
+	uuid, err := newJobUUID()
+	if err != nil {
+		return "", err
+	}
+
+	t.UUID = uuid
+
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		res, err := s.runTaskCtx(ctx, n, t, nil)
+		attempts <- attempt{node: n, uuid: uuid, res: res, err: err}
+	}()
+
+	return uuid, nil
+}
+
+// firstOtherNode returns the first of the nodes that isn't the excluded one.
+func firstOtherNode(ns Nodes, excluded Node) (Node, bool) {
+	for _, n := range ns {
+		if !n.Equals(excluded) {
+			return n, true
+		}
+	}
+
+	return Node{}, false
+}
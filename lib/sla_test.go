@@ -0,0 +1,158 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServer_ExecuteWithSLA(t *testing.T) {
+	c := NewDefaultConfig()
+	c.DisableConnectionWatchdog = true
+
+	s := NewServer(c)
+
+	sent := make(chan Message, 3)
+	_ = s.SetSendCallback(func(_ *Server, _ *Conn, m Message) error {
+		sent <- m
+		return nil
+	})
+
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	nodes := getTestNodes()
+	s.nodes = Nodes{nodes[0]}
+
+	type outcome struct {
+		res Result
+		err error
+	}
+
+	done := make(chan outcome, 1)
+	go func() {
+		res, err := s.ExecuteWithSLA(NewTask(), time.Millisecond*50, Nodes{nodes[0], nodes[1]})
+		done <- outcome{res, err}
+	}()
+
+	var uuids []string
+	for len(uuids) < 2 {
+		select {
+		case msg := <-sent:
+			if msg.Operation != OperationJobExecute {
+				t.Error("unexpected operation", msg.Operation)
+				return
+			}
+
+			task, err := decodeTask(msg.Data)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			uuids = append(uuids, task.UUID)
+		case <-time.After(time.Second):
+			t.Error("task not sent to the fallback node")
+			return
+		}
+	}
+
+	time.Sleep(time.Millisecond * 100) // Let the awaitables register
+
+	response := newMessage()
+	response.Operation = OperationJobResult
+	response, err := response.setData(Result{UUID: uuids[1]})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s.checkAwaited(response)
+
+	select {
+	case o := <-done:
+		if o.err != nil {
+			t.Error(o.err)
+			return
+		}
+
+		if o.res.UUID != uuids[1] {
+			t.Error("expected the fallback result, got", o.res.UUID)
+		}
+	case <-time.After(time.Second):
+		t.Error("execution not finished")
+		return
+	}
+
+	select {
+	case msg := <-sent:
+		if msg.Operation != OperationJobCancel || string(msg.Data) != uuids[0] {
+			t.Error("unexpected message", msg.summary())
+		}
+	case <-time.After(time.Second):
+		t.Error("outrun task not cancelled")
+	}
+}
+
+func TestServer_ExecuteWithSLATimeout(t *testing.T) {
+	c := NewDefaultConfig()
+	c.DisableConnectionWatchdog = true
+
+	s := NewServer(c)
+
+	_ = s.SetSendCallback(func(*Server, *Conn, Message) error {
+		return nil
+	})
+
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	nodes := getTestNodes()
+	s.nodes = Nodes{nodes[0]}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.ExecuteWithSLA(NewTask(), time.Millisecond*20, nil)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != ErrTimeout {
+			t.Error("expected ErrTimeout, got", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("execution not finished")
+		return
+	}
+
+	s.awaitedLock.Lock()
+	defer s.awaitedLock.Unlock()
+
+	if len(s.awaited) != 0 {
+		t.Error("expected no awaitables left, got", len(s.awaited))
+	}
+}
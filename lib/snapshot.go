@@ -0,0 +1,244 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// snapshotFormatVersion is the version of the format written by Export.
+const snapshotFormatVersion = 1
+
+// snapshotFileName is the name of the file inside the work directory holding the snapshot stored by SaveSnapshot.
+const snapshotFileName = "snapshot.json"
+
+// snapshot holds the cluster state written by Export and read by Import.
+type snapshot struct {
+	FormatVersion int              `json:"format_version"`
+	Nodes         []nodeSnapshot   `json:"nodes"`
+	Config        Config           `json:"config"`
+	History       ExecutionHistory `json:"history"`
+}
+
+// nodeSnapshot holds the exportable fields of a Node.
+type nodeSnapshot struct {
+	Name   string   `json:"name"`
+	IP     string   `json:"ip"`
+	Port   int      `json:"port"`
+	Status Status   `json:"status"`
+	Info   NodeInfo `json:"info"`
+}
+
+// newNodeSnapshot returns the exportable fields of the node.
+func newNodeSnapshot(n Node) nodeSnapshot {
+	return nodeSnapshot{
+		Name:   n.Name,
+		IP:     n.Addr.IP.String(),
+		Port:   n.Addr.Port,
+		Status: n.Status,
+		Info:   n.Info,
+	}
+}
+
+// Export writes a JSON snapshot of the known nodes, the Config and the execution history to w. The TLS private key
+// and the Token are left out, while Config.TokenFile is kept.
+func (s *Server) Export(w io.Writer) error {
+	snap := snapshot{
+		FormatVersion: snapshotFormatVersion,
+		Nodes:         []nodeSnapshot{},
+		Config:        s.Config,
+		History:       s.History(),
+	}
+
+	snap.Config.TLSPrivateKey = nil
+	snap.Config.Token = ""
+
+	s.nodesLock.RLock()
+	for _, node := range s.nodes {
+		snap.Nodes = append(snap.Nodes, newNodeSnapshot(node))
+	}
+	s.nodesLock.RUnlock()
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(snap)
+}
+
+// Import reads a snapshot written by Export from r, adds its nodes to the known nodes and merges its execution
+// history with the server's one. The Config in the snapshot is not applied.
+func (s *Server) Import(r io.Reader) error {
+	snap, err := decodeSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := snap.nodes()
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		s.updateNode(node)
+	}
+
+	s.mergeHistory(snap.History)
+
+	return nil
+}
+
+// SaveSnapshot reads a snapshot written by Export from r and stores it in the work directory of the Config. Servers
+// created afterwards with NewServer using the same work directory restore it, as with Import. The nodes in the
+// snapshot are returned.
+func SaveSnapshot(c Config, r io.Reader) (Nodes, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := decodeSnapshot(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := snap.nodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = createFolderIfNotExist(c.workDir())
+	if err != nil {
+		return nil, errors.New("unable to create the work directory: " + err.Error())
+	}
+
+	err = ioutil.WriteFile(c.snapshotPath(), data, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+// restoreSnapshot imports the snapshot stored by SaveSnapshot in the work directory, if any.
+func (s *Server) restoreSnapshot() {
+	path := s.Config.snapshotPath()
+	if !doesPathExists(path) {
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		s.log().Errorln("Unable to open the saved snapshot:", err)
+		return
+	}
+
+	defer f.Close()
+
+	err = s.Import(f)
+	if err != nil {
+		s.log().Errorln("Unable to restore the saved snapshot:", err)
+		return
+	}
+
+	s.log().Infoln("Restored the cluster snapshot saved at", path)
+}
+
+// snapshotPath returns the path where SaveSnapshot stores the snapshot.
+func (c Config) snapshotPath() string {
+	return filepath.Join(c.workDir(), snapshotFileName)
+}
+
+// decodeSnapshot reads a snapshot written by Export from r, checking its format version.
+func decodeSnapshot(r io.Reader) (snapshot, error) {
+	var snap snapshot
+
+	err := json.NewDecoder(r).Decode(&snap)
+	if err != nil {
+		return snapshot{}, err
+	}
+
+	if snap.FormatVersion != snapshotFormatVersion {
+		return snapshot{}, errors.New("unsupported snapshot format version")
+	}
+
+	return snap, nil
+}
+
+// nodes returns the nodes in the snapshot.
+func (snap snapshot) nodes() (Nodes, error) {
+	nodes := make(Nodes, 0, len(snap.Nodes))
+	for _, ns := range snap.Nodes {
+		ip := net.ParseIP(ns.IP)
+		if ip == nil {
+			return nil, errors.New("invalid node IP in snapshot: " + ns.IP)
+		}
+
+		nodes = append(nodes, Node{
+			Addr:   &net.TCPAddr{IP: ip, Port: ns.Port},
+			Name:   ns.Name,
+			Status: ns.Status,
+			Info:   ns.Info,
+			Labels: ns.Info.Labels,
+		})
+	}
+
+	return nodes, nil
+}
+
+// mergeHistory adds the records not already present in the server's execution history, keeping it ordered by start
+// time and within Config.ExecutionHistorySize.
+func (s *Server) mergeHistory(h ExecutionHistory) {
+	if s.Config.ExecutionHistorySize <= 0 {
+		return
+	}
+
+	s.historyLock.Lock()
+	defer s.historyLock.Unlock()
+
+	known := make(map[string]bool, len(s.history))
+	for _, r := range s.history {
+		known[r.TaskUUID] = true
+	}
+
+	for _, r := range h {
+		if !known[r.TaskUUID] {
+			s.history = append(s.history, r)
+		}
+	}
+
+	sort.SliceStable(s.history, func(i, j int) bool {
+		return s.history[i].StartedAt.Before(s.history[j].StartedAt)
+	})
+
+	if len(s.history) > s.Config.ExecutionHistorySize {
+		s.history = s.history[len(s.history)-s.Config.ExecutionHistorySize:]
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"go.opentelemetry.io/otel/trace/noop"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestServer_ExportImport(t *testing.T) {
+	s := &Server{Config: Config{ExecutionHistorySize: 10, TLSPrivateKey: []byte("secret"), Token: "passphrase",
+		TokenFile: "/etc/beekeeper/token", TracerProvider: noop.NewTracerProvider()},
+		nodes: getTestNodes()}
+	s.addExecutionRecord(ExecutionRecord{TaskUUID: "1", StartedAt: time.Now()})
+
+	buf := &bytes.Buffer{}
+
+	err := s.Export(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if bytes.Contains(buf.Bytes(), []byte("secret")) {
+		t.Error("private key exported")
+		return
+	}
+
+	if bytes.Contains(buf.Bytes(), []byte("passphrase")) {
+		t.Error("token exported")
+		return
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte("/etc/beekeeper/token")) {
+		t.Error("token file not exported")
+		return
+	}
+
+	s2 := &Server{Config: Config{ExecutionHistorySize: 10}}
+	s2.addExecutionRecord(ExecutionRecord{TaskUUID: "2", StartedAt: time.Now()})
+
+	err = s2.Import(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(s2.nodes) != len(getTestNodes()) || s2.nodes[0].Name != "testWorker1" {
+		t.Error("nodes not imported")
+		return
+	}
+
+	history := s2.History()
+	if len(history) != 2 || history[0].TaskUUID != "1" {
+		t.Error("history not merged:", history)
+		return
+	}
+}
+
+func TestSaveSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beekeeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Server{Config: Config{ExecutionHistorySize: 10}, nodes: getTestNodes()}
+
+	buf := &bytes.Buffer{}
+	err = s.Export(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewDefaultConfig()
+	c.WorkDir = dir
+
+	nodes, err := SaveSnapshot(c, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nodes) != len(getTestNodes()) {
+		t.Error("unexpected snapshot nodes:", len(nodes))
+	}
+
+	s2 := NewServer(c)
+	if len(s2.Nodes()) != len(getTestNodes()) {
+		t.Error("saved snapshot not restored:", len(s2.Nodes()))
+	}
+
+	_, err = SaveSnapshot(c, bytes.NewBufferString(`{"format_version": 0}`))
+	if err == nil {
+		t.Error("expected error")
+	}
+}
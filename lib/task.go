@@ -33,6 +33,73 @@ type Task struct {
 	Arguments map[string]interface{}
 	Returns   map[string]interface{}
 	Error     string
+
+	// AffinityNodeIP is the IP of the node that should run the task. When set it takes precedence over the node
+	// selected by Execute or a LoadBalancer.
+	AffinityNodeIP string
+
+	// ResourceHints holds the resources a node needs to run the task.
+	ResourceHints ResourceHints
+
+	// MaxOutputBytes is the maximum size in bytes of the result read from the job. Bigger results are discarded and
+	// the job is killed. Defaults to the node's Config.MaxMessageSize.
+	MaxOutputBytes uint64
+
+	// Tags categorize the task. They are kept in the execution history, see Server.HistoryByTag.
+	Tags []string
+
+	// OnComplete is called in its own goroutine with the Result once the task succeeds. It's not sent to the nodes,
+	// as gob skips func fields, nor JSON encoded.
+	OnComplete func(Result) `json:"-"`
+
+	// OnError is called in its own goroutine with the error once the task fails. It's not sent to the nodes, as gob
+	// skips func fields, nor JSON encoded.
+	OnError func(error) `json:"-"`
+}
+
+// ResourceHints holds the resources required by a task. Zero values mean no requirement.
+type ResourceHints struct {
+	// MinFreeMemBytes is the minimum free memory of the node, in bytes.
+	MinFreeMemBytes uint64
+
+	// MaxCPUUsagePercent is the maximum CPU usage of the node, as a percentage.
+	MaxCPUUsagePercent float32
+
+	// RequiredOS is the GOOS the node must run.
+	RequiredOS string
+}
+
+// suits reports if the node has the required resources.
+func (h ResourceHints) suits(n Node) bool {
+	if h.MinFreeMemBytes > 0 && n.Info.MemFree < h.MinFreeMemBytes {
+		return false
+	}
+
+	if h.MaxCPUUsagePercent > 0 && n.Info.Usage > h.MaxCPUUsagePercent {
+		return false
+	}
+
+	return h.compatible(n)
+}
+
+// compatible reports if the node runs the required OS.
+func (h ResourceHints) compatible(n Node) bool {
+	return h.RequiredOS == "" || n.Info.OS == h.RequiredOS
+}
+
+// complete calls the OnComplete or OnError callback, if set, depending on the outcome of the task.
+func (t Task) complete(res Result, err error) {
+	if err != nil {
+		if t.OnError != nil {
+			go t.OnError(err)
+		}
+
+		return
+	}
+
+	if t.OnComplete != nil {
+		go t.OnComplete(res)
+	}
 }
 
 // NewTask creates a Task, initializes and then returns it.
@@ -0,0 +1,114 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestResourceHints_suits(t *testing.T) {
+	n := Node{Info: NodeInfo{OS: "linux", Usage: 50, MemFree: 1 << 30}}
+
+	cases := []struct {
+		hints ResourceHints
+		suits bool
+	}{
+		{ResourceHints{}, true},
+		{ResourceHints{MinFreeMemBytes: 1 << 29, MaxCPUUsagePercent: 60, RequiredOS: "linux"}, true},
+		{ResourceHints{MinFreeMemBytes: 1 << 31}, false},
+		{ResourceHints{MaxCPUUsagePercent: 40}, false},
+		{ResourceHints{RequiredOS: "windows"}, false},
+	}
+
+	for _, c := range cases {
+		if c.hints.suits(n) != c.suits {
+			t.Error("unexpected result for hints", c.hints)
+		}
+	}
+}
+
+func TestReadTaskData(t *testing.T) {
+	task := NewTask()
+	task.ResourceHints.RequiredOS = "linux"
+
+	data, err := task.encode()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !bytes.ContainsRune(data, '\n') {
+		t.Error("expected the encoded task to contain a line break")
+	}
+
+	input := append([]byte(fmt.Sprintf("%d\n", len(data))), data...)
+
+	read, err := readTaskData(bufio.NewReader(bytes.NewReader(input)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	decoded, err := decodeTask(read)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if decoded.ResourceHints.RequiredOS != "linux" {
+		t.Error("unexpected task:", decoded)
+	}
+}
+
+func TestTask_callbacksNotEncoded(t *testing.T) {
+	task := NewTask()
+	task.Arguments["n"] = 1
+	task.OnComplete = func(Result) {}
+	task.OnError = func(error) {}
+
+	data, err := task.encode()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	decoded, err := decodeTask(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if decoded.OnComplete != nil || decoded.OnError != nil {
+		t.Error("callbacks decoded")
+		return
+	}
+
+	_, err = json.Marshal(task)
+	if err != nil {
+		t.Error(err)
+	}
+}
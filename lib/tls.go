@@ -24,8 +24,10 @@ package beekeeper
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
@@ -38,17 +40,101 @@ import (
 	"github.com/pkg/errors"
 )
 
+// ErrTLSCAConfigured is returned when trying to replace the TLS certificate by a self-signed one while Config.TLSCA is
+// set, as other nodes would reject it.
+var ErrTLSCAConfigured = errors.New("the TLS certificate must be signed by TLSCA")
+
+// certRotationDays is the amount of days before the TLS certificate expiry at which it gets automatically replaced.
+const certRotationDays = 7
+
+// FIPSCipherSuites returns the NIST-approved TLS 1.2 cipher suites, as required by FIPS 140-2. It's meant to be used
+// as Config.TLSCipherSuites.
+func FIPSCipherSuites() []uint16 {
+	return []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}
+}
+
+// newTLSConfig returns the TLS configuration used by the listener and the dialer. If Config.TLSCipherSuites is set
+// TLS 1.3 is disabled, as its cipher suites can't be configured, so only the listed suites are offered.
+func newTLSConfig(c Config, cert tls.Certificate) *tls.Config {
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, InsecureSkipVerify: true}
+
+	if len(c.TLSCipherSuites) > 0 {
+		tlsConfig.CipherSuites = c.TLSCipherSuites
+		tlsConfig.MaxVersion = tls.VersionTLS12
+	}
+
+	applyTLSCA(c, tlsConfig)
+
+	return tlsConfig
+}
+
+// applyTLSCA makes both ends of the connections require a certificate signed by Config.TLSCA, if set. Nodes are
+// dialed by IP and their certificates aren't expected to name it, so the dialer checks the chain of the remote
+// certificate but not its host name. An unparsable CA leaves the pool empty, so every connection is rejected.
+func applyTLSCA(c Config, tlsConfig *tls.Config) {
+	if c.TLSCA == nil {
+		return
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(c.TLSCA)
+
+	tlsConfig.RootCAs = pool
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		return verifyCertChain(rawCerts, pool)
+	}
+}
+
+// verifyCertChain checks that the first of the DER encoded certificates is signed by one in the pool, possibly
+// through the rest of them.
+func verifyCertChain(rawCerts [][]byte, pool *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no certificate provided")
+	}
+
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return errors.Wrap(err, "parse error")
+		}
+
+		certs[i] = cert
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+
+	return err
+}
+
+// tlsHomeDir returns the directory where the TLS cache folder is kept. It's a variable to allow for testing.
+var tlsHomeDir = homedir.Dir
+
 // getTLSCache fetches the TLS cert and key if they are present in the home directory cache. If none is found an error
 // is returned.
 func getTLSCache() (pemCert []byte, pemKey []byte, err error) {
-	homeDir, err := homedir.Dir()
+	homeDir, err := tlsHomeDir()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	folderPath := filepath.FromSlash(homeDir + "/.beekeeper")
-	certPath := filepath.FromSlash(folderPath + "/tls.cert")
-	keyPath := filepath.FromSlash(folderPath + "/tls.key")
+	_, certPath, keyPath := tlsCachePaths(homeDir)
 
 	if !doesPathExists(certPath) || !doesPathExists(keyPath) {
 		return nil, nil, errors.New("not found")
@@ -67,16 +153,21 @@ func getTLSCache() (pemCert []byte, pemKey []byte, err error) {
 	return pemCert, pemKey, nil
 }
 
+// tlsCachePaths returns the paths of the TLS cache folder, and the cert and key files inside the given home directory.
+func tlsCachePaths(homeDir string) (folderPath, certPath, keyPath string) {
+	folderPath = filepath.Join(homeDir, beekeeperFolder)
+
+	return folderPath, filepath.Join(folderPath, "tls.cert"), filepath.Join(folderPath, "tls.key")
+}
+
 // saveTLS stores the cert and key in the home directory cache.
 func saveTLS(pemCert []byte, pemKey []byte) (err error) {
-	homeDir, err := homedir.Dir()
+	homeDir, err := tlsHomeDir()
 	if err != nil {
 		return err
 	}
 
-	folderPath := filepath.FromSlash(homeDir + "/.beekeeper")
-	certPath := filepath.FromSlash(folderPath + "/tls.cert")
-	keyPath := filepath.FromSlash(folderPath + "/tls.key")
+	folderPath, certPath, keyPath := tlsCachePaths(homeDir)
 
 	err = createFolderIfNotExist(folderPath)
 	if err != nil {
@@ -96,25 +187,100 @@ func saveTLS(pemCert []byte, pemKey []byte) (err error) {
 	return nil
 }
 
-// newSelfSignedCert creates a self_signed certificate and key.
+// newSelfSignedCert creates a self_signed certificate and key valid for two years.
 func newSelfSignedCert() (pemCert []byte, pemKey []byte, err error) {
-	bits := 4096
-	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+	return newSelfSignedCertValidFor(time.Now(), time.Now().AddDate(2, 0, 0))
+}
+
+// newSelfSignedCertValidFor creates a self_signed certificate and key valid between notBefore and notAfter.
+func newSelfSignedCertValidFor(notBefore, notAfter time.Time) (pemCert []byte, pemKey []byte, err error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tpl := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Beekeeper Server"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+
+	return createCert(&tpl, &tpl, privateKey, privateKey)
+}
+
+// NewTLSCA creates a CA certificate and key valid for ten years, PEM encoded. The certificate is meant to be used as
+// Config.TLSCA, and the key to sign the certificates of the nodes with NewCASignedCertificate. The key should be kept
+// apart from the nodes.
+func NewTLSCA() (pemCert []byte, pemKey []byte, err error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	tpl := x509.Certificate{
 		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Beekeeper CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+
+	return createCert(&tpl, &tpl, privateKey, privateKey)
+}
+
+// NewCASignedCertificate creates a certificate and key valid for two years, signed by the given PEM encoded CA
+// certificate and key, such as the ones created by NewTLSCA. They are meant to be used as Config.TLSCertificate and
+// Config.TLSPrivateKey on nodes using Config.TLSCA, and are PEM encoded too.
+func NewCASignedCertificate(pemCACert, pemCAKey []byte) (pemCert []byte, pemKey []byte, err error) {
+	ca, err := tls.X509KeyPair(pemCACert, pemCAKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid CA certificate or private key")
+	}
+
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parse error")
+	}
+
+	caKey, ok := ca.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("unsupported CA private key")
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Every certificate signed by the same CA needs its own serial number
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tpl := x509.Certificate{
+		SerialNumber:          serial,
 		Subject:               pkix.Name{CommonName: "Beekeeper Server"},
 		NotBefore:             time.Now(),
 		NotAfter:              time.Now().AddDate(2, 0, 0),
 		BasicConstraintsValid: true,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
 	}
 
-	derCert, err := x509.CreateCertificate(rand.Reader, &tpl, &tpl, &privateKey.PublicKey, privateKey)
+	return createCert(&tpl, caCert, privateKey, caKey)
+}
+
+// createCert signs the template with the parent certificate and key, and returns it with the private key, PEM encoded.
+func createCert(tpl, parent *x509.Certificate, privateKey *rsa.PrivateKey, parentKey crypto.Signer) (pemCert []byte,
+	pemKey []byte, err error) {
+	derCert, err := x509.CreateCertificate(rand.Reader, tpl, parent, &privateKey.PublicKey, parentKey)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -142,3 +308,75 @@ func newSelfSignedCert() (pemCert []byte, pemKey []byte, err error) {
 
 	return pemCert, pemKey, nil
 }
+
+// getCertExpiry parses a PEM encoded certificate and returns its expiry date.
+func getCertExpiry(pemCert []byte) (time.Time, error) {
+	block, _ := pem.Decode(pemCert)
+	if block == nil {
+		return time.Time{}, errors.New("no PEM data found")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "parse error")
+	}
+
+	return cert.NotAfter, nil
+}
+
+// RotateTLSCertificate replaces the server's TLS certificate and key with a new self-signed pair and stores them in
+// the home directory cache. Only connections created afterwards will use the new certificate. Certificates signed by
+// Config.TLSCA can't be replaced this way, so ErrTLSCAConfigured is returned if it's set.
+func (s *Server) RotateTLSCertificate() error {
+	if s.Config.TLSCA != nil {
+		return ErrTLSCAConfigured
+	}
+
+	pemCert, pemKey, err := newSelfSignedCert()
+	if err != nil {
+		return errors.Wrap(err, "unable to create TLS certificate")
+	}
+
+	err = saveTLS(pemCert, pemKey)
+	if err != nil {
+		return errors.Wrap(err, "unable to save TLS certificate")
+	}
+
+	s.Config.TLSCertificate = pemCert
+	s.Config.TLSPrivateKey = pemKey
+
+	return nil
+}
+
+// checkTLSExpiry logs a warning if the server's TLS certificate expires within Config.CertExpiryWarningDays days, and
+// rotates it if it expires within certRotationDays days. Only the cached self-signed certificate is rotated; the ones
+// given in the Config, such as those signed by Config.TLSCA, are left for the user to replace.
+func (s *Server) checkTLSExpiry() {
+	if s.Config.TLSCertificate == nil {
+		return
+	}
+
+	expiry, err := getCertExpiry(s.Config.TLSCertificate)
+	if err != nil {
+		s.log().Errorln("Unable to read TLS certificate expiry:", err)
+		return
+	}
+
+	remaining := time.Until(expiry)
+	if remaining > time.Duration(s.Config.CertExpiryWarningDays)*24*time.Hour {
+		return
+	}
+
+	s.log().Warnln("The TLS certificate expires on", expiry.Format(time.RFC1123))
+
+	if !s.cachedTLS || remaining > certRotationDays*24*time.Hour {
+		return
+	}
+
+	s.log().Infoln("Rotating TLS certificate. This can take a while")
+
+	err = s.RotateTLSCertificate()
+	if err != nil {
+		s.log().Errorln("Unable to rotate TLS certificate:", err)
+	}
+}
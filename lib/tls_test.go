@@ -0,0 +1,337 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_CheckTLSExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beekeeper")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	defaultHomeDir := tlsHomeDir
+	tlsHomeDir = func() (string, error) {
+		return dir, nil
+	}
+	defer func() {
+		tlsHomeDir = defaultHomeDir
+	}()
+
+	pemCert, pemKey, err := newSelfSignedCertValidFor(time.Now().AddDate(0, 0, -10), time.Now().AddDate(0, 0, -1))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	out := &bytes.Buffer{}
+	s := &Server{Config: Config{TLSCertificate: pemCert, TLSPrivateKey: pemKey, CertExpiryWarningDays: 30},
+		cachedTLS: true}
+	s.LogTo(out)
+	s.checkTLSExpiry()
+
+	if !strings.Contains(out.String(), "expires") {
+		t.Error("expiry warning not logged")
+		return
+	}
+
+	expiry, err := getCertExpiry(s.Config.TLSCertificate)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if expiry.Before(time.Now()) {
+		t.Error("certificate was not rotated")
+		return
+	}
+
+	cached, _, err := getTLSCache()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !bytes.Equal(cached, s.Config.TLSCertificate) {
+		t.Error("rotated certificate not cached")
+	}
+}
+
+func TestNewTLSConfig_CipherSuites(t *testing.T) {
+	pemCert, pemKey, err := newSelfSignedCert()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	cert, err := tls.X509KeyPair(pemCert, pemKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	suite := tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", newTLSConfig(Config{TLSCipherSuites: []uint16{suite}}, cert))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_ = conn.(*tls.Conn).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), newTLSConfig(Config{TLSCipherSuites: FIPSCipherSuites()}, cert))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if state.CipherSuite != suite {
+		t.Error("unexpected cipher suite:", state.CipherSuite)
+	}
+
+	if state.Version != tls.VersionTLS12 {
+		t.Error("unexpected TLS version:", state.Version)
+	}
+}
+
+func TestNewTLSConfig_CA(t *testing.T) {
+	caCert, caKey, err := NewTLSCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverCert, serverKey, err := NewCASignedCertificate(caCert, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientCert, clientKey, err := NewCASignedCertificate(caCert, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	foreignCert, foreignKey, err := newSelfSignedCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Config{TLSCA: caCert}
+
+	serverPair, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", newTLSConfig(c, serverPair))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		name      string
+		cert, key []byte
+		config    Config
+		ok        bool
+	}{
+		{"signed", clientCert, clientKey, c, true},
+		{"foreign client", foreignCert, foreignKey, c, false},
+		{"foreign client without CA", foreignCert, foreignKey, Config{}, false},
+		{"foreign server", clientCert, clientKey, Config{TLSCA: foreignCert}, false},
+	}
+
+	for _, test := range tests {
+		pair, err := tls.X509KeyPair(test.cert, test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := tls.Dial("tcp", l.Addr().String(), newTLSConfig(test.config, pair))
+		if err == nil {
+			// The server may only reject the client certificate after the client finishes its side of the handshake
+			_, err = conn.Read(make([]byte, 1))
+			if err == io.EOF {
+				err = nil
+			}
+
+			_ = conn.Close()
+		}
+
+		if (err == nil) != test.ok {
+			t.Errorf("%s: unexpected handshake result: %v", test.name, err)
+		}
+	}
+}
+
+func TestConfig_ValidateTLSCA(t *testing.T) {
+	c := NewDefaultConfig()
+	c.TLSCA = []byte("not a certificate")
+
+	if c.Validate() == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestServer_RotateTLSCertificateWithCA(t *testing.T) {
+	s := &Server{Config: Config{TLSCA: []byte("ca")}}
+
+	if err := s.RotateTLSCertificate(); err != ErrTLSCAConfigured {
+		t.Error("expected ErrTLSCAConfigured, got", err)
+	}
+}
+
+func TestNewServer_TLSCAWithoutCertificate(t *testing.T) {
+	caCert, _, err := NewTLSCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewDefaultConfig()
+	c.TLSCA = caCert
+
+	s := NewServer(c)
+	if s.Config.TLSCertificate != nil {
+		t.Error("certificate not signed by TLSCA used")
+	}
+
+	if s.Config.Validate() == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestServer_checkTLSExpiryWithCA(t *testing.T) {
+	pemCert, pemKey, err := newSelfSignedCertValidFor(time.Now().AddDate(0, 0, -10), time.Now().AddDate(0, 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	s := &Server{Config: Config{TLSCertificate: pemCert, TLSPrivateKey: pemKey, TLSCA: pemCert,
+		CertExpiryWarningDays: 30}}
+	s.LogTo(out)
+	s.checkTLSExpiry()
+
+	if !strings.Contains(out.String(), "expires") {
+		t.Error("expiry warning not logged")
+	}
+
+	if strings.Contains(out.String(), "Rotating") {
+		t.Error("rotation attempted:", out.String())
+	}
+
+	if !bytes.Equal(s.Config.TLSCertificate, pemCert) {
+		t.Error("certificate signed by TLSCA replaced")
+	}
+}
+
+func TestServer_checkTLSExpiryUserCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beekeeper-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defaultHomeDir := tlsHomeDir
+	tlsHomeDir = func() (string, error) {
+		return dir, nil
+	}
+	defer func() {
+		tlsHomeDir = defaultHomeDir
+	}()
+
+	pemCert, pemKey, err := newSelfSignedCertValidFor(time.Now().AddDate(0, 0, -10), time.Now().AddDate(0, 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	s := &Server{Config: Config{TLSCertificate: pemCert, TLSPrivateKey: pemKey, CertExpiryWarningDays: 30}}
+	s.LogTo(out)
+	s.checkTLSExpiry()
+
+	if !strings.Contains(out.String(), "expires") {
+		t.Error("expiry warning not logged")
+	}
+
+	if !bytes.Equal(s.Config.TLSCertificate, pemCert) {
+		t.Error("user-supplied certificate replaced")
+	}
+
+	if _, _, err := getTLSCache(); err == nil {
+		t.Error("user-supplied certificate rotated into the cache")
+	}
+}
+
+func TestNewSelfSignedCert_notCA(t *testing.T) {
+	pemCert, _, err := newSelfSignedCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(pemCert)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.IsCA {
+		t.Error("self-signed node certificate is a CA")
+	}
+}
@@ -0,0 +1,301 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// HashAlgorithm is the algorithm used to hash the token stored in Config.Token
+type HashAlgorithm int
+
+const (
+	// HashPlain means the token is stored as plain text
+	HashPlain HashAlgorithm = iota
+	// HashBcrypt means the token is stored as a bcrypt hash
+	HashBcrypt
+	// HashArgon2id means the token is stored as an Argon2id hash, encoded in the PHC string format
+	HashArgon2id
+)
+
+// Argon2id parameters used when hashing a token. They follow the recommendations of RFC 9106.
+const (
+	argon2Time    = 1
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// maxTokenCompares is the maximum amount of hashed token comparisons run at once. Every Argon2id comparison takes
+// argon2Memory KiB, so unauthenticated nodes must not be able to start an unbounded amount of them.
+const maxTokenCompares = 4
+
+// tokenCompareSlots is a semaphore limiting the hashed token comparisons run at once to maxTokenCompares. It's shared
+// by every server in the process, as they share the memory.
+var tokenCompareSlots = make(chan struct{}, maxTokenCompares)
+
+// ErrAmbiguousToken is produced when both Config.Token and Config.TokenFile are set
+var ErrAmbiguousToken = errors.New("both Token and TokenFile are set")
+
+// ErrUnknownHashAlgorithm is produced when a token is hashed or compared with an unsupported HashAlgorithm
+var ErrUnknownHashAlgorithm = errors.New("unknown hash algorithm")
+
+// ErrInvalidTokenHash is produced when a stored token hash can't be parsed
+var ErrInvalidTokenHash = errors.New("invalid token hash")
+
+// String returns the name of the algorithm.
+func (a HashAlgorithm) String() string {
+	switch a {
+	case HashPlain:
+		return "plain"
+	case HashBcrypt:
+		return "bcrypt"
+	case HashArgon2id:
+		return "argon2id"
+	default:
+		return "unknown"
+	}
+}
+
+// HashToken returns a copy of the Config with Token replaced by its hash, using TokenHashAlgorithm. The returned
+// Config is meant for nodes, as a node with a hashed token can only reply using the plain token received from the
+// primary node. Nothing is done if TokenHashAlgorithm is HashPlain.
+func (c Config) HashToken() (Config, error) {
+	hash, err := hashToken(c.Token, c.TokenHashAlgorithm)
+	if err != nil {
+		return Config{}, err
+	}
+
+	c.Token = hash
+	return c, nil
+}
+
+// hashToken hashes a plain text token with the given algorithm.
+func hashToken(token string, alg HashAlgorithm) (string, error) {
+	switch alg {
+	case HashPlain:
+		return token, nil
+	case HashBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(token), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
+
+		return string(hash), nil
+	case HashArgon2id:
+		salt := make([]byte, argon2SaltLen)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return "", err
+		}
+
+		key := argon2.IDKey([]byte(token), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time,
+			argon2Threads, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	default:
+		return "", ErrUnknownHashAlgorithm
+	}
+}
+
+// compareToken checks a plain text token against a stored one, hashed with the given algorithm.
+func compareToken(token, stored string, alg HashAlgorithm) (bool, error) {
+	switch alg {
+	case HashPlain:
+		return subtle.ConstantTimeCompare([]byte(token), []byte(stored)) == 1, nil
+	case HashBcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(token))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+
+		if err != nil {
+			return false, fmt.Errorf("%w: %s", ErrInvalidTokenHash, err.Error())
+		}
+
+		return true, nil
+	case HashArgon2id:
+		return compareArgon2id(token, stored)
+	default:
+		return false, ErrUnknownHashAlgorithm
+	}
+}
+
+// compareArgon2id checks a plain text token against an Argon2id hash in the PHC string format.
+func compareArgon2id(token, stored string) (bool, error) {
+	// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, ErrInvalidTokenHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return false, fmt.Errorf("%w: unsupported version", ErrInvalidTokenHash)
+	}
+
+	var memory, time uint32
+	var threads uint8
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrInvalidTokenHash, err.Error())
+	}
+
+	if memory == 0 || time == 0 || threads == 0 {
+		return false, fmt.Errorf("%w: parameters can't be zero", ErrInvalidTokenHash)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrInvalidTokenHash, err.Error())
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrInvalidTokenHash, err.Error())
+	}
+
+	// An empty key would match any token
+	if len(salt) == 0 || len(key) == 0 {
+		return false, fmt.Errorf("%w: empty salt or key", ErrInvalidTokenHash)
+	}
+
+	other := argon2.IDKey([]byte(token), salt, time, memory, threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// connAuth holds the plain token authenticated on a connection. It's shared by all the requests received through the
+// same connection, so that a hashed Config.Token is only compared once per connection, and so that the token can be
+// used for replies. It's dropped along with the connection.
+type connAuth struct {
+	lock     sync.Mutex
+	token    string
+	verified bool
+}
+
+// matches reports if the token was already authenticated on the connection. A nil connAuth matches no token.
+func (a *connAuth) matches(token string) bool {
+	if a == nil {
+		return false
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.verified && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+// store keeps the authenticated token.
+func (a *connAuth) store(token string) {
+	a.lock.Lock()
+	a.token = token
+	a.verified = true
+	a.lock.Unlock()
+}
+
+// plain returns the authenticated token, or an empty string if there's none. It's safe to call on a nil connAuth.
+func (a *connAuth) plain() string {
+	if a == nil {
+		return ""
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.token
+}
+
+// authenticate checks the token of an incoming message against Config.Token. When the token is hashed, the result is
+// kept in the auth of the connection the message was received on, if any, so that later messages skip the hashing. Up
+// to maxTokenCompares hashed tokens are compared at once, and the rest wait for their turn.
+func (s *Server) authenticate(msg Message, auth *connAuth) bool {
+	if s.Config.TokenHashAlgorithm == HashPlain {
+		return msg.isTokenMatching(s.Config.Token)
+	}
+
+	if auth.matches(msg.Token) {
+		return true
+	}
+
+	tokenCompareSlots <- struct{}{}
+	matching, err := compareToken(msg.Token, s.Config.Token, s.Config.TokenHashAlgorithm)
+	<-tokenCompareSlots
+
+	if err != nil {
+		s.log().Errorln("Unable to compare token:", err)
+		return false
+	}
+
+	if matching && auth != nil {
+		auth.store(msg.Token)
+	}
+
+	return matching
+}
+
+// outgoingToken returns the plain token to be sent through the connection, which may be nil. If Config.Token is hashed,
+// the token authenticated on the connection is used, and an empty string is returned if there's none.
+func (s *Server) outgoingToken(c *Conn) string {
+	if s.Config.TokenHashAlgorithm == HashPlain {
+		return s.Config.Token
+	}
+
+	if c == nil {
+		return ""
+	}
+
+	return c.auth.plain()
+}
+
+// readTokenFile returns the Config with the Token read from Config.TokenFile, if set. Surrounding whitespace, like the
+// trailing newline of most secret files, is trimmed.
+func readTokenFile(c Config) (Config, error) {
+	if c.TokenFile == "" {
+		return c, nil
+	}
+
+	if c.Token != "" {
+		return c, ErrAmbiguousToken
+	}
+
+	data, err := ioutil.ReadFile(c.TokenFile)
+	if err != nil {
+		return c, err
+	}
+
+	c.Token = strings.TrimSpace(string(data))
+
+	return c, nil
+}
@@ -0,0 +1,217 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfig_HashToken(t *testing.T) {
+	for _, alg := range []HashAlgorithm{HashPlain, HashBcrypt, HashArgon2id} {
+		c := NewDefaultConfig()
+		c.Token = "test_token"
+		c.TokenHashAlgorithm = alg
+
+		hashed, err := c.HashToken()
+		if err != nil {
+			t.Error(alg, err)
+			return
+		}
+
+		if alg != HashPlain && hashed.Token == c.Token {
+			t.Error(alg, "token not hashed")
+			return
+		}
+
+		matching, err := compareToken("test_token", hashed.Token, alg)
+		if err != nil || !matching {
+			t.Error(alg, "token not matching:", err)
+			return
+		}
+
+		matching, err = compareToken("wrong_token", hashed.Token, alg)
+		if err != nil || matching {
+			t.Error(alg, "wrong token matching:", err)
+			return
+		}
+	}
+}
+
+func TestCompareArgon2idInvalid(t *testing.T) {
+	for _, stored := range []string{
+		"$argon2id$v=19$m=65536,t=1,p=4$c2FsdHNhbHQ$",         // Empty key
+		"$argon2id$v=19$m=65536,t=1,p=4$$a2V5a2V5",            // Empty salt
+		"$argon2id$v=19$m=65536,t=1,p=0$c2FsdHNhbHQ$a2V5a2V5", // No threads
+		"$argon2id$v=19$m=0,t=1,p=4$c2FsdHNhbHQ$a2V5a2V5",     // No memory
+		"$argon2id$v=19$m=65536,t=0,p=4$c2FsdHNhbHQ$a2V5a2V5", // No iterations
+	} {
+		matching, err := compareToken("any_token", stored, HashArgon2id)
+		if !errors.Is(err, ErrInvalidTokenHash) || matching {
+			t.Error("invalid hash accepted:", stored, matching, err)
+		}
+	}
+}
+
+func TestServer_authenticate(t *testing.T) {
+	c := NewDefaultConfig()
+	c.Token = "test_token"
+	c.TokenHashAlgorithm = HashArgon2id
+
+	c, err := c.HashToken()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s := NewServer(c)
+	auth := &connAuth{}
+	conn := &Conn{auth: auth}
+
+	if s.outgoingToken(conn) != "" {
+		t.Error("outgoing token set before authentication")
+		return
+	}
+
+	if s.authenticate(Message{Token: "wrong_token"}, auth) {
+		t.Error("wrong token authenticated")
+		return
+	}
+
+	if !s.authenticate(Message{Token: "test_token"}, auth) {
+		t.Error("token not authenticated")
+		return
+	}
+
+	if !auth.matches("test_token") || auth.matches("wrong_token") {
+		t.Error("authentication not cached on the connection")
+		return
+	}
+
+	if s.outgoingToken(conn) != "test_token" {
+		t.Error("unexpected outgoing token", s.outgoingToken(conn))
+		return
+	}
+
+	if s.outgoingToken(&Conn{}) != "" {
+		t.Error("token used outside the connection it was authenticated on")
+		return
+	}
+}
+
+func TestServer_TokenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beekeeper")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "token")
+	err = ioutil.WriteFile(path, []byte("  secret\n"), 0600)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	c := NewDefaultConfig()
+	c.TokenFile = path
+
+	s := NewServer(c)
+	if s.Config.Token != "secret" {
+		t.Errorf("expected token %q, got %q", "secret", s.Config.Token)
+	}
+
+	c.Token = "other"
+
+	err = NewServer(c).Start()
+	if err != ErrAmbiguousToken {
+		t.Error("expected ErrAmbiguousToken, got", err)
+	}
+
+	c.Token = ""
+	c.TokenFile = filepath.Join(dir, "missing")
+
+	err = NewServer(c).Start()
+	if !os.IsNotExist(err) {
+		t.Error("expected a missing file error, got", err)
+	}
+}
+
+func TestServer_handleInvalidToken(t *testing.T) {
+	c := NewDefaultConfig()
+	c.Token = "test_token"
+	c.TokenHashAlgorithm = HashBcrypt
+
+	c, err := c.HashToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(c)
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	go s.handle(server, nil)
+
+	msg := newMessage()
+	msg.Token = "wrong_token"
+
+	data, err := msg.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		_, _ = client.Write(append([]byte(fmt.Sprintf("%d\n", len(data))), data...))
+	}()
+
+	closed := make(chan error, 1)
+	go func() {
+		_, err := client.Read(make([]byte, 1))
+		closed <- err
+	}()
+
+	select {
+	case err := <-closed:
+		if err != io.EOF {
+			t.Error("unexpected error:", err)
+		}
+	case req := <-s.queue:
+		t.Error("message with an invalid token queued:", req.Msg.summary())
+	case <-time.After(time.Second * 5):
+		t.Error("connection not closed")
+	}
+
+	if len(tokenCompareSlots) != 0 {
+		t.Error("token comparison slot not released")
+	}
+}
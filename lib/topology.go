@@ -0,0 +1,176 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Topology queries every known node for the nodes it can see, and returns an adjacency map using the IPs of the
+// nodes. Edges are undirected, so if a node sees another both are listed as reachable from each other. Nodes that
+// don't respond within DefaultScanTime are left out.
+func (s *Server) Topology() map[string][]string {
+	s.nodesLock.RLock()
+	nodes := make(Nodes, len(s.nodes))
+	copy(nodes, s.nodes)
+	s.nodesLock.RUnlock()
+
+	topology := make(map[string][]string)
+	var topologyLock sync.Mutex
+
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+
+		go func(node Node) {
+			defer wg.Done()
+
+			reachable, err := s.queryTopology(node, DefaultScanTime)
+			if err != nil {
+				s.log().Debugln("Unable to query the topology of node", node.Name, ":", err)
+				return
+			}
+
+			ip := node.Addr.IP.String()
+
+			topologyLock.Lock()
+			defer topologyLock.Unlock()
+
+			if _, ok := topology[ip]; !ok {
+				topology[ip] = []string{}
+			}
+
+			for _, r := range reachable {
+				addTopologyEdge(topology, ip, r)
+			}
+		}(node)
+	}
+
+	wg.Wait()
+
+	return topology
+}
+
+// TopologyDOT returns the cluster topology, as given by Topology, in the Graphviz DOT format.
+func (s *Server) TopologyDOT() string {
+	return topologyToDOT(s.Topology())
+}
+
+// queryTopology asks a node for the IPs of the nodes it knows and blocks until they are received.
+func (s *Server) queryTopology(n Node, timeout time.Duration) ([]string, error) {
+	msg, err := s.query(n, Message{Operation: OperationTopologyQuery}, OperationTopologyReport, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeTopologyReport(msg.Data)
+}
+
+// topologyQueryCallback is the callback for the TopologyQuery operation.
+func topologyQueryCallback(s *Server, conn *Conn, _ Message) {
+	s.nodesLock.RLock()
+	ips := []string{}
+	for _, node := range s.nodes {
+		ips = append(ips, node.Addr.IP.String())
+	}
+	s.nodesLock.RUnlock()
+
+	msg, err := Message{Operation: OperationTopologyReport}.setData(ips)
+	if err != nil {
+		s.log().Errorln("Unable to encode topology report:", err)
+		return
+	}
+
+	err = s.sendWithConn(conn, msg)
+	if err != nil {
+		s.log().Errorln("Unable to respond to a topology query:", err)
+		return
+	}
+}
+
+// decodeTopologyReport decodes the gob encoded IPs sent in a TopologyReport.
+func decodeTopologyReport(data []byte) ([]string, error) {
+	var ips []string
+
+	err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&ips)
+	if err != nil {
+		return nil, err
+	}
+
+	return ips, nil
+}
+
+// addTopologyEdge adds an undirected edge between a and b, if not already present.
+func addTopologyEdge(topology map[string][]string, a, b string) {
+	if a == b {
+		return
+	}
+
+	for _, ip := range topology[a] {
+		if ip == b {
+			return
+		}
+	}
+
+	topology[a] = append(topology[a], b)
+	topology[b] = append(topology[b], a)
+}
+
+// topologyToDOT formats an undirected adjacency map in the Graphviz DOT format. The output is sorted so it's stable
+// between calls.
+func topologyToDOT(topology map[string][]string) string {
+	var ips []string
+	for ip := range topology {
+		ips = append(ips, ip)
+	}
+
+	sort.Strings(ips)
+
+	var sb strings.Builder
+	sb.WriteString("graph beekeeper {\n")
+
+	for _, ip := range ips {
+		sb.WriteString(fmt.Sprintf("\t%q;\n", ip))
+	}
+
+	for _, ip := range ips {
+		reachable := append([]string{}, topology[ip]...)
+		sort.Strings(reachable)
+
+		for _, r := range reachable {
+			if ip < r { // Undirected, so every edge is written once
+				sb.WriteString(fmt.Sprintf("\t%q -- %q;\n", ip, r))
+			}
+		}
+	}
+
+	sb.WriteString("}\n")
+
+	return sb.String()
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTopologyToDOT(t *testing.T) {
+	topology := make(map[string][]string)
+
+	addTopologyEdge(topology, "192.168.1.2", "192.168.1.1")
+	addTopologyEdge(topology, "192.168.1.1", "192.168.1.2") // Duplicated
+	addTopologyEdge(topology, "192.168.1.1", "192.168.1.3")
+
+	expect := "graph beekeeper {\n" +
+		"\t\"192.168.1.1\";\n" +
+		"\t\"192.168.1.2\";\n" +
+		"\t\"192.168.1.3\";\n" +
+		"\t\"192.168.1.1\" -- \"192.168.1.2\";\n" +
+		"\t\"192.168.1.1\" -- \"192.168.1.3\";\n" +
+		"}\n"
+
+	dot := topologyToDOT(topology)
+	if dot != expect {
+		t.Errorf("unexpected DOT output:\n%s", dot)
+		return
+	}
+}
+
+func TestTopologyQueryCallback(t *testing.T) {
+	sv, _, sendChan := startPrimaryTestChannels()
+
+	msg := getTestMessage()
+	msg.Operation = OperationTopologyQuery
+
+	go sv.handleMessage(&Conn{Conn: nil}, msg)
+
+	select {
+	case response := <-sendChan:
+		if response.Operation != OperationTopologyReport {
+			t.Fail()
+			return
+		}
+
+		_, err := decodeTopologyReport(response.Data)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	case <-time.After(time.Second):
+		t.Fail()
+		return
+	}
+}
+
+func TestServer_queryTopologyTimeout(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	_ = s.SetSendCallback(func(*Server, *Conn, Message) error {
+		return nil
+	})
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	_, err := s.queryTopology(getTestNodes()[0], time.Millisecond*10)
+	if err != ErrTimeout {
+		t.Error("expected ErrTimeout, got", err)
+	}
+
+	s.awaitedLock.Lock()
+	awaited := len(s.awaited)
+	s.awaitedLock.Unlock()
+
+	if awaited != 0 {
+		t.Error("awaitables left after the timeout", awaited)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"context"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the OpenTelemetry tracer used by Beekeeper.
+const tracerName = "github.com/CamiloHernandez/beekeeper/lib"
+
+// Span names used by Beekeeper.
+const (
+	// SpanExecute is the span of a task on the primary node, from sending it until its result is received.
+	SpanExecute = "beekeeper.Execute"
+
+	// SpanRunJob is the span of a task run by a worker node.
+	SpanRunJob = "beekeeper.RunJob"
+)
+
+// encodeTraceContext serializes the W3C traceparent and tracestate headers into the trace context of a Message.
+func encodeTraceContext(traceparent, tracestate string) []byte {
+	if traceparent == "" {
+		return nil
+	}
+
+	if tracestate == "" {
+		return []byte(traceparent)
+	}
+
+	return []byte(traceparent + "\n" + tracestate)
+}
+
+// decodeTraceContext returns the W3C traceparent and tracestate headers of a trace context made by
+// encodeTraceContext.
+func decodeTraceContext(traceContext []byte) (traceparent, tracestate string) {
+	i := bytes.IndexByte(traceContext, '\n')
+	if i < 0 {
+		return string(traceContext), ""
+	}
+
+	return string(traceContext[:i]), string(traceContext[i+1:])
+}
+
+// startSpan starts a span with the Config.TracerProvider, as a child of the parent trace context. The parent is nil
+// for root spans. It returns the trace context of the new span, to be propagated to the node, and a function that
+// ends the span with the outcome of the operation. If no TracerProvider is set nothing is done, and the returned
+// function does nothing.
+func (s *Server) startSpan(name string, kind trace.SpanKind, parent []byte) (traceContext []byte, end func(err error)) {
+	if s.Config.TracerProvider == nil {
+		return nil, func(error) {}
+	}
+
+	propagator := propagation.TraceContext{}
+
+	carrier := propagation.MapCarrier{}
+	carrier["traceparent"], carrier["tracestate"] = decodeTraceContext(parent)
+
+	ctx := propagator.Extract(context.Background(), carrier)
+	ctx, span := s.Config.TracerProvider.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(kind))
+
+	carrier = propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+
+	return encodeTraceContext(carrier.Get("traceparent"), carrier.Get("tracestate")), func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		span.End()
+	}
+}
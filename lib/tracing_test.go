@@ -0,0 +1,167 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net"
+	"testing"
+	"time"
+)
+
+// parentTraceparent is the W3C traceparent of the remote parent span used in tests.
+const parentTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func newTestTracerProvider() (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	return sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)), recorder
+}
+
+func TestEncodeTraceContext(t *testing.T) {
+	for _, tc := range []struct {
+		traceparent, tracestate string
+	}{
+		{parentTraceparent, "vendor=value"},
+		{parentTraceparent, ""},
+	} {
+		traceparent, tracestate := decodeTraceContext(encodeTraceContext(tc.traceparent, tc.tracestate))
+		if traceparent != tc.traceparent || tracestate != tc.tracestate {
+			t.Error("unexpected trace context:", traceparent, tracestate)
+		}
+	}
+
+	if traceContext := encodeTraceContext("", ""); traceContext != nil {
+		t.Error("expected no trace context, got", string(traceContext))
+	}
+}
+
+func TestServer_startSpanDisabled(t *testing.T) {
+	s := NewServer(NewDefaultConfig())
+
+	traceContext, end := s.startSpan(SpanExecute, trace.SpanKindClient, nil)
+	end(nil)
+
+	if traceContext != nil {
+		t.Error("unexpected trace context:", string(traceContext))
+	}
+}
+
+func TestServer_ExecuteTracing(t *testing.T) {
+	provider, recorder := newTestTracerProvider()
+
+	config := NewDefaultConfig()
+	config.TracerProvider = provider
+
+	s := NewServer(config)
+
+	sent := make(chan Message, 1)
+	_ = s.SetSendCallback(func(_ *Server, _ *Conn, m Message) error {
+		sent <- m
+		return nil
+	})
+	_ = s.SetConnCallback(func(*Server, string, ...time.Duration) (*Conn, error) {
+		return &Conn{}, nil
+	})
+
+	n := Node{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.1")}}
+	_, err := s.Execute(n, NewTask(), time.Millisecond*10)
+	if err == nil {
+		t.Fatal("expected a timeout")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatal("expected one ended span, got", len(spans))
+	}
+
+	span := spans[0]
+	if span.Name() != SpanExecute || span.SpanKind() != trace.SpanKindClient || span.Parent().IsValid() {
+		t.Error("unexpected span:", span.Name(), span.SpanKind(), span.Parent())
+	}
+
+	if span.Status().Code != codes.Error {
+		t.Error("expected an error status, got", span.Status())
+	}
+
+	msg := <-sent
+	traceparent, _ := decodeTraceContext(msg.TraceContext)
+	want := "00-" + span.SpanContext().TraceID().String() + "-" + span.SpanContext().SpanID().String() + "-01"
+	if traceparent != want {
+		t.Error("trace context not propagated:", string(msg.TraceContext))
+	}
+}
+
+func TestJobExecuteCallbackTracing(t *testing.T) {
+	provider, recorder := newTestTracerProvider()
+
+	config := NewDefaultConfig()
+	config.TracerProvider = provider
+	config.DryRun = true
+
+	s := NewServer(config)
+
+	sent := make(chan Message, 1)
+	_ = s.SetSendCallback(func(_ *Server, _ *Conn, m Message) error {
+		sent <- m
+		return nil
+	})
+
+	data, err := Task{UUID: "traced-task"}.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := getTestMessage()
+	msg.Operation = OperationJobExecute
+	msg.Data = data
+	msg.TraceContext = encodeTraceContext(parentTraceparent, "vendor=value")
+
+	jobExecuteCallback(s, &Conn{}, msg)
+
+	if res := <-sent; res.Operation != OperationJobResult {
+		t.Error("unexpected operation", res.Operation)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatal("expected one ended span, got", len(spans))
+	}
+
+	span := spans[0]
+	if span.Name() != SpanRunJob || span.SpanKind() != trace.SpanKindServer || span.Status().Code == codes.Error {
+		t.Error("unexpected span:", span.Name(), span.SpanKind(), span.Status())
+	}
+
+	parent := span.Parent()
+	if !parent.IsRemote() || parent.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		parent.SpanID().String() != "00f067aa0ba902b7" || parent.TraceState().Get("vendor") != "value" {
+		t.Error("unexpected parent:", parent)
+	}
+
+	if span.SpanContext().TraceID() != parent.TraceID() {
+		t.Error("span not in the parent's trace:", span.SpanContext().TraceID())
+	}
+}
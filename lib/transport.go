@@ -0,0 +1,49 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+)
+
+// TransportType is the protocol used for connections between nodes.
+type TransportType int
+
+const (
+	// TransportTCP uses TLS over TCP connections
+	TransportTCP TransportType = iota
+
+	// TransportQUIC uses QUIC streams. Only available when building with the quic build tag
+	TransportQUIC
+)
+
+// quicNextProto is the ALPN protocol negotiated by QUIC connections.
+const quicNextProto = "beekeeper"
+
+// ErrTransportUnavailable is produced when the configured transport was not included in the build
+var ErrTransportUnavailable = errors.New("transport not available in this build")
+
+// String returns a string representation of the TransportType.
+func (t TransportType) String() string {
+	return []string{"TCP", "QUIC"}[t]
+}
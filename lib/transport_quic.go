@@ -0,0 +1,175 @@
+//go:build quic
+// +build quic
+
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+)
+
+// quicConn wraps a QUIC stream and its connection as a net.Conn.
+type quicConn struct {
+	quic.Stream
+	conn quic.Connection
+}
+
+// LocalAddr returns the local address of the QUIC connection.
+func (c *quicConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the QUIC connection.
+func (c *quicConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// quicListener wraps a QUIC listener as a net.Listener.
+type quicListener struct {
+	*quic.Listener
+}
+
+// Accept waits for a new QUIC connection and returns its first stream as a net.Conn.
+func (l quicListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := conn.AcceptStream(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return &quicConn{Stream: stream, conn: conn}, nil
+}
+
+// quicConnCallback opens a QUIC stream with the ip.
+func quicConnCallback(s *Server, ip string, timeout ...time.Duration) (*Conn, error) {
+	cert, err := tls.X509KeyPair(s.Config.TLSCertificate, s.Config.TLSPrivateKey)
+	if err != nil {
+		s.log().Fatalln("Failed to parse TLS certificate")
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: true,
+		NextProtos:         []string{quicNextProto},
+	}
+	applyTLSCA(s.Config, tlsConfig)
+
+	ctx := context.Background()
+	if len(timeout) > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout[0])
+		defer cancel()
+	}
+
+	conn, err := quic.DialAddr(ctx, setOutPortIfMissing(ip, s.Config.OutboundPort), tlsConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	qConn := &quicConn{Stream: stream, conn: conn}
+
+	c := &Conn{
+		stream:       qConn,
+		limiter:      newRateLimiter(s.Config.SendBytesPerSecond),
+		capabilities: &capabilitySet{},
+	}
+
+	go s.handle(qConn, c.limiter) // Be prepared to receive on this conn
+
+	if len(s.Config.Capabilities) > 0 {
+		err = s.handshake(c)
+		if err != nil {
+			_ = stream.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// quicServeCallback listens for QUIC connections and handles every stream opened on them.
+func quicServeCallback(s *Server) error {
+	cer, err := tls.X509KeyPair(s.Config.TLSCertificate, s.Config.TLSPrivateKey)
+	if err != nil {
+		s.log().Fatal(errors.Wrap(err, "invalid tls certificate or private key"))
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates:       []tls.Certificate{cer},
+		InsecureSkipVerify: true,
+		NextProtos:         []string{quicNextProto},
+	}
+	applyTLSCA(s.Config, tlsConfig)
+
+	l, err := quic.ListenAddr(":"+strconv.Itoa(s.Config.InboundPort), tlsConfig, nil)
+	if err != nil {
+		return s.listenError(err)
+	}
+
+	s.setListener(quicListener{l})
+
+	go func() {
+		for {
+			conn, err := l.Accept(context.Background())
+			if err != nil {
+				s.log().Errorln("Received invalid connection:", err)
+				continue
+			}
+
+			if !s.isAllowedPeer(toTCPAddr(conn.RemoteAddr()).IP) {
+				_ = conn.CloseWithError(0, "not allowed")
+				continue
+			}
+
+			go func() {
+				for {
+					stream, err := conn.AcceptStream(context.Background())
+					if err != nil {
+						return
+					}
+
+					go s.handle(&quicConn{Stream: stream, conn: conn}, newRateLimiter(s.Config.SendBytesPerSecond))
+				}
+			}()
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// typedValueKey is the key of Task.Arguments and Task.Returns holding the value set by Task.SetArgs and
+// Task.SetReturns.
+const typedValueKey = "beekeeper_typed_value"
+
+// typedTypeKey is the key of Task.Arguments and Task.Returns holding the name of the type of the typed value. It's used
+// to name both types when the value can't be decoded.
+const typedTypeKey = "beekeeper_typed_type"
+
+// ErrNoTypedValue is produced when decoding a typed value that was never set
+var ErrNoTypedValue = errors.New("no typed value set")
+
+// TypedTask is a Task run by a job taking arguments of type In and returning a value of type Out, see WrapTypedJob.
+// The Task is embedded, and sent as is, so typed and untyped tasks share the wire format. In order to create a
+// TypedTask use NewTypedTask; not this structure directly.
+type TypedTask[In any, Out any] struct {
+	Task
+
+	// Args are the arguments of the task. They're gob encoded into the Arguments of the Task by Encode.
+	Args In
+}
+
+// NewTypedTask creates a TypedTask with the given arguments.
+func NewTypedTask[In any, Out any](args In) TypedTask[In, Out] {
+	return TypedTask[In, Out]{Task: NewTask(), Args: args}
+}
+
+// Encode returns the Task to be sent to the node, with the Args gob encoded into its Arguments like Task.SetArgs does.
+// The Arguments of the TypedTask itself are kept unchanged.
+func (t TypedTask[In, Out]) Encode() (Task, error) {
+	task := t.Task
+
+	task.Arguments = make(map[string]interface{}, len(t.Arguments)+1)
+	for k, v := range t.Arguments {
+		task.Arguments[k] = v
+	}
+
+	err := task.SetArgs(t.Args)
+	if err != nil {
+		return Task{}, err
+	}
+
+	return task, nil
+}
+
+// Decode decodes the value returned by the job from the Result of the task. If the job returned no value
+// ErrNoTypedValue is returned.
+func (t TypedTask[In, Out]) Decode(res Result) (Out, error) {
+	var out Out
+
+	err := res.DecodeReturns(&out)
+	if err != nil {
+		return out, err
+	}
+
+	return out, nil
+}
+
+// SetArgs gob encodes v, usually a struct, into the Arguments of the Task. The job reads it back with DecodeArgs into
+// a value of the same type, which saves the type assertions on every argument. Values held in interface fields must
+// be registered with gob.Register. The rest of the Arguments are kept.
+func (t *Task) SetArgs(v interface{}) error {
+	if t.Arguments == nil {
+		t.Arguments = make(map[string]interface{})
+	}
+
+	return setTypedValue(t.Arguments, v)
+}
+
+// DecodeArgs decodes the value set by SetArgs into v, which must be a pointer to a value of the same type. If no
+// value was set ErrNoTypedValue is returned.
+func (t Task) DecodeArgs(v interface{}) error {
+	return decodeTypedValue(t.Arguments, v)
+}
+
+// SetReturns gob encodes v into the Returns of the Task, to be read by the primary node with Result.DecodeReturns. It's
+// the typed counterpart of SetArgs, meant to be called by the job.
+func (t *Task) SetReturns(v interface{}) error {
+	if t.Returns == nil {
+		t.Returns = make(map[string]interface{})
+	}
+
+	return setTypedValue(t.Returns, v)
+}
+
+// DecodeReturns decodes the value set by the job with Task.SetReturns into v, which must be a pointer to a value of
+// the same type. If no value was set ErrNoTypedValue is returned.
+func (r Result) DecodeReturns(v interface{}) error {
+	return decodeTypedValue(r.Task.Returns, v)
+}
+
+// setTypedValue gob encodes v into the typed value key of m.
+func setTypedValue(m map[string]interface{}, v interface{}) error {
+	var buf bytes.Buffer
+
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return err
+	}
+
+	m[typedValueKey] = buf.Bytes()
+	m[typedTypeKey] = fmt.Sprintf("%T", v)
+
+	return nil
+}
+
+// decodeTypedValue decodes the typed value key of m into v. As gob matches fields by name, the value can be decoded
+// into any type with compatible fields. Otherwise the returned error names the expected and actual types.
+func decodeTypedValue(m map[string]interface{}, v interface{}) error {
+	data, ok := m[typedValueKey].([]byte)
+	if !ok {
+		return ErrNoTypedValue
+	}
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+	if err != nil {
+		actual, _ := m[typedTypeKey].(string)
+		if actual == "" {
+			actual = "unknown"
+		}
+
+		expected := strings.TrimPrefix(fmt.Sprintf("%T", v), "*")
+		return fmt.Errorf("unable to decode typed value: expected %s, got %s: %w", expected, actual, err)
+	}
+
+	return nil
+}
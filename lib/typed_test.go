@@ -0,0 +1,187 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testArgs struct {
+	Name   string
+	Values []int
+}
+
+type testReturns struct {
+	Sum int
+}
+
+func TestTask_SetArgs(t *testing.T) {
+	task := NewTask()
+	task.Arguments["untyped"] = 1
+
+	args := testArgs{Name: "sum", Values: []int{1, 2, 3}}
+	err := task.SetArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The task goes through the wire to the job
+	data, err := task.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received, err := decodeTask(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded testArgs
+	err = received.DecodeArgs(&decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, args) {
+		t.Error("unexpected arguments:", decoded)
+	}
+
+	if received.Arguments["untyped"] != 1 {
+		t.Error("untyped argument lost")
+	}
+
+	err = received.SetReturns(testReturns{Sum: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var returns testReturns
+	err = Result{Task: received}.DecodeReturns(&returns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if returns.Sum != 6 {
+		t.Error("unexpected returns:", returns)
+	}
+}
+
+func TestTask_DecodeArgsMissing(t *testing.T) {
+	var args testArgs
+	if err := (Task{}).DecodeArgs(&args); err != ErrNoTypedValue {
+		t.Error("expected ErrNoTypedValue, got", err)
+	}
+
+	var returns testReturns
+	if err := (Result{}).DecodeReturns(&returns); err != ErrNoTypedValue {
+		t.Error("expected ErrNoTypedValue, got", err)
+	}
+}
+
+func TestTypedTask(t *testing.T) {
+	task := NewTypedTask[testArgs, testReturns](testArgs{Name: "sum", Values: []int{1, 2, 3}})
+
+	encoded, err := task.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(task.Arguments) != 0 {
+		t.Error("arguments of the typed task changed:", task.Arguments)
+	}
+
+	// The task goes through the wire to the job
+	data, err := encoded.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received, err := decodeTask(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := typedJob(func(args testArgs) (testReturns, error) {
+		sum := 0
+		for _, v := range args.Values {
+			sum += v
+		}
+
+		return testReturns{Sum: sum}, nil
+	})(received)
+
+	returns, err := task.Decode(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if returns.Sum != 6 {
+		t.Error("unexpected returns:", returns)
+	}
+}
+
+func TestTypedJob_errors(t *testing.T) {
+	job := typedJob(func(testArgs) (testReturns, error) {
+		return testReturns{}, errors.New("job failed")
+	})
+
+	encoded, err := NewTypedTask[testArgs, testReturns](testArgs{}).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res := job(encoded); res.Error != "job failed" {
+		t.Error("expected the job error, got", res.Error)
+	}
+
+	res := job(NewTask())
+	if res.RemoteError == nil || res.RemoteError.Code != ErrorCodeInvalidTask {
+		t.Error("expected an invalid task error, got", res.RemoteError)
+	}
+}
+
+type testMismatchedArgs struct {
+	Name int
+}
+
+func TestTask_DecodeArgsMismatch(t *testing.T) {
+	task := NewTask()
+
+	err := task.SetArgs(testArgs{Name: "sum"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var args testMismatchedArgs
+	err = task.DecodeArgs(&args)
+	if err == nil {
+		t.Fatal("expected an error decoding mismatched arguments")
+	}
+
+	if !strings.Contains(err.Error(), "expected beekeeper.testMismatchedArgs, got beekeeper.testArgs") {
+		t.Error("types not named in the error:", err)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// minMessageSize is the smallest MaxMessageSize accepted. Smaller limits reject even empty messages.
+const minMessageSize = 100
+
+// ValidationError lists the problems found while validating a Config.
+type ValidationError struct {
+	Errors []error
+}
+
+// Error lists every problem found.
+func (e *ValidationError) Error() string {
+	details := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		details[i] = err.Error()
+	}
+
+	return "invalid config: " + strings.Join(details, "; ")
+}
+
+// Unwrap returns the problems found.
+func (e *ValidationError) Unwrap() []error {
+	return e.Errors
+}
+
+// Validate checks the Config for values that are out of range or inconsistent with each other. If any are found a
+// *ValidationError listing all of them is returned.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(isValidPort(c.InboundPort), "InboundPort %d is not a valid port", c.InboundPort)
+	check(isValidPort(c.OutboundPort), "OutboundPort %d is not a valid port", c.OutboundPort)
+
+	check(c.MaxMessageSize >= minMessageSize, "MaxMessageSize must be at least %d bytes, got %d",
+		minMessageSize, c.MaxMessageSize)
+
+	check((c.TLSCertificate == nil) == (c.TLSPrivateKey == nil),
+		"TLSCertificate and TLSPrivateKey must be set together")
+	check(c.TLSCA == nil || c.TLSCertificate != nil, "TLSCertificate and TLSPrivateKey must be set when TLSCA is set")
+	check(c.TLSCA == nil || x509.NewCertPool().AppendCertsFromPEM(c.TLSCA), "TLSCA is not a PEM encoded certificate")
+
+	check(c.Transport == TransportTCP || c.Transport == TransportQUIC, "unknown Transport %d", c.Transport)
+	check(c.Compression >= CompressionGzip && c.Compression <= CompressionNone, "unknown Compression %d",
+		c.Compression)
+
+	check(c.TokenHashAlgorithm >= HashPlain && c.TokenHashAlgorithm <= HashArgon2id,
+		"unknown TokenHashAlgorithm %d", c.TokenHashAlgorithm)
+	check(c.TokenHashAlgorithm == HashPlain || c.Token != "", "TokenHashAlgorithm is set but Token is empty")
+
+	check(c.NodeErrorHistorySize >= 0, "NodeErrorHistorySize can't be negative")
+	check(c.ExecutionHistorySize >= 0, "ExecutionHistorySize can't be negative")
+	check(c.MaxConcurrentTasksPerNode >= 0, "MaxConcurrentTasksPerNode can't be negative")
+	check(c.WorkerConcurrency >= 0, "WorkerConcurrency can't be negative")
+	check(c.BroadcastConcurrency >= 0, "BroadcastConcurrency can't be negative")
+	check(c.ScanConcurrency >= 0, "ScanConcurrency can't be negative")
+	check(c.IPv6PrefixLength >= 0 && c.IPv6PrefixLength <= 128, "IPv6PrefixLength %d is not a valid prefix length",
+		c.IPv6PrefixLength)
+	check(c.MaxNodesPerScan >= 0, "MaxNodesPerScan can't be negative")
+	check(c.BuildParallelism >= 0, "BuildParallelism can't be negative")
+	check(c.IdleConnsPerNode >= 0, "IdleConnsPerNode can't be negative")
+
+	if c.BroadcastSubnet != "" {
+		_, subnet, err := net.ParseCIDR(c.BroadcastSubnet)
+		check(err == nil, "BroadcastSubnet %q is not in CIDR notation", c.BroadcastSubnet)
+		if err == nil {
+			ones, bits := subnet.Mask.Size()
+			check(bits != 8*net.IPv4len || bits-ones <= maxSweepBits, "BroadcastSubnet %s is too large",
+				c.BroadcastSubnet)
+		}
+	}
+
+	check(c.HeartbeatInterval >= 0, "HeartbeatInterval can't be negative")
+	check(c.PerMessageReadTimeout >= 0, "PerMessageReadTimeout can't be negative")
+	check(c.IdleConnTimeout >= 0, "IdleConnTimeout can't be negative")
+	check(c.BackoffBase >= 0, "BackoffBase can't be negative")
+	check(c.BackoffBase == 0 || c.BackoffBase <= c.BackoffMax, "BackoffBase %s is greater than BackoffMax %s",
+		c.BackoffBase, c.BackoffMax)
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+
+	return nil
+}
+
+// isValidPort checks if port is in the TCP port range. Port 0 lets the system choose one.
+func isValidPort(port int) bool {
+	return port >= 0 && port <= 65535
+}
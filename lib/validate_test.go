@@ -0,0 +1,68 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	c := NewDefaultConfig()
+
+	err := c.Validate()
+	if err != nil {
+		t.Error("default config rejected:", err)
+		return
+	}
+
+	c.InboundPort = -1
+	c.MaxMessageSize = 10
+	c.TLSCertificate = []byte("cert")
+	c.Compression = CompressionNone + 1
+	c.BroadcastSubnet = "10.0.0.0/8"
+
+	err = c.Validate()
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Error("expected a ValidationError, got", err)
+		return
+	}
+
+	if len(validationErr.Unwrap()) != 5 {
+		t.Error("unexpected problems:", validationErr)
+	}
+}
+
+func TestServer_StartInvalidConfig(t *testing.T) {
+	c := NewDefaultConfig()
+	c.BackoffMax = c.BackoffBase / 2
+
+	s := NewServer(c)
+
+	var validationErr *ValidationError
+	if err := s.Start(); !errors.As(err, &validationErr) {
+		t.Error("expected a ValidationError, got", err)
+	}
+}
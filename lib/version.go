@@ -0,0 +1,63 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"runtime"
+)
+
+// gitCommit and buildTime are set at build time with:
+//
+//	go build -ldflags "-X github.com/CamiloHernandez/beekeeper/lib.gitCommit=<commit>
+//		-X github.com/CamiloHernandez/beekeeper/lib.buildTime=<time>"
+//
+// They are "unknown" otherwise.
+var (
+	gitCommit = "unknown"
+	buildTime = "unknown"
+)
+
+// VersionDetail holds the version information of the package and the binary using it.
+type VersionDetail struct {
+	// Semver is the version of the package in semantic notation. It's the same as Version.
+	Semver string
+
+	// GitCommit is the commit the binary was built from, or "unknown" if it wasn't set at build time.
+	GitCommit string
+
+	// BuildTime is the time the binary was built at, or "unknown" if it wasn't set at build time.
+	BuildTime string
+
+	// GoVersion is the version of Go the binary was built with.
+	GoVersion string
+}
+
+// VersionInfo returns the version information of the package and the binary using it.
+func VersionInfo() VersionDetail {
+	return VersionDetail{
+		Semver:    Version,
+		GitCommit: gitCommit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	}
+}
@@ -27,7 +27,7 @@ import (
 )
 
 // startConnectionWatchdog will periodically clear the online workers list and broadcastOperation a new status Request to
-// refill it.
+// refill it. See Server.clearNodes.
 func startConnectionWatchdog(s *Server, terminate chan bool) {
 	for {
 		select {
@@ -36,10 +36,10 @@ func startConnectionWatchdog(s *Server, terminate chan bool) {
 		default:
 			time.Sleep(WatchdogSleep)
 
-			s.nodes = Nodes{}
+			s.clearNodes()
 			err := s.broadcastOperation(OperationStatus, false)
 			if err != nil {
-				logger.Errorln("Unable to broadcast from watchdog:", err.Error())
+				s.log().Errorln("Unable to broadcast from watchdog:", err.Error())
 			}
 		}
 	}
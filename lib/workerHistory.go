@@ -0,0 +1,129 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+)
+
+// ExecutionSummary is a short description of a task run by a node, as reported to Server.QueryNodeHistory.
+type ExecutionSummary struct {
+	// UUID is the UUID of the task.
+	UUID string
+
+	// StartedAt is the time at which the node started running the task.
+	StartedAt time.Time
+
+	// Duration is the time the node took to run the task.
+	Duration time.Duration
+
+	// Error is whether the task failed.
+	Error bool
+}
+
+// QueryNodeHistory asks the node for the tasks it ran recently and blocks until they are received. At most limit
+// summaries are returned, from oldest to newest. A limit of 0 or less returns every summary kept by the node, see
+// Config.WorkerHistorySize. Optionally a timeout argument can be passed.
+func (s *Server) QueryNodeHistory(n Node, limit int, timeout ...time.Duration) ([]ExecutionSummary, error) {
+	msg, err := Message{Operation: OperationQueryHistory}.setData(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.query(n, msg, OperationHistoryResponse, timeout...)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeExecutionSummaries(res.Data)
+}
+
+// queryHistoryCallback is the callback for the QueryHistory operation.
+func queryHistoryCallback(s *Server, conn *Conn, msg Message) {
+	var limit int
+
+	err := gob.NewDecoder(bytes.NewBuffer(msg.Data)).Decode(&limit)
+	if err != nil {
+		s.log().Errorln("Unable to read history query:", err)
+		return
+	}
+
+	res, err := Message{Operation: OperationHistoryResponse}.setData(s.executionSummaries(limit))
+	if err != nil {
+		s.log().Errorln("Unable to encode history:", err)
+		return
+	}
+
+	err = s.sendWithConn(conn, res)
+	if err != nil {
+		s.log().Errorln("Unable to respond to a history query:", err)
+		return
+	}
+}
+
+// executionSummaries returns the latest tasks run by this node, from oldest to newest. A limit of 0 or less returns
+// all of them.
+func (s *Server) executionSummaries(limit int) []ExecutionSummary {
+	s.workerHistoryLock.RLock()
+	defer s.workerHistoryLock.RUnlock()
+
+	history := s.workerHistory
+	if limit > 0 && len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+
+	summaries := make([]ExecutionSummary, len(history))
+	copy(summaries, history)
+
+	return summaries
+}
+
+// addExecutionSummary stores a task run by this node, discarding the oldest one when Config.WorkerHistorySize is
+// exceeded.
+func (s *Server) addExecutionSummary(summary ExecutionSummary) {
+	if s.Config.WorkerHistorySize <= 0 {
+		return
+	}
+
+	s.workerHistoryLock.Lock()
+	defer s.workerHistoryLock.Unlock()
+
+	s.workerHistory = append(s.workerHistory, summary)
+	if len(s.workerHistory) > s.Config.WorkerHistorySize {
+		s.workerHistory = s.workerHistory[len(s.workerHistory)-s.Config.WorkerHistorySize:]
+	}
+}
+
+// decodeExecutionSummaries decodes the gob encoded ExecutionSummary list sent in a HistoryResponse.
+func decodeExecutionSummaries(data []byte) ([]ExecutionSummary, error) {
+	var summaries []ExecutionSummary
+
+	err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&summaries)
+	if err != nil {
+		return nil, err
+	}
+
+	return summaries, nil
+}
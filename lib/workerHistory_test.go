@@ -0,0 +1,112 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueryHistoryCallback(t *testing.T) {
+	sv, _, sendChan := startPrimaryTestChannels()
+
+	for _, uuid := range []string{"first", "second", "third"} {
+		sv.addExecutionSummary(ExecutionSummary{UUID: uuid, StartedAt: time.Now()})
+	}
+
+	msg, err := getTestMessage().setData(2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	msg.Operation = OperationQueryHistory
+
+	go sv.handleMessage(&Conn{Conn: nil}, msg)
+
+	select {
+	case response := <-sendChan:
+		if response.Operation != OperationHistoryResponse {
+			t.Fail()
+			return
+		}
+
+		summaries, err := decodeExecutionSummaries(response.Data)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(summaries) != 2 || summaries[0].UUID != "second" || summaries[1].UUID != "third" {
+			t.Error("unexpected summaries", summaries)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fail()
+	}
+}
+
+func TestServer_QueryNodeHistory(t *testing.T) {
+	sv, receiveChan, sendChan := startPrimaryTestChannels()
+
+	type result struct {
+		summaries []ExecutionSummary
+		err       error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		summaries, err := sv.QueryNodeHistory(getTestNodes()[0], 10, time.Second)
+		done <- result{summaries, err}
+	}()
+
+	select {
+	case query := <-sendChan:
+		if query.Operation != OperationQueryHistory {
+			t.Error("unexpected operation", query.Operation)
+			return
+		}
+	case <-time.After(time.Second):
+		t.Error("query not sent")
+		return
+	}
+
+	msg, err := getTestMessage().setData([]ExecutionSummary{{UUID: "remote", Error: true}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	msg.Operation = OperationHistoryResponse
+	msg.Token = sv.Config.Token
+	receiveChan <- Request{Msg: msg, Conn: Conn{}}
+
+	res := <-done
+	if res.err != nil {
+		t.Error(res.err)
+		return
+	}
+
+	if len(res.summaries) != 1 || res.summaries[0].UUID != "remote" || !res.summaries[0].Error {
+		t.Error("unexpected summaries", res.summaries)
+	}
+}
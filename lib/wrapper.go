@@ -24,15 +24,60 @@ package beekeeper
 
 import (
 	"bufio"
-	"fmt"
+	"errors"
+	"io"
 	"os"
+	"strconv"
 )
 
 // WrapJob wraps a job function with input and output parsing to transfer the Result. The provided function must never
-// use STDIO.
+// use STDIO. Results bigger than Config.MaxInlineResultSize are transferred through a temporary file, see
+// WrapJobToFile. It's the wrapper used by the jobs built by DistributeJob.
 func WrapJob(job func(*Task)) {
-	reader := bufio.NewReader(os.Stdin)
-	input, err := reader.ReadBytes('\n')
+	WrapJobToFile(func(t Task) Result {
+		job(&t)
+		return Result{Task: t}
+	}, "")
+}
+
+// WrapTypedJob wraps a typed job function like WrapJob. The job receives the arguments of a TypedTask decoded as In,
+// and the value it returns is sent back to be decoded by TypedTask.Decode. If it returns an error the Result's Error
+// is set instead. The provided function must never use STDIO. It's the wrapper used by the jobs built by
+// DistributeTypedJob. The signature of the job is checked at compile time, and arguments that can't be decoded as In
+// are reported with both types in the Result's RemoteError.
+func WrapTypedJob[In any, Out any](job func(In) (Out, error)) {
+	WrapJobToFile(typedJob(job), "")
+}
+
+// typedJob adapts a typed job function to the func(Task) Result taken by WrapJobToFile.
+func typedJob[In any, Out any](job func(In) (Out, error)) func(Task) Result {
+	return func(t Task) Result {
+		var args In
+
+		err := t.DecodeArgs(&args)
+		if err != nil {
+			return newRemoteErrorResult(&RemoteError{Code: ErrorCodeInvalidTask, Message: err.Error()})
+		}
+
+		returns, err := job(args)
+		if err == nil {
+			err = t.SetReturns(returns)
+		}
+
+		if err != nil {
+			t.Error = err.Error()
+		}
+
+		return Result{Task: t}
+	}
+}
+
+// WrapJobToFile wraps a job function like WrapJob, but results bigger than Config.MaxInlineResultSize are written to
+// a file in a new temporary folder inside the path folder and only the file's path is transferred. Smaller results are
+// kept in memory and sent inline. If path is empty the default temporary folder is used. The provided function must
+// never use STDIO.
+func WrapJobToFile(job func(Task) Result, path string) {
+	input, err := readTaskData(bufio.NewReader(os.Stdin))
 	if err != nil {
 		newErrorResult(err).printEncode()
 		return
@@ -40,17 +85,46 @@ func WrapJob(job func(*Task)) {
 
 	t, err := decodeTask(input)
 	if err != nil {
-		newErrorResult(err).printEncode()
+		newRemoteErrorResult(&RemoteError{Code: ErrorCodeInvalidTask, Message: err.Error()}).printEncode()
 		return
 	}
 
 	defer func() {
 		if r := recover(); r != nil {
-			newErrorResult(fmt.Errorf("%s", r)).printEncode()
+			newRemoteErrorResult(newPanicRemoteError(r)).printEncode()
 		}
 	}()
 
-	job(&t)
+	res := job(t)
+	res.UUID = t.UUID
+
+	var maxInline uint64 = defaultMaxInlineResultSize
+	if env, err := strconv.ParseUint(os.Getenv(maxInlineResultSizeEnv), 10, 64); err == nil {
+		maxInline = env
+	}
+
+	res.printEncodeToFile(path, maxInline)
+}
+
+// readTaskData reads the encoded Task sent by runLocalJob. It's preceded by a header with its length, as the encoded
+// data may contain line breaks.
+func readTaskData(reader *bufio.Reader) ([]byte, error) {
+	header, _, err := reader.ReadLine()
+	if err != nil {
+		return nil, errors.New("error reading task header: " + err.Error())
+	}
+
+	dataLen, err := strconv.Atoi(string(header))
+	if err != nil {
+		return nil, errors.New("error parsing task header: " + err.Error())
+	}
+
+	data := make([]byte, dataLen)
+
+	_, err = io.ReadFull(reader, data)
+	if err != nil {
+		return nil, errors.New("unable to read task data: " + err.Error())
+	}
 
-	Result{UUID: t.UUID, Task: t}.printEncode()
+	return data, nil
 }
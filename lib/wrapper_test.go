@@ -0,0 +1,91 @@
+/*
+ * Copyright © 2020 Camilo Hernández <me@camiloh.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package beekeeper
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestWrapJobLargeResult(t *testing.T) {
+	stdin, stdout := os.Stdin, os.Stdout
+	defer func() {
+		os.Stdin, os.Stdout = stdin, stdout
+	}()
+
+	inReader, inWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outReader, outWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Stdin, os.Stdout = inReader, outWriter
+
+	err = os.Setenv(maxInlineResultSizeEnv, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv(maxInlineResultSizeEnv)
+
+	task := NewTask()
+	data, err := task.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = inWriter.Write(append([]byte(fmt.Sprintf("%d\n", len(data))), data...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	WrapJob(func(t *Task) {
+		t.Returns["result"] = "large"
+	})
+	_ = outWriter.Close()
+
+	reader := bufio.NewReader(outReader)
+
+	magic, err := reader.Peek(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if magic[0] != resultFileMagic {
+		t.Fatal("result not sent through a file")
+	}
+
+	res, err := readResultFile(reader, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.UUID != task.UUID || res.Task.Returns["result"] != "large" {
+		t.Error("unexpected result:", res)
+	}
+}